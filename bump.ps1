@@ -110,7 +110,7 @@ if (Test-TargetInstalled "x86_64-pc-windows-msvc") {
     if ($LASTEXITCODE -eq 0) {
         Write-Output "✅ Successfully built Windows binary"
         # Copy the binary to release folder
-        Copy-Item -Path "./target/x86_64-pc-windows-msvc/release/prime.exe" -Destination "$releaseFolder/prime.exe" -Force
+        Copy-Item -Path "./target/x86_64-pc-windows-msvc/release/prime.exe" -Destination "$releaseFolder/prime-windows.exe" -Force
     } else {
         Write-Output "❌ Failed to build Windows binary"
         exit 1
@@ -128,7 +128,7 @@ if (Test-TargetInstalled "x86_64-unknown-linux-gnu") {
     if ($LASTEXITCODE -eq 0) {
         Write-Output "✅ Successfully built Linux binary"
         # Copy the binary to release folder
-        Copy-Item -Path "./target/x86_64-unknown-linux-gnu/release/prime" -Destination "$releaseFolder/prime" -Force
+        Copy-Item -Path "./target/x86_64-unknown-linux-gnu/release/prime" -Destination "$releaseFolder/prime-linux" -Force
     } else {
         Write-Output "❌ Failed to build Linux binary"
         exit 1
@@ -139,6 +139,39 @@ if (Test-TargetInstalled "x86_64-unknown-linux-gnu") {
 }
 
 
+# build for macos
+Write-Output "🔨 Building macOS binary..."
+if (Test-TargetInstalled "x86_64-apple-darwin") {
+    cargo build --release --bin prime --target x86_64-apple-darwin
+    if ($LASTEXITCODE -eq 0) {
+        Write-Output "✅ Successfully built macOS binary"
+        Copy-Item -Path "./target/x86_64-apple-darwin/release/prime" -Destination "$releaseFolder/prime-macos" -Force
+    } else {
+        Write-Output "❌ Failed to build macOS binary"
+        exit 1
+    }
+} else {
+    Write-Output "⚠️ macOS target (x86_64-apple-darwin) not installed. Skipping macOS build."
+    Write-Output "To install the target, run: rustup target add x86_64-apple-darwin"
+}
+
+
+# `prime update` only installs binaries listed in SHA256SUMS, so the checksums are
+# written next to the binaries and published with them
+$releaseAssets = @("prime-windows.exe", "prime-linux", "prime-macos") |
+    ForEach-Object { Join-Path $releaseFolder $_ } |
+    Where-Object { Test-Path $_ }
+if ($releaseAssets) {
+    $checksumLines = $releaseAssets | ForEach-Object {
+        $hash = (Get-FileHash -Algorithm SHA256 -Path $_).Hash.ToLower()
+        "$hash  $(Split-Path $_ -Leaf)"
+    }
+    # LF line endings and no BOM, as sha256sum -c expects
+    [System.IO.File]::WriteAllText((Join-Path (Resolve-Path $releaseFolder) "SHA256SUMS"), (($checksumLines -join "`n") + "`n"))
+    Write-Output "✅ Wrote SHA256SUMS for $($releaseAssets.Count) binaries"
+}
+
+
 
 
 # Check if at least one target was built successfully
@@ -262,6 +295,22 @@ if ($LASTEXITCODE -ne 0) {
     exit 1
 }
 
+# Publish the GitHub release that `prime update` downloads from
+if (-not $releaseAssets) {
+    Write-Output "⚠️ No binaries were built, so the GitHub release is not created and 'prime update' will not offer v$newVersion."
+} elseif (-not (Get-Command gh -ErrorAction SilentlyContinue)) {
+    Write-Output "❌ GitHub CLI (gh) not found; install it to publish the release binaries and SHA256SUMS"
+    exit 1
+} else {
+    Write-Output "📤 Publishing GitHub release v$newVersion..."
+    gh release create "v$newVersion" @releaseAssets (Join-Path $releaseFolder "SHA256SUMS") --title "$releaseMessage" --notes "$releaseMessage"
+    if ($LASTEXITCODE -ne 0) {
+        Write-Output "❌ Failed to publish the GitHub release"
+        exit 1
+    }
+    Write-Output "✅ Published $($releaseAssets.Count) binaries and SHA256SUMS"
+}
+
 # Check if CARGO_TOKEN is available
 $cargoToken = $env:CARGO_TOKEN
 if (-not $cargoToken) {
@@ -0,0 +1,234 @@
+//! Opt-in local usage analytics
+//! Events are appended to ~/.prime/analytics.jsonl and never leave the machine.
+//! `prime report` aggregates them per month.
+
+use std::collections::BTreeMap;
+use std::fs::{self, OpenOptions};
+use std::io::Write;
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Context, Result};
+use chrono::{DateTime, Datelike, Local, NaiveDate};
+use crate::terminal::Stylize;
+use serde::{Deserialize, Serialize};
+
+const ANALYTICS_FILENAME: &str = "analytics.jsonl";
+
+#[derive(Serialize, Deserialize, Debug, Clone, Copy, PartialEq, Eq)]
+#[serde(rename_all = "snake_case")]
+pub enum UsageEventKind {
+    /// A user prompt handled by the session
+    Turn,
+    /// A model-generated command or script that was executed
+    Command,
+    /// A tool failure that sent the model into self-correction
+    Recovery,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct UsageEvent {
+    pub timestamp: String,
+    pub kind: UsageEventKind,
+    pub model: String,
+    pub success: bool,
+}
+
+/// Appends usage events for one session
+#[derive(Debug, Clone)]
+pub struct UsageRecorder {
+    path: PathBuf,
+    model: String,
+}
+
+impl UsageRecorder {
+    pub fn new(base_dir: &Path, model: &str) -> Self {
+        Self { path: base_dir.join(ANALYTICS_FILENAME), model: model.to_string() }
+    }
+
+    /// Records an event; failures are reported but never interrupt the session
+    pub fn record(&self, kind: UsageEventKind, success: bool) {
+        let event = UsageEvent {
+            timestamp: Local::now().to_rfc3339(),
+            kind,
+            model: self.model.clone(),
+            success,
+        };
+        if let Err(e) = self.append(&event) {
+            eprintln!("{}", format!("Warning: Failed to record usage event: {}", e).yellow());
+        }
+    }
+
+    fn append(&self, event: &UsageEvent) -> Result<()> {
+        let line = serde_json::to_string(event).context("Failed to serialize usage event")?;
+        let mut file = OpenOptions::new()
+            .create(true)
+            .append(true)
+            .open(&self.path)
+            .with_context(|| format!("Failed to open analytics file: {}", self.path.display()))?;
+        writeln!(file, "{}", line)
+            .with_context(|| format!("Failed to write analytics file: {}", self.path.display()))
+    }
+}
+
+#[derive(Debug, Default, PartialEq)]
+pub struct ModelStats {
+    pub turns: usize,
+    pub commands: usize,
+    pub commands_succeeded: usize,
+    pub recoveries: usize,
+}
+
+#[derive(Debug, Default)]
+pub struct MonthlyReport {
+    pub month: String,
+    pub active_days: usize,
+    pub turns: usize,
+    pub commands: usize,
+    pub commands_succeeded: usize,
+    pub recoveries: usize,
+    pub per_model: BTreeMap<String, ModelStats>,
+}
+
+impl MonthlyReport {
+    pub fn turns_per_active_day(&self) -> f64 {
+        if self.active_days == 0 { 0.0 } else { self.turns as f64 / self.active_days as f64 }
+    }
+}
+
+fn percentage(part: usize, total: usize) -> f64 {
+    if total == 0 { 0.0 } else { part as f64 * 100.0 / total as f64 }
+}
+
+/// Aggregates the events that fall into the given calendar month
+pub fn build_report(events: &[UsageEvent], year: i32, month: u32) -> MonthlyReport {
+    let mut report = MonthlyReport { month: format!("{:04}-{:02}", year, month), ..Default::default() };
+    let mut days = std::collections::BTreeSet::new();
+    for event in events {
+        let Ok(timestamp) = DateTime::parse_from_rfc3339(&event.timestamp) else {
+            continue;
+        };
+        if timestamp.year() != year || timestamp.month() != month {
+            continue;
+        }
+        let stats = report.per_model.entry(event.model.clone()).or_default();
+        match event.kind {
+            UsageEventKind::Turn => {
+                days.insert(timestamp.day());
+                report.turns += 1;
+                stats.turns += 1;
+            }
+            UsageEventKind::Command => {
+                report.commands += 1;
+                stats.commands += 1;
+                if event.success {
+                    report.commands_succeeded += 1;
+                    stats.commands_succeeded += 1;
+                }
+            }
+            UsageEventKind::Recovery => {
+                report.recoveries += 1;
+                stats.recoveries += 1;
+            }
+        }
+    }
+    report.active_days = days.len();
+    report
+}
+
+fn load_events(path: &Path) -> Result<Vec<UsageEvent>> {
+    if !path.exists() {
+        return Ok(Vec::new());
+    }
+    let content = fs::read_to_string(path)
+        .with_context(|| format!("Failed to read analytics file: {}", path.display()))?;
+    // Skip lines that fail to parse rather than losing the whole report to one bad write.
+    Ok(content.lines().filter_map(|line| serde_json::from_str(line).ok()).collect())
+}
+
+fn parse_month(month: &str) -> Result<(i32, u32)> {
+    let date = NaiveDate::parse_from_str(&format!("{}-01", month.trim()), "%Y-%m-%d")
+        .map_err(|_| anyhow!("Invalid month '{}'. Expected YYYY-MM", month))?;
+    Ok((date.year(), date.month()))
+}
+
+/// Prints the monthly report for `month` (YYYY-MM), defaulting to the current month
+pub fn run_report(base_dir: &Path, month: Option<&str>, enabled: bool) -> Result<()> {
+    let (year, month) = match month {
+        Some(m) => parse_month(m)?,
+        None => {
+            let now = Local::now();
+            (now.year(), now.month())
+        }
+    };
+    if !enabled {
+        println!("{}", "Usage analytics are disabled. Set `analytics = true` in config.toml to start recording.".yellow());
+    }
+
+    let events = load_events(&base_dir.join(ANALYTICS_FILENAME))?;
+    let report = build_report(&events, year, month);
+
+    println!("{}", format!("Prime usage report for {}", report.month).white().bold());
+    if report.turns == 0 && report.commands == 0 {
+        println!("No usage recorded for this month.");
+        return Ok(());
+    }
+    println!(" {:<28} {}", "Turns".cyan(), report.turns);
+    println!(" {:<28} {}", "Active days".cyan(), report.active_days);
+    println!(" {:<28} {:.1}", "Turns per active day".cyan(), report.turns_per_active_day());
+    println!(
+        " {:<28} {}/{} ({:.0}%)",
+        "Commands succeeded".cyan(),
+        report.commands_succeeded,
+        report.commands,
+        percentage(report.commands_succeeded, report.commands)
+    );
+    println!(" {:<28} {}", "Recoveries".cyan(), report.recoveries);
+    println!();
+    println!("{}", "Per model:".white().bold());
+    println!(" {:<28} {:>6} {:>10} {:>12}", "model", "turns", "success", "recov/turn");
+    for (model, stats) in &report.per_model {
+        let recoveries_per_turn = if stats.turns == 0 { 0.0 } else { stats.recoveries as f64 / stats.turns as f64 };
+        println!(
+            " {:<28} {:>6} {:>9.0}% {:>12.2}",
+            model,
+            stats.turns,
+            percentage(stats.commands_succeeded, stats.commands),
+            recoveries_per_turn
+        );
+    }
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn event(timestamp: &str, kind: UsageEventKind, model: &str, success: bool) -> UsageEvent {
+        UsageEvent { timestamp: timestamp.to_string(), kind, model: model.to_string(), success }
+    }
+
+    #[test]
+    fn test_build_report_filters_month_and_aggregates() {
+        let events = vec![
+            event("2025-06-01T10:00:00+00:00", UsageEventKind::Turn, "gemma2", true),
+            event("2025-06-01T10:00:05+00:00", UsageEventKind::Command, "gemma2", true),
+            event("2025-06-01T10:00:09+00:00", UsageEventKind::Command, "gemma2", false),
+            event("2025-06-01T10:00:10+00:00", UsageEventKind::Recovery, "gemma2", false),
+            event("2025-06-03T09:00:00+00:00", UsageEventKind::Turn, "gemini-2.5-flash", true),
+            event("2025-07-01T09:00:00+00:00", UsageEventKind::Turn, "gemma2", true),
+        ];
+        let report = build_report(&events, 2025, 6);
+        assert_eq!(report.turns, 2);
+        assert_eq!(report.active_days, 2);
+        assert_eq!(report.commands, 2);
+        assert_eq!(report.commands_succeeded, 1);
+        assert_eq!(report.per_model["gemma2"].recoveries, 1);
+        assert_eq!(report.per_model["gemini-2.5-flash"].turns, 1);
+    }
+
+    #[test]
+    fn test_parse_month() {
+        assert_eq!(parse_month("2025-06").unwrap(), (2025, 6));
+        assert!(parse_month("June").is_err());
+    }
+}
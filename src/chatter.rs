@@ -0,0 +1,139 @@
+//! Meta-chatter filter for history sent back to the model
+//! Pleasantries ("Sure! I'd be happy to help…", "Let me know if…") and sentences already
+//! said in an earlier response are dropped from the history copy of assistant messages.
+//! The session log and the terminal output keep the original text; code blocks are
+//! never touched, so primeactions survive unchanged.
+
+use std::collections::HashSet;
+
+/// Whole sentences that carry no content on their own
+const FILLER_SENTENCES: &[&str] = &[
+    "sure", "sure thing", "certainly", "of course", "absolutely", "no problem", "okay", "ok", "alright",
+    "great question", "good question", "happy to help", "got it", "understood", "great",
+];
+
+/// Sentence openings that mark boilerplate regardless of how they end
+const FILLER_PREFIXES: &[&str] = &[
+    "i'd be happy to help", "i would be happy to help", "i'd be glad to help", "i'll help you with",
+    "i can help you with that", "let me help you with", "sure, i can", "sure, i'll", "sure, let me",
+    "let me know if", "feel free to", "hope this helps", "i hope this helps", "is there anything else",
+    "if you have any other questions", "if you need anything else", "happy coding",
+];
+
+/// Restated sentences shorter than this are kept; short repeats are usually meaningful
+const MIN_REPEAT_CHARS: usize = 25;
+
+fn normalize(sentence: &str) -> String {
+    sentence
+        .to_lowercase()
+        .replace('’', "'")
+        .chars()
+        .filter(|c| c.is_alphanumeric() || c.is_whitespace() || *c == '\'' || *c == ',')
+        .collect::<String>()
+        .split_whitespace()
+        .collect::<Vec<_>>()
+        .join(" ")
+}
+
+fn is_filler(normalized: &str) -> bool {
+    let bare = normalized.trim_end_matches(',');
+    FILLER_SENTENCES.contains(&bare) || FILLER_PREFIXES.iter().any(|p| bare.starts_with(p))
+}
+
+/// Splits a line into sentences, keeping terminal punctuation with each sentence
+fn sentences(line: &str) -> Vec<&str> {
+    let mut parts = Vec::new();
+    let mut start = 0;
+    let chars: Vec<(usize, char)> = line.char_indices().collect();
+    for (i, &(pos, ch)) in chars.iter().enumerate() {
+        let at_boundary = chars.get(i + 1).map_or(true, |(_, next)| next.is_whitespace());
+        if matches!(ch, '.' | '!' | '?') && at_boundary {
+            let end = pos + ch.len_utf8();
+            parts.push(line[start..end].trim());
+            start = end;
+        }
+    }
+    if start < line.len() && !line[start..].trim().is_empty() {
+        parts.push(line[start..].trim());
+    }
+    parts
+}
+
+/// Sentence fingerprints of earlier responses, for spotting restatements
+pub fn fingerprints(text: &str) -> HashSet<String> {
+    let mut in_code = false;
+    let mut seen = HashSet::new();
+    for line in text.lines() {
+        if line.trim_start().starts_with("```") {
+            in_code = !in_code;
+            continue;
+        }
+        if !in_code {
+            seen.extend(sentences(line).into_iter().map(normalize).filter(|s| s.len() >= MIN_REPEAT_CHARS));
+        }
+    }
+    seen
+}
+
+/// Returns the history copy of an assistant response: filler and sentences already in
+/// `earlier` are dropped, runs of blank lines collapse to one
+pub fn compact_response(text: &str, earlier: &HashSet<String>) -> String {
+    let mut in_code = false;
+    let mut out: Vec<String> = Vec::new();
+    for line in text.lines() {
+        if line.trim_start().starts_with("```") {
+            in_code = !in_code;
+            out.push(line.to_string());
+            continue;
+        }
+        if in_code || line.trim().is_empty() {
+            out.push(line.to_string());
+            continue;
+        }
+        let kept: Vec<&str> = sentences(line)
+            .into_iter()
+            .filter(|sentence| {
+                let normalized = normalize(sentence);
+                !is_filler(&normalized) && !(normalized.len() >= MIN_REPEAT_CHARS && earlier.contains(&normalized))
+            })
+            .collect();
+        if !kept.is_empty() {
+            let indent = &line[..line.len() - line.trim_start().len()];
+            out.push(format!("{}{}", indent, kept.join(" ")));
+        }
+    }
+    let mut compacted: Vec<String> = Vec::new();
+    for line in out {
+        if line.trim().is_empty() && compacted.last().map_or(true, |prev: &String| prev.trim().is_empty()) {
+            continue;
+        }
+        compacted.push(line);
+    }
+    compacted.join("\n").trim().to_string()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_strips_pleasantries_but_keeps_substance() {
+        let text = "Sure! I'd be happy to help you with that. The config lives in ~/.prime/config.toml.\n\nLet me know if you need anything else!";
+        assert_eq!(compact_response(text, &HashSet::new()), "The config lives in ~/.prime/config.toml.");
+        let substantive = "Sure, the file is at src/main.rs.";
+        assert_eq!(compact_response(substantive, &HashSet::new()), substantive);
+    }
+
+    #[test]
+    fn test_drops_restated_plan_sentences() {
+        let earlier = fingerprints("First I will check the installed Python version. Then install uv.");
+        let text = "First I will check the installed Python version. The check failed, so I will use pyenv.";
+        assert_eq!(compact_response(text, &earlier), "The check failed, so I will use pyenv.");
+    }
+
+    #[test]
+    fn test_code_blocks_are_untouched() {
+        let text = "Okay.\n```primeactions\nshell: echo Sure!\n```\nHope this helps!";
+        assert_eq!(compact_response(text, &HashSet::new()), "```primeactions\nshell: echo Sure!\n```");
+    }
+}
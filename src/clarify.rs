@@ -0,0 +1,84 @@
+//! Clarify-first mode
+//! With `clarify_first` on (or `!clarify on`), the model opens every action block with a
+//! self-assessment line, `assess: confidence=<0-100>; question=<one question>`. When the
+//! confidence is below `CONFIDENCE_THRESHOLD` the planned actions are held back and the
+//! question goes to the user instead, so a vague prompt costs one question rather than
+//! a round of commands in the wrong direction. A plan without a readable assessment is
+//! treated as uncertain. The line sits inside the `primeactions` block, which the
+//! streamed display already hides.
+
+/// Plans assessed below this confidence are not run
+pub const CONFIDENCE_THRESHOLD: u8 = 60;
+
+/// System prompt rule for clarify-first mode
+pub const RULE: &str = "Clarify first: Start every `primeactions` block with one line `assess: confidence=<0-100>; question=<the one question whose answer would most change the plan>`; the question runs to the end of the line. A block without this line is not run. Confidence is how sure you are that the plan matches what the user wants, not whether the commands will succeed. Below 60 the actions are not run and the user gets your question instead, so ask about the ambiguity itself (which file, which environment, which of two readings). When the request is clear, answer with a high confidence and `question=none`.";
+
+/// The model's self-assessment of a plan
+#[derive(Debug, Clone, PartialEq)]
+pub struct Assessment {
+    pub confidence: u8,
+    pub question: Option<String>,
+}
+
+impl Assessment {
+    /// Parses the arguments of an `assess:` line; None when the confidence is missing.
+    /// The question is the rest of the line, so it may contain `;` itself.
+    pub fn parse(args: &str) -> Option<Self> {
+        let (fields, question) = match args.find("question") {
+            Some(start) => match args[start + "question".len()..].trim_start().strip_prefix('=') {
+                Some(question) => (&args[..start], Some(question.trim().trim_matches('"'))),
+                None => (args, None),
+            },
+            None => (args, None),
+        };
+        let mut confidence = None;
+        for field in fields.split(';') {
+            if let Some(("confidence", value)) = field.split_once('=').map(|(key, value)| (key.trim(), value.trim().trim_matches('"'))) {
+                confidence = value.trim_end_matches('%').parse::<u8>().ok().map(|c| c.min(100));
+            }
+        }
+        let question = question.filter(|q| !q.is_empty() && !q.eq_ignore_ascii_case("none")).map(str::to_string);
+        Some(Self { confidence: confidence?, question })
+    }
+
+    /// Stands in for a plan that came without a readable `assess:` line
+    pub fn unstated() -> Self {
+        Self { confidence: 0, question: None }
+    }
+
+    /// The question to ask before acting, when the plan is too uncertain to run
+    pub fn clarification(&self) -> Option<&str> {
+        match self.confidence < CONFIDENCE_THRESHOLD {
+            true => Some(self.question.as_deref().unwrap_or("Could you say more about what you want done?")),
+            false => None,
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_low_confidence_asks_the_question() {
+        let unsure = Assessment::parse("confidence=35; question=Which service should be restarted, api or worker?").unwrap();
+        assert_eq!(unsure.confidence, 35);
+        assert_eq!(unsure.clarification(), Some("Which service should be restarted, api or worker?"));
+
+        let sure = Assessment::parse(" confidence = 90% ; question = none").unwrap();
+        assert_eq!(sure, Assessment { confidence: 90, question: None });
+        assert_eq!(sure.clarification(), None);
+
+        assert!(Assessment::parse("confidence=20").unwrap().clarification().is_some());
+        assert_eq!(Assessment::parse("question=what?"), None);
+        assert!(Assessment::unstated().clarification().is_some());
+    }
+
+    #[test]
+    fn test_question_keeps_its_semicolons() {
+        let unsure = Assessment::parse("confidence=40; question=Restart api; or the worker too?").unwrap();
+        assert_eq!(unsure.question.as_deref(), Some("Restart api; or the worker too?"));
+        let quoted = Assessment::parse("confidence=10; question=\"Which branch; main or dev?\"").unwrap();
+        assert_eq!((quoted.confidence, quoted.question.as_deref()), (10, Some("Which branch; main or dev?")));
+    }
+}
@@ -0,0 +1,287 @@
+//! Command-line argument handling for Prime
+//! Anything that is not a recognised subcommand falls through to the REPL.
+
+use anyhow::{anyhow, Result};
+use crate::terminal::Stylize;
+
+/// Top-level action selected from the process arguments
+#[derive(Debug, PartialEq)]
+pub enum CliCommand {
+    /// Interactive REPL (default)
+    Repl,
+    /// REPL continuing a stored session (by default the last one in this workspace)
+    Resume { session: Option<String> },
+    /// Self-update from the release endpoint
+    Update { check_only: bool, migrate_only: bool },
+    /// Monthly usage report from local analytics
+    Report { month: Option<String> },
+    /// Export sessions and memory to an Obsidian vault
+    ExportObsidian { vault: Option<String> },
+    /// Write rated turns as a JSONL dataset
+    ExportDataset { path: Option<String> },
+    /// Combine two sessions into a new one, interleaved by time
+    MergeSessions { a: String, b: String },
+    /// Rewrite one session log, or all of them, in another storage format
+    ConvertSessions { format: String, session: Option<String> },
+    /// Run one shell command through the risk policy and audit log
+    Exec { command: String },
+    /// Read or change memory without starting the REPL
+    Memory { action: MemoryAction, memory_type: Option<String>, category: Option<String> },
+    /// Answer one prompt and exit; `quiet` leaves only the response (and, with
+    /// `command_results`, command output) on stdout; `fast` skips the startup steps a
+    /// one-shot answer does not need
+    Prompt { prompt: String, quiet: bool, command_results: bool, fast: bool },
+    /// Print usage and exit
+    Help,
+}
+
+/// Operation of `prime memory`
+#[derive(Debug, PartialEq)]
+pub enum MemoryAction {
+    Add { content: String },
+    Read,
+    Search { query: String },
+    Clear,
+}
+
+const MEMORY_USAGE: &str =
+    "Usage: prime memory <add|read|search|clear> [--type long|short] [--category NAME] [TEXT]";
+
+fn parse_memory(args: &[String]) -> Result<CliCommand> {
+    let mut memory_type = None;
+    let mut category = None;
+    let mut words = Vec::new();
+    let mut rest = args.iter().skip(1);
+    while let Some(arg) = rest.next() {
+        match arg.as_str() {
+            "--type" | "-t" => {
+                let value = rest.next().ok_or_else(|| anyhow!("{}", MEMORY_USAGE))?;
+                memory_type = Some(match value.as_str() {
+                    "long" | "long_term" => "long_term".to_string(),
+                    "short" | "short_term" => "short_term".to_string(),
+                    other => return Err(anyhow!("Unknown memory type: {} (use long or short)", other)),
+                });
+            }
+            "--category" | "-c" => category = Some(rest.next().ok_or_else(|| anyhow!("{}", MEMORY_USAGE))?.clone()),
+            _ => words.push(arg.as_str()),
+        }
+    }
+    let text = words.join(" ");
+    let action = match args.first().map(String::as_str) {
+        // Text may also come on stdin, which is read when the command runs.
+        Some("add") => MemoryAction::Add { content: text },
+        Some("read") if text.is_empty() => MemoryAction::Read,
+        Some("search") if !text.is_empty() => MemoryAction::Search { query: text },
+        Some("clear") if text.is_empty() => MemoryAction::Clear,
+        _ => return Err(anyhow!("{}", MEMORY_USAGE)),
+    };
+    Ok(CliCommand::Memory { action, memory_type, category })
+}
+
+const PROMPT_USAGE: &str = "Usage: prime -p \"<prompt>\" [--quiet] [--results] [--fast]";
+
+fn parse_prompt(args: &[String]) -> Result<CliCommand> {
+    let mut prompt = None;
+    let mut quiet = false;
+    let mut command_results = false;
+    let mut fast = false;
+    let mut rest = args.iter();
+    while let Some(arg) = rest.next() {
+        match arg.as_str() {
+            "-p" | "--print" => prompt = Some(rest.next().ok_or_else(|| anyhow!("{}", PROMPT_USAGE))?.clone()),
+            "-q" | "--quiet" => quiet = true,
+            "--results" => command_results = true,
+            "--fast" => fast = true,
+            other => return Err(anyhow!("Unknown option: {}. {}", other, PROMPT_USAGE)),
+        }
+    }
+    match prompt {
+        Some(prompt) if !prompt.trim().is_empty() => Ok(CliCommand::Prompt { prompt, quiet, command_results, fast }),
+        _ => Err(anyhow!("{}", PROMPT_USAGE)),
+    }
+}
+
+pub fn parse_args<I: IntoIterator<Item = String>>(args: I) -> Result<CliCommand> {
+    let args: Vec<String> = args.into_iter().collect();
+    let Some(first) = args.first() else {
+        return Ok(CliCommand::Repl);
+    };
+    match first.as_str() {
+        "update" => {
+            let mut check_only = false;
+            let mut migrate_only = false;
+            for flag in &args[1..] {
+                match flag.as_str() {
+                    "--check" => check_only = true,
+                    "--migrate" => migrate_only = true,
+                    other => return Err(anyhow!("Unknown option for 'update': {}", other)),
+                }
+            }
+            Ok(CliCommand::Update { check_only, migrate_only })
+        }
+        "report" => match args.len() {
+            1 => Ok(CliCommand::Report { month: None }),
+            2 => Ok(CliCommand::Report { month: Some(args[1].clone()) }),
+            _ => Err(anyhow!("Usage: prime report [YYYY-MM]")),
+        },
+        "export" => match args.get(1).map(String::as_str) {
+            Some("obsidian") if args.len() <= 3 => Ok(CliCommand::ExportObsidian { vault: args.get(2).cloned() }),
+            Some("dataset") if args.len() <= 3 => Ok(CliCommand::ExportDataset { path: args.get(2).cloned() }),
+            _ => Err(anyhow!("Usage: prime export obsidian [VAULT_DIR] | prime export dataset [FILE]")),
+        },
+        "exec" => {
+            let rest = match args.get(1).map(String::as_str) {
+                Some("--") => &args[2..],
+                _ => &args[1..],
+            };
+            if rest.is_empty() {
+                return Err(anyhow!("Usage: prime exec \"<command>\""));
+            }
+            Ok(CliCommand::Exec { command: rest.join(" ") })
+        }
+        "memory" => parse_memory(&args[1..]),
+        "resume" => match args.len() {
+            1 | 2 => Ok(CliCommand::Resume { session: args.get(1).cloned() }),
+            _ => Err(anyhow!("Usage: prime resume [SESSION]")),
+        },
+        "sessions" => match (args.get(1).map(String::as_str), args.len()) {
+            (Some("merge"), 4) => Ok(CliCommand::MergeSessions { a: args[2].clone(), b: args[3].clone() }),
+            (Some("convert"), 3 | 4) => Ok(CliCommand::ConvertSessions { format: args[2].clone(), session: args.get(3).cloned() }),
+            _ => Err(anyhow!("Usage: prime sessions merge <SESSION_A> <SESSION_B> | prime sessions convert <markdown|json> [SESSION]")),
+        },
+        "-p" | "--print" | "-q" | "--quiet" | "--results" | "--fast" => parse_prompt(&args),
+        "help" | "-h" | "--help" => Ok(CliCommand::Help),
+        other => Err(anyhow!("Unknown command: {}. Run 'prime help' for usage.", other)),
+    }
+}
+
+pub fn print_usage() {
+    println!("{}", "Usage:".white().bold());
+    println!(" {:<30} - Start the interactive session.", "prime".cyan());
+    println!(" {:<30} - Continue a session (default: the last one here) with its settings.", "prime resume [SESSION]".cyan());
+    println!(" {:<30} - Answer one prompt and exit (status 1 if an action failed, 2 if the plan was cancelled).", "prime -p \"<prompt>\"".cyan());
+    println!(" {:<30} - Print only the response, for pipelines (--results adds command output).", "prime -p \"<prompt>\" --quiet".cyan());
+    println!(" {:<30} - Skip the banner and startup probes for quicker one-shot answers.", "prime -p \"<prompt>\" --fast".cyan());
+    println!(" {:<30} - Run piped prompts and !commands, one per line, without a terminal.", "... | prime".cyan());
+    println!(" {:<30} - Download, checksum and install the latest release.", "prime update".cyan());
+    println!(" {:<30} - Only check whether a newer release exists.", "prime update --check".cyan());
+    println!(" {:<30} - Summarize local usage analytics for a month.", "prime report [YYYY-MM]".cyan());
+    println!(" {:<30} - Write sessions and memory into an Obsidian vault.", "prime export obsidian [DIR]".cyan());
+    println!(" {:<30} - Write rated turns as JSONL for fine-tuning or evaluation.", "prime export dataset [FILE]".cyan());
+    println!(" {:<30} - Combine two sessions into a new one, ordered by time.", "prime sessions merge <a> <b>".cyan());
+    println!(" {:<30} - Rewrite session logs (or one session) as markdown or JSON.", "prime sessions convert <fmt> [s]".cyan());
+    println!(" {:<30} - Run a command under the same risk policy and audit log.", "prime exec \"<command>\"".cyan());
+    println!(" {:<30} - Add, read, search or clear memory (--type, --category).", "prime memory <action>".cyan());
+    println!(" {:<30} - Show this help message.", "prime help".cyan());
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn args(list: &[&str]) -> Vec<String> {
+        list.iter().map(|s| s.to_string()).collect()
+    }
+
+    #[test]
+    fn test_no_args_starts_repl() {
+        assert_eq!(parse_args(args(&[])).unwrap(), CliCommand::Repl);
+    }
+
+    #[test]
+    fn test_update_flags() {
+        assert_eq!(
+            parse_args(args(&["update", "--check"])).unwrap(),
+            CliCommand::Update { check_only: true, migrate_only: false }
+        );
+        assert!(parse_args(args(&["update", "--force"])).is_err());
+    }
+
+    #[test]
+    fn test_export_obsidian() {
+        assert_eq!(
+            parse_args(args(&["export", "obsidian", "~/notes"])).unwrap(),
+            CliCommand::ExportObsidian { vault: Some("~/notes".to_string()) }
+        );
+        assert_eq!(parse_args(args(&["export", "obsidian"])).unwrap(), CliCommand::ExportObsidian { vault: None });
+        assert!(parse_args(args(&["export", "notion"])).is_err());
+        assert_eq!(parse_args(args(&["export", "dataset"])).unwrap(), CliCommand::ExportDataset { path: None });
+    }
+
+    #[test]
+    fn test_exec_joins_command_words() {
+        assert_eq!(parse_args(args(&["exec", "git status"])).unwrap(), CliCommand::Exec { command: "git status".to_string() });
+        assert_eq!(
+            parse_args(args(&["exec", "--", "ls", "-la"])).unwrap(),
+            CliCommand::Exec { command: "ls -la".to_string() }
+        );
+        assert!(parse_args(args(&["exec"])).is_err());
+    }
+
+    #[test]
+    fn test_memory_subcommands() {
+        assert_eq!(
+            parse_args(args(&["memory", "add", "--type", "short", "-c", "tools", "use", "pnpm"])).unwrap(),
+            CliCommand::Memory {
+                action: MemoryAction::Add { content: "use pnpm".to_string() },
+                memory_type: Some("short_term".to_string()),
+                category: Some("tools".to_string()),
+            }
+        );
+        assert_eq!(
+            parse_args(args(&["memory", "search", "pnpm"])).unwrap(),
+            CliCommand::Memory { action: MemoryAction::Search { query: "pnpm".to_string() }, memory_type: None, category: None }
+        );
+        assert!(parse_args(args(&["memory", "search"])).is_err());
+        assert!(parse_args(args(&["memory", "read", "--type", "medium"])).is_err());
+        assert!(parse_args(args(&["memory"])).is_err());
+    }
+
+    #[test]
+    fn test_print_mode_flags_in_any_order() {
+        assert_eq!(
+            parse_args(args(&["--quiet", "-p", "list files", "--results"])).unwrap(),
+            CliCommand::Prompt { prompt: "list files".to_string(), quiet: true, command_results: true, fast: false }
+        );
+        assert_eq!(
+            parse_args(args(&["-p", "hi"])).unwrap(),
+            CliCommand::Prompt { prompt: "hi".to_string(), quiet: false, command_results: false, fast: false }
+        );
+        assert_eq!(
+            parse_args(args(&["--fast", "-p", "hi"])).unwrap(),
+            CliCommand::Prompt { prompt: "hi".to_string(), quiet: false, command_results: false, fast: true }
+        );
+        assert!(parse_args(args(&["--quiet"])).is_err());
+        assert!(parse_args(args(&["-p"])).is_err());
+        assert!(parse_args(args(&["-p", "hi", "--verbose"])).is_err());
+    }
+
+    #[test]
+    fn test_sessions_merge() {
+        assert_eq!(
+            parse_args(args(&["sessions", "merge", "session_1", "session_2"])).unwrap(),
+            CliCommand::MergeSessions { a: "session_1".to_string(), b: "session_2".to_string() }
+        );
+        assert!(parse_args(args(&["sessions", "merge", "session_1"])).is_err());
+        assert_eq!(
+            parse_args(args(&["sessions", "convert", "json"])).unwrap(),
+            CliCommand::ConvertSessions { format: "json".to_string(), session: None }
+        );
+        assert!(parse_args(args(&["sessions"])).is_err());
+    }
+
+    #[test]
+    fn test_resume() {
+        assert_eq!(parse_args(args(&["resume"])).unwrap(), CliCommand::Resume { session: None });
+        assert_eq!(
+            parse_args(args(&["resume", "session_1"])).unwrap(),
+            CliCommand::Resume { session: Some("session_1".to_string()) }
+        );
+        assert!(parse_args(args(&["resume", "a", "b"])).is_err());
+    }
+
+    #[test]
+    fn test_unknown_command() {
+        assert!(parse_args(args(&["frobnicate"])).is_err());
+    }
+}
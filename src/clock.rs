@@ -0,0 +1,89 @@
+//! Injectable time source
+//! Session ids, log timestamps, memory entries, job and lock stamps, snapshot manifests
+//! and audit records all read the time. Taking it from the session's `Clock` instead of
+//! `chrono::Local::now()` lets tests and replays pin it: with the test-only
+//! `FixedClock` a session gets the same id and the same log on every run.
+//! Clocks are shared as `Arc<dyn Clock>` between the session, its memory and
+//! background tasks, so they are `Send + Sync` and `FixedClock` moves behind a mutex.
+
+use std::sync::Arc;
+#[cfg(test)]
+use std::sync::Mutex;
+
+use chrono::{DateTime, Local};
+#[cfg(test)]
+use chrono::{Duration, TimeZone};
+
+pub trait Clock: Send + Sync + std::fmt::Debug {
+    fn now(&self) -> DateTime<Local>;
+}
+
+pub type SharedClock = Arc<dyn Clock>;
+
+/// The wall clock
+#[derive(Debug, Default)]
+pub struct SystemClock;
+
+impl Clock for SystemClock {
+    fn now(&self) -> DateTime<Local> {
+        Local::now()
+    }
+}
+
+/// The wall clock, shared
+pub fn system() -> SharedClock {
+    Arc::new(SystemClock)
+}
+
+/// A clock that only moves when told to
+#[cfg(test)]
+#[derive(Debug)]
+pub struct FixedClock {
+    now: Mutex<DateTime<Local>>,
+}
+
+#[cfg(test)]
+impl FixedClock {
+    pub fn new(now: DateTime<Local>) -> Self {
+        Self { now: Mutex::new(now) }
+    }
+
+    /// A clock at `year-month-day hour:minute:second` local time
+    pub fn at(year: i32, month: u32, day: u32, hour: u32, minute: u32, second: u32) -> Self {
+        let now = Local.with_ymd_and_hms(year, month, day, hour, minute, second).earliest().unwrap_or_else(Local::now);
+        Self::new(now)
+    }
+
+    pub fn advance(&self, by: Duration) {
+        let mut now = self.now.lock().unwrap_or_else(|poisoned| poisoned.into_inner());
+        *now += by;
+    }
+}
+
+#[cfg(test)]
+impl Clock for FixedClock {
+    fn now(&self) -> DateTime<Local> {
+        *self.now.lock().unwrap_or_else(|poisoned| poisoned.into_inner())
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_fixed_clock_moves_only_when_advanced() {
+        let clock = Arc::new(FixedClock::at(2025, 6, 7, 17, 54, 46));
+        assert_eq!(clock.now().format("%Y-%m-%d %H:%M:%S").to_string(), "2025-06-07 17:54:46");
+        let workers: Vec<_> = (0..4)
+            .map(|_| {
+                let clock = Arc::clone(&clock);
+                std::thread::spawn(move || clock.advance(Duration::seconds(15)))
+            })
+            .collect();
+        for worker in workers {
+            worker.join().unwrap();
+        }
+        assert_eq!(clock.now().format("%H:%M:%S").to_string(), "17:55:46");
+    }
+}
@@ -7,16 +7,16 @@
 //!
 //! This rewrite fixes those issues while staying API‑compatible with the rest of Prime.
 
-use std::fs;
-use std::io::{BufRead, BufReader, Read, Write};
 use std::path::Path;
 use std::process::{Command, Stdio};
 
 use anyhow::{anyhow, Context, Result};
-use crossterm::style::Stylize;
+use crate::terminal::Stylize;
 use glob::Pattern;
 
 use crate::config;
+use crate::ignore::PrimeIgnore;
+use crate::vfs::{FileSystem, SharedFs};
 
 // ---------------------------------------------------------------------
 // Constants & helpers
@@ -41,17 +41,24 @@ pub struct CommandProcessor {
     shell_args: Vec<String>,
     ignored_path_patterns: Vec<Pattern>,
     ask_me_before_patterns: Vec<String>,
+    /// Extra environment variables for executed commands
+    env: Vec<(String, String)>,
+    workspace_ignore: PrimeIgnore,
+    /// Where file actions read and write
+    fs: SharedFs,
 }
 
 impl CommandProcessor {
-    pub fn new() -> Self {
+    /// A processor whose file actions go through `fs`, with the ignored-path and "ask me
+    /// before" patterns of the Prime config directory `config_dir` on it
+    pub fn new(config_dir: &Path, fs: SharedFs) -> Self {
         #[cfg(target_os = "windows")]
         let (shell_command, shell_args) = ("powershell".to_string(), vec!["-NoLogo".into(), "-Command".into()]);
 
         #[cfg(not(target_os = "windows"))]
         let (shell_command, shell_args) = ("sh".to_string(), vec!["-c".into()]);
 
-        let ignored_path_patterns = config::load_ignored_path_patterns().unwrap_or_else(|e| {
+        let ignored_path_patterns = config::load_ignored_path_patterns(fs.as_ref(), config_dir).unwrap_or_else(|e| {
             eprintln!("{}", format!("Warning: Failed to load ignored path patterns: {}. Using defaults.", e).yellow());
             config::DEFAULT_IGNORED_PATHS
                 .iter()
@@ -59,12 +66,30 @@ impl CommandProcessor {
                 .collect()
         });
 
-        let ask_me_before_patterns = config::load_ask_me_before_patterns().unwrap_or_else(|e| {
+        let ask_me_before_patterns = config::load_ask_me_before_patterns(fs.as_ref(), config_dir).unwrap_or_else(|e| {
             eprintln!("{}", format!("Warning: Failed to load 'ask me before' patterns: {}. Using defaults.", e).yellow());
             config::DEFAULT_ASK_ME_BEFORE_PATTERNS.iter().map(|s| s.to_string()).collect()
         });
 
-        Self { shell_command, shell_args, ignored_path_patterns, ask_me_before_patterns }
+        Self {
+            shell_command,
+            shell_args,
+            ignored_path_patterns,
+            ask_me_before_patterns,
+            env: Vec::new(),
+            workspace_ignore: PrimeIgnore::default(),
+            fs,
+        }
+    }
+
+    /// (Re)loads the workspace's .primeignore rules
+    pub fn load_workspace_ignore(&mut self, workspace: &Path) -> Result<()> {
+        self.workspace_ignore = PrimeIgnore::load(workspace)?;
+        Ok(())
+    }
+
+    pub fn has_workspace_ignore_rules(&self) -> bool {
+        !self.workspace_ignore.is_empty()
     }
 
     // -------------------------------------------------- //
@@ -76,26 +101,13 @@ impl CommandProcessor {
         // let current_dir = working_dir.unwrap_or_else(|| Path::new("."));
         // println!("{}", format!("Executing in '{}': {}", current_dir.display(), command).cyan());
 
-        for pattern in &self.ask_me_before_patterns {
-            if command.contains(pattern) {
-                println!("{}", format!("DANGEROUS COMMAND DETECTED: '{}' matches safety pattern '{}'.", command, pattern).bold().red());
-                print!("Do you want to continue? (y/N): ");
-                std::io::stdout().flush().context("Failed to flush stdout")?;
-
-                let mut line = String::new();
-                std::io::stdin().read_line(&mut line).context("Failed to read user input")?;
-                if !line.trim().eq_ignore_ascii_case("y") {
-                    return Ok((-1, "Command cancelled by user.".into()));
-                }
-            }
-        }
-
         let current_dir = working_dir.unwrap_or_else(|| Path::new("."));
         let mut args = self.shell_args.clone();
         args.push(command.to_string());
 
         let output = Command::new(&self.shell_command)
             .args(&args)
+            .envs(self.env.iter().map(|(k, v)| (k, v)))
             .current_dir(current_dir)
             .stdout(Stdio::piped())
             .stderr(Stdio::piped())
@@ -122,24 +134,58 @@ impl CommandProcessor {
         Ok((exit_code, String::from_utf8_lossy(&merged).into()))
     }
 
+    /// Runs `command` with the configured shell, passing stdio through; used by `prime exec`
+    pub fn run_command_inherited(&self, command: &str, working_dir: &Path) -> Result<i32> {
+        let mut args = self.shell_args.clone();
+        args.push(command.to_string());
+        let status = Command::new(&self.shell_command)
+            .args(&args)
+            .current_dir(working_dir)
+            .status()
+            .with_context(|| format!("Failed to execute command: {}", command))?;
+        Ok(status.code().unwrap_or(-1))
+    }
+
     // -------------------------------------------------- //
     // File helpers
     // -------------------------------------------------- //
 
     pub fn read_file_to_string_with_limit(&self, path: &Path, line_range: Option<(usize, usize)>) -> Result<(String, bool)> {
-        read_file_to_string_with_limit(path, line_range)
+        if self.workspace_ignore.is_ignored(path) {
+            return Err(anyhow!("Access to {} is blocked by .primeignore", path.display()));
+        }
+        read_file_to_string_with_limit(self.fs.as_ref(), path, line_range)
     }
 
     pub fn write_file_to_path(&self, path: &Path, content: &str, append: bool) -> Result<()> {
-        write_file(path, content, append)
+        write_file(self.fs.as_ref(), path, content, append)
     }
 
     pub fn list_directory_smart(&self, path: &Path) -> Result<Vec<String>> {
-        list_directory_smart(path, &self.ignored_path_patterns)
+        if self.workspace_ignore.is_ignored(path) {
+            return Err(anyhow!("Access to {} is blocked by .primeignore", path.display()));
+        }
+        list_directory_smart(self.fs.as_ref(), path, &self.ignored_path_patterns, &self.workspace_ignore)
     }
 
-    pub fn is_command_destructive(&self, command: &str) -> bool {
-        self.ask_me_before_patterns.iter().any(|pattern| command.contains(pattern))
+    /// Sets (or with None removes) an environment variable for executed commands
+    pub fn set_env(&mut self, key: &str, value: Option<String>) {
+        self.env.retain(|(k, _)| k != key);
+        if let Some(value) = value {
+            self.env.push((key.to_string(), value));
+        }
+    }
+
+    /// True for paths hidden from listings: ignored_paths.txt patterns or .primeignore
+    pub fn is_path_excluded(&self, path: &Path) -> bool {
+        let file_name = path.file_name().map(|n| n.to_string_lossy().to_string()).unwrap_or_default();
+        self.ignored_path_patterns.iter().any(|p| p.matches_path(path) || p.matches(&file_name))
+            || self.workspace_ignore.is_ignored(path)
+    }
+
+    /// Patterns that mark a command destructive; the risk policy confirms them before execution
+    pub fn ask_me_before_patterns(&self) -> &[String] {
+        &self.ask_me_before_patterns
     }
 }
 
@@ -147,9 +193,7 @@ impl CommandProcessor {
 // Stand‑alone utility functions – small & pure for easy unit testing
 // ---------------------------------------------------------------------
 
-fn read_file_to_string_with_limit(path: &Path, line_range: Option<(usize, usize)>) -> Result<(String, bool)> {
-    let file = fs::File::open(path).with_context(|| format!("Failed to open file: {}", path.display()))?;
-    let reader = BufReader::new(file);
+fn read_file_to_string_with_limit(fs: &dyn FileSystem, path: &Path, line_range: Option<(usize, usize)>) -> Result<(String, bool)> {
     let content: String;
     let truncated: bool;
 
@@ -157,13 +201,9 @@ fn read_file_to_string_with_limit(path: &Path, line_range: Option<(usize, usize)
         if start == 0 || start > end {
             return Err(anyhow!("Invalid line range: start must be >= 1 and start <= end. Got start={} end={}", start, end));
         }
-        let all_lines: Vec<_> = reader.lines().enumerate().map(|(i, l)| {
-            l.with_context(|| format!("Failed to read line {} from file: {}", i + 1, path.display()))
-                .unwrap_or_else(|e| {
-                    eprintln!("Warning: {}", e);
-                    String::new()
-                })
-        }).collect();
+        let bytes = fs.read(path).with_context(|| format!("Failed to open file: {}", path.display()))?;
+        let text = String::from_utf8_lossy(&bytes);
+        let all_lines: Vec<String> = text.lines().map(str::to_string).collect();
         let total_lines = all_lines.len();
 
         if start > total_lines {
@@ -181,12 +221,8 @@ fn read_file_to_string_with_limit(path: &Path, line_range: Option<(usize, usize)
             truncated = end < total_lines;
         }
     } else {
-        let metadata = fs::metadata(path)?;
-        if metadata.len() > MAX_FILE_READ_BYTES {
-            let mut limited_reader = BufReader::new(fs::File::open(path).with_context(|| format!("Failed to open file for reading (size limit): {}", path.display()))?).take(MAX_FILE_READ_BYTES);
-            let mut buffer = Vec::new();
-            limited_reader.read_to_end(&mut buffer).with_context(|| format!("Failed to read file content (size limit): {}", path.display()))?;
-
+        let (buffer, longer) = fs.read_head(path, MAX_FILE_READ_BYTES).with_context(|| format!("Failed to open file: {}", path.display()))?;
+        if longer {
             truncated = true;
             if looks_binary(&buffer) {
                 content = "[binary data omitted]".into();
@@ -196,9 +232,7 @@ fn read_file_to_string_with_limit(path: &Path, line_range: Option<(usize, usize)
                 content = lines.join("\n");
             }
         } else {
-            let mut tmp = String::new();
-            BufReader::new(fs::File::open(path).with_context(|| format!("Failed to open file for reading: {}", path.display()))?).read_to_string(&mut tmp).with_context(|| format!("Failed to read file content: {}", path.display()))?;
-            content = tmp;
+            content = String::from_utf8(buffer).with_context(|| format!("Failed to read file content: {}", path.display()))?;
             truncated = false;
         }
     }
@@ -211,43 +245,35 @@ fn read_file_to_string_with_limit(path: &Path, line_range: Option<(usize, usize)
     Ok((final_content, truncated))
 }
 
-fn write_file(path: &Path, content: &str, append: bool) -> Result<()> {
+fn write_file(fs: &dyn FileSystem, path: &Path, content: &str, append: bool) -> Result<()> {
     if let Some(parent) = path.parent() {
-        if !parent.exists() {
-            fs::create_dir_all(parent).with_context(|| format!("Failed to create directories for: {}", path.display()))?;
+        if !parent.as_os_str().is_empty() && !fs.exists(parent) {
+            fs.create_dir_all(parent).with_context(|| format!("Failed to create directories for: {}", path.display()))?;
         }
     }
 
-    let mut file = fs::OpenOptions::new()
-        .write(true)
-        .create(true)
-        .append(append)
-        .truncate(!append)
-        .open(path)
-        .with_context(|| format!("Failed to open file for writing: {}", path.display()))?;
-
-    file.write_all(content.as_bytes())
+    fs.write(path, content.as_bytes(), append)
         .with_context(|| format!("Failed to write to file: {}", path.display()))
 }
 
-fn list_directory_smart(path: &Path, ignored_patterns: &[Pattern]) -> Result<Vec<String>> {
-    if !path.is_dir() {
+fn list_directory_smart(fs: &dyn FileSystem, path: &Path, ignored_patterns: &[Pattern], workspace_ignore: &PrimeIgnore) -> Result<Vec<String>> {
+    if !fs.is_dir(path) {
         return Err(anyhow!("Path is not a directory: {}", path.display()));
     }
 
-    let entries = fs::read_dir(path).with_context(|| format!("Failed to read directory: {}", path.display()))?;
+    let entries = fs.read_dir(path).with_context(|| format!("Failed to read directory: {}", path.display()))?;
 
     let mut items = Vec::new();
-    for entry_result in entries {
-        let entry = entry_result.with_context(|| format!("Error reading directory entry in {}", path.display()))?;
-        let entry_path = entry.path();
-        let file_name = entry.file_name().to_string_lossy().to_string();
+    for (file_name, is_dir) in entries {
+        let entry_path = path.join(&file_name);
 
-        if ignored_patterns.iter().any(|p| p.matches_path(&entry_path) || p.matches(&file_name)) {
+        if ignored_patterns.iter().any(|p| p.matches_path(&entry_path) || p.matches(&file_name))
+            || workspace_ignore.is_ignored(&entry_path)
+        {
             continue;
         }
 
-        let display_name = if entry_path.is_dir() { format!("{}/", file_name) } else { file_name };
+        let display_name = if is_dir { format!("{}/", file_name) } else { file_name };
         items.push(display_name);
     }
 
@@ -1,13 +1,14 @@
 use anyhow::{anyhow, Context, Result};
-use crossterm::style::Stylize;
+use crate::terminal::Stylize;
 use glob::Pattern;
 use serde::{Deserialize, Serialize};
 use std::{
     fs,
-    io::{BufRead, BufReader},
     path::{Path, PathBuf},
 };
 
+use crate::vfs::{FileSystem, RealFs};
+
 const CONFIG_FILENAME: &str = "config.toml";
 const IGNORED_PATHS_FILENAME: &str = "ignored_paths.txt";
 const ASK_ME_BEFORE_PATTERNS_FILENAME: &str = "ask_me_before_patterns.txt";
@@ -40,16 +41,193 @@ pub struct Config {
     pub temperature: f32,
     #[serde(default = "default_max_tokens")]
     pub max_tokens: u32,
+    /// Follow-up requests made when a response stops mid code block (0 = off)
+    #[serde(default = "default_max_continuations")]
+    pub max_continuations: usize,
     #[serde(default = "default_api_key")]
     pub gemini_api_key: String,
     #[serde(default = "default_api_key")]
     pub ollama_api_key: String,
+    /// Language of the REPL interface (en, es, de, fr)
+    #[serde(default = "default_ui_language")]
+    pub ui_language: String,
+    /// How new session logs are stored: markdown (`.md`) or json (JSON Lines, `.jsonl`)
+    #[serde(default = "default_log_format")]
+    pub log_format: String,
+    /// Colored output: auto (off for NO_COLOR, TERM=dumb and consoles without ANSI support), always or never
+    #[serde(default = "default_color")]
+    pub color: String,
+    /// Language the assistant answers and writes memory in; unset follows the user
+    #[serde(default)]
+    pub response_language: Option<String>,
+    /// Record local usage analytics to ~/.prime/analytics.jsonl (never uploaded)
+    #[serde(default)]
+    pub analytics: bool,
+    /// Pace streamed responses to this many characters per second (0 = as fast as they arrive)
+    #[serde(default)]
+    pub typewriter_cps: u32,
+    /// Start sessions in step mode (pause after every action)
+    #[serde(default)]
+    pub step_mode: bool,
+    /// Ask a clarifying question instead of acting when the model is unsure of a plan (see `!clarify`)
+    #[serde(default)]
+    pub clarify_first: bool,
+    /// Ask the model whether warnings printed by successful commands need follow-up
+    #[serde(default)]
+    pub warning_follow_up: bool,
+    /// Seconds without a first token before the spinner reports a loading or stalled model (0 = never)
+    #[serde(default = "default_stall_warning_secs")]
+    pub stall_warning_secs: u64,
+    /// How destructive plans are approved when stdin is not a terminal: deny, trusted or file
+    #[serde(default = "default_headless_approval")]
+    pub headless_approval: String,
+    /// File or FIFO an operator writes `approve <id>` / `deny <id>` to (headless_approval = "file")
+    #[serde(default)]
+    pub approval_file: Option<String>,
+    /// Glob patterns of actions (e.g. "shell: git push*") approved under headless_approval = "trusted";
+    /// chained commands must each match, and redirections, substitutions and subshells never do
+    #[serde(default)]
+    pub trusted_commands: Vec<String>,
+    /// Seconds to wait for a decision in the approval file before denying
+    #[serde(default = "default_approval_timeout_secs")]
+    pub approval_timeout_secs: u64,
+    /// Default vault directory for `prime export obsidian`
+    #[serde(default)]
+    pub obsidian_vault: Option<String>,
+    /// Snapshot non-git workspaces before each turn that runs actions (see `!restore-files`)
+    #[serde(default = "default_true")]
+    pub file_snapshots: bool,
+    /// Files larger than this are left out of snapshots
+    #[serde(default = "default_snapshot_max_file_bytes")]
+    pub snapshot_max_file_bytes: u64,
+    /// Risk tiers: how each tier is confirmed and which commands belong to it
+    #[serde(default)]
+    pub risk: RiskConfig,
+    /// Experimental voice input (see `!voice`)
+    #[serde(default)]
+    pub voice: VoiceConfig,
+}
+
+/// Behavior per risk tier: "auto", "confirm", "deny" or "phrase" (type the tier name)
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct RiskLevels {
+    #[serde(default = "default_level_auto")]
+    pub read_only: String,
+    #[serde(default = "default_level_auto")]
+    pub mutating: String,
+    #[serde(default = "default_level_confirm")]
+    pub network_egress: String,
+    #[serde(default = "default_level_confirm")]
+    pub destructive: String,
+    #[serde(default = "default_level_phrase")]
+    pub credential_access: String,
+}
+
+/// Extra case-insensitive substrings that place a command in a tier, on top of the built-in rules
+#[derive(Serialize, Deserialize, Debug, Clone, Default)]
+pub struct RiskRules {
+    #[serde(default)]
+    pub read_only: Vec<String>,
+    #[serde(default)]
+    pub mutating: Vec<String>,
+    #[serde(default)]
+    pub network_egress: Vec<String>,
+    #[serde(default)]
+    pub destructive: Vec<String>,
+    #[serde(default)]
+    pub credential_access: Vec<String>,
+}
+
+#[derive(Serialize, Deserialize, Debug, Clone, Default)]
+pub struct RiskConfig {
+    /// Ask the model to classify commands that no rule matched (can only raise the tier)
+    #[serde(default)]
+    pub model_classification: bool,
+    #[serde(default)]
+    pub levels: RiskLevels,
+    #[serde(default)]
+    pub rules: RiskRules,
+}
+
+/// Voice input: a recorder command captures the microphone, a speech-to-text server transcribes it
+#[derive(Serialize, Deserialize, Debug, Clone)]
+pub struct VoiceConfig {
+    /// Voice input is off unless enabled here
+    #[serde(default)]
+    pub enabled: bool,
+    /// Transcription endpoint taking a multipart `file`: a whisper.cpp server (`/inference`)
+    /// or an OpenAI-compatible `/v1/audio/transcriptions`
+    #[serde(default = "default_stt_url")]
+    pub stt_url: String,
+    /// Bearer token for hosted endpoints
+    #[serde(default)]
+    pub api_key: Option<String>,
+    /// Model name sent to OpenAI-compatible endpoints (e.g. "whisper-1")
+    #[serde(default)]
+    pub model: Option<String>,
+    /// Spoken language hint (e.g. "en"); detected by the server when unset
+    #[serde(default)]
+    pub language: Option<String>,
+    /// Command recording 16 kHz mono WAV to `{file}` for `{seconds}` seconds
+    #[serde(default = "default_record_command")]
+    pub record_command: String,
+    /// Recording length when `!voice` is given no duration
+    #[serde(default = "default_voice_seconds")]
+    pub seconds: u64,
+}
+
+fn default_stt_url() -> String { "http://127.0.0.1:8080/inference".to_string() }
+fn default_voice_seconds() -> u64 { 8 }
+
+#[cfg(target_os = "linux")]
+fn default_record_command() -> String { "arecord -q -f S16_LE -r 16000 -c 1 -d {seconds} {file}".to_string() }
+
+#[cfg(not(target_os = "linux"))]
+fn default_record_command() -> String { "sox -q -d -r 16000 -c 1 -b 16 {file} trim 0 {seconds}".to_string() }
+
+impl Default for VoiceConfig {
+    fn default() -> Self {
+        Self {
+            enabled: false,
+            stt_url: default_stt_url(),
+            api_key: None,
+            model: None,
+            language: None,
+            record_command: default_record_command(),
+            seconds: default_voice_seconds(),
+        }
+    }
+}
+
+fn default_level_auto() -> String { "auto".to_string() }
+fn default_level_confirm() -> String { "confirm".to_string() }
+fn default_level_phrase() -> String { "phrase".to_string() }
+
+impl Default for RiskLevels {
+    fn default() -> Self {
+        Self {
+            read_only: default_level_auto(),
+            mutating: default_level_auto(),
+            network_egress: default_level_confirm(),
+            destructive: default_level_confirm(),
+            credential_access: default_level_phrase(),
+        }
+    }
 }
 
 fn default_provider() -> String { "google".to_string() }
 fn default_temperature() -> f32 { 0.2 }
 fn default_max_tokens() -> u32 { 8192 } // Increased for more complex plans
+fn default_max_continuations() -> usize { 2 }
+fn default_snapshot_max_file_bytes() -> u64 { 1_000_000 }
+fn default_true() -> bool { true }
 fn default_api_key() -> String { "".to_string() }
+fn default_ui_language() -> String { "en".to_string() }
+fn default_color() -> String { "auto".to_string() }
+fn default_log_format() -> String { "markdown".to_string() }
+fn default_stall_warning_secs() -> u64 { 8 }
+fn default_headless_approval() -> String { "deny".to_string() }
+fn default_approval_timeout_secs() -> u64 { 300 }
 
 impl Default for Config {
     fn default() -> Self {
@@ -58,8 +236,28 @@ impl Default for Config {
             model: None,
             temperature: default_temperature(),
             max_tokens: default_max_tokens(),
+            max_continuations: default_max_continuations(),
             gemini_api_key: default_api_key(),
             ollama_api_key: default_api_key(),
+            ui_language: default_ui_language(),
+            color: default_color(),
+            log_format: default_log_format(),
+            response_language: None,
+            analytics: false,
+            typewriter_cps: 0,
+            step_mode: false,
+            clarify_first: false,
+            warning_follow_up: false,
+            stall_warning_secs: default_stall_warning_secs(),
+            headless_approval: default_headless_approval(),
+            approval_file: None,
+            trusted_commands: Vec::new(),
+            approval_timeout_secs: default_approval_timeout_secs(),
+            obsidian_vault: None,
+            file_snapshots: true,
+            snapshot_max_file_bytes: default_snapshot_max_file_bytes(),
+            risk: RiskConfig::default(),
+            voice: VoiceConfig::default(),
         }
     }
 }
@@ -89,7 +287,7 @@ pub fn load_config() -> Result<Config> {
         let has_ollama_key = std::env::var("OLLAMA_API_KEY").is_ok();
 
         if !has_gemini_key && !has_ollama_key {
-            println!("{}", format!("Configuration file created at {}. Please edit it to add your API keys.", config_path.display()).yellow());
+            eprintln!("{}", format!("Configuration file created at {}. Please edit it to add your API keys.", config_path.display()).yellow());
         }
         return Ok(default_config);
     }
@@ -103,13 +301,29 @@ pub fn load_config() -> Result<Config> {
     Ok(config)
 }
 
-fn get_prime_config_dir() -> Result<PathBuf> {
-    dirs::home_dir()
+pub fn get_prime_config_dir() -> Result<PathBuf> {
+    prime_config_dir(&RealFs)
+}
+
+/// `.prime` under the home directory of `fs`
+pub fn prime_config_dir(fs: &dyn FileSystem) -> Result<PathBuf> {
+    fs.home_dir()
         .ok_or_else(|| anyhow!("Could not determine home directory"))
         .map(|home| home.join(".prime"))
 }
 
+/// Expands a leading `~` to the home directory; other paths are returned unchanged
+pub fn expand_home(path: &str) -> PathBuf {
+    match (path.strip_prefix('~'), dirs::home_dir()) {
+        (Some(rest), Some(home)) if rest.is_empty() || rest.starts_with('/') || rest.starts_with('\\') => {
+            home.join(rest.trim_start_matches(['/', '\\']))
+        }
+        _ => PathBuf::from(path),
+    }
+}
+
 fn load_patterns_from_file(
+    fs: &dyn FileSystem,
     config_dir: &Path,
     filename: &str,
     default_patterns: &[&str],
@@ -117,15 +331,11 @@ fn load_patterns_from_file(
     let file_path = config_dir.join(filename);
     let mut patterns = Vec::new();
 
-    if file_path.exists() {
-        let file = fs::File::open(&file_path)
-            .with_context(|| format!("Failed to open pattern file: {}", file_path.display()))?;
-        let reader = BufReader::new(file);
-        for line in reader.lines() {
-            let line_content = line.with_context(|| {
-                format!("Failed to read line from pattern file: {}", file_path.display())
-            })?;
-            let trimmed_line = line_content.trim();
+    if fs.exists(&file_path) {
+        let content = fs.read_to_string(&file_path)
+            .with_context(|| format!("Failed to read pattern file: {}", file_path.display()))?;
+        for line in content.lines() {
+            let trimmed_line = line.trim();
             if !trimmed_line.is_empty() && !trimmed_line.starts_with('#') {
                 patterns.push(trimmed_line.to_string());
             }
@@ -134,23 +344,23 @@ fn load_patterns_from_file(
 
     if patterns.is_empty() {
         patterns = default_patterns.iter().map(|s| s.to_string()).collect();
-        if !config_dir.exists() {
-            fs::create_dir_all(config_dir).with_context(|| {
+        if !fs.is_dir(config_dir) {
+            fs.create_dir_all(config_dir).with_context(|| {
                 format!("Failed to create Prime config directory: {}", config_dir.display())
             })?;
         }
         let default_content = default_patterns.join("\n");
-        fs::write(&file_path, default_content).with_context(|| {
+        fs.write(&file_path, default_content.as_bytes(), false).with_context(|| {
             format!("Failed to write default patterns to {}", file_path.display())
         })?;
     }
     Ok(patterns)
 }
 
-pub fn load_ignored_path_patterns() -> Result<Vec<Pattern>> {
-    let config_dir = get_prime_config_dir()?;
+/// Ignored path patterns from `config_dir` on `fs`, written with the defaults on first use
+pub fn load_ignored_path_patterns(fs: &dyn FileSystem, config_dir: &Path) -> Result<Vec<Pattern>> {
     let string_patterns =
-        load_patterns_from_file(&config_dir, IGNORED_PATHS_FILENAME, DEFAULT_IGNORED_PATHS)?;
+        load_patterns_from_file(fs, config_dir, IGNORED_PATHS_FILENAME, DEFAULT_IGNORED_PATHS)?;
 
     string_patterns
         .iter()
@@ -158,10 +368,11 @@ pub fn load_ignored_path_patterns() -> Result<Vec<Pattern>> {
         .collect()
 }
 
-pub fn load_ask_me_before_patterns() -> Result<Vec<String>> {
-    let config_dir = get_prime_config_dir()?;
+/// "Ask me before" patterns from `config_dir` on `fs`, written with the defaults on first use
+pub fn load_ask_me_before_patterns(fs: &dyn FileSystem, config_dir: &Path) -> Result<Vec<String>> {
     load_patterns_from_file(
-        &config_dir,
+        fs,
+        config_dir,
         ASK_ME_BEFORE_PATTERNS_FILENAME,
         DEFAULT_ASK_ME_BEFORE_PATTERNS,
     )
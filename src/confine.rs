@@ -0,0 +1,152 @@
+//! Confinement of file writes to the workspace
+//! A write target is resolved the way the OS will resolve it before anything is
+//! written: `.` and `..` are applied, and symlinks in the part of the path that exists
+//! are followed, including a dangling link that would create its target. Targets that
+//! land outside the workspace are refused, so neither `../../etc/profile`, an absolute
+//! path, `..\` on Windows, nor a link planted inside the workspace can reach other
+//! files. `write_file: <path> outside_workspace=true` asks the user instead.
+
+use std::fmt;
+use std::fs;
+use std::path::{Component, Path, PathBuf};
+
+/// Links followed before a chain is treated as a loop
+const MAX_LINKS: usize = 40;
+
+/// A write target that resolves outside the workspace
+#[derive(Debug, Clone, PartialEq)]
+pub struct Escape {
+    pub requested: String,
+    /// Where the write would actually go
+    pub resolved: PathBuf,
+    pub root: PathBuf,
+    /// The path looks inside the workspace but a symlink leads out of it
+    pub via_symlink: bool,
+}
+
+impl fmt::Display for Escape {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "'{}' resolves to {}", self.requested, self.resolved.display())?;
+        if self.via_symlink {
+            write!(f, " through a symlink")?;
+        }
+        write!(f, ", outside the workspace {}", self.root.display())
+    }
+}
+
+impl Escape {
+    /// Tool output for a refused write
+    pub fn refusal(&self) -> String {
+        format!(
+            "Refused to write: {}. Nothing was written. Write inside the workspace, or add outside_workspace=true to the write_file line if the user asked for this location; the user will be asked to confirm.",
+            self
+        )
+    }
+}
+
+/// Applies `.` and `..` without touching the filesystem; `..` never climbs above the root
+fn normalize(path: &Path) -> PathBuf {
+    let mut normalized = PathBuf::new();
+    for component in path.components() {
+        match component {
+            Component::CurDir => {}
+            Component::ParentDir => {
+                normalized.pop();
+            }
+            other => normalized.push(other.as_os_str()),
+        }
+    }
+    normalized
+}
+
+/// Where a write to `path` lands: symlinks in its existing part are resolved, and the
+/// part that does not exist yet is appended as it is
+fn resolve_links(path: &Path) -> PathBuf {
+    let mut path = normalize(path);
+    for _ in 0..MAX_LINKS {
+        let mut existing = path.clone();
+        let mut missing = Vec::new();
+        loop {
+            if let Ok(canonical) = existing.canonicalize() {
+                return missing.iter().rev().fold(canonical, |resolved, part| resolved.join(part));
+            }
+            // A dangling link does not canonicalize, but writing through it creates its target.
+            if let Ok(target) = fs::read_link(&existing) {
+                let base = existing.parent().map(Path::to_path_buf).unwrap_or_default();
+                let relinked = missing.iter().rev().fold(base.join(target), |resolved, part| resolved.join(part));
+                path = normalize(&relinked);
+                break;
+            }
+            match (existing.file_name().map(|name| name.to_os_string()), existing.parent()) {
+                (Some(name), Some(parent)) => {
+                    missing.push(name);
+                    existing = parent.to_path_buf();
+                }
+                _ => return path,
+            }
+        }
+    }
+    path
+}
+
+/// Path to write for `requested`, relative to `working_dir`, or the escape when it
+/// resolves outside `root`
+pub fn resolve_write(root: &Path, working_dir: &Path, requested: &str) -> Result<PathBuf, Escape> {
+    let target = normalize(&working_dir.join(requested));
+    let resolved = resolve_links(&target);
+    let lexical_root = normalize(root);
+    let real_root = root.canonicalize().unwrap_or_else(|_| lexical_root.clone());
+    if resolved.starts_with(&real_root) {
+        return Ok(target);
+    }
+    let via_symlink = target.starts_with(&lexical_root) || target.starts_with(&real_root);
+    Err(Escape { requested: requested.to_string(), resolved, root: real_root, via_symlink })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_writes_stay_in_the_workspace() {
+        let base = std::env::temp_dir().join(format!("prime_confine_{}", std::process::id()));
+        let _ = fs::remove_dir_all(&base);
+        let (root, outside) = (base.join("workspace"), base.join("outside"));
+        fs::create_dir_all(root.join("src")).unwrap();
+        fs::create_dir_all(&outside).unwrap();
+        let src = root.join("src");
+
+        assert_eq!(resolve_write(&root, &src, "lib.rs").unwrap(), src.join("lib.rs"));
+        assert_eq!(resolve_write(&root, &src, "../new/dir/a.txt").unwrap(), root.join("new/dir/a.txt"));
+        assert_eq!(resolve_write(&root, &root, "./src/../b.txt").unwrap(), root.join("b.txt"));
+        let escape = resolve_write(&root, &src, "../../outside/x.txt").unwrap_err();
+        assert_eq!(escape.resolved, outside.canonicalize().unwrap().join("x.txt"));
+        assert!(!escape.via_symlink);
+        assert!(resolve_write(&root, &root, "/etc/profile").is_err());
+        assert!(resolve_write(&root, &root, "../../../../../../tmp/x").is_err());
+        // Outside the workspace after `cd ..`, even a plain name escapes.
+        assert!(resolve_write(&root, &base, "notes.txt").is_err());
+        let _ = fs::remove_dir_all(&base);
+    }
+
+    #[cfg(unix)]
+    #[test]
+    fn test_symlinks_out_of_the_workspace_are_caught() {
+        use std::os::unix::fs::symlink;
+        let base = std::env::temp_dir().join(format!("prime_confine_links_{}", std::process::id()));
+        let _ = fs::remove_dir_all(&base);
+        let (root, outside) = (base.join("workspace"), base.join("outside"));
+        fs::create_dir_all(&root).unwrap();
+        fs::create_dir_all(&outside).unwrap();
+        symlink(&outside, root.join("linked")).unwrap();
+        symlink(outside.join("created.txt"), root.join("dangling")).unwrap();
+        symlink(root.join("real.txt"), root.join("inner")).unwrap();
+
+        let escape = resolve_write(&root, &root, "linked/x.txt").unwrap_err();
+        assert!(escape.via_symlink);
+        assert!(escape.to_string().contains("through a symlink"));
+        assert_eq!(resolve_write(&root, &root, "dangling").unwrap_err().resolved, outside.canonicalize().unwrap().join("created.txt"));
+        assert!(resolve_write(&root, &root, "inner").is_ok());
+        let _ = fs::remove_dir_all(&base);
+    }
+}
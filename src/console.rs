@@ -1,9 +1,12 @@
 
 use std::borrow::Cow;
-use std::io::{self, Write};
+use std::io::{self, IsTerminal};
 use std::path::PathBuf;
 use anyhow::{Context, Result};
-use crossterm::style::Stylize;
+use crossterm::cursor::{MoveRight, MoveTo, MoveUp};
+use crossterm::execute;
+use crate::terminal::Stylize;
+use crossterm::terminal::{Clear, ClearType};
 use rustyline::completion::{Completer, Pair};
 use rustyline::error::ReadlineError;
 use rustyline::highlight::Highlighter;
@@ -11,7 +14,18 @@ use rustyline::hint::Hinter;
 use rustyline::history::DefaultHistory;
 use rustyline::validate::Validator;
 use rustyline::{Context as RustylineContext, Editor, Helper};
+use crate::config;
+use crate::dataset::Rating;
+use crate::display;
+use crate::i18n::{tr, Msg};
+use crate::opener;
+use crate::pr;
+use crate::recording::Recording;
 use crate::session::PrimeSession;
+use crate::status;
+use crate::stdin;
+use crate::terminal;
+use crate::voice;
 use std::env;
 
 const BANNER: &str = r#"
@@ -21,16 +35,22 @@ const BANNER: &str = r#"
 pub fn display_banner() {
     println!("{}", BANNER.bold().white());
     let version = env!("CARGO_PKG_VERSION");
-    print!("\x1B[2A\x1B[25C");
+    // Version and PWD sit beside the logo unless the terminal cannot position the cursor.
+    let beside_logo = !terminal::is_plain();
+    if beside_logo {
+        let _ = execute!(io::stdout(), MoveUp(2), MoveRight(25));
+    }
     let vtag = format!(" V{} ", version);
     println!("{}", vtag.on_white().black().bold());
     let pwd = std::env::current_dir()
         .unwrap_or_else(|_| PathBuf::from("."))
         .display()
         .to_string();
-    print!("\x1B[25C");
+    if beside_logo {
+        let _ = execute!(io::stdout(), MoveRight(25));
+    }
     println!("{} {}", "PWD".bold().white(), pwd.cyan());
-    println!("{}", "━".repeat(70).dark_grey());
+    println!("{}", display::rule(display::layout_width()).dark_grey());
 }
 
 pub fn display_init_info(
@@ -39,11 +59,11 @@ pub fn display_init_info(
     prime_config_base_dir: &PathBuf,
     workspace_dir: &PathBuf,
 ) {
-    println!("model {}", model);
-    println!("provider {}", provider);
-    println!("configuration {}", prime_config_base_dir.display());
-    println!("workspace {}", workspace_dir.display());
-    println!("{}", "━".repeat(70).dark_grey());
+    println!("{} {}", tr(Msg::LabelModel), model);
+    println!("{} {}", tr(Msg::LabelProvider), provider);
+    println!("{} {}", tr(Msg::LabelConfiguration), prime_config_base_dir.display());
+    println!("{} {}", tr(Msg::LabelWorkspace), workspace_dir.display());
+    println!("{}", display::rule(display::layout_width()).dark_grey());
 }
 
 pub async fn run_repl(mut session: PrimeSession) -> Result<()> {
@@ -51,9 +71,7 @@ pub async fn run_repl(mut session: PrimeSession) -> Result<()> {
         .context("Failed to initialize rustyline editor")?;
     editor.set_helper(Some(PrimeHelper {}));
    
-    let prime_config_dir = dirs::home_dir()
-        .ok_or_else(|| anyhow::anyhow!("Could not determine home directory"))?
-        .join(".prime");
+    let prime_config_dir = config::prime_config_dir(session.fs().as_ref())?;
     let history_file = prime_config_dir.join("history.txt");
    
     if history_file.exists() {
@@ -63,28 +81,29 @@ pub async fn run_repl(mut session: PrimeSession) -> Result<()> {
     }
     let prompt = "» ".to_string();
     loop {
-        match editor.readline(&prompt) {
+        let line = match session.pending_input.take() {
+            Some(text) => editor.readline_with_initial(&prompt, (&text, "")),
+            None => editor.readline(&prompt),
+        };
+        match line {
             Ok(line) => {
                 let _ = editor.add_history_entry(line.as_str());
                 let input = line.trim();
                 if input.is_empty() {
                     continue;
                 }
-                if input.eq_ignore_ascii_case("exit") || input.eq_ignore_ascii_case("quit") {
+                if !handle_line(input, &mut session).await? {
                     break;
                 }
-                if input.starts_with('!') {
-                    if !handle_special_command(&input[1..], &mut session)? {
-                        break;
-                    }
-                    continue;
-                }
-                if let Err(e) = session.process_input(input).await {
-                    eprintln!("{}", format!("[ERROR] {}", e).red());
-                }
+            }
+            // A terminal that went away (closed window, dropped SSH connection) ends the
+            // session like Ctrl-D, keeping the history, rather than as an input error.
+            Err(_) if !io::stdin().is_terminal() => {
+                eprintln!("{}", tr(Msg::TerminalDetached).yellow());
+                break;
             }
             Err(ReadlineError::Interrupted) => {
-                println!("\n{}", "Interrupted. Type 'exit' or Ctrl-D to exit.".yellow());
+                println!("\n{}", tr(Msg::Interrupted).yellow());
             }
             Err(ReadlineError::Eof) => break,
             Err(err) => {
@@ -93,6 +112,7 @@ pub async fn run_repl(mut session: PrimeSession) -> Result<()> {
             }
         }
     }
+    stop_recording(&mut session);
    
     if !prime_config_dir.exists() {
         std::fs::create_dir_all(&prime_config_dir).unwrap_or_else(|e| {
@@ -107,36 +127,88 @@ pub async fn run_repl(mut session: PrimeSession) -> Result<()> {
     Ok(())
 }
 
-fn handle_special_command(cmd_line: &str, session: &mut PrimeSession) -> Result<bool> {
+/// Runs one line of input; returns false when the session should end
+async fn handle_line(input: &str, session: &mut PrimeSession) -> Result<bool> {
+    if input.eq_ignore_ascii_case("exit") || input.eq_ignore_ascii_case("quit") {
+        return Ok(false);
+    }
+    if let Some(command) = input.strip_prefix('!') {
+        return handle_special_command(command, session).await;
+    }
+    if let Err(e) = session.process_input(input).await {
+        eprintln!("{}", format!("[ERROR] {}", e).red());
+    }
+    Ok(true)
+}
+
+/// Session for a stdin that is not a terminal: one prompt or `!command` per line until EOF
+pub async fn run_headless(mut session: PrimeSession) -> Result<()> {
+    let mut reader = io::BufReader::new(io::stdin());
+    while let Some(line) = stdin::next_line(&mut reader).context("Failed to read standard input")? {
+        if !handle_line(&line, &mut session).await? {
+            break;
+        }
+    }
+    stop_recording(&mut session);
+    Ok(())
+}
+
+/// Finishes the `!record` cast, if one is running, and says where it went
+fn stop_recording(session: &mut PrimeSession) {
+    match session.recording.take().map(Recording::stop) {
+        Some(Ok((path, _))) => println!("{} {}", tr(Msg::RecordingSaved).green(), path.display()),
+        Some(Err(e)) => eprintln!("{}", format!("Warning: Failed to finish the recording: {:#}", e).yellow()),
+        None => {}
+    }
+}
+
+async fn handle_special_command(cmd_line: &str, session: &mut PrimeSession) -> Result<bool> {
     let parts: Vec<&str> = cmd_line.splitn(2, ' ').collect();
     let command = parts[0].to_lowercase();
     let args = if parts.len() > 1 { parts[1] } else { "" };
     match command.as_str() {
         "clear" | "cls" => {
-            print!("\x1B[2J\x1B[1;1H");
-            io::stdout().flush().context("Failed to flush stdout for clear command")?;
+            execute!(io::stdout(), Clear(ClearType::All), MoveTo(0, 0)).context("Failed to clear the screen")?;
             Ok(true)
         }
         "help" => {
-            println!("{}", "Available Special Commands:".white().bold());
-            println!(" {:<25} - Show this help message.", "!help".cyan());
-            println!(" {:<25} - Clear the terminal screen.", "!clear | !cls".cyan());
-            println!(
-                " {:<25} - Show the full conversation log.",
-                "!log".cyan()
-            );
-            println!(
-                " {:<25} - Read long-term or short-term memory.",
-                "!memory [long|short]".cyan()
-            );
-            println!(" {:<25} - List all available tools.", "!tools".cyan());
-            println!(" {:<25} - Exit Prime.", "!exit | !quit".cyan());
+            println!("{}", tr(Msg::HelpTitle).white().bold());
+            println!(" {:<25} - {}", "!help".cyan(), tr(Msg::HelpHelp));
+            println!(" {:<25} - {}", "!clear | !cls".cyan(), tr(Msg::HelpClear));
+            println!(" {:<25} - {}", "!log".cyan(), tr(Msg::HelpLog));
+            println!(" {:<25} - {}", "!memory [long|short]".cyan(), tr(Msg::HelpMemory));
+            println!(" {:<25} - {}", "!remember <text>".cyan(), tr(Msg::HelpRemember));
+            println!(" {:<25} - {}", "!tools".cyan(), tr(Msg::HelpTools));
+            println!(" {:<25} - {}", "!status".cyan(), tr(Msg::HelpStatus));
+            println!(" {:<25} - {}", "!context".cyan(), tr(Msg::HelpContext));
+            println!(" {:<25} - {}", "!set <param> <value>".cyan(), tr(Msg::HelpSet));
+            println!(" {:<25} - {}", "!step [on|off]".cyan(), tr(Msg::HelpStep));
+            println!(" {:<25} - {}", "!clarify [on|off]".cyan(), tr(Msg::HelpClarify));
+            println!(" {:<25} - {}", "!record start|stop".cyan(), tr(Msg::HelpRecord));
+            println!(" {:<25} - {}", "!voice [seconds]".cyan(), tr(Msg::HelpVoice));
+            println!(" {:<25} - {}", "!open [n|path|url]".cyan(), tr(Msg::HelpOpen));
+            println!(" {:<25} - {}", "!export [path]".cyan(), tr(Msg::HelpExport));
+            println!(" {:<25} - {}", "!restore-files [turn]".cyan(), tr(Msg::HelpRestoreFiles));
+            println!(" {:<25} - {}", "!keep-tmp".cyan(), tr(Msg::HelpKeepTmp));
+            println!(" {:<25} - {}", "!pin [msg <n>]".cyan(), tr(Msg::HelpPin));
+            println!(" {:<25} - {}", "!unpin <n>".cyan(), tr(Msg::HelpUnpin));
+            println!(" {:<25} - {}", "!pr".cyan(), tr(Msg::HelpPr));
+            println!(" {:<25} - {}", "!rate good|bad [comment]".cyan(), tr(Msg::HelpRate));
+            println!(" {:<25} - {}", "!exit | !quit".cyan(), tr(Msg::HelpExit));
             Ok(true)
         }
         "log" => {
             match session.list_messages() {
                 Ok(content) => println!("{}", content),
-                Err(e) => eprintln!("{}", format!("Error reading log: {}", e).red()),
+                Err(e) => eprintln!("{}", format!("{} {}", tr(Msg::ErrorReadingLog), e).red()),
+            }
+            Ok(true)
+        }
+        "export" => {
+            let path = Some(args.trim()).filter(|p| !p.is_empty());
+            match session.export_transcript(path) {
+                Ok(target) => println!("{} {}", tr(Msg::TranscriptExported).green(), target.display()),
+                Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
             }
             Ok(true)
         }
@@ -150,7 +222,19 @@ fn handle_special_command(cmd_line: &str, session: &mut PrimeSession) -> Result<
             };
             match session.read_memory(memory_type) {
                 Ok(content) => println!("{}", content),
-                Err(e) => eprintln!("{}", format!("Error reading memory: {}", e).red()),
+                Err(e) => eprintln!("{}", format!("{} {}", tr(Msg::ErrorReadingMemory), e).red()),
+            }
+            Ok(true)
+        }
+        "remember" => {
+            let text = args.trim();
+            if text.is_empty() {
+                eprintln!("{}", "Error: Usage: !remember <text>".red());
+            } else {
+                match session.write_memory("long_term", text) {
+                    Ok(()) => println!("{}", tr(Msg::Remembered).green()),
+                    Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+                }
             }
             Ok(true)
         }
@@ -158,19 +242,251 @@ fn handle_special_command(cmd_line: &str, session: &mut PrimeSession) -> Result<
             println!("{}", session.list_tools());
             Ok(true)
         }
+        "context" => {
+            println!("{}", tr(Msg::ContextTitle).white().bold());
+            let outline = session.context_outline()?;
+            let rows: Vec<(&str, String)> = outline.iter().map(|(label, tokens)| (label.as_str(), format!("~{}", tokens))).collect();
+            for row in status::format_rows(&rows) {
+                println!("{}", row);
+            }
+            Ok(true)
+        }
+        "set" => {
+            let rest = args.trim();
+            if rest.is_empty() {
+                println!("{} {}", tr(Msg::LabelParams), session.params());
+                return Ok(true);
+            }
+            let (name, value) = rest.split_once(char::is_whitespace).unwrap_or((rest, ""));
+            match session.set_param(name, value) {
+                Ok(()) => println!("{} {}", tr(Msg::ParamsUpdated).green(), session.params()),
+                Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+            }
+            Ok(true)
+        }
+        "status" => {
+            println!("{}", tr(Msg::StatusTitle).white().bold());
+            for row in status::format_rows(&session.status_report()) {
+                println!("{}", row);
+            }
+            Ok(true)
+        }
+        "step" => {
+            session.step_mode = match args.trim() {
+                "on" => true,
+                "off" => false,
+                _ => !session.step_mode,
+            };
+            let message = if session.step_mode { tr(Msg::StepModeOn) } else { tr(Msg::StepModeOff) };
+            println!("{}", message.yellow());
+            Ok(true)
+        }
+        "clarify" => {
+            session.clarify_first = match args.trim() {
+                "on" => true,
+                "off" => false,
+                _ => !session.clarify_first,
+            };
+            let message = if session.clarify_first { tr(Msg::ClarifyOn) } else { tr(Msg::ClarifyOff) };
+            println!("{}", message.yellow());
+            Ok(true)
+        }
+        "record" => {
+            let mut parts = args.split_whitespace();
+            match (parts.next(), session.recording.as_ref()) {
+                (Some("start"), Some(recording)) => println!("{} {}", tr(Msg::RecordingActive).yellow(), recording.path.display()),
+                (Some("start"), None) => {
+                    let path = match parts.next() {
+                        Some(file) => session.working_dir.join(file),
+                        None => session.working_dir.join(format!("{}.cast", session.session_id)),
+                    };
+                    match Recording::start(&path, &format!("Prime {}", session.session_id)) {
+                        Ok(recording) => {
+                            println!("{} {}", tr(Msg::RecordingStarted).yellow(), path.display());
+                            session.recording = Some(recording);
+                        }
+                        Err(e) => eprintln!("{}", format!("Error: {:#}", e).red()),
+                    }
+                }
+                (Some("stop"), Some(_)) => stop_recording(session),
+                (_, Some(recording)) => println!("{} {}", tr(Msg::RecordingActive).yellow(), recording.path.display()),
+                (_, None) => println!("{}", tr(Msg::RecordingNotActive).yellow()),
+            }
+            Ok(true)
+        }
+        "voice" => {
+            if !session.voice.enabled {
+                println!("{}", tr(Msg::VoiceDisabled).yellow());
+                return Ok(true);
+            }
+            if !io::stdin().is_terminal() {
+                eprintln!("{}", tr(Msg::VoiceNeedsTerminal).yellow());
+                return Ok(true);
+            }
+            let seconds = args.trim().parse::<u64>().unwrap_or(session.voice.seconds).max(1);
+            println!("{} {}", tr(Msg::VoiceListening).cyan(), seconds);
+            match voice::listen(&session.voice, seconds).await {
+                Ok(text) => {
+                    println!("{}", tr(Msg::VoiceConfirm).dark_grey());
+                    session.pending_input = Some(text);
+                }
+                Err(e) => eprintln!("{}", format!("Error: {:#}", e).red()),
+            }
+            Ok(true)
+        }
+        "open" => {
+            if args.trim().is_empty() {
+                if session.open_targets.is_empty() {
+                    println!("{}", tr(Msg::NoOpenTargets).yellow());
+                } else {
+                    println!("{}", tr(Msg::OpenTargetsTitle).white().bold());
+                    for (i, target) in session.open_targets.iter().enumerate() {
+                        println!(" {:>3}  {}", (i + 1).to_string().cyan(), target);
+                    }
+                }
+                return Ok(true);
+            }
+            match opener::resolve_target(args, &session.open_targets, &session.working_dir)
+                .and_then(|target| opener::open_target(&target).map(|_| target))
+            {
+                Ok(target) => println!("{} {}", "→".cyan(), target),
+                Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+            }
+            Ok(true)
+        }
+        "restore-files" => {
+            let turn = args.trim();
+            if turn.is_empty() {
+                match session.list_file_snapshots() {
+                    Ok(snapshots) if snapshots.is_empty() => println!("{}", tr(Msg::NoFileSnapshots).yellow()),
+                    Ok(snapshots) => {
+                        println!("{}", tr(Msg::FileSnapshotsTitle).white().bold());
+                        for info in snapshots {
+                            println!(" {:>4}  {}  {}", info.turn.to_string().cyan(), info.taken_at, info.files);
+                        }
+                    }
+                    Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+                }
+                return Ok(true);
+            }
+            let result = turn
+                .parse::<usize>()
+                .map_err(|_| anyhow::anyhow!("Usage: !restore-files [turn]"))
+                .and_then(|turn| session.restore_files(turn).map(|summary| (turn, summary)));
+            match result {
+                Ok((turn, summary)) => println!(
+                    "{} {} (↺ {} · ✕ {})",
+                    tr(Msg::FilesRestored).green(),
+                    turn,
+                    summary.restored,
+                    summary.removed
+                ),
+                Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+            }
+            Ok(true)
+        }
+        "keep-tmp" => {
+            match session.keep_scratch() {
+                Some(dir) => println!("{} {}", tr(Msg::ScratchKept).green(), dir.display()),
+                None => println!("{}", tr(Msg::NoScratchDir).yellow()),
+            }
+            Ok(true)
+        }
+        "pin" => {
+            let rest = args.trim();
+            if rest.is_empty() {
+                let pinned = session.pinned_messages();
+                if pinned.is_empty() {
+                    println!("{}", tr(Msg::NoPinnedMessages).yellow());
+                } else {
+                    println!("{}", tr(Msg::PinnedMessagesTitle).white().bold());
+                    for (number, title, preview) in pinned {
+                        println!(" {:>4}  {:<14}  {}", number.to_string().cyan(), title, preview);
+                    }
+                }
+                return Ok(true);
+            }
+            let number = rest.strip_prefix("msg").map(str::trim).unwrap_or(rest);
+            if number.is_empty() {
+                println!("{}", tr(Msg::SessionMessagesTitle).white().bold());
+                for (number, title, preview) in session.message_outline() {
+                    println!(" {:>4}  {:<14}  {}", number.to_string().cyan(), title, preview);
+                }
+                return Ok(true);
+            }
+            let result = number
+                .parse::<usize>()
+                .map_err(|_| anyhow::anyhow!("Usage: !pin msg <n>"))
+                .and_then(|number| session.pin_message(number).map(|preview| (number, preview)));
+            match result {
+                Ok((number, preview)) => println!("{} {}: {}", tr(Msg::MessagePinned).green(), number, preview),
+                Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+            }
+            Ok(true)
+        }
+        "unpin" => {
+            let number = args.trim();
+            let number = number.strip_prefix("msg").map(str::trim).unwrap_or(number);
+            match number.parse::<usize>() {
+                Ok(number) if session.unpin_message(number) => println!("{} {}", tr(Msg::MessageUnpinned).green(), number),
+                Ok(_) => println!("{}", tr(Msg::MessageNotPinned).yellow()),
+                Err(_) => eprintln!("{}", "Error: Usage: !unpin <n>".red()),
+            }
+            Ok(true)
+        }
+        "rate" => {
+            match Rating::parse(args).and_then(|rating| session.rate_last_turn(&rating).map(|prompt| (rating, prompt))) {
+                Ok((rating, prompt)) => {
+                    let verdict = if rating.good { tr(Msg::RatedGood) } else { tr(Msg::RatedBad) };
+                    println!("{} {}", verdict.green(), prompt);
+                }
+                Err(e) => eprintln!("{}", format!("Error: {}", e).red()),
+            }
+            Ok(true)
+        }
+        "pr" => {
+            if let Err(e) = open_pull_request(session).await {
+                eprintln!("{}", format!("Error: {}", e).red());
+            }
+            Ok(true)
+        }
         "exit" | "quit" => Ok(false),
         _ => {
             println!(
-                "{} Unknown command: !{}. Type {} for help.",
+                "{} {} !{}. {}",
                 "Error:".red(),
+                tr(Msg::UnknownCommand),
                 command,
-                "!help".cyan()
+                tr(Msg::TypeHelpForHelp)
             );
             Ok(true)
         }
     }
 }
 
+/// Drafts a commit and PR for the session's changes, then commits and publishes on confirmation
+async fn open_pull_request(session: &mut PrimeSession) -> Result<()> {
+    println!("{}", tr(Msg::DraftingPr).dim());
+    let draft = session.draft_pr().await?;
+    println!("{}", tr(Msg::CommitMessageTitle).white().bold());
+    println!("{}\n", draft.commit_message);
+    println!("{}", tr(Msg::PullRequestTitle).white().bold());
+    println!("{}\n\n{}\n", draft.pr_title.clone().bold(), draft.pr_body);
+    println!("{}", tr(Msg::FilesToCommitTitle).white().bold());
+    println!("{}\n", pr::pending_changes(&session.working_dir)?);
+    if !display::prompt_confirmation(tr(Msg::ConfirmCommit), false)? {
+        return Ok(());
+    }
+    let branch = pr::commit(&session.working_dir, &draft.commit_message)?;
+    println!("{} {}", tr(Msg::ChangesCommitted).green(), branch);
+    if !display::prompt_confirmation(tr(Msg::ConfirmPublish), false)? {
+        return Ok(());
+    }
+    let url = pr::publish(&session.working_dir, &draft)?;
+    println!("{} {}", tr(Msg::PullRequestOpened).green(), url);
+    Ok(())
+}
+
 pub struct PrimeHelper {}
 
 impl Helper for PrimeHelper {}
@@ -211,7 +527,7 @@ impl Hinter for PrimeHelper {
         }
         let commands = [
             "exit", "quit", "!help", "!clear", "!cls", "!log",
-            "!memory", "!memory long", "!memory short", "!tools"
+            "!memory", "!memory long", "!memory short", "!remember", "!tools", "!status", "!context", "!set", "!step", "!clarify", "!record start", "!record stop", "!voice", "!open", "!export", "!restore-files", "!keep-tmp", "!pin", "!pin msg", "!unpin", "!pr", "!rate good", "!rate bad"
         ];
         for cmd in commands {
             if cmd.starts_with(line) && line.len() < cmd.len() {
@@ -241,7 +557,24 @@ impl Completer for PrimeHelper {
                 ("!memory", "memory"),
                 ("!memory long", "memory long"),
                 ("!memory short", "memory short"),
+                ("!remember", "remember"),
                 ("!tools", "tools"),
+                ("!status", "status"),
+                ("!context", "context"),
+                ("!set", "set"),
+                ("!step", "step"),
+                ("!clarify", "clarify"),
+                ("!record", "record"),
+                ("!voice", "voice"),
+                ("!open", "open"),
+                ("!export", "export"),
+                ("!restore-files", "restore-files"),
+                ("!keep-tmp", "keep-tmp"),
+                ("!pin", "pin"),
+                ("!pin msg", "pin msg"),
+                ("!unpin", "unpin"),
+                ("!pr", "pr"),
+                ("!rate", "rate"),
                 ("!exit", "exit"),
                 ("!quit", "quit"),
             ];
@@ -0,0 +1,88 @@
+//! Continuation of responses cut off at the output limit
+//! The chat interface does not expose the provider's stop reason, so a response counts
+//! as truncated when it leaves a ``` fence open. The follow-up part is stitched onto
+//! the first, dropping a re-opened fence or text the model repeated.
+
+/// Longest repeated text looked for where two parts meet
+const MAX_OVERLAP: usize = 400;
+/// Shorter matches are likely coincidence (a closing brace, a newline)
+const MIN_OVERLAP: usize = 12;
+
+fn is_fence(line: &str) -> bool {
+    line.trim_start().starts_with("```")
+}
+
+/// True when the response ends inside a fenced block
+pub fn is_truncated(text: &str) -> bool {
+    text.lines().filter(|line| is_fence(line)).count() % 2 == 1
+}
+
+pub fn continuation_prompt(partial: &str) -> String {
+    let tail: String = {
+        let chars: Vec<char> = partial.trim_end().chars().collect();
+        chars[chars.len().saturating_sub(80)..].iter().collect()
+    };
+    format!(
+        "Your previous response was cut off at the output limit. It ended with:\n{}\n\n\
+         Continue exactly where it stopped. Do not repeat anything already written, do not reopen the code block, \
+         and close every open ``` fence when you are done.",
+        tail
+    )
+}
+
+/// Joins `continuation` onto `partial`, skipping a re-opened fence and repeated text
+pub fn stitch(partial: &str, continuation: &str) -> String {
+    let mut rest = continuation;
+    let first_line = rest.lines().next().unwrap_or_default();
+    let reopened = is_fence(first_line) && first_line.trim() != "```";
+    if reopened {
+        // The model restarted the unfinished block (```lang); keep the body only.
+        rest = rest[first_line.len()..].trim_start_matches(['\r', '\n']);
+    }
+    let overlap = (MIN_OVERLAP..=MAX_OVERLAP.min(rest.len()).min(partial.len()))
+        .rev()
+        .filter(|&k| rest.is_char_boundary(k))
+        .find(|&k| partial.ends_with(&rest[..k]))
+        .unwrap_or(0);
+    // A restarted block begins on a fresh line even when the cut came mid-line.
+    let separator = if reopened && overlap == 0 && !partial.ends_with('\n') { "\n" } else { "" };
+    format!("{}{}{}", partial, separator, &rest[overlap..])
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_unclosed_fence_is_truncated() {
+        assert!(is_truncated("Plan:\n```primeactions\nwrite_file: a.rs\nfn main"));
+        assert!(!is_truncated("Plan:\n```primeactions\nshell: ls\n```\nDone."));
+        assert!(!is_truncated("No code here."));
+    }
+
+    #[test]
+    fn test_stitch_drops_reopened_fence_and_overlap() {
+        let partial = "```primeactions\nwrite_file: a.rs\nfn main() {\n    println!(\"hi\");";
+        let continuation = "```primeactions\n    println!(\"hi\");\n}\nEOF_PRIME\n```";
+        assert_eq!(
+            stitch(partial, continuation),
+            "```primeactions\nwrite_file: a.rs\nfn main() {\n    println!(\"hi\");\n}\nEOF_PRIME\n```"
+        );
+    }
+
+    #[test]
+    fn test_stitch_restarted_block_without_overlap() {
+        assert_eq!(stitch("```primeactions
+shell: ls", "```primeactions
+shell: pwd
+```"), "```primeactions
+shell: ls
+shell: pwd
+```");
+    }
+
+    #[test]
+    fn test_stitch_appends_plain_continuation() {
+        assert_eq!(stitch("```\nlet x = 1", ";\n```"), "```\nlet x = 1;\n```");
+    }
+}
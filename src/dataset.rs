@@ -0,0 +1,192 @@
+//! Turn ratings and their export as a fine-tuning dataset
+//! `!rate good|bad [comment]` appends a `Rating` entry to the session log, after the
+//! turn it judges; a later rating of the same turn replaces the earlier one. The
+//! exporter walks all session logs and writes one JSON line per rated turn with the
+//! prompt, the final response, the full exchange and the rating.
+
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Context, Result};
+use serde::Serialize;
+use crate::terminal::Stylize;
+
+use crate::clock;
+use crate::metadata::MetadataStore;
+use crate::params::ModelParams;
+use crate::session::LogEntry;
+use crate::vault;
+
+/// Log entry title under which ratings are stored
+pub const RATING_TITLE: &str = "Rating";
+/// File written in the working directory when no path is given
+pub const DEFAULT_FILE: &str = "prime-dataset.jsonl";
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Rating {
+    pub good: bool,
+    pub comment: String,
+}
+
+impl Rating {
+    /// Reads `good|bad [comment]` as typed after `!rate`
+    pub fn parse(args: &str) -> Result<Self> {
+        let (verdict, comment) = args.trim().split_once(char::is_whitespace).unwrap_or((args.trim(), ""));
+        let good = match verdict.to_lowercase().as_str() {
+            "good" | "+" | "up" => true,
+            "bad" | "-" | "down" => false,
+            _ => return Err(anyhow!("Usage: !rate good|bad [comment]")),
+        };
+        Ok(Self { good, comment: comment.trim().to_string() })
+    }
+
+    fn label(&self) -> &'static str {
+        if self.good {
+            "good"
+        } else {
+            "bad"
+        }
+    }
+
+    /// Content of the log entry: the verdict, then the comment on the next line
+    pub fn to_log(&self) -> String {
+        match self.comment.is_empty() {
+            true => self.label().to_string(),
+            false => format!("{}\n{}", self.label(), self.comment),
+        }
+    }
+
+    fn from_log(content: &str) -> Option<Self> {
+        let (verdict, comment) = content.split_once('\n').unwrap_or((content, ""));
+        let good = match verdict.trim() {
+            "good" => true,
+            "bad" => false,
+            _ => return None,
+        };
+        Some(Self { good, comment: comment.trim().to_string() })
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct Message {
+    pub role: &'static str,
+    pub content: String,
+}
+
+/// One line of the dataset
+#[derive(Debug, Clone, PartialEq, Serialize)]
+pub struct RatedTurn {
+    pub session: String,
+    pub timestamp: String,
+    pub prompt: String,
+    pub response: String,
+    /// The turn as a chat: the prompt, each response, and tool output fed back as user turns
+    pub messages: Vec<Message>,
+    pub rating: &'static str,
+    #[serde(skip_serializing_if = "String::is_empty")]
+    pub comment: String,
+    /// Generation parameters set for the session, when any were
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub params: Option<ModelParams>,
+}
+
+/// Turns of one session that carry a rating
+pub fn rated_turns(session: &str, entries: &[LogEntry]) -> Vec<RatedTurn> {
+    let mut turns = Vec::new();
+    let starts: Vec<usize> = entries.iter().enumerate().filter(|(_, e)| e.title == "User Input").map(|(i, _)| i).collect();
+    for (n, &start) in starts.iter().enumerate() {
+        let turn = &entries[start..starts.get(n + 1).copied().unwrap_or(entries.len())];
+        let Some(rating) = turn.iter().rev().filter(|e| e.title == RATING_TITLE).find_map(|e| Rating::from_log(&e.content))
+        else {
+            continue;
+        };
+        let messages: Vec<Message> = turn
+            .iter()
+            .filter_map(|entry| match entry.title.as_str() {
+                "User Input" | "Tool Results" | "Tool Failure" => Some(Message { role: "user", content: entry.content.clone() }),
+                "Prime Response" => Some(Message { role: "assistant", content: entry.content.clone() }),
+                _ => None,
+            })
+            .collect();
+        let Some(response) = messages.iter().rev().find(|m| m.role == "assistant").map(|m| m.content.clone()) else {
+            continue;
+        };
+        turns.push(RatedTurn {
+            session: session.to_string(),
+            timestamp: turn[0].timestamp.clone(),
+            prompt: turn[0].content.clone(),
+            response,
+            messages,
+            rating: rating.label(),
+            comment: rating.comment,
+            params: None,
+        });
+    }
+    turns
+}
+
+/// Writes every rated turn under `base_dir` to `path`; returns the counts of good and bad turns
+pub fn export_dataset(base_dir: &Path, path: &Path) -> Result<(usize, usize)> {
+    let mut lines = Vec::new();
+    let (mut good, mut bad) = (0, 0);
+    let session_params = MetadataStore::new(base_dir, clock::system()).load()?.session_params;
+    for session in vault::load_sessions(&base_dir.join("conversations"))? {
+        for mut turn in rated_turns(&session.id, &session.entries) {
+            turn.params = session_params.get(&session.id).cloned();
+            if turn.rating == "good" {
+                good += 1;
+            } else {
+                bad += 1;
+            }
+            lines.push(serde_json::to_string(&turn)?);
+        }
+    }
+    let mut content = lines.join("\n");
+    if !content.is_empty() {
+        content.push('\n');
+    }
+    fs::write(path, content).with_context(|| format!("Failed to write {}", path.display()))?;
+    Ok((good, bad))
+}
+
+pub fn run_export(base_dir: &Path, path: Option<PathBuf>) -> Result<()> {
+    let path = path.unwrap_or_else(|| PathBuf::from(DEFAULT_FILE));
+    let (good, bad) = export_dataset(base_dir, &path)?;
+    if good + bad == 0 {
+        println!("{}", "No rated turns yet. Rate a turn with !rate good|bad [comment] in a session.".yellow());
+        return Ok(());
+    }
+    println!("{}", format!("Exported {} rated turns ({} good, {} bad) to {}", good + bad, good, bad, path.display()).green());
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::session::parse_log_entries;
+
+    const LOG: &str = "\n## User Input (2025-06-07 17:54:46)\n```\nlist files\n```\n\n## Prime Response (2025-06-07 17:54:50)\n```\nListing.\n```\n\n## Tool Results (2025-06-07 17:54:52)\n```\na.txt\n```\n\n## Prime Response (2025-06-07 17:54:53)\n```\nThere is one file, a.txt.\n```\n\n## Rating (2025-06-07 17:55:00)\n```\nbad\nshould use ls -la\n```\n\n## Rating (2025-06-07 17:55:10)\n```\ngood\n```\n\n## User Input (2025-06-07 17:56:00)\n```\nthanks\n```\n\n## Prime Response (2025-06-07 17:56:01)\n```\nYou're welcome.\n```\n";
+
+    #[test]
+    fn test_rating_parse_and_round_trip() {
+        let rating = Rating::parse("bad  missed the hidden files ").unwrap();
+        assert_eq!(rating, Rating { good: false, comment: "missed the hidden files".to_string() });
+        assert_eq!(Rating::from_log(&rating.to_log()), Some(rating));
+        assert_eq!(Rating::parse("GOOD").unwrap().to_log(), "good");
+        assert!(Rating::parse("meh").is_err());
+        assert!(Rating::parse("").is_err());
+    }
+
+    #[test]
+    fn test_only_rated_turns_are_exported_with_the_latest_rating() {
+        let turns = rated_turns("session_1", &parse_log_entries(LOG));
+        assert_eq!(turns.len(), 1);
+        let turn = &turns[0];
+        assert_eq!((turn.prompt.as_str(), turn.response.as_str()), ("list files", "There is one file, a.txt."));
+        assert_eq!((turn.rating, turn.comment.as_str()), ("good", ""));
+        assert_eq!(turn.messages.iter().map(|m| m.role).collect::<Vec<_>>(), vec!["user", "assistant", "user", "assistant"]);
+        let line = serde_json::to_string(turn).unwrap();
+        assert!(line.starts_with(r#"{"session":"session_1","timestamp":"2025-06-07 17:54:46","prompt":"list files""#), "{}", line);
+        assert!(!line.contains("comment"));
+    }
+}
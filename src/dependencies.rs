@@ -0,0 +1,239 @@
+//! Summary of the project's declared dependencies for the system prompt
+//! Manifests and lockfiles in the working directory (Cargo, npm, Go modules, pip
+//! requirements) are reduced to the direct dependencies with the versions actually in
+//! use, so generated code targets those APIs. The summary is rebuilt only when one of
+//! the files changes.
+
+use std::collections::HashMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
+
+use serde_json::Value;
+
+/// Dependencies listed per manifest before the rest is counted as "+N more"
+const MAX_PER_MANIFEST: usize = 30;
+/// Files that feed the summary; any change to them triggers a rebuild
+const WATCHED: &[&str] = &["Cargo.toml", "Cargo.lock", "package.json", "package-lock.json", "go.mod", "requirements.txt"];
+
+/// Direct dependencies of one manifest as `name version` pairs
+#[derive(Debug)]
+struct Manifest {
+    label: String,
+    dependencies: Vec<(String, String)>,
+}
+
+/// Cached summary for a directory, keyed on the modification times of the watched files
+#[derive(Debug, Default)]
+pub struct DependencySummary {
+    dir: PathBuf,
+    signature: Vec<Option<SystemTime>>,
+    text: String,
+}
+
+impl DependencySummary {
+    /// Rebuilds the summary when `dir` or one of its manifests changed since the last call
+    pub fn refresh(&mut self, dir: &Path) {
+        let signature: Vec<Option<SystemTime>> =
+            WATCHED.iter().map(|name| fs::metadata(dir.join(name)).and_then(|m| m.modified()).ok()).collect();
+        if dir == self.dir && signature == self.signature {
+            return;
+        }
+        self.text = summarize(dir);
+        self.dir = dir.to_path_buf();
+        self.signature = signature;
+    }
+
+    /// Prompt section, empty when the directory has no recognized manifest
+    pub fn text(&self) -> &str {
+        &self.text
+    }
+}
+
+/// Prompt section describing the dependencies found in `dir`
+pub fn summarize(dir: &Path) -> String {
+    let manifests: Vec<Manifest> = [cargo(dir), npm(dir), go_mod(dir), requirements(dir)]
+        .into_iter()
+        .flatten()
+        .filter(|manifest| !manifest.dependencies.is_empty())
+        .collect();
+    if manifests.is_empty() {
+        return String::new();
+    }
+    let mut out = "Project Dependencies (versions in use; write code against these, not newer or older APIs):".to_string();
+    for manifest in manifests {
+        let shown: Vec<String> = manifest
+            .dependencies
+            .iter()
+            .take(MAX_PER_MANIFEST)
+            .map(|(name, version)| if version.is_empty() { name.clone() } else { format!("{} {}", name, version) })
+            .collect();
+        out.push_str(&format!("\n- {}: {}", manifest.label, shown.join(", ")));
+        if manifest.dependencies.len() > MAX_PER_MANIFEST {
+            out.push_str(&format!(" (+{} more)", manifest.dependencies.len() - MAX_PER_MANIFEST));
+        }
+    }
+    out
+}
+
+/// Direct dependencies from Cargo.toml, with resolved versions from Cargo.lock when present
+fn cargo(dir: &Path) -> Option<Manifest> {
+    let manifest: toml::Value = toml::from_str(&fs::read_to_string(dir.join("Cargo.toml")).ok()?).ok()?;
+    let locked: HashMap<String, String> = fs::read_to_string(dir.join("Cargo.lock"))
+        .ok()
+        .and_then(|lock| toml::from_str::<toml::Value>(&lock).ok())
+        .and_then(|lock| lock.get("package")?.as_array().cloned())
+        .unwrap_or_default()
+        .iter()
+        .filter_map(|package| Some((package.get("name")?.as_str()?.to_string(), package.get("version")?.as_str()?.to_string())))
+        .collect();
+    let mut dependencies = Vec::new();
+    for table in ["dependencies", "dev-dependencies", "build-dependencies"] {
+        let Some(entries) = manifest.get(table).and_then(|t| t.as_table()) else { continue };
+        for (name, spec) in entries {
+            // `foo = { package = "bar" }` renames a crate; the lockfile knows it as bar.
+            let package = spec.get("package").and_then(|p| p.as_str()).unwrap_or(name);
+            let version = locked
+                .get(package)
+                .cloned()
+                .or_else(|| spec.as_str().or_else(|| spec.get("version")?.as_str()).map(str::to_string))
+                .unwrap_or_default();
+            dependencies.push((name.clone(), version));
+        }
+    }
+    let label = if locked.is_empty() { "Cargo.toml" } else { "Cargo.lock" };
+    Some(Manifest { label: label.to_string(), dependencies })
+}
+
+/// Direct dependencies from package.json, with installed versions from package-lock.json
+fn npm(dir: &Path) -> Option<Manifest> {
+    let read_json = |name: &str| -> Option<Value> { serde_json::from_str(&fs::read_to_string(dir.join(name)).ok()?).ok() };
+    let package = read_json("package.json");
+    let lock = read_json("package-lock.json");
+    if package.is_none() && lock.is_none() {
+        return None;
+    }
+    // The root package of a v2/v3 lockfile repeats the manifest's dependency lists.
+    let root = package.clone().or_else(|| lock.as_ref()?.pointer("/packages/").cloned()).unwrap_or(Value::Null);
+    let locked_version = |name: &str| -> Option<String> {
+        let lock = lock.as_ref()?;
+        lock.pointer(&format!("/packages/node_modules~1{}/version", name.replace('~', "~0").replace('/', "~1")))
+            .or_else(|| lock.get("dependencies")?.get(name)?.get("version"))
+            .and_then(Value::as_str)
+            .map(str::to_string)
+    };
+    let mut dependencies = Vec::new();
+    for field in ["dependencies", "devDependencies"] {
+        let Some(entries) = root.get(field).and_then(Value::as_object) else { continue };
+        for (name, range) in entries {
+            let version = locked_version(name).or_else(|| range.as_str().map(str::to_string)).unwrap_or_default();
+            dependencies.push((name.clone(), version));
+        }
+    }
+    let label = if lock.is_some() { "package-lock.json" } else { "package.json" };
+    Some(Manifest { label: label.to_string(), dependencies })
+}
+
+/// Required modules from go.mod, leaving out those marked `// indirect`
+fn go_mod(dir: &Path) -> Option<Manifest> {
+    let content = fs::read_to_string(dir.join("go.mod")).ok()?;
+    let mut go_version = None;
+    let mut dependencies = Vec::new();
+    let mut in_block = false;
+    for line in content.lines().map(str::trim) {
+        if line.contains("// indirect") {
+            continue;
+        }
+        let requirement = if in_block {
+            if line == ")" {
+                in_block = false;
+                continue;
+            }
+            line
+        } else if let Some(version) = line.strip_prefix("go ") {
+            go_version = Some(version.trim().to_string());
+            continue;
+        } else if line == "require (" {
+            in_block = true;
+            continue;
+        } else if let Some(single) = line.strip_prefix("require ") {
+            single
+        } else {
+            continue;
+        };
+        let mut words = requirement.split_whitespace();
+        if let (Some(module), Some(version)) = (words.next(), words.next()) {
+            if !module.starts_with("//") {
+                dependencies.push((module.to_string(), version.to_string()));
+            }
+        }
+    }
+    let label = match go_version {
+        Some(version) => format!("go.mod (go {})", version),
+        None => "go.mod".to_string(),
+    };
+    Some(Manifest { label, dependencies })
+}
+
+/// Requirements with their version specifiers as written
+fn requirements(dir: &Path) -> Option<Manifest> {
+    let content = fs::read_to_string(dir.join("requirements.txt")).ok()?;
+    let dependencies = content
+        .lines()
+        .map(|line| line.split('#').next().unwrap_or_default().trim())
+        .filter(|line| !line.is_empty() && !line.starts_with('-'))
+        .map(|line| {
+            let line = line.split(';').next().unwrap_or(line).trim();
+            match line.find(|c: char| matches!(c, '=' | '<' | '>' | '~' | '!')) {
+                Some(at) => (line[..at].trim().to_string(), line[at..].replace(' ', "")),
+                None => (line.to_string(), String::new()),
+            }
+        })
+        .collect();
+    Some(Manifest { label: "requirements.txt".to_string(), dependencies })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn temp(name: &str) -> PathBuf {
+        let dir = std::env::temp_dir().join(format!("prime_deps_{}_{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_lockfile_versions_win_over_ranges() {
+        let dir = temp("lock");
+        fs::write(dir.join("Cargo.toml"), "[package]\nname = \"app\"\n[dependencies]\nserde = \"1\"\nlog = { version = \"0.4\" }\n").unwrap();
+        fs::write(dir.join("Cargo.lock"), "[[package]]\nname = \"serde\"\nversion = \"1.0.203\"\n").unwrap();
+        fs::write(dir.join("package.json"), r#"{"dependencies": {"react": "^18.0.0"}, "devDependencies": {"@types/node": "^20"}}"#).unwrap();
+        fs::write(
+            dir.join("package-lock.json"),
+            r#"{"lockfileVersion": 3, "packages": {"": {}, "node_modules/react": {"version": "18.3.1"}, "node_modules/@types/node": {"version": "20.14.2"}}}"#,
+        )
+        .unwrap();
+        let summary = summarize(&dir);
+        assert!(summary.contains("- Cargo.lock: log 0.4, serde 1.0.203"), "{}", summary);
+        assert!(summary.contains("- package-lock.json: react 18.3.1, @types/node 20.14.2"), "{}", summary);
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_go_mod_and_requirements() {
+        let dir = temp("go");
+        fs::write(
+            dir.join("go.mod"),
+            "module example.com/app\n\ngo 1.22\n\nrequire github.com/spf13/cobra v1.8.0\n\nrequire (\n\tgolang.org/x/sys v0.20.0 // indirect\n\tgithub.com/google/uuid v1.6.0\n)\n",
+        )
+        .unwrap();
+        fs::write(dir.join("requirements.txt"), "# web\nflask==3.0.3\nrequests >= 2.31 ; python_version > '3.8'\n-r dev.txt\nnumpy\n").unwrap();
+        let summary = summarize(&dir);
+        assert!(summary.contains("- go.mod (go 1.22): github.com/spf13/cobra v1.8.0, github.com/google/uuid v1.6.0"), "{}", summary);
+        assert!(summary.contains("- requirements.txt: flask ==3.0.3, requests >=2.31, numpy"), "{}", summary);
+        assert!(summarize(&temp("empty")).is_empty());
+        let _ = fs::remove_dir_all(&dir);
+    }
+}
@@ -1,9 +1,68 @@
 //! Enhanced display utilities for rich terminal output
 //! Maintains simple protocol while providing beautiful formatting
 
-use crossterm::style::Stylize;
+use crate::i18n::{tr, Msg};
+use crate::stdin;
+use crate::terminal::{self, Stylize};
 use std::io::{self, Write};
 use std::time::Duration;
+use textwrap::core::display_width;
+
+/// Width used when the terminal size cannot be detected (pipes, CI logs)
+pub const FALLBACK_WIDTH: usize = 70;
+/// Layout never gets narrower than this, even in tiny panes
+pub const MIN_WIDTH: usize = 40;
+/// Prose stays readable on very wide terminals
+pub const MAX_WIDTH: usize = 100;
+
+/// Current layout width. Queried on every call, so a resized terminal (SIGWINCH)
+/// is picked up by the next thing printed.
+pub fn layout_width() -> usize {
+    crossterm::terminal::size()
+        .ok()
+        .map(|(columns, _)| columns as usize)
+        .filter(|&columns| columns > 0)
+        .map(|columns| columns.clamp(MIN_WIDTH, MAX_WIDTH))
+        .unwrap_or(FALLBACK_WIDTH)
+}
+
+/// Full-width separator line
+pub fn rule(width: usize) -> String {
+    "━".repeat(width)
+}
+
+/// Closing edge of an action box: `┗━━━…`
+pub fn box_bottom(width: usize) -> String {
+    format!("┗{}", "━".repeat(width.saturating_sub(1)))
+}
+
+/// Closing edge of an action box carrying a status label: `┗━━━… label ━━━━━`
+pub fn box_bottom_with_label(label: &str, width: usize) -> String {
+    let fill = width.saturating_sub(display_width(label) + 8).max(3);
+    format!("┗{} {} ━━━━━", "━".repeat(fill), label)
+}
+
+/// Footer under executed actions: `╰───… label ────────`
+pub fn completion_bar(label: &str, width: usize) -> String {
+    let fill = width.saturating_sub(display_width(label) + 11).max(3);
+    format!("╰{} {} ────────", "─".repeat(fill), label)
+}
+
+/// Wraps every line of `text` to `width`, repeating `prefix` on continuation lines
+/// so boxed output keeps its left edge when a long line wraps.
+pub fn wrap_prefixed(text: &str, prefix: &str, width: usize) -> Vec<String> {
+    let available = width.saturating_sub(display_width(prefix)).max(1);
+    let options = textwrap::Options::new(available).break_words(true);
+    text.lines()
+        .flat_map(|line| {
+            if line.is_empty() {
+                vec![prefix.trim_end().to_string()]
+            } else {
+                textwrap::wrap(line, &options).into_iter().map(|part| format!("{}{}", prefix, part)).collect()
+            }
+        })
+        .collect()
+}
 
 /// Display styles for different message types
 pub struct DisplayStyle {
@@ -159,11 +218,27 @@ pub fn format_streaming_text(text: &str, width: usize) -> Vec<String> {
         .collect()
 }
 
+/// Where questions to the user are written: stdout, or stderr in quiet mode so they
+/// stay out of piped output
+pub fn prompt_writer() -> Box<dyn Write> {
+    if terminal::is_quiet() {
+        Box::new(io::stderr())
+    } else {
+        Box::new(io::stdout())
+    }
+}
+
 /// Display a confirmation prompt
 pub fn prompt_confirmation(message: &str, default: bool) -> io::Result<bool> {
     let default_str = if default { "Y/n" } else { "y/N" };
-    print!("{} [{}]: ", message.yellow(), default_str);
-    io::stdout().flush()?;
+    let mut out = prompt_writer();
+    write!(out, "{} [{}]: ", message.yellow(), default_str)?;
+    // Piped stdin carries the next prompts, not answers; never consume one as a yes.
+    if !stdin::answers_prompts() {
+        writeln!(out, "{}", tr(Msg::NoTerminalAnswer))?;
+        return Ok(false);
+    }
+    out.flush()?;
 
     let mut input = String::new();
     io::stdin().read_line(&mut input)?;
@@ -177,18 +252,6 @@ pub fn prompt_confirmation(message: &str, default: bool) -> io::Result<bool> {
     })
 }
 
-/// Clear current line (for updating spinners/progress)
-pub fn clear_line() {
-    print!("\r\x1b[K");
-    let _ = io::stdout().flush();
-}
-
-/// Move cursor up N lines
-pub fn cursor_up(n: usize) {
-    print!("\x1b[{}A", n);
-    let _ = io::stdout().flush();
-}
-
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -211,6 +274,24 @@ mod tests {
         assert!(output.contains("Processing"));
     }
 
+    #[test]
+    fn test_bars_fill_the_requested_width() {
+        for width in [40, 70, 100] {
+            assert_eq!(display_width(&box_bottom(width)), width);
+            assert_eq!(display_width(&box_bottom_with_label("destructive", width)), width);
+            assert_eq!(display_width(&completion_bar("completed in 1.2s", width)), width);
+        }
+    }
+
+    #[test]
+    fn test_wrap_prefixed_keeps_prefix_and_width() {
+        let lines = wrap_prefixed("short\n\na-very-long-unbroken-token-that-needs-splitting", "│ ", 20);
+        assert_eq!(lines[0], "│ short");
+        assert_eq!(lines[1], "│");
+        assert!(lines.len() > 3);
+        assert!(lines.iter().all(|l| l.starts_with('│') && display_width(l) <= 20));
+    }
+
     #[test]
     fn test_text_wrapping() {
         let text = "This is a very long line that should be wrapped at the specified width";
@@ -0,0 +1,227 @@
+//! Footnotes linking claims in responses to executed evidence
+//! When a response says tests pass, a file was created, a build succeeded or a package
+//! was installed, the matching tool result from earlier in the session is cited as a
+//! footnote (action, status, exit code, time). The log itself is never rewritten; notes
+//! are added when a transcript is rendered.
+
+use crate::session::LogEntry;
+
+/// One tool result recorded in the session log
+#[derive(Debug, Clone, PartialEq)]
+pub struct Evidence {
+    pub action: String,
+    pub success: bool,
+    pub exit_code: Option<i32>,
+    pub logged_at: String,
+}
+
+impl Evidence {
+    /// Action without the content preview that write_file and create_tool carry
+    pub fn short_action(&self) -> &str {
+        self.action.split(" (content:").next().unwrap_or(&self.action).trim()
+    }
+
+    fn footnote(&self) -> String {
+        let outcome = match (self.exit_code, self.success) {
+            (Some(code), true) => format!("exited {}", code),
+            (Some(code), false) => format!("FAILED, exit {}", code),
+            (None, true) => "succeeded".to_string(),
+            (None, false) => "FAILED".to_string(),
+        };
+        format!("`{}` {} ({})", self.short_action(), outcome, self.logged_at)
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum ClaimKind {
+    Tests,
+    FileWritten,
+    Build,
+    Install,
+}
+
+/// Extracts the tool results of a `Tool Results` or `Tool Failure` log entry
+pub fn collect_evidence(entry: &LogEntry) -> Vec<Evidence> {
+    if entry.title != "Tool Results" && entry.title != "Tool Failure" {
+        return Vec::new();
+    }
+    entry
+        .content
+        .lines()
+        .filter_map(|line| parse_tool_output_header(line.trim(), &entry.timestamp))
+        .collect()
+}
+
+/// Parses `<tool_output id="0" for="shell: ls" status="SUCCESS" exit="0">`. The action can
+/// itself contain quotes, so it runs up to the last ` status=` attribute.
+fn parse_tool_output_header(line: &str, logged_at: &str) -> Option<Evidence> {
+    let rest = line.strip_prefix("<tool_output ")?.strip_suffix('>')?;
+    let for_start = rest.find("for=\"")? + "for=\"".len();
+    let status_at = rest.rfind("\" status=\"")?;
+    let action = rest.get(for_start..status_at)?.to_string();
+    let attributes = &rest[status_at + "\" status=\"".len()..];
+    let success = attributes.starts_with("SUCCESS");
+    let exit_code = attributes
+        .split("exit=\"")
+        .nth(1)
+        .and_then(|v| v.split('"').next())
+        .and_then(|v| v.parse().ok());
+    Some(Evidence { action, success, exit_code, logged_at: logged_at.to_string() })
+}
+
+/// Words of `line` that look like paths: `src/lib.rs`, `notes.txt`, `.\run.ps1`. A
+/// sentence's closing period does not make the word before it a file name.
+fn path_tokens(line: &str) -> Vec<&str> {
+    line.split_whitespace()
+        .map(|word| word.trim_matches(|c: char| "`'\"()[]{}<>,;:!?".contains(c)).trim_end_matches('.'))
+        .filter(|word| {
+            // A two-character stem keeps "e.g" and "i.e" out.
+            word.contains('/')
+                || word.contains('\\')
+                || word.rsplit_once('.').is_some_and(|(stem, extension)| {
+                    stem.chars().count() >= 2 && (1..=8).contains(&extension.len()) && extension.chars().all(|c| c.is_ascii_alphanumeric())
+                })
+        })
+        .collect()
+}
+
+fn claim_kinds(line: &str) -> Vec<ClaimKind> {
+    let lower = line.to_lowercase();
+    let has = |words: &[&str]| words.iter().any(|w| lower.contains(w));
+    let mut kinds = Vec::new();
+    if has(&["test"]) && has(&["pass", "succeed", "green"]) {
+        kinds.push(ClaimKind::Tests);
+    }
+    if has(&["created", "wrote", "written", "saved", "updated"]) && (has(&[" file", " tool"]) || !path_tokens(line).is_empty()) {
+        kinds.push(ClaimKind::FileWritten);
+    }
+    if has(&["build", "compiled", "compiles"]) && has(&["succe", "pass", "compiles", "clean", "without error"]) {
+        kinds.push(ClaimKind::Build);
+    }
+    if has(&["installed"]) {
+        kinds.push(ClaimKind::Install);
+    }
+    kinds
+}
+
+fn supports(kind: ClaimKind, line: &str, evidence: &Evidence) -> bool {
+    let action = evidence.action.to_lowercase();
+    match kind {
+        ClaimKind::Tests => action.starts_with("shell:") && (action.contains("test") || action.contains("spec")),
+        ClaimKind::FileWritten => {
+            if !(action.starts_with("write_file:") || action.starts_with("create_tool:")) {
+                return false;
+            }
+            // Prefer the file the claim names; any write counts when none is named.
+            let target = evidence.short_action().split_whitespace().nth(1).unwrap_or_default();
+            let file_name = target.rsplit(['/', '\\']).next().unwrap_or(target);
+            file_name.is_empty() || line.contains(file_name) || path_tokens(line).is_empty()
+        }
+        ClaimKind::Build => ["build", "compile", "make", "cargo check", "tsc"].iter().any(|w| action.contains(w)),
+        ClaimKind::Install => action.contains("install"),
+    }
+}
+
+/// Appends `[^n]` to lines of `text` that make a supported claim, citing the most recent
+/// matching evidence. Code blocks are left alone. Returns the text and the footnote bodies.
+pub fn add_footnotes(text: &str, evidence: &[Evidence], next_number: &mut usize) -> (String, Vec<String>) {
+    let mut notes = Vec::new();
+    let mut in_code = false;
+    let mut lines = Vec::new();
+    for line in text.lines() {
+        if line.trim_start().starts_with("```") {
+            in_code = !in_code;
+            lines.push(line.to_string());
+            continue;
+        }
+        let mut annotated = line.to_string();
+        if !in_code {
+            for kind in claim_kinds(line) {
+                if let Some(found) = evidence.iter().rev().find(|e| supports(kind, line, e)) {
+                    annotated.push_str(&format!("[^{}]", *next_number));
+                    notes.push(format!("[^{}]: {}", *next_number, found.footnote()));
+                    *next_number += 1;
+                }
+            }
+        }
+        lines.push(annotated);
+    }
+    (lines.join("\n"), notes)
+}
+
+/// Returns the entries with footnotes added to every `Prime Response`, citing evidence
+/// logged earlier in the same session
+pub fn annotate_entries(entries: &[LogEntry]) -> Vec<LogEntry> {
+    let mut evidence = Vec::new();
+    let mut next_number = 1;
+    entries
+        .iter()
+        .map(|entry| {
+            evidence.extend(collect_evidence(entry));
+            if entry.title != "Prime Response" {
+                return entry.clone();
+            }
+            let (content, notes) = add_footnotes(&entry.content, &evidence, &mut next_number);
+            let content = if notes.is_empty() { content } else { format!("{}\n\n{}", content, notes.join("\n")) };
+            LogEntry { content, ..entry.clone() }
+        })
+        .collect()
+}
+
+/// Renders entries in the session log layout with footnotes applied
+pub fn render_transcript(entries: &[LogEntry]) -> String {
+    annotate_entries(entries)
+        .iter()
+        .map(|e| format!("\n## {} ({})\n```\n{}\n```\n", e.title, e.timestamp, e.content))
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(title: &str, timestamp: &str, content: &str) -> LogEntry {
+        LogEntry { title: title.to_string(), timestamp: timestamp.to_string(), content: content.to_string() }
+    }
+
+    #[test]
+    fn test_parse_tool_output_header_with_quotes_and_exit() {
+        let line = r#"<tool_output id="0" for="shell: echo "a b" && cargo test" status="FAILURE" exit="101">"#;
+        let evidence = parse_tool_output_header(line, "10:00").unwrap();
+        assert_eq!(evidence.action, r#"shell: echo "a b" && cargo test"#);
+        assert!(!evidence.success);
+        assert_eq!(evidence.exit_code, Some(101));
+    }
+
+    #[test]
+    fn test_claims_cite_latest_matching_evidence() {
+        let entries = vec![
+            entry("Tool Results", "2025-06-07 10:00:01", "<tool_output id=\"0\" for=\"shell: cargo test\" status=\"FAILURE\" exit=\"101\">\nfailed\n</tool_output>"),
+            entry("Tool Results", "2025-06-07 10:01:00", "<tool_output id=\"0\" for=\"write_file: src/lib.rs append=false (content: \"x\")\" status=\"SUCCESS\">\nok\n</tool_output>\n<tool_output id=\"1\" for=\"shell: cargo test\" status=\"SUCCESS\" exit=\"0\">\nok\n</tool_output>"),
+            entry("Prime Response", "2025-06-07 10:01:05", "I updated the file src/lib.rs.\nAll tests pass now.\n```\ntests pass\n```"),
+        ];
+        let annotated = annotate_entries(&entries);
+        let response = &annotated[2].content;
+        assert!(response.contains("src/lib.rs.[^1]"));
+        assert!(response.contains("All tests pass now.[^2]"));
+        assert!(response.contains("```\ntests pass\n```"));
+        assert!(response.contains("[^1]: `write_file: src/lib.rs append=false` succeeded (2025-06-07 10:01:00)"));
+        assert!(response.contains("[^2]: `shell: cargo test` exited 0 (2025-06-07 10:01:00)"));
+    }
+
+    #[test]
+    fn test_unsupported_claims_get_no_footnote() {
+        let entries = vec![entry("Prime Response", "10:00", "The build succeeded.")];
+        assert_eq!(annotate_entries(&entries)[0].content, "The build succeeded.");
+    }
+
+    #[test]
+    fn test_file_claims_need_a_file_or_path() {
+        assert_eq!(claim_kinds("I wrote src/main.rs"), vec![ClaimKind::FileWritten]);
+        assert_eq!(claim_kinds("Saved the notes to notes.txt."), vec![ClaimKind::FileWritten]);
+        assert_eq!(claim_kinds("I updated the config file"), vec![ClaimKind::FileWritten]);
+        assert!(claim_kinds("I updated my answer.").is_empty());
+        assert!(claim_kinds("Nothing was written, e.g. no changes were saved.").is_empty());
+        assert_eq!(path_tokens("See `src/lib.rs`, then run.ps1. Done."), vec!["src/lib.rs", "run.ps1"]);
+    }
+}
@@ -0,0 +1,58 @@
+//! `prime exec "<command>"`: runs one human-initiated command through the same pipeline
+//! as model-initiated ones — risk tiers (including the ask-me-before patterns in
+//! ~/.prime/ask_me_before_patterns.txt), approval (interactive or headless) and the
+//! audit log, which masks credentials in the command and also records the exit status —
+//! and exits with the command's status. The .primeignore rules only govern file
+//! actions, so the paths a command touches are not checked against them.
+
+use std::path::Path;
+
+use anyhow::{Context, Result};
+use crate::terminal::Stylize;
+
+use crate::approval::ApprovalPolicy;
+use crate::commands::CommandProcessor;
+use crate::config::Config;
+use crate::i18n::{self, tr, Msg};
+use crate::policy::{RiskPolicy, TierAction};
+use crate::vfs;
+
+/// Exit status when the policy or the approver refuses the command, as shells use for
+/// "found but not executable"
+pub const EXIT_REFUSED: i32 = 126;
+
+pub async fn run_exec(config: &Config, base_dir: &Path, command: &str) -> Result<i32> {
+    if let Some(language) = i18n::Language::from_code(&config.ui_language) {
+        i18n::set_language(language);
+    }
+    let working_dir = std::env::current_dir().context("Failed to get current working directory")?;
+    let processor = CommandProcessor::new(base_dir, vfs::real());
+    let risk = RiskPolicy::from_config(&config.risk, processor.ask_me_before_patterns())?;
+    let mut approval = ApprovalPolicy::from_config(config, base_dir)?;
+
+    let session_id = format!("exec_{}", chrono::Local::now().format("%Y%m%d_%H%M%S"));
+    let tier = risk.classify_command(command).tier;
+    let actions = vec![format!("shell: {}", command)];
+    let decision = match risk.action_for(tier) {
+        TierAction::AutoRun => {
+            approval.record_auto_run(&session_id, &actions, tier.id());
+            None
+        }
+        TierAction::Deny => Some(approval.refuse(&session_id, &actions, tier.id())),
+        TierAction::Confirm => {
+            eprintln!("{}", format!("{} [{}]", command, tier.label()).red());
+            Some(approval.decide(&session_id, &actions, tier.id(), None).await?)
+        }
+        TierAction::TypedPhrase => {
+            eprintln!("{}", format!("{} [{}]", command, tier.label()).red());
+            Some(approval.decide(&session_id, &actions, tier.id(), Some(tier.id())).await?)
+        }
+    };
+    if let Some(decision) = decision.filter(|d| !d.approved) {
+        eprintln!("{}", format!("{} ({})", tr(Msg::PlanCancelled), decision.reason).red());
+        return Ok(EXIT_REFUSED);
+    }
+    let exit_code = processor.run_command_inherited(command, &working_dir)?;
+    approval.record_exit(&session_id, &actions, tier.id(), exit_code);
+    Ok(exit_code)
+}
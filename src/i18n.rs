@@ -0,0 +1,728 @@
+//! Localized UI strings for the REPL
+//! Only user-facing chrome is translated; logs, prompts sent to the model and the
+//! primeactions syntax stay in English so command extraction is unaffected.
+
+use std::sync::OnceLock;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Default)]
+pub enum Language {
+    #[default]
+    English,
+    Spanish,
+    German,
+    French,
+}
+
+impl Language {
+    pub fn from_code(code: &str) -> Option<Self> {
+        match code.trim().to_lowercase().as_str() {
+            "en" | "english" => Some(Language::English),
+            "es" | "spanish" | "español" => Some(Language::Spanish),
+            "de" | "german" | "deutsch" => Some(Language::German),
+            "fr" | "french" | "français" => Some(Language::French),
+            _ => None,
+        }
+    }
+
+    /// English name of the language, used when instructing the model
+    pub fn name(&self) -> &'static str {
+        match self {
+            Language::English => "English",
+            Language::Spanish => "Spanish",
+            Language::German => "German",
+            Language::French => "French",
+        }
+    }
+}
+
+/// Identifiers for every translatable UI string
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Msg {
+    HelpTitle,
+    HelpHelp,
+    HelpClear,
+    HelpLog,
+    HelpMemory,
+    HelpTools,
+    HelpExit,
+    Interrupted,
+    UnknownCommand,
+    TypeHelpForHelp,
+    ErrorReadingLog,
+    ErrorReadingMemory,
+    LabelModel,
+    LabelProvider,
+    LabelConfiguration,
+    LabelWorkspace,
+    Actions,
+    Destructive,
+    ExecutePrompt,
+    ExecutingIn2s,
+    PlanCancelled,
+    ToolFailed,
+    GeneratingResponse,
+    MaxTurnsReached,
+    CompletedIn,
+    HelpStep,
+    StepPrompt,
+    StepModeOn,
+    StepModeOff,
+    StepAborted,
+    StepAskModel,
+    ModelStalled,
+    StallHint,
+    StallPrompt,
+    ResponseCancelled,
+    HelpOpen,
+    NoOpenTargets,
+    OpenTargetsTitle,
+    HelpExport,
+    TranscriptExported,
+    TierReadOnly,
+    TierMutating,
+    TierNetworkEgress,
+    TierCredentialAccess,
+    TypePhrasePrompt,
+    HelpStatus,
+    StatusTitle,
+    LabelSession,
+    LabelHealth,
+    LabelTokens,
+    LabelMemory,
+    LabelPolicy,
+    HealthUnknown,
+    HealthOk,
+    HealthFailed,
+    TokensSent,
+    TokensReceived,
+    TokensRequests,
+    MemoryGlobal,
+    MemoryLongTerm,
+    MemoryShortTerm,
+    ContinuingResponse,
+    HelpRestoreFiles,
+    NoFileSnapshots,
+    FileSnapshotsTitle,
+    FilesRestored,
+    HelpKeepTmp,
+    ScratchPending,
+    ScratchKept,
+    NoScratchDir,
+    HelpPin,
+    HelpUnpin,
+    MessagePinned,
+    MessageUnpinned,
+    MessageNotPinned,
+    NoPinnedMessages,
+    PinnedMessagesTitle,
+    SessionMessagesTitle,
+    LabelPinned,
+    HelpPr,
+    DraftingPr,
+    CommitMessageTitle,
+    PullRequestTitle,
+    ConfirmCommit,
+    ChangesCommitted,
+    ConfirmPublish,
+    PullRequestOpened,
+    LabelTurns,
+    TurnsThisSession,
+    TurnsAllSessions,
+    LoadChoiceTrim,
+    LoadChoiceSwitch,
+    LoadChoiceContinue,
+    HistoryTrimmed,
+    ModelSwitched,
+    HelpRate,
+    NothingToRate,
+    RatedGood,
+    RatedBad,
+    HelpRemember,
+    Remembered,
+    CommandWarnings,
+    TerminalDetached,
+    StdinClosed,
+    HelpContext,
+    ContextTitle,
+    OutsideWorkspaceWrite,
+    HelpSet,
+    LabelParams,
+    ParamsUpdated,
+    SessionResumed,
+    HelpClarify,
+    ClarifyOn,
+    ClarifyOff,
+    PlanHeldBack,
+    HelpRecord,
+    RecordingStarted,
+    RecordingSaved,
+    RecordingNotActive,
+    RecordingActive,
+    HelpVoice,
+    VoiceDisabled,
+    VoiceNeedsTerminal,
+    VoiceListening,
+    VoiceConfirm,
+    NoTerminalAnswer,
+    StepNeedsTerminal,
+    FilesToCommitTitle,
+    LabelJobs,
+}
+
+static UI_LANGUAGE: OnceLock<Language> = OnceLock::new();
+
+/// Sets the UI language once at startup; later calls are ignored
+pub fn set_language(language: Language) {
+    let _ = UI_LANGUAGE.set(language);
+}
+
+pub fn language() -> Language {
+    UI_LANGUAGE.get().copied().unwrap_or_default()
+}
+
+/// Returns the UI string for `msg` in the configured language, falling back to English
+pub fn tr(msg: Msg) -> &'static str {
+    let localized = match language() {
+        Language::English => None,
+        Language::Spanish => spanish(msg),
+        Language::German => german(msg),
+        Language::French => french(msg),
+    };
+    localized.unwrap_or_else(|| english(msg))
+}
+
+fn english(msg: Msg) -> &'static str {
+    match msg {
+        Msg::HelpTitle => "Available Special Commands:",
+        Msg::HelpHelp => "Show this help message.",
+        Msg::HelpClear => "Clear the terminal screen.",
+        Msg::HelpLog => "Show the full conversation log.",
+        Msg::HelpMemory => "Read long-term or short-term memory.",
+        Msg::HelpTools => "List all available tools.",
+        Msg::HelpExit => "Exit Prime.",
+        Msg::Interrupted => "Interrupted. Type 'exit' or Ctrl-D to exit.",
+        Msg::UnknownCommand => "Unknown command:",
+        Msg::TypeHelpForHelp => "Type !help for help.",
+        Msg::ErrorReadingLog => "Error reading log:",
+        Msg::ErrorReadingMemory => "Error reading memory:",
+        Msg::LabelModel => "model",
+        Msg::LabelProvider => "provider",
+        Msg::LabelConfiguration => "configuration",
+        Msg::LabelWorkspace => "workspace",
+        Msg::Actions => "actions",
+        Msg::Destructive => "destructive",
+        Msg::ExecutePrompt => "Execute? (y/N): ",
+        Msg::ExecutingIn2s => "executing in 2s",
+        Msg::PlanCancelled => "Plan cancelled",
+        Msg::ToolFailed => "A tool failed. The AI will attempt to self-correct.",
+        Msg::GeneratingResponse => "Generating response...",
+        Msg::MaxTurnsReached => "Reached maximum tool execution turns. The session might be in a loop. Please try a new prompt.",
+        Msg::CompletedIn => "completed in",
+        Msg::HelpStep => "Toggle step-by-step execution of actions.",
+        Msg::StepPrompt => "[c]ontinue, [a]bort, [m] ask model: ",
+        Msg::StepModeOn => "Step mode enabled: Prime pauses after each action.",
+        Msg::StepModeOff => "Step mode disabled.",
+        Msg::StepAborted => "Plan aborted in step mode.",
+        Msg::StepAskModel => "Returning partial results to the model for review.",
+        Msg::ModelStalled => "No output yet, the model may be loading or stalled",
+        Msg::StallHint => "Ctrl-C to retry or cancel",
+        Msg::StallPrompt => "[r]etry, [c]ancel, [w]ait: ",
+        Msg::ResponseCancelled => "Response cancelled by user.",
+        Msg::HelpOpen => "Open a file or URL from the last turn.",
+        Msg::NoOpenTargets => "Nothing to open from the last turn.",
+        Msg::OpenTargetsTitle => "Files and URLs from the last turn:",
+        Msg::HelpExport => "Save the transcript with evidence footnotes.",
+        Msg::TranscriptExported => "Transcript written to",
+        Msg::TierReadOnly => "read-only",
+        Msg::TierMutating => "mutating",
+        Msg::TierNetworkEgress => "network egress",
+        Msg::TierCredentialAccess => "credential access",
+        Msg::TypePhrasePrompt => "To run these actions, type",
+        Msg::HelpStatus => "Show model, provider health, tokens, memory and policy",
+        Msg::StatusTitle => "Session status",
+        Msg::LabelSession => "session",
+        Msg::LabelHealth => "provider health",
+        Msg::LabelTokens => "tokens (est.)",
+        Msg::LabelMemory => "memory",
+        Msg::LabelPolicy => "policy",
+        Msg::HealthUnknown => "no request yet",
+        Msg::HealthOk => "ok, last response took",
+        Msg::HealthFailed => "last request failed:",
+        Msg::TokensSent => "sent",
+        Msg::TokensReceived => "received",
+        Msg::TokensRequests => "requests",
+        Msg::MemoryGlobal => "global",
+        Msg::MemoryLongTerm => "long-term entries",
+        Msg::MemoryShortTerm => "short-term entries",
+        Msg::ContinuingResponse => "Response was cut off, asking the model to continue…",
+        Msg::HelpRestoreFiles => "List file snapshots, or restore the workspace to before a turn",
+        Msg::NoFileSnapshots => "No file snapshots in this session (git workspaces are not snapshotted).",
+        Msg::FileSnapshotsTitle => "File snapshots (turn, taken, files):",
+        Msg::FilesRestored => "Workspace restored to before turn",
+        Msg::HelpKeepTmp => "Keep the last turn's scratch directory (PRIME_TMP)",
+        Msg::ScratchPending => "Scratch files left in (removed at the next turn, !keep-tmp keeps them):",
+        Msg::ScratchKept => "Scratch directory kept:",
+        Msg::NoScratchDir => "No scratch directory to keep.",
+        Msg::HelpPin => "Pin message N so it stays in context (no N: list messages)",
+        Msg::HelpUnpin => "Unpin message N",
+        Msg::MessagePinned => "Pinned message",
+        Msg::MessageUnpinned => "Unpinned message",
+        Msg::MessageNotPinned => "That message is not pinned.",
+        Msg::NoPinnedMessages => "No pinned messages. Use !pin msg <n>.",
+        Msg::PinnedMessagesTitle => "Pinned messages:",
+        Msg::SessionMessagesTitle => "Session messages:",
+        Msg::LabelPinned => "pinned",
+        Msg::HelpPr => "Draft a commit and pull request for this session's changes",
+        Msg::DraftingPr => "Drafting commit message and PR description...",
+        Msg::CommitMessageTitle => "Commit message:",
+        Msg::PullRequestTitle => "Pull request:",
+        Msg::ConfirmCommit => "Stage all changes and commit?",
+        Msg::ChangesCommitted => "Committed on branch",
+        Msg::ConfirmPublish => "Push the branch and open the pull request with gh?",
+        Msg::PullRequestOpened => "Pull request opened:",
+        Msg::LabelTurns => "turns",
+        Msg::TurnsThisSession => "this session",
+        Msg::TurnsAllSessions => "across all sessions",
+        Msg::LoadChoiceTrim => "[t]rim history",
+        Msg::LoadChoiceSwitch => "[s]witch to",
+        Msg::LoadChoiceContinue => "[c]ontinue",
+        Msg::HistoryTrimmed => "Older messages dropped to fit the context window:",
+        Msg::ModelSwitched => "Switched model to",
+        Msg::HelpRate => "Rate the last turn for the fine-tuning dataset",
+        Msg::NothingToRate => "There is no turn to rate yet",
+        Msg::RatedGood => "Rated good:",
+        Msg::RatedBad => "Rated bad:",
+        Msg::HelpRemember => "Add a note to long-term memory",
+        Msg::Remembered => "Saved to long-term memory; the next prompt includes it.",
+        Msg::CommandWarnings => "warning(s) in the output of a successful command",
+        Msg::TerminalDetached => "Terminal detached; ending the session.",
+        Msg::StdinClosed => "Standard input is closed; nothing to do. Pipe prompts into prime or use prime -p \"<prompt>\".",
+        Msg::HelpContext => "List the sections of the next prompt by source, with token estimates",
+        Msg::ContextTitle => "Next prompt by source (tokens est.)",
+        Msg::OutsideWorkspaceWrite => "Write outside the workspace:",
+        Msg::HelpSet => "Set temperature, top_p, max_tokens, context_budget or stop for this session (none clears)",
+        Msg::LabelParams => "params",
+        Msg::ParamsUpdated => "Session parameters:",
+        Msg::SessionResumed => "Resumed session",
+        Msg::HelpClarify => "Ask before acting when the model is unsure what you meant.",
+        Msg::ClarifyOn => "Clarify-first enabled: uncertain plans become a question.",
+        Msg::ClarifyOff => "Clarify-first disabled.",
+        Msg::PlanHeldBack => "Plan not run, confidence",
+        Msg::HelpRecord => "Record the session as an asciinema cast (start [file] | stop).",
+        Msg::RecordingStarted => "Recording to",
+        Msg::RecordingSaved => "Recording saved:",
+        Msg::RecordingNotActive => "No recording in progress.",
+        Msg::RecordingActive => "A recording is already in progress:",
+        Msg::HelpVoice => "Speak a prompt; it is transcribed to the prompt for review (experimental).",
+        Msg::VoiceDisabled => "Voice input is off. Set enabled = true under [voice] in config.toml.",
+        Msg::VoiceNeedsTerminal => "Voice input needs an interactive terminal.",
+        Msg::VoiceListening => "Listening, seconds:",
+        Msg::VoiceConfirm => "Edit the transcription if needed, Enter to run it, Ctrl+C to discard.",
+        Msg::NoTerminalAnswer => "no (standard input is not a terminal)",
+        Msg::StepNeedsTerminal => "Step mode needs an interactive terminal to pause; the rest of the plan is not run.",
+        Msg::FilesToCommitTitle => "Files to commit (git add -A):",
+        Msg::LabelJobs => "jobs",
+    }
+}
+
+fn spanish(msg: Msg) -> Option<&'static str> {
+    Some(match msg {
+        Msg::HelpTitle => "Comandos especiales disponibles:",
+        Msg::HelpHelp => "Muestra este mensaje de ayuda.",
+        Msg::HelpClear => "Limpia la pantalla del terminal.",
+        Msg::HelpLog => "Muestra el registro completo de la conversación.",
+        Msg::HelpMemory => "Lee la memoria a largo o corto plazo.",
+        Msg::HelpTools => "Lista todas las herramientas disponibles.",
+        Msg::HelpExit => "Sale de Prime.",
+        Msg::Interrupted => "Interrumpido. Escribe 'exit' o pulsa Ctrl-D para salir.",
+        Msg::UnknownCommand => "Comando desconocido:",
+        Msg::TypeHelpForHelp => "Escribe !help para ver la ayuda.",
+        Msg::ErrorReadingLog => "Error al leer el registro:",
+        Msg::ErrorReadingMemory => "Error al leer la memoria:",
+        Msg::LabelModel => "modelo",
+        Msg::LabelProvider => "proveedor",
+        Msg::LabelConfiguration => "configuración",
+        Msg::LabelWorkspace => "espacio de trabajo",
+        Msg::Actions => "acciones",
+        Msg::Destructive => "destructivo",
+        Msg::ExecutePrompt => "¿Ejecutar? (y/N): ",
+        Msg::ExecutingIn2s => "ejecutando en 2s",
+        Msg::PlanCancelled => "Plan cancelado",
+        Msg::ToolFailed => "Una herramienta falló. La IA intentará corregirse.",
+        Msg::GeneratingResponse => "Generando respuesta...",
+        Msg::MaxTurnsReached => "Se alcanzó el máximo de turnos de herramientas. La sesión podría estar en bucle. Prueba con otra petición.",
+        Msg::CompletedIn => "completado en",
+        Msg::HelpStep => "Activa o desactiva la ejecución paso a paso.",
+        Msg::StepPrompt => "[c]ontinuar, [a]bortar, [m] preguntar al modelo: ",
+        Msg::StepModeOn => "Modo paso a paso activado: Prime se detiene tras cada acción.",
+        Msg::StepModeOff => "Modo paso a paso desactivado.",
+        Msg::StepAborted => "Plan abortado en modo paso a paso.",
+        Msg::StepAskModel => "Devolviendo los resultados parciales al modelo para su revisión.",
+        Msg::ModelStalled => "Aún sin respuesta; el modelo puede estar cargando o bloqueado",
+        Msg::StallHint => "Ctrl-C para reintentar o cancelar",
+        Msg::StallPrompt => "[r]eintentar, [c]ancelar, [w] esperar: ",
+        Msg::ResponseCancelled => "Respuesta cancelada por el usuario.",
+        Msg::HelpOpen => "Abre un archivo o URL del último turno.",
+        Msg::NoOpenTargets => "No hay nada que abrir del último turno.",
+        Msg::OpenTargetsTitle => "Archivos y URL del último turno:",
+        Msg::HelpExport => "Guarda la transcripción con notas de evidencia.",
+        Msg::TranscriptExported => "Transcripción guardada en",
+        Msg::TierReadOnly => "solo lectura",
+        Msg::TierMutating => "modificación",
+        Msg::TierNetworkEgress => "salida de red",
+        Msg::TierCredentialAccess => "acceso a credenciales",
+        Msg::TypePhrasePrompt => "Para ejecutar estas acciones, escribe",
+        Msg::HelpStatus => "Muestra modelo, estado del proveedor, tokens, memoria y política",
+        Msg::StatusTitle => "Estado de la sesión",
+        Msg::LabelSession => "sesión",
+        Msg::LabelHealth => "estado del proveedor",
+        Msg::LabelTokens => "tokens (est.)",
+        Msg::LabelMemory => "memoria",
+        Msg::LabelPolicy => "política",
+        Msg::HealthUnknown => "sin solicitudes aún",
+        Msg::HealthOk => "ok, última respuesta en",
+        Msg::HealthFailed => "la última solicitud falló:",
+        Msg::TokensSent => "enviados",
+        Msg::TokensReceived => "recibidos",
+        Msg::TokensRequests => "solicitudes",
+        Msg::MemoryGlobal => "global",
+        Msg::MemoryLongTerm => "entradas a largo plazo",
+        Msg::MemoryShortTerm => "entradas a corto plazo",
+        Msg::ContinuingResponse => "La respuesta se cortó, pidiendo al modelo que continúe…",
+        Msg::HelpRestoreFiles => "Lista instantáneas de archivos o restaura el espacio de trabajo a antes de un turno",
+        Msg::NoFileSnapshots => "No hay instantáneas de archivos en esta sesión (los espacios git no se capturan).",
+        Msg::FileSnapshotsTitle => "Instantáneas de archivos (turno, fecha, archivos):",
+        Msg::FilesRestored => "Espacio de trabajo restaurado a antes del turno",
+        Msg::HelpKeepTmp => "Conserva el directorio temporal del último turno (PRIME_TMP)",
+        Msg::ScratchPending => "Archivos temporales en (se eliminan en el próximo turno, !keep-tmp los conserva):",
+        Msg::ScratchKept => "Directorio temporal conservado:",
+        Msg::NoScratchDir => "No hay directorio temporal que conservar.",
+        Msg::HelpPin => "Fija el mensaje N para que siga en contexto (sin N: lista mensajes)",
+        Msg::HelpUnpin => "Desfija el mensaje N",
+        Msg::MessagePinned => "Mensaje fijado",
+        Msg::MessageUnpinned => "Mensaje desfijado",
+        Msg::MessageNotPinned => "Ese mensaje no está fijado.",
+        Msg::NoPinnedMessages => "No hay mensajes fijados. Usa !pin msg <n>.",
+        Msg::PinnedMessagesTitle => "Mensajes fijados:",
+        Msg::SessionMessagesTitle => "Mensajes de la sesión:",
+        Msg::LabelPinned => "fijados",
+        Msg::HelpPr => "Redactar un commit y un pull request con los cambios de la sesión",
+        Msg::DraftingPr => "Redactando el mensaje de commit y la descripción del PR...",
+        Msg::CommitMessageTitle => "Mensaje de commit:",
+        Msg::PullRequestTitle => "Pull request:",
+        Msg::ConfirmCommit => "¿Preparar todos los cambios y hacer commit?",
+        Msg::ChangesCommitted => "Commit creado en la rama",
+        Msg::ConfirmPublish => "¿Subir la rama y abrir el pull request con gh?",
+        Msg::PullRequestOpened => "Pull request abierto:",
+        Msg::LabelTurns => "turnos",
+        Msg::TurnsThisSession => "en esta sesión",
+        Msg::TurnsAllSessions => "en todas las sesiones",
+        Msg::LoadChoiceTrim => "[t] recortar historial",
+        Msg::LoadChoiceSwitch => "[s] cambiar a",
+        Msg::LoadChoiceContinue => "[c] continuar",
+        Msg::HistoryTrimmed => "Mensajes antiguos descartados para caber en la ventana de contexto:",
+        Msg::ModelSwitched => "Modelo cambiado a",
+        Msg::HelpRate => "Valorar el último turno para el conjunto de ajuste fino",
+        Msg::NothingToRate => "Todavía no hay ningún turno que valorar",
+        Msg::RatedGood => "Valorado como bueno:",
+        Msg::RatedBad => "Valorado como malo:",
+        Msg::HelpRemember => "Añadir una nota a la memoria a largo plazo",
+        Msg::Remembered => "Guardado en la memoria a largo plazo; el siguiente prompt lo incluye.",
+        Msg::CommandWarnings => "advertencia(s) en la salida de un comando exitoso",
+        Msg::TerminalDetached => "Terminal desconectado; se cierra la sesión.",
+        Msg::StdinClosed => "La entrada estándar está cerrada; no hay nada que hacer. Envía prompts a prime por una tubería o usa prime -p \"<prompt>\".",
+        Msg::HelpContext => "Lista las secciones del próximo prompt por origen, con tokens estimados",
+        Msg::ContextTitle => "Próximo prompt por origen (tokens est.)",
+        Msg::OutsideWorkspaceWrite => "Escritura fuera del espacio de trabajo:",
+        Msg::HelpSet => "Fija temperature, top_p, max_tokens, context_budget o stop para esta sesión (none lo borra)",
+        Msg::LabelParams => "parámetros",
+        Msg::ParamsUpdated => "Parámetros de la sesión:",
+        Msg::SessionResumed => "Sesión reanudada",
+        Msg::HelpClarify => "Pregunta antes de actuar cuando el modelo no está seguro de lo que quieres.",
+        Msg::ClarifyOn => "Aclarar primero activado: los planes inciertos se convierten en una pregunta.",
+        Msg::ClarifyOff => "Aclarar primero desactivado.",
+        Msg::PlanHeldBack => "Plan no ejecutado, confianza",
+        Msg::HelpRecord => "Graba la sesión como cast de asciinema (start [archivo] | stop).",
+        Msg::RecordingStarted => "Grabando en",
+        Msg::RecordingSaved => "Grabación guardada:",
+        Msg::RecordingNotActive => "No hay ninguna grabación en curso.",
+        Msg::RecordingActive => "Ya hay una grabación en curso:",
+        Msg::HelpVoice => "Dicta una petición; se transcribe en el prompt para revisarla (experimental).",
+        Msg::VoiceDisabled => "La entrada por voz está desactivada. Pon enabled = true en [voice] de config.toml.",
+        Msg::VoiceNeedsTerminal => "La entrada por voz necesita un terminal interactivo.",
+        Msg::VoiceListening => "Escuchando, segundos:",
+        Msg::VoiceConfirm => "Corrige la transcripción si hace falta, Enter para ejecutarla, Ctrl+C para descartarla.",
+        Msg::NoTerminalAnswer => "no (la entrada estándar no es un terminal)",
+        Msg::StepNeedsTerminal => "El modo paso a paso necesita un terminal interactivo para detenerse; el resto del plan no se ejecuta.",
+        Msg::FilesToCommitTitle => "Archivos que se incluirán (git add -A):",
+        Msg::LabelJobs => "trabajos",
+    })
+}
+
+fn german(msg: Msg) -> Option<&'static str> {
+    Some(match msg {
+        Msg::HelpTitle => "Verfügbare Sonderbefehle:",
+        Msg::HelpHelp => "Zeigt diese Hilfe an.",
+        Msg::HelpClear => "Leert den Terminalbildschirm.",
+        Msg::HelpLog => "Zeigt das vollständige Gesprächsprotokoll.",
+        Msg::HelpMemory => "Liest das Langzeit- oder Kurzzeitgedächtnis.",
+        Msg::HelpTools => "Listet alle verfügbaren Werkzeuge auf.",
+        Msg::HelpExit => "Beendet Prime.",
+        Msg::Interrupted => "Unterbrochen. 'exit' eingeben oder Strg-D drücken zum Beenden.",
+        Msg::UnknownCommand => "Unbekannter Befehl:",
+        Msg::TypeHelpForHelp => "Gib !help für Hilfe ein.",
+        Msg::ErrorReadingLog => "Fehler beim Lesen des Protokolls:",
+        Msg::ErrorReadingMemory => "Fehler beim Lesen des Gedächtnisses:",
+        Msg::LabelModel => "Modell",
+        Msg::LabelProvider => "Anbieter",
+        Msg::LabelConfiguration => "Konfiguration",
+        Msg::LabelWorkspace => "Arbeitsbereich",
+        Msg::Actions => "Aktionen",
+        Msg::Destructive => "destruktiv",
+        Msg::ExecutePrompt => "Ausführen? (y/N): ",
+        Msg::ExecutingIn2s => "Ausführung in 2s",
+        Msg::PlanCancelled => "Plan abgebrochen",
+        Msg::ToolFailed => "Ein Werkzeug ist fehlgeschlagen. Die KI versucht, sich zu korrigieren.",
+        Msg::GeneratingResponse => "Antwort wird erzeugt...",
+        Msg::MaxTurnsReached => "Maximale Anzahl an Werkzeugrunden erreicht. Die Sitzung steckt eventuell in einer Schleife. Bitte neue Anfrage stellen.",
+        Msg::CompletedIn => "abgeschlossen in",
+        Msg::HelpStep => "Schaltet die schrittweise Ausführung um.",
+        Msg::StepPrompt => "[c] weiter, [a] abbrechen, [m] Modell fragen: ",
+        Msg::StepModeOn => "Schrittmodus aktiviert: Prime pausiert nach jeder Aktion.",
+        Msg::StepModeOff => "Schrittmodus deaktiviert.",
+        Msg::StepAborted => "Plan im Schrittmodus abgebrochen.",
+        Msg::StepAskModel => "Teilergebnisse werden dem Modell zur Prüfung übergeben.",
+        Msg::ModelStalled => "Noch keine Ausgabe, das Modell lädt oder hängt möglicherweise",
+        Msg::StallHint => "Strg-C zum Wiederholen oder Abbrechen",
+        Msg::StallPrompt => "[r] wiederholen, [c] abbrechen, [w] warten: ",
+        Msg::ResponseCancelled => "Antwort vom Benutzer abgebrochen.",
+        Msg::HelpOpen => "Öffnet eine Datei oder URL aus der letzten Runde.",
+        Msg::NoOpenTargets => "Aus der letzten Runde gibt es nichts zu öffnen.",
+        Msg::OpenTargetsTitle => "Dateien und URLs aus der letzten Runde:",
+        Msg::HelpExport => "Speichert das Protokoll mit Belegfußnoten.",
+        Msg::TranscriptExported => "Protokoll gespeichert unter",
+        Msg::TierReadOnly => "nur lesend",
+        Msg::TierMutating => "ändernd",
+        Msg::TierNetworkEgress => "Netzwerkzugriff",
+        Msg::TierCredentialAccess => "Zugriff auf Zugangsdaten",
+        Msg::TypePhrasePrompt => "Zum Ausführen dieser Aktionen eingeben",
+        Msg::HelpStatus => "Modell, Anbieterstatus, Tokens, Gedächtnis und Richtlinie anzeigen",
+        Msg::StatusTitle => "Sitzungsstatus",
+        Msg::LabelSession => "Sitzung",
+        Msg::LabelHealth => "Anbieterstatus",
+        Msg::LabelTokens => "Tokens (gesch.)",
+        Msg::LabelMemory => "Gedächtnis",
+        Msg::LabelPolicy => "Richtlinie",
+        Msg::HealthUnknown => "noch keine Anfrage",
+        Msg::HealthOk => "ok, letzte Antwort dauerte",
+        Msg::HealthFailed => "letzte Anfrage fehlgeschlagen:",
+        Msg::TokensSent => "gesendet",
+        Msg::TokensReceived => "empfangen",
+        Msg::TokensRequests => "Anfragen",
+        Msg::MemoryGlobal => "global",
+        Msg::MemoryLongTerm => "Langzeiteinträge",
+        Msg::MemoryShortTerm => "Kurzzeiteinträge",
+        Msg::ContinuingResponse => "Antwort wurde abgeschnitten, Modell wird zum Fortsetzen aufgefordert…",
+        Msg::HelpRestoreFiles => "Datei-Snapshots anzeigen oder Arbeitsbereich auf den Stand vor einem Zug zurücksetzen",
+        Msg::NoFileSnapshots => "Keine Datei-Snapshots in dieser Sitzung (Git-Arbeitsbereiche werden nicht gesichert).",
+        Msg::FileSnapshotsTitle => "Datei-Snapshots (Zug, Zeitpunkt, Dateien):",
+        Msg::FilesRestored => "Arbeitsbereich zurückgesetzt auf den Stand vor Zug",
+        Msg::HelpKeepTmp => "Temp-Verzeichnis des letzten Zugs behalten (PRIME_TMP)",
+        Msg::ScratchPending => "Temporäre Dateien in (werden beim nächsten Zug gelöscht, !keep-tmp behält sie):",
+        Msg::ScratchKept => "Temp-Verzeichnis behalten:",
+        Msg::NoScratchDir => "Kein Temp-Verzeichnis zum Behalten.",
+        Msg::HelpPin => "Nachricht N anheften, damit sie im Kontext bleibt (ohne N: Nachrichten auflisten)",
+        Msg::HelpUnpin => "Nachricht N lösen",
+        Msg::MessagePinned => "Nachricht angeheftet",
+        Msg::MessageUnpinned => "Nachricht gelöst",
+        Msg::MessageNotPinned => "Diese Nachricht ist nicht angeheftet.",
+        Msg::NoPinnedMessages => "Keine angehefteten Nachrichten. Nutze !pin msg <n>.",
+        Msg::PinnedMessagesTitle => "Angeheftete Nachrichten:",
+        Msg::SessionMessagesTitle => "Nachrichten der Sitzung:",
+        Msg::LabelPinned => "angeheftet",
+        Msg::HelpPr => "Commit und Pull Request für die Änderungen dieser Sitzung entwerfen",
+        Msg::DraftingPr => "Commit-Nachricht und PR-Beschreibung werden entworfen...",
+        Msg::CommitMessageTitle => "Commit-Nachricht:",
+        Msg::PullRequestTitle => "Pull Request:",
+        Msg::ConfirmCommit => "Alle Änderungen vormerken und committen?",
+        Msg::ChangesCommitted => "Committet auf Branch",
+        Msg::ConfirmPublish => "Branch pushen und Pull Request mit gh öffnen?",
+        Msg::PullRequestOpened => "Pull Request geöffnet:",
+        Msg::LabelTurns => "Runden",
+        Msg::TurnsThisSession => "in dieser Sitzung",
+        Msg::TurnsAllSessions => "über alle Sitzungen",
+        Msg::LoadChoiceTrim => "[t] Verlauf kürzen",
+        Msg::LoadChoiceSwitch => "[s] wechseln zu",
+        Msg::LoadChoiceContinue => "[c] fortfahren",
+        Msg::HistoryTrimmed => "Ältere Nachrichten verworfen, damit das Kontextfenster reicht:",
+        Msg::ModelSwitched => "Modell gewechselt zu",
+        Msg::HelpRate => "Die letzte Runde für den Fine-Tuning-Datensatz bewerten",
+        Msg::NothingToRate => "Es gibt noch keine Runde zum Bewerten",
+        Msg::RatedGood => "Als gut bewertet:",
+        Msg::RatedBad => "Als schlecht bewertet:",
+        Msg::HelpRemember => "Eine Notiz zum Langzeitgedächtnis hinzufügen",
+        Msg::Remembered => "Im Langzeitgedächtnis gespeichert; der nächste Prompt enthält es.",
+        Msg::CommandWarnings => "Warnung(en) in der Ausgabe eines erfolgreichen Befehls",
+        Msg::TerminalDetached => "Terminal getrennt; die Sitzung wird beendet.",
+        Msg::StdinClosed => "Die Standardeingabe ist geschlossen; nichts zu tun. Prompts per Pipe an prime übergeben oder prime -p \"<prompt>\" verwenden.",
+        Msg::HelpContext => "Abschnitte des nächsten Prompts nach Quelle auflisten, mit geschätzten Tokens",
+        Msg::ContextTitle => "Nächster Prompt nach Quelle (Tokens geschätzt)",
+        Msg::OutsideWorkspaceWrite => "Schreiben außerhalb des Arbeitsbereichs:",
+        Msg::HelpSet => "Setzt temperature, top_p, max_tokens, context_budget oder stop für diese Sitzung (none entfernt)",
+        Msg::LabelParams => "Parameter",
+        Msg::ParamsUpdated => "Sitzungsparameter:",
+        Msg::SessionResumed => "Sitzung fortgesetzt",
+        Msg::HelpClarify => "Fragt nach, bevor gehandelt wird, wenn das Modell unsicher ist.",
+        Msg::ClarifyOn => "Erst nachfragen aktiviert: unsichere Pläne werden zur Rückfrage.",
+        Msg::ClarifyOff => "Erst nachfragen deaktiviert.",
+        Msg::PlanHeldBack => "Plan nicht ausgeführt, Sicherheit",
+        Msg::HelpRecord => "Zeichnet die Sitzung als asciinema-Cast auf (start [Datei] | stop).",
+        Msg::RecordingStarted => "Aufnahme nach",
+        Msg::RecordingSaved => "Aufnahme gespeichert:",
+        Msg::RecordingNotActive => "Keine Aufnahme aktiv.",
+        Msg::RecordingActive => "Es läuft bereits eine Aufnahme:",
+        Msg::HelpVoice => "Eine Anfrage sprechen; sie wird zur Prüfung in die Eingabe transkribiert (experimentell).",
+        Msg::VoiceDisabled => "Spracheingabe ist aus. Setze enabled = true unter [voice] in config.toml.",
+        Msg::VoiceNeedsTerminal => "Spracheingabe benötigt ein interaktives Terminal.",
+        Msg::VoiceListening => "Höre zu, Sekunden:",
+        Msg::VoiceConfirm => "Transkription bei Bedarf bearbeiten, Enter zum Ausführen, Strg+C zum Verwerfen.",
+        Msg::NoTerminalAnswer => "nein (die Standardeingabe ist kein Terminal)",
+        Msg::StepNeedsTerminal => "Der Schrittmodus braucht ein interaktives Terminal zum Pausieren; der Rest des Plans wird nicht ausgeführt.",
+        Msg::FilesToCommitTitle => "Zu committende Dateien (git add -A):",
+        Msg::LabelJobs => "Jobs",
+    })
+}
+
+fn french(msg: Msg) -> Option<&'static str> {
+    Some(match msg {
+        Msg::HelpTitle => "Commandes spéciales disponibles :",
+        Msg::HelpHelp => "Affiche ce message d'aide.",
+        Msg::HelpClear => "Efface l'écran du terminal.",
+        Msg::HelpLog => "Affiche le journal complet de la conversation.",
+        Msg::HelpMemory => "Lit la mémoire à long ou court terme.",
+        Msg::HelpTools => "Liste tous les outils disponibles.",
+        Msg::HelpExit => "Quitte Prime.",
+        Msg::Interrupted => "Interrompu. Tapez 'exit' ou Ctrl-D pour quitter.",
+        Msg::UnknownCommand => "Commande inconnue :",
+        Msg::TypeHelpForHelp => "Tapez !help pour l'aide.",
+        Msg::ErrorReadingLog => "Erreur de lecture du journal :",
+        Msg::ErrorReadingMemory => "Erreur de lecture de la mémoire :",
+        Msg::LabelModel => "modèle",
+        Msg::LabelProvider => "fournisseur",
+        Msg::LabelConfiguration => "configuration",
+        Msg::LabelWorkspace => "espace de travail",
+        Msg::Actions => "actions",
+        Msg::Destructive => "destructif",
+        Msg::ExecutePrompt => "Exécuter ? (y/N) : ",
+        Msg::ExecutingIn2s => "exécution dans 2s",
+        Msg::PlanCancelled => "Plan annulé",
+        Msg::ToolFailed => "Un outil a échoué. L'IA va tenter de se corriger.",
+        Msg::GeneratingResponse => "Génération de la réponse...",
+        Msg::MaxTurnsReached => "Nombre maximal de tours d'outils atteint. La session tourne peut-être en boucle. Essayez une nouvelle requête.",
+        Msg::CompletedIn => "terminé en",
+        Msg::HelpStep => "Active ou désactive l'exécution pas à pas.",
+        Msg::StepPrompt => "[c]ontinuer, [a]bandonner, [m] demander au modèle : ",
+        Msg::StepModeOn => "Mode pas à pas activé : Prime s'arrête après chaque action.",
+        Msg::StepModeOff => "Mode pas à pas désactivé.",
+        Msg::StepAborted => "Plan abandonné en mode pas à pas.",
+        Msg::StepAskModel => "Résultats partiels renvoyés au modèle pour examen.",
+        Msg::ModelStalled => "Pas encore de réponse, le modèle charge ou est bloqué",
+        Msg::StallHint => "Ctrl-C pour réessayer ou annuler",
+        Msg::StallPrompt => "[r]éessayer, [c] annuler, [w] attendre : ",
+        Msg::ResponseCancelled => "Réponse annulée par l'utilisateur.",
+        Msg::HelpOpen => "Ouvre un fichier ou une URL du dernier tour.",
+        Msg::NoOpenTargets => "Rien à ouvrir depuis le dernier tour.",
+        Msg::OpenTargetsTitle => "Fichiers et URL du dernier tour :",
+        Msg::HelpExport => "Enregistre la transcription avec les notes de preuve.",
+        Msg::TranscriptExported => "Transcription enregistrée dans",
+        Msg::TierReadOnly => "lecture seule",
+        Msg::TierMutating => "modification",
+        Msg::TierNetworkEgress => "sortie réseau",
+        Msg::TierCredentialAccess => "accès aux identifiants",
+        Msg::TypePhrasePrompt => "Pour exécuter ces actions, tapez",
+        Msg::HelpStatus => "Affiche modèle, état du fournisseur, jetons, mémoire et politique",
+        Msg::StatusTitle => "État de la session",
+        Msg::LabelSession => "session",
+        Msg::LabelHealth => "état du fournisseur",
+        Msg::LabelTokens => "jetons (est.)",
+        Msg::LabelMemory => "mémoire",
+        Msg::LabelPolicy => "politique",
+        Msg::HealthUnknown => "aucune requête pour l'instant",
+        Msg::HealthOk => "ok, dernière réponse en",
+        Msg::HealthFailed => "la dernière requête a échoué :",
+        Msg::TokensSent => "envoyés",
+        Msg::TokensReceived => "reçus",
+        Msg::TokensRequests => "requêtes",
+        Msg::MemoryGlobal => "globale",
+        Msg::MemoryLongTerm => "entrées à long terme",
+        Msg::MemoryShortTerm => "entrées à court terme",
+        Msg::ContinuingResponse => "Réponse tronquée, demande au modèle de continuer…",
+        Msg::HelpRestoreFiles => "Liste les instantanés de fichiers ou restaure l'espace de travail avant un tour",
+        Msg::NoFileSnapshots => "Aucun instantané de fichiers dans cette session (les espaces git ne sont pas capturés).",
+        Msg::FileSnapshotsTitle => "Instantanés de fichiers (tour, date, fichiers) :",
+        Msg::FilesRestored => "Espace de travail restauré avant le tour",
+        Msg::HelpKeepTmp => "Conserve le répertoire temporaire du dernier tour (PRIME_TMP)",
+        Msg::ScratchPending => "Fichiers temporaires dans (supprimés au prochain tour, !keep-tmp les conserve) :",
+        Msg::ScratchKept => "Répertoire temporaire conservé :",
+        Msg::NoScratchDir => "Aucun répertoire temporaire à conserver.",
+        Msg::HelpPin => "Épingler le message N pour le garder en contexte (sans N : lister les messages)",
+        Msg::HelpUnpin => "Désépingler le message N",
+        Msg::MessagePinned => "Message épinglé",
+        Msg::MessageUnpinned => "Message désépinglé",
+        Msg::MessageNotPinned => "Ce message n'est pas épinglé.",
+        Msg::NoPinnedMessages => "Aucun message épinglé. Utilisez !pin msg <n>.",
+        Msg::PinnedMessagesTitle => "Messages épinglés :",
+        Msg::SessionMessagesTitle => "Messages de la session :",
+        Msg::LabelPinned => "épinglés",
+        Msg::HelpPr => "Rédiger un commit et une pull request pour les modifications de la session",
+        Msg::DraftingPr => "Rédaction du message de commit et de la description de la PR...",
+        Msg::CommitMessageTitle => "Message de commit :",
+        Msg::PullRequestTitle => "Pull request :",
+        Msg::ConfirmCommit => "Indexer toutes les modifications et committer ?",
+        Msg::ChangesCommitted => "Commit créé sur la branche",
+        Msg::ConfirmPublish => "Pousser la branche et ouvrir la pull request avec gh ?",
+        Msg::PullRequestOpened => "Pull request ouverte :",
+        Msg::LabelTurns => "tours",
+        Msg::TurnsThisSession => "dans cette session",
+        Msg::TurnsAllSessions => "toutes sessions confondues",
+        Msg::LoadChoiceTrim => "[t] réduire l'historique",
+        Msg::LoadChoiceSwitch => "[s] passer à",
+        Msg::LoadChoiceContinue => "[c] continuer",
+        Msg::HistoryTrimmed => "Anciens messages retirés pour tenir dans la fenêtre de contexte :",
+        Msg::ModelSwitched => "Modèle changé pour",
+        Msg::HelpRate => "Noter le dernier tour pour le jeu de données d'affinage",
+        Msg::NothingToRate => "Il n'y a encore aucun tour à noter",
+        Msg::RatedGood => "Noté bon :",
+        Msg::RatedBad => "Noté mauvais :",
+        Msg::HelpRemember => "Ajouter une note à la mémoire à long terme",
+        Msg::Remembered => "Enregistré dans la mémoire à long terme ; le prochain prompt l'inclut.",
+        Msg::CommandWarnings => "avertissement(s) dans la sortie d'une commande réussie",
+        Msg::TerminalDetached => "Terminal détaché ; fin de la session.",
+        Msg::StdinClosed => "L'entrée standard est fermée ; rien à faire. Envoyez des prompts à prime via un tube ou utilisez prime -p \"<prompt>\".",
+        Msg::HelpContext => "Lister les sections du prochain prompt par source, avec une estimation des tokens",
+        Msg::ContextTitle => "Prochain prompt par source (tokens est.)",
+        Msg::OutsideWorkspaceWrite => "Écriture hors de l'espace de travail :",
+        Msg::HelpSet => "Règle temperature, top_p, max_tokens, context_budget ou stop pour cette session (none efface)",
+        Msg::LabelParams => "paramètres",
+        Msg::ParamsUpdated => "Paramètres de la session :",
+        Msg::SessionResumed => "Session reprise",
+        Msg::HelpClarify => "Pose une question avant d'agir quand le modèle n'est pas sûr de la demande.",
+        Msg::ClarifyOn => "Clarifier d'abord activé : les plans incertains deviennent une question.",
+        Msg::ClarifyOff => "Clarifier d'abord désactivé.",
+        Msg::PlanHeldBack => "Plan non exécuté, confiance",
+        Msg::HelpRecord => "Enregistre la session au format asciinema (start [fichier] | stop).",
+        Msg::RecordingStarted => "Enregistrement dans",
+        Msg::RecordingSaved => "Enregistrement sauvegardé :",
+        Msg::RecordingNotActive => "Aucun enregistrement en cours.",
+        Msg::RecordingActive => "Un enregistrement est déjà en cours :",
+        Msg::HelpVoice => "Dictez une demande ; elle est transcrite dans l'invite pour relecture (expérimental).",
+        Msg::VoiceDisabled => "La saisie vocale est désactivée. Mettez enabled = true dans [voice] de config.toml.",
+        Msg::VoiceNeedsTerminal => "La saisie vocale nécessite un terminal interactif.",
+        Msg::VoiceListening => "Écoute, secondes :",
+        Msg::VoiceConfirm => "Corrigez la transcription si besoin, Entrée pour l'exécuter, Ctrl+C pour l'abandonner.",
+        Msg::NoTerminalAnswer => "non (l'entrée standard n'est pas un terminal)",
+        Msg::StepNeedsTerminal => "Le mode pas à pas a besoin d'un terminal interactif pour s'arrêter ; le reste du plan n'est pas exécuté.",
+        Msg::FilesToCommitTitle => "Fichiers à committer (git add -A) :",
+        Msg::LabelJobs => "tâches",
+    })
+}
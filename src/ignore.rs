@@ -0,0 +1,150 @@
+//! Workspace `.primeignore` support
+//! Gitignore-style rules deciding which workspace paths Prime's file tools may read.
+//! Supported syntax: `#` comments, `!` negation, trailing `/` for directories,
+//! patterns without a `/` match any path component, `**` spans directories.
+
+use std::fs;
+use std::path::{Component, Path, PathBuf};
+
+use anyhow::{Context, Result};
+use glob::{MatchOptions, Pattern};
+
+pub const PRIMEIGNORE_FILENAME: &str = ".primeignore";
+
+#[derive(Debug, Clone)]
+struct IgnoreRule {
+    pattern: Pattern,
+    negated: bool,
+    dir_only: bool,
+    anchored: bool,
+}
+
+#[derive(Debug, Clone, Default)]
+pub struct PrimeIgnore {
+    root: PathBuf,
+    rules: Vec<IgnoreRule>,
+}
+
+impl PrimeIgnore {
+    /// Loads `<workspace>/.primeignore`; a missing file yields an empty rule set
+    pub fn load(workspace: &Path) -> Result<Self> {
+        let path = workspace.join(PRIMEIGNORE_FILENAME);
+        let content = if path.exists() {
+            fs::read_to_string(&path).with_context(|| format!("Failed to read {}", path.display()))?
+        } else {
+            String::new()
+        };
+        Self::parse(workspace, &content)
+    }
+
+    pub fn parse(workspace: &Path, content: &str) -> Result<Self> {
+        let mut rules = Vec::new();
+        for line in content.lines() {
+            let line = line.trim();
+            if line.is_empty() || line.starts_with('#') {
+                continue;
+            }
+            let (negated, line) = match line.strip_prefix('!') {
+                Some(rest) => (true, rest),
+                None => (false, line),
+            };
+            let dir_only = line.ends_with('/');
+            let line = line.trim_end_matches('/');
+            let anchored = line.contains('/');
+            let line = line.trim_start_matches('/');
+            if line.is_empty() {
+                continue;
+            }
+            let pattern = Pattern::new(line)
+                .with_context(|| format!("Invalid pattern in {}: {}", PRIMEIGNORE_FILENAME, line))?;
+            rules.push(IgnoreRule { pattern, negated, dir_only, anchored });
+        }
+        Ok(Self { root: workspace.to_path_buf(), rules })
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.rules.is_empty()
+    }
+
+    /// Returns true when `path` (absolute or workspace-relative) is excluded.
+    /// Paths outside the workspace are not governed by .primeignore.
+    pub fn is_ignored(&self, path: &Path) -> bool {
+        if self.rules.is_empty() {
+            return false;
+        }
+        let relative = if path.is_absolute() {
+            match path.strip_prefix(&self.root) {
+                Ok(rel) => rel,
+                Err(_) => return false,
+            }
+        } else {
+            path
+        };
+        let components: Vec<String> = relative
+            .components()
+            .filter_map(|c| match c {
+                Component::Normal(part) => Some(part.to_string_lossy().to_string()),
+                _ => None,
+            })
+            .collect();
+        if components.is_empty() {
+            return false;
+        }
+        let is_dir = path.is_dir() || self.root.join(relative).is_dir();
+        let options = MatchOptions { require_literal_separator: true, ..MatchOptions::new() };
+
+        // Later rules override earlier ones, as in .gitignore.
+        let mut ignored = false;
+        for rule in &self.rules {
+            let matched = (0..components.len()).any(|i| {
+                let is_last = i + 1 == components.len();
+                if rule.dir_only && is_last && !is_dir {
+                    return false;
+                }
+                if rule.anchored {
+                    rule.pattern.matches_with(&components[..=i].join("/"), options)
+                } else {
+                    rule.pattern.matches_with(&components[i], options)
+                }
+            });
+            if matched {
+                ignored = !rule.negated;
+            }
+        }
+        ignored
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rules(content: &str) -> PrimeIgnore {
+        PrimeIgnore::parse(Path::new("/nonexistent/ws"), content).unwrap()
+    }
+
+    #[test]
+    fn test_name_patterns_match_any_component() {
+        let ignore = rules("# secrets\n.env\n*.pem\n");
+        assert!(ignore.is_ignored(Path::new(".env")));
+        assert!(ignore.is_ignored(Path::new("config/prod/key.pem")));
+        assert!(!ignore.is_ignored(Path::new("src/main.rs")));
+    }
+
+    #[test]
+    fn test_anchored_and_directory_patterns() {
+        let ignore = rules("vendor/\n/build/output\n");
+        assert!(ignore.is_ignored(Path::new("vendor/lib/a.go")));
+        assert!(!ignore.is_ignored(Path::new("vendor")));
+        assert!(ignore.is_ignored(Path::new("build/output/app.bin")));
+        assert!(!ignore.is_ignored(Path::new("src/build/output")));
+    }
+
+    #[test]
+    fn test_negation_and_outside_paths() {
+        let ignore = rules("*.log\n!keep.log\n");
+        assert!(ignore.is_ignored(Path::new("/nonexistent/ws/logs/app.log")));
+        assert!(!ignore.is_ignored(Path::new("logs/keep.log")));
+        assert!(!ignore.is_ignored(Path::new("/elsewhere/app.log")));
+    }
+}
@@ -0,0 +1,326 @@
+//! Detection of commands that wait for a terminal
+//! Shell actions run with captured output and no stdin, so editors, pagers, REPLs and
+//! password prompts hang the turn instead of failing. Commands with a known
+//! non-interactive form are rewritten before they run; the rest are refused with a hint
+//! the model can act on. The checks are heuristics over whitespace-separated words.
+
+/// Environment given to every shell action so common tools do not page or prompt
+pub const NON_INTERACTIVE_ENV: &[(&str, &str)] = &[
+    ("PAGER", "cat"),
+    ("GIT_PAGER", "cat"),
+    ("GIT_TERMINAL_PROMPT", "0"),
+    ("DEBIAN_FRONTEND", "noninteractive"),
+];
+
+const EDITORS: &[&str] = &["vi", "vim", "nvim", "nano", "pico", "emacs", "micro", "joe"];
+const MONITORS: &[&str] = &["top", "htop", "btop", "watch", "tmux", "screen"];
+const PAGERS: &[&str] = &["less", "more", "most"];
+const REPLS: &[&str] = &[
+    "python", "python3", "node", "deno", "irb", "ghci", "lua", "R", "php", "psql", "mysql", "sqlite3", "bash", "sh", "zsh",
+];
+const PROMPTS_FOR_PASSWORD: &[&str] = &["su", "passwd", "login"];
+/// Wrappers that run the rest of the segment as the actual command
+const PREFIXES: &[&str] = &["time", "nohup", "exec", "command"];
+/// ssh options that take a value, so the word after them is not the host
+const SSH_VALUE_OPTIONS: &str = "bcDEeFIiJLlmOopQRSWw";
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Interactive {
+    /// The command runs in this form instead; `reason` says what was changed
+    Rewritten { command: String, reason: String },
+    /// The command would wait for input; `reason` explains and suggests an alternative
+    Blocking { reason: String },
+}
+
+#[derive(Debug, PartialEq)]
+enum Finding {
+    Rewrite(String, Vec<&'static str>),
+    Block(String),
+}
+
+/// Checks every segment of a command line (split on `;`, `&`, `|` and newlines)
+pub fn check(command: &str) -> Option<Interactive> {
+    let mut rewritten = String::with_capacity(command.len());
+    let mut reasons: Vec<&'static str> = Vec::new();
+    let mut last = 0;
+    for (start, end, piped_input) in segments(command) {
+        match check_segment(&command[start..end], piped_input) {
+            Some(Finding::Block(reason)) => return Some(Interactive::Blocking { reason }),
+            Some(Finding::Rewrite(segment, why)) => {
+                rewritten.push_str(&command[last..start]);
+                rewritten.push_str(&segment);
+                last = end;
+                for reason in why {
+                    if !reasons.contains(&reason) {
+                        reasons.push(reason);
+                    }
+                }
+            }
+            None => {}
+        }
+    }
+    if reasons.is_empty() {
+        return None;
+    }
+    rewritten.push_str(&command[last..]);
+    Some(Interactive::Rewritten { command: rewritten, reason: reasons.join("; ") })
+}
+
+/// Whether the `&` or `|` at `i` belongs to a redirection (`2>&1`, `<&3`, `&>log`, `>|log`)
+fn is_redirect(bytes: &[u8], i: usize) -> bool {
+    let previous = i.checked_sub(1).map(|p| bytes[p]);
+    match bytes[i] {
+        b'&' => matches!(previous, Some(b'>' | b'<')) || bytes.get(i + 1) == Some(&b'>'),
+        b'|' => previous == Some(b'>'),
+        _ => false,
+    }
+}
+
+/// Byte ranges of the trimmed segments, each with whether it reads from a pipe. Operators
+/// inside quotes or escaped with a backslash do not split.
+fn segments(command: &str) -> Vec<(usize, usize, bool)> {
+    let bytes = command.as_bytes();
+    let is_separator = |i: usize| matches!(bytes[i], b';' | b'&' | b'|' | b'\n') && !is_redirect(bytes, i);
+    let mut ranges = Vec::new();
+    let mut push = |from: usize, to: usize, piped_input: bool| {
+        let raw = &command[from..to];
+        let trimmed = raw.trim();
+        if !trimmed.is_empty() {
+            let start = from + (raw.len() - raw.trim_start().len());
+            ranges.push((start, start + trimmed.len(), piped_input));
+        }
+    };
+    let mut quote = None;
+    let mut start = 0;
+    let mut piped_input = false;
+    let mut i = 0;
+    while i < bytes.len() {
+        match (quote, bytes[i]) {
+            (Some(open), byte) if byte == open => quote = None,
+            (Some(b'"'), b'\\') | (None, b'\\') => i += 1,
+            (Some(_), _) => {}
+            (None, byte @ (b'\'' | b'"')) => quote = Some(byte),
+            (None, _) if is_separator(i) => {
+                push(start, i, piped_input);
+                let run = i;
+                while i < bytes.len() && is_separator(i) {
+                    i += 1;
+                }
+                piped_input = &command[run..i] == "|";
+                start = i;
+                continue;
+            }
+            _ => {}
+        }
+        i += 1;
+    }
+    push(start, bytes.len(), piped_input);
+    ranges
+}
+
+/// End offset of the `index`th word of `segment`
+fn word_end(segment: &str, index: usize) -> usize {
+    segment
+        .split_whitespace()
+        .nth(index)
+        .map(|word| word.as_ptr() as usize - segment.as_ptr() as usize + word.len())
+        .unwrap_or(segment.len())
+}
+
+fn insert_after_word(segment: &str, index: usize, text: &str) -> String {
+    let at = word_end(segment, index);
+    format!("{}{}{}", &segment[..at], text, &segment[at..])
+}
+
+fn has_any(words: &[&str], flags: &[&str]) -> bool {
+    words.iter().any(|word| {
+        flags.contains(word) || flags.iter().any(|flag| flag.starts_with("--") && word.starts_with(&format!("{}=", flag)))
+    })
+}
+
+fn check_segment(segment: &str, piped_input: bool) -> Option<Finding> {
+    let words: Vec<&str> = segment.split_whitespace().collect();
+    // Skip `FOO=bar` assignments and wrappers such as `time` to reach the program.
+    let head = words.iter().position(|word| !(word.contains('=') && !word.starts_with('-')) && !PREFIXES.contains(word))?;
+    if head > 0 {
+        return prefixed(segment, word_end(segment, head) - words[head].len(), piped_input);
+    }
+    let program = words[0].rsplit('/').next().unwrap_or(words[0]);
+    let args = &words[1..];
+    let block = |reason: String| Some(Finding::Block(reason));
+
+    if program == "sudo" {
+        let mut i = 1;
+        while i < words.len() && words[i].starts_with('-') {
+            i += if matches!(words[i], "-u" | "-g" | "-C" | "-D" | "-p" | "-h") { 2 } else { 1 };
+        }
+        if !has_any(&words[1..i.min(words.len())], &["-n", "-S", "--non-interactive", "--stdin"]) {
+            let with_flag = insert_after_word(segment, 0, " -n");
+            let (segment, mut reasons) = match check_segment(&with_flag, piped_input) {
+                Some(Finding::Block(reason)) => return Some(Finding::Block(reason)),
+                Some(Finding::Rewrite(segment, reasons)) => (segment, reasons),
+                None => (with_flag, Vec::new()),
+            };
+            reasons.insert(0, "sudo -n fails instead of waiting for a password");
+            return Some(Finding::Rewrite(segment, reasons));
+        }
+        if i >= words.len() {
+            return None;
+        }
+        return prefixed(segment, word_end(segment, i) - words[i].len(), piped_input);
+    }
+    if EDITORS.contains(&program) && !(program == "emacs" && has_any(args, &["--batch", "-batch"])) {
+        return block(format!("`{}` is an interactive editor and would hang the turn; use write_file to change files", program));
+    }
+    if MONITORS.contains(&program) && !(program == "top" && has_any(args, &["-b"])) {
+        return block(format!(
+            "`{}` redraws the screen until it is quit; use a one-shot command such as `ps aux` or `top -b -n 1`",
+            program
+        ));
+    }
+    if PROMPTS_FOR_PASSWORD.contains(&program) {
+        return block(format!("`{}` asks for a password on the terminal; ask the user to run it", program));
+    }
+    if REPLS.contains(&program) && (args.is_empty() || args == ["-i"]) && !piped_input && !segment.contains('<') {
+        return block(format!("`{}` without a script waits for input; run a file or pass the code inline (e.g. -c / -e)", program));
+    }
+    if PAGERS.contains(&program) {
+        let files: Vec<&str> = args.iter().copied().filter(|arg| !arg.starts_with('-') && !arg.starts_with('+')).collect();
+        let rewritten = std::iter::once("cat").chain(files).collect::<Vec<_>>().join(" ");
+        return Some(Finding::Rewrite(rewritten, vec!["pagers wait for keys; cat prints the same text"]));
+    }
+    match (program, args) {
+        ("ssh", _) => ssh(segment, args),
+        ("git", [sub, rest @ ..]) => git(sub, rest),
+        ("npm" | "yarn" | "pnpm", ["init", rest @ ..]) if !has_any(rest, &["-y", "--yes"]) => {
+            Some(Finding::Rewrite(insert_after_word(segment, 1, " -y"), vec!["init -y accepts the defaults instead of asking questions"]))
+        }
+        ("npx", _) if !has_any(args, &["-y", "--yes", "--no"]) => {
+            Some(Finding::Rewrite(insert_after_word(segment, 0, " --yes"), vec!["npx --yes installs the package without asking"]))
+        }
+        ("apt" | "apt-get" | "dnf" | "yum", [sub, rest @ ..])
+            if matches!(*sub, "install" | "remove" | "purge" | "upgrade" | "dist-upgrade" | "autoremove")
+                && !has_any(rest, &["-y", "--yes", "--assume-yes", "-q=2"]) =>
+        {
+            Some(Finding::Rewrite(insert_after_word(segment, 1, " -y"), vec!["-y answers the package manager's confirmation"]))
+        }
+        _ => None,
+    }
+}
+
+/// Re-checks what follows leading assignments or wrappers, keeping them in front
+fn prefixed(segment: &str, inner_start: usize, piped_input: bool) -> Option<Finding> {
+    match check_segment(&segment[inner_start..], piped_input)? {
+        Finding::Block(reason) => Some(Finding::Block(reason)),
+        Finding::Rewrite(inner, reasons) => Some(Finding::Rewrite(format!("{}{}", &segment[..inner_start], inner), reasons)),
+    }
+}
+
+fn ssh(segment: &str, args: &[&str]) -> Option<Finding> {
+    let mut i = 0;
+    while i < args.len() && args[i].starts_with('-') {
+        let option = args[i].trim_start_matches('-');
+        let takes_value = option.len() == 1 && SSH_VALUE_OPTIONS.contains(option);
+        i += if takes_value { 2 } else { 1 };
+    }
+    if i + 1 >= args.len() {
+        return Some(Finding::Block(
+            "`ssh` without a remote command opens an interactive shell; pass the command to run on the host".to_string(),
+        ));
+    }
+    let options = &args[..i];
+    let batch = options.windows(2).any(|pair| pair[0] == "-o" && pair[1].eq_ignore_ascii_case("BatchMode=yes"));
+    let mut added = String::new();
+    if !options.contains(&"-T") {
+        added.push_str(" -T");
+    }
+    if !batch {
+        added.push_str(" -o BatchMode=yes");
+    }
+    if added.is_empty() {
+        return None;
+    }
+    Some(Finding::Rewrite(
+        insert_after_word(segment, 0, &added),
+        vec!["ssh -T -o BatchMode=yes fails instead of prompting for a password or host key"],
+    ))
+}
+
+fn git(sub: &str, rest: &[&str]) -> Option<Finding> {
+    let block = |reason: &str| Some(Finding::Block(reason.to_string()));
+    match sub {
+        "commit"
+            if !has_any(rest, &["--message", "--file", "--reuse-message", "--no-edit"])
+                && !rest.iter().any(|arg| arg.starts_with('-') && !arg.starts_with("--") && arg.contains(['m', 'F', 'C'])) =>
+        {
+            block("`git commit` without a message opens an editor; pass the message with -m")
+        }
+        "rebase" | "add" | "checkout" | "reset" | "stash" if has_any(rest, &["-i", "--interactive", "-p", "--patch"]) => {
+            block("interactive git modes (-i / -p) wait for input; use the non-interactive form")
+        }
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn rewritten(command: &str) -> String {
+        match check(command) {
+            Some(Interactive::Rewritten { command, .. }) => command,
+            other => panic!("expected a rewrite of {:?}, got {:?}", command, other),
+        }
+    }
+
+    fn blocks(command: &str) -> bool {
+        matches!(check(command), Some(Interactive::Blocking { .. }))
+    }
+
+    #[test]
+    fn test_non_interactive_flags_are_added() {
+        assert_eq!(rewritten("ssh deploy@host uptime"), "ssh -T -o BatchMode=yes deploy@host uptime");
+        assert_eq!(rewritten("cd web && npm init"), "cd web && npm init -y");
+        assert_eq!(rewritten("sudo apt-get install curl"), "sudo -n apt-get install -y curl");
+        assert_eq!(rewritten("git log | less -R"), "git log | cat");
+        assert_eq!(rewritten("CI=1 npx create-vite app"), "CI=1 npx --yes create-vite app");
+        assert_eq!(rewritten("make 2>&1 | less"), "make 2>&1 | cat");
+        assert_eq!(rewritten("echo 'a | b' && git log | more"), "echo 'a | b' && git log | cat");
+    }
+
+    #[test]
+    fn test_quotes_and_redirections_do_not_split() {
+        assert_eq!(segments("echo 'a | less -R'"), vec![(0, 18, false)]);
+        assert_eq!(segments("echo \"x; vim\" \\; ls"), vec![(0, 19, false)]);
+        assert_eq!(segments("cargo build &> log && cat log"), vec![(0, 18, false), (22, 29, false)]);
+        assert_eq!(segments("ls >| out | sort"), vec![(0, 9, false), (12, 16, true)]);
+        assert_eq!(check("echo 'a | less -R'"), None);
+        assert_eq!(check("printf 'x' 2>&1 >/dev/null"), None);
+    }
+
+    #[test]
+    fn test_blocking_commands_are_refused() {
+        assert!(blocks("vim src/main.rs"));
+        assert!(blocks("ssh -p 2222 host"));
+        assert!(blocks("git commit"));
+        assert!(blocks("git rebase -i HEAD~3"));
+        assert!(blocks("python3"));
+        assert!(blocks("top"));
+    }
+
+    #[test]
+    fn test_ordinary_commands_pass() {
+        for command in [
+            "ls -la",
+            "git commit -am 'fix'",
+            "npm init -y",
+            "ssh -T -o BatchMode=yes host ls",
+            "echo 'print(1)' | python3",
+            "python3 < script.py",
+            "top -b -n 1",
+            "sudo -n systemctl restart nginx",
+        ] {
+            assert_eq!(check(command), None, "{}", command);
+        }
+    }
+}
@@ -1,29 +1,195 @@
 //! Entry point for Prime CLI
 //! v0.2.5: Enhanced with streaming responses and rich display
 
+mod analytics;
+mod approval;
+mod chatter;
+mod clarify;
+mod clock;
+mod cli;
 mod commands;
 mod config;
+mod confine;
 mod console;
+mod continuation;
+mod dataset;
+mod dependencies;
 mod memory;
+mod metadata;
+mod model_load;
+mod opener;
+mod outcome;
+mod scratch;
 mod session;
+mod sessions;
+mod snapshot;
+mod status;
+mod startup;
+mod stdin;
+mod terminal;
+mod tokens;
+mod transcript;
+mod params;
 mod parser;
+mod placeholders;
+mod provenance;
+mod policy;
+mod pr;
+mod recording;
+mod recovery;
 mod streaming;
+mod turn_lock;
 mod display;
+mod evidence;
+mod exec;
+mod interactive;
+mod i18n;
+mod ignore;
+mod update;
+mod voice;
+mod vault;
+mod vfs;
+
+#[cfg(test)]
+mod testing;
 
 use std::env;
 use std::process;
 
 use anyhow::{Context as AnyhowContext, Result};
-use crossterm::style::Stylize;
+use crate::terminal::Stylize;
 use llm::builder::{LLMBackend, LLMBuilder};
-use session::PrimeSession;
+use llm::chat::ChatProvider;
+use llm::LLMProvider;
+use session::{PrimeSession, TurnOutcome};
+use crate::cli::CliCommand;
 use crate::config::Config;
 
 pub const APP_NAME: &str = "prime";
 
 #[tokio::main]
 async fn main() -> Result<()> {
-    console::display_banner();
+    let mut timer = startup::StartupTimer::start();
+    // Subcommands keep the detected mode; the REPL applies the `color` setting once the config is loaded.
+    let mut color_mode = terminal::init(terminal::ColorSetting::Auto);
+    let command = match cli::parse_args(env::args().skip(1)) {
+        Ok(command) => command,
+        Err(e) => {
+            eprintln!("{}", format!("[ERROR] {}", e).red());
+            process::exit(2);
+        }
+    };
+
+    let mut resume = None;
+    let mut fast = false;
+    let one_shot = match command {
+        CliCommand::Help => {
+            cli::print_usage();
+            return Ok(());
+        }
+        CliCommand::Update { check_only, migrate_only } => {
+            let result = if migrate_only {
+                config::get_prime_config_dir().and_then(|dir| update::migrate_data_dir(&dir))
+            } else {
+                update::run_update(check_only).await
+            };
+            if let Err(e) = result {
+                eprintln!("{}", format!("[ERROR] Update failed: {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::Report { month } => {
+            let result = config::load_config().and_then(|cfg| {
+                let base_dir = config::get_prime_config_dir()?;
+                analytics::run_report(&base_dir, month.as_deref(), cfg.analytics)
+            });
+            if let Err(e) = result {
+                eprintln!("{}", format!("[ERROR] {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::ExportObsidian { vault } => {
+            let result = config::load_config().and_then(|cfg| {
+                let base_dir = config::get_prime_config_dir()?;
+                let vault_dir = vault.or(cfg.obsidian_vault).ok_or_else(|| {
+                    anyhow::anyhow!("No vault directory given. Pass one or set `obsidian_vault` in config.toml.")
+                })?;
+                vault::run_export(&base_dir, &config::expand_home(&vault_dir))
+            });
+            if let Err(e) = result {
+                eprintln!("{}", format!("[ERROR] Export failed: {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::ExportDataset { path } => {
+            let result = config::get_prime_config_dir()
+                .and_then(|base_dir| dataset::run_export(&base_dir, path.map(|path| config::expand_home(&path))));
+            if let Err(e) = result {
+                eprintln!("{}", format!("[ERROR] Export failed: {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::MergeSessions { a, b } => {
+            if let Err(e) = config::get_prime_config_dir().and_then(|base_dir| sessions::run_merge(&base_dir, &a, &b)) {
+                eprintln!("{}", format!("[ERROR] Merge failed: {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::ConvertSessions { format, session } => {
+            let result = config::get_prime_config_dir()
+                .and_then(|base_dir| transcript::run_convert(&base_dir, &format, session.as_deref()));
+            if let Err(e) = result {
+                eprintln!("{}", format!("[ERROR] Conversion failed: {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::Exec { command } => {
+            let result = match config::load_config().and_then(|cfg| Ok((cfg, config::get_prime_config_dir()?))) {
+                Ok((cfg, base_dir)) => exec::run_exec(&cfg, &base_dir, &command).await,
+                Err(e) => Err(e),
+            };
+            match result {
+                Ok(code) => process::exit(code),
+                Err(e) => {
+                    eprintln!("{}", format!("[ERROR] {}", e).red());
+                    process::exit(1);
+                }
+            }
+        }
+        CliCommand::Memory { action, memory_type, category } => {
+            let result = config::get_prime_config_dir()
+                .and_then(|base_dir| memory::run_cli(&base_dir, action, memory_type.as_deref(), category.as_deref()));
+            if let Err(e) = result {
+                eprintln!("{}", format!("[ERROR] {}", e).red());
+                process::exit(1);
+            }
+            return Ok(());
+        }
+        CliCommand::Prompt { prompt, quiet, command_results, fast: skip_probes } => {
+            fast = skip_probes;
+            if quiet {
+                // Quiet output is read by other programs, so it never carries escapes.
+                terminal::set_output_mode(terminal::OutputMode::Quiet { command_results });
+                color_mode = terminal::init(terminal::ColorSetting::Never);
+            }
+            Some(prompt)
+        }
+        CliCommand::Repl => None,
+        CliCommand::Resume { session } => {
+            resume = Some(session);
+            None
+        }
+    };
+
+    if !fast {
+        update::cleanup_previous_binary();
+    }
 
     let config = match config::load_config() {
         Ok(cfg) => cfg,
@@ -32,8 +198,34 @@ async fn main() -> Result<()> {
             process::exit(1);
         }
     };
+    timer.mark("config");
+
+    match terminal::ColorSetting::from_config(&config.color) {
+        Ok(_) if terminal::is_quiet() => {}
+        Ok(terminal::ColorSetting::Auto) => {}
+        Ok(setting) => color_mode = terminal::init(setting),
+        Err(e) => eprintln!("{}", format!("Warning: {}", e).yellow()),
+    }
+    if let terminal::ColorMode::Plain { reason: Some(reason) } = color_mode {
+        println!("{}", reason);
+    }
+
+    match i18n::Language::from_code(&config.ui_language) {
+        Some(language) => i18n::set_language(language),
+        None => eprintln!("{}", format!("Warning: Unsupported ui_language '{}'. Using English.", config.ui_language).yellow()),
+    }
+    if !terminal::is_quiet() && !fast {
+        console::display_banner();
+    }
 
-    let session = match init_session(config).await {
+    // Fast runs migrate too: the session must never read an old layout, and an up-to-date
+    // directory costs one small file read.
+    if let Err(e) = config::get_prime_config_dir().and_then(|dir| update::migrate_data_dir(&dir)) {
+        eprintln!("{}", format!("Warning: Failed to migrate Prime data directory: {}", e).yellow());
+    }
+    timer.mark("banner");
+
+    let mut session = match init_session(config, fast).await {
         Ok(session) => session,
         Err(e) => {
             eprintln!("{}", format!("[ERROR] Initialization error: {}", e).red());
@@ -41,7 +233,40 @@ async fn main() -> Result<()> {
         }
     };
 
-    if let Err(e) = console::run_repl(session).await {
+    if let Some(requested) = resume {
+        match session.resume(requested.as_deref()) {
+            Ok(session_id) => println!("{} {}", i18n::tr(i18n::Msg::SessionResumed), session_id),
+            Err(e) => {
+                eprintln!("{}", format!("[ERROR] Resume failed: {}", e).red());
+                process::exit(1);
+            }
+        }
+    }
+
+    timer.mark("session");
+    timer.report();
+
+    if let Some(prompt) = one_shot {
+        match session.process_input(&prompt).await {
+            Ok(TurnOutcome::Completed) => return Ok(()),
+            Ok(TurnOutcome::Failed) => process::exit(1),
+            Ok(TurnOutcome::Cancelled) => process::exit(2),
+            Err(e) => {
+                eprintln!("{}", format!("[ERROR] {}", e).red());
+                process::exit(1);
+            }
+        }
+    }
+
+    let run = match stdin::InputSource::detect() {
+        stdin::InputSource::Terminal => console::run_repl(session).await,
+        stdin::InputSource::Stream => console::run_headless(session).await,
+        stdin::InputSource::Closed => {
+            eprintln!("{}", i18n::tr(i18n::Msg::StdinClosed).yellow());
+            Ok(())
+        }
+    };
+    if let Err(e) = run {
         eprintln!("{}", format!("[ERROR] Session ended with an error: {}", e).red());
         process::exit(1);
     }
@@ -49,7 +274,33 @@ async fn main() -> Result<()> {
     Ok(())
 }
 
-async fn init_session(config: Config) -> Result<PrimeSession> {
+/// Builds the chat provider; also used to rebuild it with session parameters
+fn build_llm(provider: &str, api_key: &str, model: &str, max_tokens: u32, temperature: f32, top_p: Option<f32>) -> Result<Box<dyn LLMProvider>> {
+    let (backend, label) = match provider {
+        "google" => (LLMBackend::Google, "Google"),
+        "ollama" => (LLMBackend::Ollama, "Ollama"),
+        other => return Err(anyhow::anyhow!("Unsupported LLM provider: {}", other)),
+    };
+    let builder = LLMBuilder::new()
+        .backend(backend)
+        .api_key(api_key)
+        .model(model)
+        .max_tokens(max_tokens)
+        .temperature(temperature);
+    let builder = match top_p {
+        Some(top_p) => builder.top_p(top_p),
+        None => builder,
+    };
+    builder
+        .build()
+        .with_context(|| format!("Failed to build LLM provider ({})", label))
+}
+
+/// Builds the session from the config; `fast` leaves out the init info and model load probes
+async fn init_session(config: Config, fast: bool) -> Result<PrimeSession> {
+    let prime_config_base_dir = config::get_prime_config_dir()?;
+    let approval = approval::ApprovalPolicy::from_config(&config, &prime_config_base_dir)?;
+
     let provider = env::var("LLM_PROVIDER").unwrap_or(config.provider);
     let model_from_env = env::var("LLM_MODEL").ok();
     
@@ -71,48 +322,72 @@ async fn init_session(config: Config) -> Result<PrimeSession> {
         .and_then(|s| s.parse::<u32>().ok())
         .unwrap_or(config.max_tokens);
 
-    let prime_config_base_dir = dirs::home_dir()
-        .ok_or_else(|| anyhow::anyhow!("Could not determine home directory"))?
-        .join(".prime");
-
     let workspace_dir = env::current_dir().context("Failed to get current working directory")?;
 
-    let (llm, provider_name) = match provider.as_str() {
+    let (api_key, provider_name) = match provider.as_str() {
         "google" => {
             let api_key = env::var("GEMINI_API_KEY").unwrap_or(config.gemini_api_key);
             if api_key.is_empty() {
                 return Err(anyhow::anyhow!("GEMINI_API_KEY not set in environment or config.toml. Please get a key from Google AI Studio."));
             }
-            let llm = LLMBuilder::new()
-                .backend(LLMBackend::Google)
-                .api_key(api_key)
-                .model(model.clone())
-                .max_tokens(max_tokens)
-                .temperature(temperature)
-                .build()
-                .context("Failed to build LLM provider (Google)")?;
-            (llm, "Google AI Platform")
-        },
-        "ollama" => {
-            let api_key = env::var("OLLAMA_API_KEY").unwrap_or(config.ollama_api_key);
-            let llm = LLMBuilder::new()
-                .backend(LLMBackend::Ollama)
-                .api_key(api_key)
-                .model(model.clone())
-                .max_tokens(max_tokens)
-                .temperature(temperature)
-                .build()
-                .context("Failed to build LLM provider (Ollama)")?;
-            (llm, "Ollama")
+            (api_key, "Google AI Platform")
         },
+        "ollama" => (env::var("OLLAMA_API_KEY").unwrap_or(config.ollama_api_key), "Ollama"),
         _ => {
             return Err(anyhow::anyhow!("Unsupported LLM provider: {}", provider));
         }
     };
+    let llm = build_llm(&provider, &api_key, &model, max_tokens, temperature, None)?;
+
+    if !terminal::is_quiet() && !fast {
+        console::display_init_info(&model, provider_name, &prime_config_base_dir, &workspace_dir);
+    }
+
+    // Accept either a known language code or a free-form language name.
+    let response_language = config.response_language
+        .filter(|lang| !lang.trim().is_empty())
+        .map(|lang| i18n::Language::from_code(&lang).map(|l| l.name().to_string()).unwrap_or(lang));
 
-    console::display_init_info(&model, provider_name, &prime_config_base_dir, &workspace_dir);
+    let usage = config.analytics.then(|| analytics::UsageRecorder::new(&prime_config_base_dir, &model));
 
-    let session = PrimeSession::new(prime_config_base_dir, llm)?;
+    let mut session = PrimeSession::new(prime_config_base_dir, llm)?;
+    session.response_language = response_language;
+    session.usage = usage;
+    session.typewriter_cps = config.typewriter_cps;
+    session.step_mode = config.step_mode;
+    session.clarify_first = config.clarify_first;
+    session.voice = config.voice.clone();
+    session.warning_follow_up = config.warning_follow_up;
+    match transcript::LogFormat::from_config(&config.log_format) {
+        Ok(format) => session.set_log_format(format),
+        Err(e) => eprintln!("{}", format!("Warning: {}", e).yellow()),
+    }
+    session.stall_warning_secs = config.stall_warning_secs;
+    session.max_continuations = config.max_continuations;
+    if config.file_snapshots {
+        session.enable_file_snapshots(snapshot::SnapshotLimits {
+            max_file_bytes: config.snapshot_max_file_bytes,
+            ..Default::default()
+        });
+    }
+    session.set_approval(approval);
+    session.model_name = model.clone();
+    session.provider_name = provider_name.to_string();
+    session.configure_risk(&config.risk)?;
+    if provider == "ollama" && !fast {
+        session.enable_load_checks(model_load::ollama_host());
+    }
+    session.enable_adaptive_recovery(temperature, Box::new(move |model, params| {
+        let llm: Box<dyn ChatProvider> = build_llm(
+            &provider,
+            &api_key,
+            model,
+            params.max_tokens.unwrap_or(max_tokens),
+            params.temperature.unwrap_or(temperature),
+            params.top_p,
+        )?;
+        Ok(llm)
+    }));
 
     Ok(session)
 }
\ No newline at end of file
@@ -1,32 +1,154 @@
 use anyhow::{anyhow, Context, Result};
-use std::fs;
-use std::io::Write;
-use std::path::PathBuf;
+use std::io::{IsTerminal, Read};
+use std::path::{Path, PathBuf};
+use std::time::SystemTime;
 use chrono::Utc;
- 
+use crate::terminal::Stylize;
+
+use crate::cli::MemoryAction;
+use crate::clock::{self, SharedClock};
+use crate::vfs::{self, SharedFs};
+use crate::provenance::{self, Source};
+
+pub const MEMORY_TYPES: &[&str] = &["long_term", "short_term"];
+
+/// One `## Entry (timestamp)` section of a memory file
+#[derive(Debug, Clone, PartialEq)]
+pub struct MemoryEntry {
+    pub timestamp: String,
+    /// From a `Category: name` line directly under the entry header
+    pub category: Option<String>,
+    pub content: String,
+}
+
+/// Splits a memory file into its entries; text before the first entry is the header
+fn parse_entries(content: &str) -> Vec<MemoryEntry> {
+    let mut entries: Vec<MemoryEntry> = Vec::new();
+    for line in content.lines() {
+        if let Some(timestamp) = line.strip_prefix("## Entry (").and_then(|rest| rest.strip_suffix(')')) {
+            entries.push(MemoryEntry { timestamp: timestamp.to_string(), category: None, content: String::new() });
+        } else if let Some(entry) = entries.last_mut() {
+            match line.strip_prefix("Category: ") {
+                Some(category) if entry.content.is_empty() && entry.category.is_none() => {
+                    entry.category = Some(category.trim().to_string());
+                }
+                _ => {
+                    entry.content.push_str(line);
+                    entry.content.push('\n');
+                }
+            }
+        }
+    }
+    for entry in &mut entries {
+        entry.content = entry.content.trim().to_string();
+    }
+    entries
+}
+
+fn render_entry(timestamp: &str, category: Option<&str>, content: &str) -> String {
+    match category {
+        Some(category) => format!("\n## Entry ({})\nCategory: {}\n{}\n", timestamp, category, content),
+        None => format!("\n## Entry ({})\n{}\n", timestamp, content),
+    }
+}
+
+/// `content` without the entries filed under `category` (case-insensitive), and how many
+/// were removed. The notes before the first entry and all other entries stay byte for byte.
+fn remove_category(content: &str, category: &str) -> (String, usize) {
+    let mut blocks = vec![String::new()];
+    for line in content.split_inclusive('\n') {
+        if line.starts_with("## Entry (") {
+            blocks.push(String::new());
+        }
+        if let Some(block) = blocks.last_mut() {
+            block.push_str(line);
+        }
+    }
+    let mut kept = blocks.remove(0);
+    let mut removed = 0;
+    for block in blocks {
+        let filed_under = parse_entries(&block).pop().and_then(|entry| entry.category);
+        if filed_under.is_some_and(|own| own.eq_ignore_ascii_case(category)) {
+            removed += 1;
+        } else {
+            kept.push_str(&block);
+        }
+    }
+    (kept, removed)
+}
+
+fn file_header(memory_type: &str) -> String {
+    format!(
+        "# Prime {} Memory\n\n(This file is for notes. The AI will read this.)",
+        if memory_type == "long_term" { "Long-term" } else { "Short-term" }
+    )
+}
+
+fn file_name(memory_type: &str) -> Result<&'static str> {
+    match memory_type {
+        "long_term" => Ok("long_term.md"),
+        "short_term" => Ok("short_term.md"),
+        _ => Err(anyhow!("Invalid memory type '{}' specified", memory_type)),
+    }
+}
+
+/// Size and modification time of each memory file
+type MemorySignature = Vec<Option<(SystemTime, u64)>>;
+
+/// Memory as rendered into the system prompt, reread only when a memory file changed
+/// on disk (including edits by `prime memory` or by hand) or after `invalidate`
+#[derive(Debug, Default)]
+pub struct MemoryCache {
+    signature: MemorySignature,
+    text: Option<String>,
+}
+
+impl MemoryCache {
+    pub fn get(&mut self, manager: &MemoryManager) -> Result<&str> {
+        let signature = manager.signature();
+        if self.text.is_none() || signature != self.signature {
+            self.text = Some(manager.read_memory(None)?);
+            self.signature = signature;
+        }
+        Ok(self.text.as_deref().unwrap_or_default())
+    }
+
+    /// Forces a reread, for writes that may not move the modification time
+    pub fn invalidate(&mut self) {
+        self.text = None;
+    }
+}
 
 /// Manages long-term and short-term memory for the assistant
 #[derive(Debug, Clone)]
 pub struct MemoryManager {
     memory_dir: PathBuf,
+    fs: SharedFs,
+    clock: SharedClock,
 }
 
 impl MemoryManager {
-    /// Creates a new MemoryManager, ensuring required files exist.
+    /// Creates a new MemoryManager. The directory and files are created on the first
+    /// write; until then a missing file reads as its empty header.
     pub fn new(memory_dir: PathBuf) -> Result<Self> {
-        fs::create_dir_all(&memory_dir)
-            .with_context(|| format!("Failed to create memory directory at {}", memory_dir.display()))?;
-
-        for mem_file in ["long_term.md", "short_term.md"] {
-            let file_path = memory_dir.join(mem_file);
-            if !file_path.exists() {
-                let header = format!("# Prime {} Memory\n\n(This file is for notes. The AI will read this.)",
-                    if mem_file == "long_term.md" { "Long-term" } else { "Short-term" });
-                fs::write(&file_path, header)
-                    .with_context(|| format!("Failed to create initial memory file at {}", file_path.display()))?;
-            }
+        Ok(Self::with_host(memory_dir, vfs::real(), clock::system()))
+    }
+
+    /// A MemoryManager on the given filesystem, stamping entries with the given clock
+    pub fn with_host(memory_dir: PathBuf, fs: SharedFs, clock: SharedClock) -> Self {
+        Self { memory_dir, fs, clock }
+    }
+
+    /// Path of a memory file, created with its header if it does not exist yet
+    fn ensure_file(&self, memory_type: &str) -> Result<PathBuf> {
+        let file_path = self.memory_dir.join(file_name(memory_type)?);
+        if !self.fs.exists(&file_path) {
+            self.fs.create_dir_all(&self.memory_dir)
+                .with_context(|| format!("Failed to create memory directory at {}", self.memory_dir.display()))?;
+            self.fs.write(&file_path, file_header(memory_type).as_bytes(), false)
+                .with_context(|| format!("Failed to create initial memory file at {}", file_path.display()))?;
         }
-        Ok(Self { memory_dir })
+        Ok(file_path)
     }
 
     /// Reads memory content from the specified file (or both if none specified)
@@ -44,13 +166,11 @@ impl MemoryManager {
                 memory_content.push_str(&content);
             }
             None => {
-                let long_term = self.read_file("long_term.md").unwrap_or_default();
-                let short_term = self.read_file("short_term.md").unwrap_or_default();
                 memory_content.push_str("\n<LONG_TERM_MEMORY>\n");
-                memory_content.push_str(long_term.trim());
+                memory_content.push_str(self.labeled_file("long_term").trim());
                 memory_content.push_str("\n</LONG_TERM_MEMORY>\n");
                 memory_content.push_str("\n<SHORT_TERM_MEMORY>\n");
-                memory_content.push_str(short_term.trim());
+                memory_content.push_str(self.labeled_file("short_term").trim());
                 memory_content.push_str("\n</SHORT_TERM_MEMORY>\n");
             }
             Some(other) => return Err(anyhow!("Invalid memory type '{}' specified", other)),
@@ -58,49 +178,250 @@ impl MemoryManager {
         Ok(memory_content)
     }
     
+    /// A memory file with each entry, and any free text above the entries, under its provenance label
+    fn labeled_file(&self, memory_type: &str) -> String {
+        let content = file_name(memory_type).and_then(|name| self.read_file(name)).unwrap_or_default();
+        let source = |category: Option<&str>| Source::Memory { memory_type: memory_type.to_string(), category: category.map(str::to_string) };
+        let mut labeled = String::new();
+        let notes: Vec<&str> = content.lines().take_while(|line| !line.starts_with("## Entry (")).collect();
+        let notes = notes.join("\n");
+        let notes = notes.trim().strip_prefix(file_header(memory_type).as_str()).unwrap_or(notes.trim()).trim();
+        if !notes.is_empty() {
+            labeled.push_str(&provenance::labeled(&source(None), notes));
+            labeled.push('\n');
+        }
+        for entry in parse_entries(&content) {
+            let text = format!("## Entry ({})\n{}", entry.timestamp, entry.content);
+            labeled.push_str(&provenance::labeled(&source(entry.category.as_deref()), &text));
+            labeled.push('\n');
+        }
+        labeled
+    }
+
     /// Writes content to the specified memory type
     pub fn write_memory(&self, memory_type: &str, content: &str) -> Result<()> {
-        let file_name = match memory_type {
-            "long_term" => "long_term.md",
-            "short_term" => "short_term.md",
-            _ => return Err(anyhow!("Invalid memory type '{}' specified", memory_type)),
-        };
-        
-        let file_path = self.memory_dir.join(file_name);
-        let timestamp = Utc::now();
-        let entry = format!("\n## Entry ({})\n{}\n", timestamp, content);
-        
-        fs::OpenOptions::new()
-            .append(true)
-            .open(&file_path)
-            .with_context(|| format!("Failed to open memory file for writing: {}", file_path.display()))
-            .and_then(|mut file| {
-                file.write_all(entry.as_bytes())
-                    .map_err(|e| anyhow::anyhow!("Failed to write to memory file: {}", e))
-            })
+        self.write_entry(memory_type, None, content)
+    }
+
+    /// Appends an entry, tagged with `category` when given
+    pub fn write_entry(&self, memory_type: &str, category: Option<&str>, content: &str) -> Result<()> {
+        let file_path = self.ensure_file(memory_type)?;
+        let entry = render_entry(&self.clock.now().with_timezone(&Utc).to_string(), category, content);
+        self.fs.write(&file_path, entry.as_bytes(), true)
             .with_context(|| format!("Failed to write to memory file: {}", file_path.display()))
     }
-    
+
     /// Clears the specified memory type
     pub fn clear_memory(&self, memory_type: &str) -> Result<()> {
-        let file_name = match memory_type {
-            "long_term" => "long_term.md",
-            "short_term" => "short_term.md",
-            _ => return Err(anyhow!("Invalid memory type '{}' specified", memory_type)),
-        };
-        
-        let file_path = self.memory_dir.join(file_name);
-        let header = format!("# Prime {} Memory\n\n(This file is for notes. The AI will read this.)",
-            if file_name == "long_term.md" { "Long-term" } else { "Short-term" });
-        
-        fs::write(&file_path, header)
+        let file_path = self.ensure_file(memory_type)?;
+        self.fs.write(&file_path, file_header(memory_type).as_bytes(), false)
             .with_context(|| format!("Failed to clear memory file: {}", file_path.display()))
     }
-    
+
+    /// Removes the entries of one category, keeping the rest; returns how many were removed
+    pub fn clear_category(&self, memory_type: &str, category: &str) -> Result<usize> {
+        let (content, removed) = remove_category(&self.read_file(file_name(memory_type)?)?, category);
+        if removed == 0 {
+            return Ok(0);
+        }
+        let file_path = self.ensure_file(memory_type)?;
+        self.fs.write(&file_path, content.as_bytes(), false).with_context(|| format!("Failed to rewrite memory file: {}", file_path.display()))?;
+        Ok(removed)
+    }
+
+    /// Entries of one memory type, oldest first
+    pub fn entries(&self, memory_type: &str) -> Result<Vec<MemoryEntry>> {
+        Ok(parse_entries(&self.read_file(file_name(memory_type)?)?))
+    }
+
+    /// Entries containing `query` (case-insensitive), optionally narrowed to a category
+    pub fn search(&self, memory_type: &str, query: &str, category: Option<&str>) -> Result<Vec<MemoryEntry>> {
+        let query = query.to_lowercase();
+        Ok(self
+            .entries(memory_type)?
+            .into_iter()
+            .filter(|entry| category.map_or(true, |c| entry.category.as_deref().is_some_and(|own| own.eq_ignore_ascii_case(c))))
+            .filter(|entry| entry.content.to_lowercase().contains(&query))
+            .collect())
+    }
+
+    /// Number of entries written to the given memory type
+    pub fn entry_count(&self, memory_type: &str) -> usize {
+        let file_name = if memory_type == "long_term" { "long_term.md" } else { "short_term.md" };
+        self.read_file(file_name)
+            .map(|content| content.lines().filter(|line| line.starts_with("## Entry (")).count())
+            .unwrap_or(0)
+    }
+
+    fn signature(&self) -> MemorySignature {
+        MEMORY_TYPES
+            .iter()
+            .map(|memory_type| self.fs.stat(&self.memory_dir.join(file_name(memory_type).ok()?)))
+            .collect()
+    }
+
+    pub fn memory_dir(&self) -> &PathBuf {
+        &self.memory_dir
+    }
+
     /// Helper to read a specific memory file
     fn read_file(&self, file_name: &str) -> Result<String> {
         let file_path = self.memory_dir.join(file_name);
-        fs::read_to_string(&file_path)
-            .with_context(|| format!("Failed to read memory file: {}", file_path.display()))
+        match self.fs.read_to_string(&file_path) {
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => {
+                Ok(file_header(if file_name == "long_term.md" { "long_term" } else { "short_term" }))
+            }
+            result => result.with_context(|| format!("Failed to read memory file: {}", file_path.display())),
+        }
+    }
+}
+
+/// Runs a `prime memory` subcommand against the memory under `base_dir`
+pub fn run_cli(base_dir: &Path, action: MemoryAction, memory_type: Option<&str>, category: Option<&str>) -> Result<()> {
+    let manager = MemoryManager::new(base_dir.join("memory"))?;
+    let types: Vec<&str> = match memory_type {
+        Some(memory_type) => vec![memory_type],
+        None => MEMORY_TYPES.to_vec(),
+    };
+    match action {
+        MemoryAction::Add { mut content } => {
+            if content.is_empty() && !std::io::stdin().is_terminal() {
+                std::io::stdin().read_to_string(&mut content).context("Failed to read memory text from stdin")?;
+            }
+            let content = content.trim();
+            if content.is_empty() {
+                return Err(anyhow!("Nothing to add; pass the text as arguments or on stdin"));
+            }
+            let memory_type = memory_type.unwrap_or("long_term");
+            manager.write_entry(memory_type, category, content)?;
+            println!("{}", format!("Added to {} memory.", memory_type).green());
+        }
+        MemoryAction::Read => {
+            for memory_type in types {
+                let entries = manager.entries(memory_type)?.into_iter().filter(|entry| {
+                    category.map_or(true, |c| entry.category.as_deref().is_some_and(|own| own.eq_ignore_ascii_case(c)))
+                });
+                for entry in entries {
+                    print_entry(memory_type, &entry);
+                }
+            }
+        }
+        MemoryAction::Search { query } => {
+            let mut found = 0;
+            for memory_type in types {
+                for entry in manager.search(memory_type, &query, category)? {
+                    print_entry(memory_type, &entry);
+                    found += 1;
+                }
+            }
+            if found == 0 {
+                // Lets scripts test for a match with the exit status.
+                return Err(anyhow!("No memory entries match '{}'", query));
+            }
+        }
+        MemoryAction::Clear => {
+            let memory_type = memory_type.ok_or_else(|| anyhow!("Clearing memory needs --type long or --type short"))?;
+            match category {
+                Some(category) => {
+                    let removed = manager.clear_category(memory_type, category)?;
+                    println!("{}", format!("Removed {} '{}' entries from {} memory.", removed, category, memory_type).green());
+                }
+                None => {
+                    manager.clear_memory(memory_type)?;
+                    println!("{}", format!("Cleared {} memory.", memory_type).green());
+                }
+            }
+        }
+    }
+    Ok(())
+}
+
+fn print_entry(memory_type: &str, entry: &MemoryEntry) {
+    let category = entry.category.as_deref().map(|c| format!(" [{}]", c)).unwrap_or_default();
+    println!("{}", format!("{} · {}{}", memory_type, entry.timestamp, category).dark_grey());
+    println!("{}\n", entry.content);
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::fs;
+    use std::sync::Arc;
+    use chrono::TimeZone;
+    use crate::clock::FixedClock;
+    use crate::vfs::MemoryFs;
+
+    fn manager(name: &str) -> MemoryManager {
+        let dir = std::env::temp_dir().join(format!("prime_memory_{}_{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        MemoryManager::new(dir).unwrap()
+    }
+
+    #[test]
+    fn test_categories_round_trip_and_search() {
+        let memory = manager("search");
+        memory.write_memory("long_term", "User prefers tabs.").unwrap();
+        memory.write_entry("long_term", Some("tools"), "Use pnpm, not npm.\nCategory: stays in the body").unwrap();
+        let entries = memory.entries("long_term").unwrap();
+        assert_eq!(entries.len(), 2);
+        assert_eq!(entries[0].category, None);
+        assert_eq!(entries[1].category.as_deref(), Some("tools"));
+        assert_eq!(entries[1].content, "Use pnpm, not npm.\nCategory: stays in the body");
+        assert_eq!(memory.search("long_term", "PNPM", None).unwrap().len(), 1);
+        assert!(memory.search("long_term", "tabs", Some("tools")).unwrap().is_empty());
+        let prompt = memory.read_memory(None).unwrap();
+        assert!(prompt.contains("[src: memory:long]\n## Entry ("), "{}", prompt);
+        assert!(prompt.contains("[src: memory:long/tools]\n## Entry ("), "{}", prompt);
+        assert!(!prompt.contains("This file is for notes"));
+        let _ = fs::remove_dir_all(memory.memory_dir());
+    }
+
+    #[test]
+    fn test_cache_rereads_only_after_changes() {
+        let memory = manager("cache");
+        let mut cache = MemoryCache::default();
+        assert!(!cache.get(&memory).unwrap().contains("likes tea"));
+        // Written behind the cache's back, as `prime memory add` from another terminal would.
+        memory.write_memory("long_term", "likes tea").unwrap();
+        assert!(cache.get(&memory).unwrap().contains("likes tea"));
+        cache.text = Some("stale".to_string());
+        assert_eq!(cache.get(&memory).unwrap(), "stale");
+        cache.invalidate();
+        assert!(cache.get(&memory).unwrap().contains("likes tea"));
+        let _ = fs::remove_dir_all(memory.memory_dir());
+    }
+
+    #[test]
+    fn test_clear_category_keeps_other_entries() {
+        let memory = manager("clear");
+        memory.write_entry("short_term", Some("build"), "cargo build is slow").unwrap();
+        memory.write_entry("short_term", None, "working on the parser").unwrap();
+        assert_eq!(memory.clear_category("short_term", "BUILD").unwrap(), 1);
+        let entries = memory.entries("short_term").unwrap();
+        assert_eq!(entries.len(), 1);
+        assert_eq!(entries[0].content, "working on the parser");
+        assert_eq!(memory.entry_count("short_term"), 1);
+        let _ = fs::remove_dir_all(memory.memory_dir());
+    }
+
+    #[test]
+    fn test_clear_category_keeps_hand_written_notes() {
+        let content = "# My notes\n\nAlways answer in British English.\n\n## Entry (1)\nCategory: build\nslow\n\n## Entry (2)\nparser\n";
+        let (kept, removed) = remove_category(content, "Build");
+        assert_eq!(removed, 1);
+        assert_eq!(kept, "# My notes\n\nAlways answer in British English.\n\n## Entry (2)\nparser\n");
+        assert_eq!(remove_category(content, "docs"), (content.to_string(), 0));
     }
-}
\ No newline at end of file
+
+    #[test]
+    fn test_entries_are_stamped_by_the_clock() {
+        let memory = MemoryManager::with_host(
+            PathBuf::from("/home/prime/.prime/memory"),
+            Arc::new(MemoryFs::new("/home/prime")),
+            Arc::new(FixedClock::new(Utc.with_ymd_and_hms(2025, 6, 7, 17, 54, 46).unwrap().with_timezone(&chrono::Local))),
+        );
+        memory.write_entry("long_term", Some("tools"), "Use pnpm, not npm.").unwrap();
+        assert_eq!(memory.entries("long_term").unwrap()[0].timestamp, "2025-06-07 17:54:46 UTC");
+        assert!(!memory.memory_dir().exists());
+    }
+}
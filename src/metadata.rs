@@ -0,0 +1,316 @@
+//! Cross-session metadata in `metadata.json` under the Prime base directory
+//! Holds state that outlives a single session and would otherwise have to be pieced
+//! together from directory listings: the last session started per project, global
+//! session and turn counters, generation parameters tuned per session, and a
+//! registry of running jobs, which `!status` lists. Every change is a
+//! read-modify-write under `metadata.lock`, and the file is replaced atomically, so
+//! concurrent clients neither lose updates nor see a half-written file.
+//!
+//! The store is one small JSON file rather than an embedded database: it holds a few
+//! counters and maps, is read whole on every change anyway, stays readable by hand,
+//! and needs no extra dependency or native library in the build.
+
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::time::Duration;
+
+use anyhow::{anyhow, Context, Result};
+use serde::{Deserialize, Serialize};
+
+use crate::clock::SharedClock;
+use crate::params::ModelParams;
+use crate::turn_lock::{self, TurnLock};
+
+/// Version of the file layout written by this build
+pub const SCHEMA_VERSION: u32 = 2;
+/// How long an update waits for another client's update to finish
+const LOCK_WAIT: Duration = Duration::from_secs(5);
+/// Jobs older than this are assumed to belong to a process that died without cleaning up
+const STALE_JOB_HOURS: i64 = 24;
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Job {
+    pub kind: String,
+    pub session_id: String,
+    pub pid: u32,
+    pub started_at: String,
+}
+
+impl Job {
+    /// One-line summary for `!status`, e.g. `turn session_x (pid 42, since 17:54:46)`
+    pub fn summary(&self) -> String {
+        let since = chrono::DateTime::parse_from_rfc3339(&self.started_at)
+            .map(|started| started.format("%H:%M:%S").to_string())
+            .unwrap_or_else(|_| self.started_at.clone());
+        format!("{} {} (pid {}, since {})", self.kind, self.session_id, self.pid, since)
+    }
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct Metadata {
+    /// Files from before versioning have no field and load as version 0
+    #[serde(default)]
+    pub schema_version: u32,
+    #[serde(default)]
+    pub sessions_started: u64,
+    #[serde(default)]
+    pub turns_total: u64,
+    /// Most recent session per project directory
+    #[serde(default)]
+    pub last_session: BTreeMap<String, String>,
+    /// Parameters set with `!set`, by session id
+    #[serde(default)]
+    pub session_params: BTreeMap<String, ModelParams>,
+    /// Running jobs by id
+    #[serde(default)]
+    pub jobs: BTreeMap<String, Job>,
+    #[serde(default)]
+    next_job_id: u64,
+}
+
+impl Default for Metadata {
+    fn default() -> Self {
+        Self {
+            schema_version: SCHEMA_VERSION,
+            sessions_started: 0,
+            turns_total: 0,
+            last_session: BTreeMap::new(),
+            session_params: BTreeMap::new(),
+            jobs: BTreeMap::new(),
+            next_job_id: 0,
+        }
+    }
+}
+
+impl Metadata {
+    /// Brings an older layout up to `SCHEMA_VERSION`
+    fn migrate(mut self) -> Result<Self> {
+        if self.schema_version > SCHEMA_VERSION {
+            return Err(anyhow!(
+                "metadata.json has schema version {}, but this build of prime only knows up to {}; upgrade prime",
+                self.schema_version,
+                SCHEMA_VERSION
+            ));
+        }
+        // Version 0 had the same fields without the version marker; version 1 had no
+        // session parameters. Both load as they are; the bump keeps version 1 builds
+        // from rewriting the file and dropping the parameters.
+        self.schema_version = SCHEMA_VERSION;
+        Ok(self)
+    }
+
+    fn prune_stale_jobs(&mut self, now: chrono::DateTime<chrono::Local>) {
+        let cutoff = now - chrono::Duration::hours(STALE_JOB_HOURS);
+        self.jobs.retain(|_, job| {
+            chrono::DateTime::parse_from_rfc3339(&job.started_at).map_or(false, |started| started > cutoff)
+        });
+    }
+
+    /// Registers a job started at `now`; returns its id
+    fn add_job(&mut self, kind: &str, session_id: &str, now: chrono::DateTime<chrono::Local>) -> String {
+        self.prune_stale_jobs(now);
+        self.next_job_id += 1;
+        let id = format!("job_{}", self.next_job_id);
+        let job = Job {
+            kind: kind.to_string(),
+            session_id: session_id.to_string(),
+            pid: std::process::id(),
+            started_at: now.to_rfc3339(),
+        };
+        self.jobs.insert(id.clone(), job);
+        id
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct MetadataStore {
+    path: PathBuf,
+    lock: TurnLock,
+    /// Stamps jobs and the lock owner
+    clock: SharedClock,
+}
+
+impl MetadataStore {
+    pub fn new(base_dir: &Path, clock: SharedClock) -> Self {
+        Self { path: base_dir.join("metadata.json"), lock: TurnLock::new(base_dir, "metadata"), clock }
+    }
+
+    /// Current contents; a missing file reads as empty metadata
+    pub fn load(&self) -> Result<Metadata> {
+        match fs::read_to_string(&self.path) {
+            Ok(content) => serde_json::from_str::<Metadata>(&content)
+                .with_context(|| format!("Corrupt metadata file: {}", self.path.display()))?
+                .migrate(),
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(Metadata::default()),
+            Err(e) => Err(e).with_context(|| format!("Failed to read {}", self.path.display())),
+        }
+    }
+
+    /// Applies `change` to the stored metadata and writes it back, holding the lock throughout
+    pub fn update<T>(&self, change: impl FnOnce(&mut Metadata) -> T) -> Result<T> {
+        let _guard = self.lock.acquire_blocking(&turn_lock::owner_label(self.clock.as_ref()), LOCK_WAIT)?;
+        self.write_change(change)
+    }
+
+    /// `update` for the turn path, waiting for the lock without blocking the runtime
+    pub async fn update_async<T>(&self, change: impl FnOnce(&mut Metadata) -> T) -> Result<T> {
+        let _guard = self.lock.acquire(&turn_lock::owner_label(self.clock.as_ref()), LOCK_WAIT).await?;
+        self.write_change(change)
+    }
+
+    /// The read-modify-write itself; callers hold the lock
+    fn write_change<T>(&self, change: impl FnOnce(&mut Metadata) -> T) -> Result<T> {
+        let mut metadata = self.load()?;
+        let result = change(&mut metadata);
+        let temp = self.path.with_extension("json.tmp");
+        fs::write(&temp, serde_json::to_string_pretty(&metadata)?)
+            .with_context(|| format!("Failed to write {}", temp.display()))?;
+        fs::rename(&temp, &self.path).with_context(|| format!("Failed to replace {}", self.path.display()))?;
+        Ok(result)
+    }
+
+    /// Records a new session as the latest for `project`; returns the one it replaces
+    pub fn start_session(&self, project: &Path, session_id: &str) -> Result<Option<String>> {
+        self.update(|metadata| {
+            metadata.sessions_started += 1;
+            metadata.last_session.insert(project.display().to_string(), session_id.to_string())
+        })
+    }
+
+    /// Makes a resumed session the latest for `project` again; returns its parameters
+    pub fn resume_session(&self, project: &Path, session_id: &str) -> Result<ModelParams> {
+        self.update(|metadata| {
+            metadata.last_session.insert(project.display().to_string(), session_id.to_string());
+            metadata.session_params.get(session_id).cloned().unwrap_or_default()
+        })
+    }
+
+    /// Stores the parameters of a session; default parameters remove the record
+    pub fn save_params(&self, session_id: &str, params: &ModelParams) -> Result<()> {
+        self.update(|metadata| {
+            if params.is_empty() {
+                metadata.session_params.remove(session_id);
+            } else {
+                metadata.session_params.insert(session_id.to_string(), params.clone());
+            }
+        })
+    }
+
+    /// Counts a turn and registers it as a running job in one update; returns the turn
+    /// total across all sessions and a guard that removes the job when it drops
+    pub async fn start_turn(&self, session_id: &str) -> Result<(u64, JobGuard)> {
+        let now = self.clock.now();
+        let (total, id) = self
+            .update_async(|metadata| {
+                metadata.turns_total += 1;
+                (metadata.turns_total, metadata.add_job("turn", session_id, now))
+            })
+            .await?;
+        Ok((total, JobGuard { store: self.clone(), id }))
+    }
+}
+
+/// Keeps a job registered for as long as it is alive
+#[derive(Debug)]
+pub struct JobGuard {
+    store: MetadataStore,
+    id: String,
+}
+
+impl JobGuard {
+    /// Removes the job before returning, for callers that may exit right after
+    pub async fn release(mut self) {
+        let id = std::mem::take(&mut self.id);
+        let _ = self.store.update_async(|metadata| metadata.jobs.remove(&id)).await;
+    }
+}
+
+impl Drop for JobGuard {
+    // Drop cannot await, and waiting for the metadata lock here would hold up a runtime
+    // thread, so inside a runtime the job is removed on the blocking pool. A removal lost
+    // to a crash is caught by the stale-job pruning.
+    fn drop(&mut self) {
+        if self.id.is_empty() {
+            return;
+        }
+        let (store, id) = (self.store.clone(), std::mem::take(&mut self.id));
+        let release = move || {
+            let _ = store.update(|metadata| metadata.jobs.remove(&id));
+        };
+        match tokio::runtime::Handle::try_current() {
+            Ok(runtime) => drop(runtime.spawn_blocking(release)),
+            Err(_) => release(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::clock::{self, FixedClock};
+    use std::sync::Arc;
+
+    fn temp_dir(name: &str) -> PathBuf {
+        let dir = std::env::temp_dir().join(format!("prime_metadata_{}_{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        dir
+    }
+
+    /// Waits for released jobs to leave the registry, which happens off the runtime thread
+    async fn settled_jobs(store: &MetadataStore, expected: usize) -> usize {
+        for _ in 0..100 {
+            if store.load().unwrap().jobs.len() == expected {
+                break;
+            }
+            tokio::time::sleep(Duration::from_millis(10)).await;
+        }
+        store.load().unwrap().jobs.len()
+    }
+
+    #[tokio::test]
+    async fn test_counters_sessions_and_jobs_persist() {
+        let dir = temp_dir("persist");
+        let store = MetadataStore::new(&dir, Arc::new(FixedClock::at(2025, 6, 7, 17, 54, 46)));
+        assert_eq!(store.start_session(Path::new("/work/a"), "session_1").unwrap(), None);
+        assert_eq!(store.start_session(Path::new("/work/a"), "session_2").unwrap().as_deref(), Some("session_1"));
+        let params = ModelParams { context_budget: Some(4096), ..Default::default() };
+        store.save_params("session_1", &params).unwrap();
+        assert_eq!(store.resume_session(Path::new("/work/a"), "session_1").unwrap(), params);
+        store.start_session(Path::new("/work/a"), "session_2").unwrap();
+        let (total, first) = store.start_turn("session_2").await.unwrap();
+        assert_eq!(total, 1);
+        drop(first);
+        assert_eq!(settled_jobs(&store, 0).await, 0);
+        let (total, job) = store.start_turn("session_2").await.unwrap();
+        assert_eq!(total, 2);
+
+        let metadata = MetadataStore::new(&dir, clock::system()).load().unwrap();
+        assert_eq!(metadata.sessions_started, 3);
+        assert_eq!(metadata.session_params.get("session_1"), Some(&params));
+        assert_eq!(metadata.last_session.get("/work/a").map(String::as_str), Some("session_2"));
+        assert_eq!(metadata.jobs.values().map(|job| job.kind.as_str()).collect::<Vec<_>>(), vec!["turn"]);
+        assert!(metadata.jobs.values().next().unwrap().summary().starts_with("turn session_2 (pid "));
+        assert!(metadata.jobs.values().next().unwrap().summary().ends_with(", since 17:54:46)"));
+        job.release().await;
+        assert!(store.load().unwrap().jobs.is_empty());
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_schema_versions() {
+        let dir = temp_dir("schema");
+        let store = MetadataStore::new(&dir, clock::system());
+        fs::write(dir.join("metadata.json"), r#"{"turns_total": 7}"#).unwrap();
+        let metadata = store.load().unwrap();
+        assert_eq!((metadata.schema_version, metadata.turns_total), (SCHEMA_VERSION, 7));
+        fs::write(dir.join("metadata.json"), r#"{"schema_version": 1, "sessions_started": 3}"#).unwrap();
+        let metadata = store.load().unwrap();
+        assert_eq!((metadata.schema_version, metadata.sessions_started), (SCHEMA_VERSION, 3));
+        assert!(metadata.session_params.is_empty());
+        fs::write(dir.join("metadata.json"), r#"{"schema_version": 99}"#).unwrap();
+        assert!(store.load().unwrap_err().to_string().contains("upgrade prime"));
+        let _ = fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,213 @@
+//! Ollama load awareness before heavy generations
+//! Large prompts are the ones that suffer when the model is cold, partly offloaded to
+//! the CPU, or loaded with a context window smaller than the prompt (Ollama silently
+//! drops the oldest tokens). Before sending one, the running models are read from
+//! `/api/ps` and host memory from /proc/meminfo where it exists, and anything that
+//! will make the request slow or lossy is reported.
+
+use std::fmt;
+use std::time::Duration;
+
+use anyhow::{Context, Result};
+use serde::Deserialize;
+
+/// Prompts estimated at or above this many tokens get a load check first
+pub const HEAVY_PROMPT_TOKENS: usize = 4_000;
+/// Used when OLLAMA_HOST is not set, as the provider does
+const DEFAULT_HOST: &str = "http://localhost:11434";
+/// The check must not become the slow part of the request
+const PS_TIMEOUT: Duration = Duration::from_secs(2);
+/// Less free host memory than this makes a load likely to swap or evict
+const LOW_MEMORY_BYTES: u64 = 1 << 30;
+
+/// One entry of `/api/ps`
+#[derive(Debug, Clone, PartialEq, Deserialize)]
+pub struct LoadedModel {
+    pub name: String,
+    #[serde(default)]
+    pub size: u64,
+    #[serde(default)]
+    pub size_vram: u64,
+    /// num_ctx the model was loaded with; older Ollama versions do not report it
+    #[serde(default)]
+    pub context_length: Option<usize>,
+}
+
+#[derive(Debug, Deserialize)]
+struct PsResponse {
+    #[serde(default)]
+    models: Vec<LoadedModel>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum LoadWarning {
+    /// The model has to be loaded first; `others` may be unloaded to make room
+    ColdLoad { model: String, others: Vec<String> },
+    /// Only `vram_percent` of the model is on the GPU
+    PartialOffload { model: String, vram_percent: u64 },
+    /// The prompt does not fit the loaded context window
+    ContextOverflow { prompt_tokens: usize, num_ctx: usize },
+    LowMemory { available_bytes: u64 },
+}
+
+impl fmt::Display for LoadWarning {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            LoadWarning::ColdLoad { model, others } if others.is_empty() => {
+                write!(f, "{} is not loaded; the reply waits until Ollama loads it", model)
+            }
+            LoadWarning::ColdLoad { model, others } => write!(
+                f,
+                "{} is not loaded; Ollama may unload {} to make room, and the next request to it reloads it",
+                model,
+                others.join(", ")
+            ),
+            LoadWarning::PartialOffload { model, vram_percent } => {
+                write!(f, "{} is only {}% on the GPU; the rest runs on the CPU and a large prompt will be slow", model, vram_percent)
+            }
+            LoadWarning::ContextOverflow { prompt_tokens, num_ctx } => write!(
+                f,
+                "The prompt is ~{} tokens but the model was loaded with num_ctx {}; the oldest context will be cut",
+                prompt_tokens, num_ctx
+            ),
+            LoadWarning::LowMemory { available_bytes } => {
+                write!(f, "Only {} MiB of host memory is free; loading may swap", available_bytes >> 20)
+            }
+        }
+    }
+}
+
+/// Base URL of the Ollama server, from OLLAMA_HOST when set
+pub fn ollama_host() -> String {
+    match std::env::var("OLLAMA_HOST").ok().filter(|host| !host.trim().is_empty()) {
+        Some(host) if host.contains("://") => host.trim_end_matches('/').to_string(),
+        Some(host) => format!("http://{}", host.trim_end_matches('/')),
+        None => DEFAULT_HOST.to_string(),
+    }
+}
+
+/// Models Ollama currently holds in memory
+pub async fn loaded_models(host: &str) -> Result<Vec<LoadedModel>> {
+    let client = reqwest::Client::builder().timeout(PS_TIMEOUT).build().context("Failed to build HTTP client")?;
+    let response: PsResponse = client
+        .get(format!("{}/api/ps", host))
+        .send()
+        .await
+        .and_then(|response| response.error_for_status())
+        .with_context(|| format!("Failed to query {}/api/ps", host))?
+        .json()
+        .await
+        .context("Unexpected /api/ps response")?;
+    Ok(response.models)
+}
+
+/// MemAvailable from /proc/meminfo; None on systems without it
+pub fn available_memory() -> Option<u64> {
+    let meminfo = std::fs::read_to_string("/proc/meminfo").ok()?;
+    let line = meminfo.lines().find(|line| line.starts_with("MemAvailable:"))?;
+    let kib: u64 = line.split_whitespace().nth(1)?.parse().ok()?;
+    Some(kib * 1024)
+}
+
+/// `model` and `name` refer to the same model; a missing tag means `latest`
+fn same_model(model: &str, name: &str) -> bool {
+    let tagged = |value: &str| if value.contains(':') { value.to_string() } else { format!("{}:latest", value) };
+    tagged(model) == tagged(name)
+}
+
+/// What will make a request of `prompt_tokens` to `model` slow or lossy
+pub fn assess(model: &str, prompt_tokens: usize, loaded: &[LoadedModel], available_memory: Option<u64>) -> Vec<LoadWarning> {
+    let mut warnings = Vec::new();
+    match loaded.iter().find(|entry| same_model(model, &entry.name)) {
+        None => {
+            let others = loaded.iter().map(|entry| entry.name.clone()).collect();
+            warnings.push(LoadWarning::ColdLoad { model: model.to_string(), others });
+        }
+        Some(entry) => {
+            if entry.size > 0 && entry.size_vram < entry.size {
+                let vram_percent = entry.size_vram * 100 / entry.size;
+                warnings.push(LoadWarning::PartialOffload { model: entry.name.clone(), vram_percent });
+            }
+            if let Some(num_ctx) = entry.context_length.filter(|&num_ctx| prompt_tokens > num_ctx) {
+                warnings.push(LoadWarning::ContextOverflow { prompt_tokens, num_ctx });
+            }
+        }
+    }
+    if let Some(available_bytes) = available_memory.filter(|&bytes| bytes < LOW_MEMORY_BYTES) {
+        warnings.push(LoadWarning::LowMemory { available_bytes });
+    }
+    warnings
+}
+
+/// Smallest loaded model other than `model`, offered as a faster alternative
+pub fn smaller_model(model: &str, loaded: &[LoadedModel]) -> Option<String> {
+    let current_size = loaded.iter().find(|entry| same_model(model, &entry.name)).map(|entry| entry.size);
+    loaded
+        .iter()
+        .filter(|entry| !same_model(model, &entry.name))
+        .filter(|entry| current_size.map_or(true, |size| entry.size < size))
+        .min_by_key(|entry| entry.size)
+        .map(|entry| entry.name.clone())
+}
+
+/// What the user can do about `warnings`: trim the history to the loaded window, or
+/// switch to `smaller` when the model is cold or split across CPU and GPU. Low memory
+/// alone has no remedy here and is only reported.
+pub fn remedies(warnings: &[LoadWarning], smaller: Option<String>) -> (Option<usize>, Option<String>) {
+    let num_ctx = warnings.iter().find_map(|warning| match warning {
+        LoadWarning::ContextOverflow { num_ctx, .. } => Some(*num_ctx),
+        _ => None,
+    });
+    let slow = warnings.iter().any(|warning| matches!(warning, LoadWarning::ColdLoad { .. } | LoadWarning::PartialOffload { .. }));
+    (num_ctx, smaller.filter(|_| slow))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn loaded(name: &str, size: u64, size_vram: u64, context_length: Option<usize>) -> LoadedModel {
+        LoadedModel { name: name.to_string(), size, size_vram, context_length }
+    }
+
+    #[test]
+    fn test_ps_response_parses() {
+        let body = r#"{"models":[{"name":"gemma2:latest","model":"gemma2:latest","size":6000,"size_vram":3000,"context_length":8192,"expires_at":"2026-01-01T00:00:00Z"}]}"#;
+        let response: PsResponse = serde_json::from_str(body).unwrap();
+        assert_eq!(response.models, vec![loaded("gemma2:latest", 6000, 3000, Some(8192))]);
+        assert!(serde_json::from_str::<PsResponse>(r#"{"models":[{"name":"old"}]}"#).is_ok());
+    }
+
+    #[test]
+    fn test_assess_reports_cold_offload_and_overflow() {
+        let models = vec![loaded("gemma2:latest", 6000, 3000, Some(8192)), loaded("qwen2.5:0.5b", 500, 500, Some(4096))];
+        assert_eq!(
+            assess("gemma2", 9000, &models, None),
+            vec![
+                LoadWarning::PartialOffload { model: "gemma2:latest".to_string(), vram_percent: 50 },
+                LoadWarning::ContextOverflow { prompt_tokens: 9000, num_ctx: 8192 },
+            ]
+        );
+        assert_eq!(
+            assess("llama3", 100, &models, Some(512 << 20)),
+            vec![
+                LoadWarning::ColdLoad { model: "llama3".to_string(), others: vec!["gemma2:latest".into(), "qwen2.5:0.5b".into()] },
+                LoadWarning::LowMemory { available_bytes: 512 << 20 },
+            ]
+        );
+        assert!(assess("qwen2.5:0.5b", 1000, &models, Some(8 << 30)).is_empty());
+        assert_eq!(smaller_model("gemma2", &models).as_deref(), Some("qwen2.5:0.5b"));
+        assert_eq!(smaller_model("qwen2.5:0.5b", &models), None);
+    }
+
+    #[test]
+    fn test_only_actionable_warnings_offer_remedies() {
+        let overflow = LoadWarning::ContextOverflow { prompt_tokens: 9000, num_ctx: 8192 };
+        let low_memory = LoadWarning::LowMemory { available_bytes: 512 << 20 };
+        let offload = LoadWarning::PartialOffload { model: "gemma2".to_string(), vram_percent: 50 };
+        let smaller = || Some("qwen2.5:0.5b".to_string());
+        assert_eq!(remedies(&[low_memory.clone()], smaller()), (None, None));
+        assert_eq!(remedies(&[overflow, low_memory], smaller()), (Some(8192), None));
+        assert_eq!(remedies(&[offload], smaller()), (None, smaller()));
+    }
+}
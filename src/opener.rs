@@ -0,0 +1,117 @@
+//! Opening files and URLs from the last turn with the OS default handler
+//! Targets are harvested while a turn runs (written files, URLs in responses and
+//! tool output) so `!open <n>` can refer to them by number.
+
+use std::path::Path;
+use std::process::{Command, Stdio};
+
+use anyhow::{anyhow, Context, Result};
+
+/// Characters that end a URL when it is embedded in prose or markdown
+const URL_TERMINATORS: &[char] = &['<', '>', '"', '\'', '`', ')', ']', '}', '|'];
+
+/// Finds http(s) URLs in free text, in order of appearance and without duplicates
+pub fn extract_urls(text: &str) -> Vec<String> {
+    let mut urls = Vec::new();
+    let mut rest = text;
+    while let Some(start) = ["http://", "https://"].iter().filter_map(|scheme| rest.find(scheme)).min() {
+        let candidate = &rest[start..];
+        let end = candidate
+            .find(|c: char| c.is_whitespace() || URL_TERMINATORS.contains(&c))
+            .unwrap_or(candidate.len());
+        let url = candidate[..end].trim_end_matches(['.', ',', ';', ':', '!', '?']);
+        if url.len() > "https://".len() {
+            push_target(&mut urls, url.to_string());
+        }
+        rest = &candidate[end.max(1)..];
+    }
+    urls
+}
+
+/// Adds `target` unless it is already listed
+pub fn push_target(targets: &mut Vec<String>, target: String) {
+    if !targets.contains(&target) {
+        targets.push(target);
+    }
+}
+
+fn is_url(target: &str) -> bool {
+    target.starts_with("http://") || target.starts_with("https://")
+}
+
+/// Resolves `!open` arguments: a 1-based index into `targets`, a URL, or a path
+/// relative to `working_dir`
+pub fn resolve_target(arg: &str, targets: &[String], working_dir: &Path) -> Result<String> {
+    let arg = arg.trim();
+    if let Ok(index) = arg.parse::<usize>() {
+        return index
+            .checked_sub(1)
+            .and_then(|i| targets.get(i))
+            .cloned()
+            .ok_or_else(|| anyhow!("No item {} from the last turn (there are {}).", index, targets.len()));
+    }
+    if is_url(arg) {
+        return Ok(arg.to_string());
+    }
+    let path = working_dir.join(arg);
+    if !path.exists() {
+        return Err(anyhow!("Path not found: {}", path.display()));
+    }
+    Ok(path.display().to_string())
+}
+
+/// Program and arguments that hand `target` to the default handler on `os`. Targets
+/// come from model and tool output, so no shell sees them: on Windows `cmd /C start`
+/// would run anything after a `&` in a URL, while url.dll takes the target as one argument.
+fn launcher(os: &str, target: &str) -> (&'static str, Vec<String>) {
+    match os {
+        "windows" => ("rundll32", vec!["url.dll,FileProtocolHandler".to_string(), target.to_string()]),
+        "macos" => ("open", vec![target.to_string()]),
+        _ => ("xdg-open", vec![target.to_string()]),
+    }
+}
+
+/// Launches `target` with url.dll/open/xdg-open without waiting for it
+pub fn open_target(target: &str) -> Result<()> {
+    let (program, args) = launcher(std::env::consts::OS, target);
+    Command::new(program)
+        .args(&args)
+        .stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::null())
+        .spawn()
+        .with_context(|| format!("Failed to open {}", target))?;
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_extract_urls_from_markdown_and_prose() {
+        let text = "See [the docs](https://docs.rs/tokio/latest). Report at http://localhost:8080/report.html, \
+                    and again https://docs.rs/tokio/latest.";
+        assert_eq!(extract_urls(text), vec!["https://docs.rs/tokio/latest", "http://localhost:8080/report.html"]);
+        assert!(extract_urls("no links, just https:// alone").is_empty());
+    }
+
+    #[test]
+    fn test_resolve_target_by_index_url_and_path() {
+        let targets = vec!["/tmp/out.txt".to_string(), "https://example.com".to_string()];
+        let cwd = std::env::temp_dir();
+        assert_eq!(resolve_target("2", &targets, &cwd).unwrap(), "https://example.com");
+        assert!(resolve_target("3", &targets, &cwd).is_err());
+        assert_eq!(resolve_target("http://a.test/x", &targets, &cwd).unwrap(), "http://a.test/x");
+        assert!(resolve_target("surely-missing-file.txt", &targets, &cwd).is_err());
+    }
+
+    #[test]
+    fn test_urls_reach_the_launcher_as_one_argument() {
+        let url = "https://example.com/search?q=a&b=c|whoami";
+        let (program, args) = launcher("windows", url);
+        assert_eq!(program, "rundll32");
+        assert_eq!(args, vec!["url.dll,FileProtocolHandler".to_string(), url.to_string()]);
+        assert_eq!(launcher("linux", url), ("xdg-open", vec![url.to_string()]));
+    }
+}
@@ -0,0 +1,77 @@
+//! Warnings in the output of successful commands
+//! Exit code 0 does not mean a clean run: compilers, linters and package managers
+//! report deprecations and suspicious code on stdout/stderr and still succeed. Lines
+//! that look like such warnings are picked out so the success is shown as
+//! "with warnings" and the model can be asked whether they need follow-up.
+
+/// Warning lines kept per command; the rest are only counted
+pub const MAX_WARNING_LINES: usize = 5;
+/// Lowercase fragments that mark a warning line
+const WARNING_MARKERS: &[&str] = &[
+    "warning:",
+    "warning[",
+    "[warn]",
+    "[warning]",
+    "warn ",
+    "deprecated",
+    "deprecationwarning",
+    "futurewarning",
+    "userwarning",
+    "runtimewarning",
+];
+/// Summary lines that mention warnings without reporting one
+const NOT_WARNINGS: &[&str] = &["0 warnings", "no warnings", "warnings: 0", "0 warning(s)", "-wno-"];
+
+/// Warnings found in a command's output
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct Warnings {
+    /// The first `MAX_WARNING_LINES` warning lines, trimmed
+    pub lines: Vec<String>,
+    pub total: usize,
+}
+
+impl Warnings {
+    pub fn is_empty(&self) -> bool {
+        self.total == 0
+    }
+}
+
+fn is_warning(line: &str) -> bool {
+    let lower = line.trim().to_lowercase();
+    WARNING_MARKERS.iter().any(|marker| lower.contains(marker)) && !NOT_WARNINGS.iter().any(|summary| lower.contains(summary))
+}
+
+pub fn find_warnings(output: &str) -> Warnings {
+    let mut warnings = Warnings::default();
+    for line in output.lines().filter(|line| is_warning(line)) {
+        warnings.total += 1;
+        if warnings.lines.len() < MAX_WARNING_LINES {
+            warnings.lines.push(line.trim().to_string());
+        }
+    }
+    warnings
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_finds_compiler_and_package_manager_warnings() {
+        let output = "   Compiling app v0.1.0\nwarning: unused variable: `x`\n --> src/main.rs:2:9\nnpm WARN deprecated inflight@1.0.6: not supported\n/app/x.py:3: DeprecationWarning: datetime.utcnow() is deprecated\nwarning: `app` (bin \"app\") generated 1 warning\n    Finished dev";
+        let warnings = find_warnings(output);
+        assert_eq!(warnings.total, 4);
+        assert_eq!(warnings.lines[0], "warning: unused variable: `x`");
+        assert!(warnings.lines[1].starts_with("npm WARN deprecated"));
+    }
+
+    #[test]
+    fn test_clean_summaries_are_not_warnings() {
+        assert!(find_warnings("Build succeeded.\n    0 Warning(s)\n    0 Error(s)").is_empty());
+        assert!(find_warnings("test result: ok. 12 passed; 0 failed\nno warnings found").is_empty());
+        assert!(find_warnings("gcc -Wall -Wno-unused main.c").is_empty());
+        let many = "warning: a\n".repeat(8);
+        let warnings = find_warnings(&many);
+        assert_eq!((warnings.total, warnings.lines.len()), (8, MAX_WARNING_LINES));
+    }
+}
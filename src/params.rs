@@ -0,0 +1,146 @@
+//! Generation parameters tuned for one session
+//! `!set temperature 0.2`, `!set context_budget 8192` or `!set stop ###` override the config
+//! for the current session. The overrides are stored with the session in the metadata
+//! file, so `prime resume` restores the exact settings, and `!status` and the exports
+//! show them next to the conversation they produced. Temperature, top_p and max_tokens
+//! go to the provider; context_budget bounds the prompt Prime sends (older history is
+//! dropped to fit) without changing the window the server loads the model with, and
+//! stop sequences cut the response where the first one appears.
+
+use std::fmt;
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+
+/// Names accepted by `!set`
+pub const PARAM_NAMES: &[&str] = &["temperature", "top_p", "max_tokens", "context_budget", "stop"];
+
+/// Session overrides; unset fields fall back to the config
+#[derive(Debug, Clone, Default, PartialEq, Serialize, Deserialize)]
+pub struct ModelParams {
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub temperature: Option<f32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub top_p: Option<f32>,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub max_tokens: Option<u32>,
+    /// Tokens of prompt Prime sends; stored as `num_ctx` by earlier builds
+    #[serde(default, alias = "num_ctx", skip_serializing_if = "Option::is_none")]
+    pub context_budget: Option<usize>,
+    #[serde(default, skip_serializing_if = "Vec::is_empty")]
+    pub stop: Vec<String>,
+}
+
+fn parse_number<T: std::str::FromStr>(name: &str, value: &str) -> Result<T> {
+    value.trim().parse().map_err(|_| anyhow!("{} must be a number, got '{}'", name, value.trim()))
+}
+
+impl ModelParams {
+    pub fn is_empty(&self) -> bool {
+        *self == Self::default()
+    }
+
+    /// Applies `!set <name> <value>`; `none` (or `default`) clears the override. Each
+    /// `stop` adds a sequence; `stop none` removes them all.
+    pub fn set(&mut self, name: &str, value: &str) -> Result<()> {
+        let value = value.trim();
+        let clear = value.eq_ignore_ascii_case("none") || value.eq_ignore_ascii_case("default");
+        match name {
+            "temperature" if clear => self.temperature = None,
+            "temperature" => {
+                let temperature: f32 = parse_number(name, value)?;
+                if !(0.0..=2.0).contains(&temperature) {
+                    return Err(anyhow!("temperature must be between 0 and 2"));
+                }
+                self.temperature = Some(temperature);
+            }
+            "top_p" if clear => self.top_p = None,
+            "top_p" => {
+                let top_p: f32 = parse_number(name, value)?;
+                if !(top_p > 0.0 && top_p <= 1.0) {
+                    return Err(anyhow!("top_p must be above 0 and at most 1"));
+                }
+                self.top_p = Some(top_p);
+            }
+            "max_tokens" if clear => self.max_tokens = None,
+            "max_tokens" => self.max_tokens = Some(parse_number::<u32>(name, value)?.max(1)),
+            "context_budget" if clear => self.context_budget = None,
+            "context_budget" => self.context_budget = Some(parse_number::<usize>(name, value)?.max(256)),
+            "stop" if clear => self.stop.clear(),
+            "stop" if value.is_empty() => return Err(anyhow!("Usage: !set stop <sequence>")),
+            "stop" => {
+                let sequence = value.replace("\\n", "\n");
+                if !self.stop.contains(&sequence) {
+                    self.stop.push(sequence);
+                }
+            }
+            other => return Err(anyhow!("Unknown parameter '{}'. Use one of: {}", other, PARAM_NAMES.join(", "))),
+        }
+        Ok(())
+    }
+
+    /// Byte offset of the first stop sequence in `text`
+    pub fn stop_at(&self, text: &str) -> Option<usize> {
+        self.stop.iter().filter(|sequence| !sequence.is_empty()).filter_map(|sequence| text.find(sequence.as_str())).min()
+    }
+}
+
+impl fmt::Display for ModelParams {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        let mut parts = Vec::new();
+        if let Some(temperature) = self.temperature {
+            parts.push(format!("temperature {}", temperature));
+        }
+        if let Some(top_p) = self.top_p {
+            parts.push(format!("top_p {}", top_p));
+        }
+        if let Some(max_tokens) = self.max_tokens {
+            parts.push(format!("max_tokens {}", max_tokens));
+        }
+        if let Some(budget) = self.context_budget {
+            parts.push(format!("context_budget {}", budget));
+        }
+        if !self.stop.is_empty() {
+            parts.push(format!("stop {:?}", self.stop));
+        }
+        match parts.is_empty() {
+            true => write!(f, "-"),
+            false => write!(f, "{}", parts.join(" · ")),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_set_validates_and_clears() {
+        let mut params = ModelParams::default();
+        params.set("temperature", "0.2").unwrap();
+        params.set("context_budget", "8192").unwrap();
+        params.set("stop", "###").unwrap();
+        params.set("stop", "\\nUser:").unwrap();
+        assert_eq!(params.to_string(), "temperature 0.2 · context_budget 8192 · stop [\"###\", \"\\nUser:\"]");
+        assert!(params.set("temperature", "hot").is_err());
+        assert!(params.set("temperature", "3").is_err());
+        assert!(params.set("top_p", "0").is_err());
+        assert!(params.set("seed", "1").is_err());
+        assert!(params.set("num_ctx", "8192").is_err());
+
+        params.set("stop", "none").unwrap();
+        params.set("temperature", "default").unwrap();
+        assert_eq!(params, ModelParams { context_budget: Some(8192), ..Default::default() });
+        assert_eq!(serde_json::to_string(&params).unwrap(), r#"{"context_budget":8192}"#);
+        assert_eq!(serde_json::from_str::<ModelParams>(r#"{"num_ctx":8192}"#).unwrap(), params);
+        assert_eq!(ModelParams::default().to_string(), "-");
+    }
+
+    #[test]
+    fn test_stop_at_finds_the_earliest_sequence() {
+        let params = ModelParams { stop: vec!["END".to_string(), "###".to_string()], ..Default::default() };
+        assert_eq!(params.stop_at("answer ### more END"), Some(7));
+        assert_eq!(params.stop_at("no stop here"), None);
+        assert_eq!(ModelParams::default().stop_at("END"), None);
+    }
+}
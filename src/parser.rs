@@ -1,10 +1,13 @@
 use anyhow::{anyhow, Context, Result};
 
+use crate::clarify::Assessment;
+
 #[derive(Debug, PartialEq, Clone)]
 pub enum ToolCall {
     Shell { command: String },
     ReadFile { path: String, lines: Option<(usize, usize)> },
-    WriteFile { path: String, content: String, append: bool },
+    /// `outside_workspace` asks the user before writing to a path outside the workspace
+    WriteFile { path: String, content: String, append: bool, outside_workspace: bool },
     ListDir { path: String },
     ChangeDir { path: String },
     WriteMemory { memory_type: String, content: String },
@@ -17,18 +20,26 @@ pub enum ToolCall {
 pub struct ParsedResponse {
     pub natural_language: String,
     pub tool_calls: Vec<ToolCall>,
+    /// The block's `assess:` line, written in clarify-first mode
+    pub assessment: Option<Assessment>,
 }
 
-fn parse_write_args(args_str: &str) -> (String, bool) {
-    let mut append = false;
-    let mut path = args_str.to_string();
-    if let Some(pos) = path.rfind(" append=true") {
-        if pos + " append=true".len() == path.len() {
+/// Path, `append=true` and `outside_workspace=true` of a write_file line; the flags may come in either order
+fn parse_write_args(args_str: &str) -> (String, bool, bool) {
+    let (mut append, mut outside_workspace) = (false, false);
+    let mut path = args_str.trim_end();
+    loop {
+        if let Some(rest) = path.strip_suffix(" append=true") {
             append = true;
-            path.truncate(pos);
+            path = rest.trim_end();
+        } else if let Some(rest) = path.strip_suffix(" outside_workspace=true") {
+            outside_workspace = true;
+            path = rest.trim_end();
+        } else {
+            break;
         }
     }
-    (path.trim().to_string(), append)
+    (path.trim().to_string(), append, outside_workspace)
 }
 
 fn parse_read_args(args_str: &str) -> Result<(String, Option<(usize, usize)>)> {
@@ -144,6 +155,10 @@ pub fn parse_llm_response(input: &str) -> Result<ParsedResponse> {
             None => continue,
         };
         let tool_call = match tool_name {
+            "assess" => {
+                resp.assessment = Assessment::parse(args_str);
+                continue;
+            }
             "shell" => ToolCall::Shell {
                 command: args_str.into(),
             },
@@ -178,7 +193,7 @@ pub fn parse_llm_response(input: &str) -> Result<ParsedResponse> {
                 }
             }
             "write_file" => {
-                let (path, append) = parse_write_args(args_str);
+                let (path, append, outside_workspace) = parse_write_args(args_str);
                 let mut content_lines = Vec::new();
                 while let Some(cl) = lines_iter.next() {
                     if cl.trim() == "EOF_PRIME" {
@@ -190,6 +205,7 @@ pub fn parse_llm_response(input: &str) -> Result<ParsedResponse> {
                     path,
                     content: content_lines.join("\n"),
                     append,
+                    outside_workspace,
                 }
             }
             "create_tool" => {
@@ -0,0 +1,108 @@
+//! Laziness guard for generated files
+//! Models sometimes elide code with "// ... rest of the code unchanged" or a bare "...".
+//! Writing that to disk silently truncates the file, so such writes are refused and the
+//! model is asked for the complete content instead. A comment only counts when it has
+//! an ellipsis as well as an elision phrase: "// API remains unchanged" is a real
+//! comment. In prose files only HTML comments are comments, so bullets are left alone.
+
+use std::path::Path;
+
+/// Comment text that stands in for code the model did not write out
+const ELISION_PHRASES: &[&str] = &[
+    "rest of the code", "rest of code", "rest of the file", "rest of file", "rest of the implementation",
+    "rest of the function", "rest of the class", "rest of the method", "code unchanged", "remains unchanged",
+    "remain unchanged", "unchanged from", "same as before", "omitted for brevity", "for brevity",
+    "existing code here", "existing code unchanged", "previous code here", "other code here", "... existing",
+];
+
+const COMMENT_MARKERS: &[&str] = &["//", "#", "/*", "*", "<!--", "--", ";", "rem "];
+/// Markdown and plain text have no line comments; `*`, `--` and `#` start bullets and headings
+const PROSE_COMMENT_MARKERS: &[&str] = &["<!--"];
+
+const PROSE_EXTENSIONS: &[&str] = &["md", "markdown", "txt", "rst"];
+/// Extensions besides prose where a bare `...` line is valid content (Python's Ellipsis)
+const ELLIPSIS_ALLOWED: &[&str] = &["py", "pyi"];
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Placeholder {
+    /// 1-based line number in the content
+    pub line: usize,
+    pub text: String,
+}
+
+fn comment_text<'a>(line: &'a str, markers: &[&str]) -> Option<&'a str> {
+    let lower_start = line.get(..4).map(str::to_lowercase).unwrap_or_default();
+    markers
+        .iter()
+        .find(|marker| line.starts_with(**marker) || lower_start.starts_with(**marker))
+        .map(|marker| line[marker.len()..].trim_end_matches("*/").trim_end_matches("-->").trim())
+}
+
+fn is_ellipsis(text: &str) -> bool {
+    let text = text.trim();
+    text == "..." || text == "…"
+}
+
+fn has_ellipsis(text: &str) -> bool {
+    text.contains("...") || text.contains('…')
+}
+
+/// First line of `content` that looks like elided code, if any
+pub fn find_placeholder(path: &str, content: &str) -> Option<Placeholder> {
+    let extension = Path::new(path).extension().and_then(|e| e.to_str()).unwrap_or_default().to_lowercase();
+    let prose = PROSE_EXTENSIONS.contains(&extension.as_str());
+    let bare_ellipsis_allowed = prose || ELLIPSIS_ALLOWED.contains(&extension.as_str());
+    let markers = if prose { PROSE_COMMENT_MARKERS } else { COMMENT_MARKERS };
+    content.lines().enumerate().find_map(|(i, line)| {
+        let trimmed = line.trim();
+        let flagged = match comment_text(trimmed, markers) {
+            Some(comment) => {
+                let comment = comment.to_lowercase();
+                is_ellipsis(&comment)
+                    || (has_ellipsis(&comment) && ELISION_PHRASES.iter().any(|phrase| comment.contains(phrase)))
+            }
+            None => is_ellipsis(trimmed) && !bare_ellipsis_allowed,
+        };
+        flagged.then(|| Placeholder { line: i + 1, text: trimmed.to_string() })
+    })
+}
+
+/// Tool output sent back to the model when a write is refused
+pub fn refusal_message(path: &str, placeholder: &Placeholder) -> String {
+    format!(
+        "Refused to write '{}': line {} (`{}`) is a placeholder for elided code, and writing it would truncate the file. \
+         Nothing was written. Send the write again with the complete content, every line written out in full.",
+        path, placeholder.line, placeholder.text
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_detects_elision_comments() {
+        let rust = "fn main() {\n    setup();\n    // ... rest of the code unchanged\n}";
+        assert_eq!(find_placeholder("src/main.rs", rust).unwrap().line, 3);
+        assert!(find_placeholder("app.js", "/* ... */").is_some());
+        assert!(find_placeholder("index.html", "<!-- ... existing code here -->").is_some());
+        assert!(find_placeholder("run.py", "# Other methods omitted for brevity…").is_some());
+        assert!(find_placeholder("schema.sql", "-- ... rest of the file unchanged").is_some());
+        assert!(find_placeholder("README.md", "<!-- ... rest of the file -->").is_some());
+    }
+
+    #[test]
+    fn test_bare_ellipsis_depends_on_file_type() {
+        assert!(find_placeholder("lib.rs", "struct A;\n...\n").is_some());
+        assert!(find_placeholder("stubs.pyi", "def f() -> int:\n    ...\n").is_none());
+        assert!(find_placeholder("notes.md", "To be continued\n...\n").is_none());
+    }
+
+    #[test]
+    fn test_ordinary_code_passes() {
+        let code = "// Keep the existing code path for Windows\nlet s = \"...\";\n# include <stdio.h>\nprint('rest of code')";
+        assert_eq!(find_placeholder("main.c", code), None);
+        assert_eq!(find_placeholder("lib.rs", "// API remains unchanged\n// for brevity, errors are strings"), None);
+        assert_eq!(find_placeholder("CHANGES.md", "* rest of the code unchanged...\n-- same as before...\n; for brevity..."), None);
+    }
+}
@@ -0,0 +1,196 @@
+//! Session-to-PR workflow behind `!pr`
+//! The workspace's git changes and the actions run this session are summarized for the
+//! model, which drafts a conventional commit message and a PR description. Committing,
+//! pushing and opening the PR (through the gh CLI) each happen only after the user
+//! confirms.
+
+use std::io::Write;
+use std::path::Path;
+use std::process::{Command, Stdio};
+
+use anyhow::{anyhow, Context, Result};
+
+/// Diff text sent to the model; larger diffs are cut and rely on the stat summary
+const MAX_DIFF_CHARS: usize = 12_000;
+/// Branches a PR should not be opened from; the commit goes to a new branch instead
+const DEFAULT_BRANCHES: &[&str] = &["main", "master", "trunk", "develop"];
+
+/// What changed in the workspace and how the session got there
+#[derive(Debug, Clone, PartialEq)]
+pub struct ChangeContext {
+    pub status: String,
+    pub diff_stat: String,
+    pub diff: String,
+    pub requests: Vec<String>,
+    pub actions: Vec<String>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct Draft {
+    pub commit_message: String,
+    pub pr_title: String,
+    pub pr_body: String,
+}
+
+/// Runs a program without a shell, feeding `input` on stdin; returns trimmed stdout
+fn run(program: &str, args: &[&str], dir: &Path, input: Option<&str>) -> Result<String> {
+    let mut child = Command::new(program)
+        .args(args)
+        .current_dir(dir)
+        .stdin(if input.is_some() { Stdio::piped() } else { Stdio::null() })
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .spawn()
+        .with_context(|| format!("Failed to run {} (is it installed and on PATH?)", program))?;
+    if let (Some(input), Some(mut stdin)) = (input, child.stdin.take()) {
+        stdin.write_all(input.as_bytes())?;
+    }
+    let output = child.wait_with_output()?;
+    if !output.status.success() {
+        let stderr = String::from_utf8_lossy(&output.stderr);
+        return Err(anyhow!("{} {} failed: {}", program, args.join(" "), stderr.trim()));
+    }
+    Ok(String::from_utf8_lossy(&output.stdout).trim().to_string())
+}
+
+/// `git status --porcelain` of `dir`: every file `commit` would stage, untracked ones marked ??
+pub fn pending_changes(dir: &Path) -> Result<String> {
+    run("git", &["status", "--porcelain", "--untracked-files=all"], dir, None)
+}
+
+/// Collects the uncommitted changes in `dir`; fails outside a repository or with nothing to commit
+pub fn gather(dir: &Path, requests: Vec<String>, actions: Vec<String>) -> Result<ChangeContext> {
+    run("git", &["rev-parse", "--is-inside-work-tree"], dir, None)
+        .map_err(|_| anyhow!("{} is not inside a git repository", dir.display()))?;
+    let status = pending_changes(dir)?;
+    if status.is_empty() {
+        return Err(anyhow!("No changes to commit"));
+    }
+    // HEAD does not exist before the first commit; the staged/unstaged diff still works.
+    let diff_stat =
+        run("git", &["diff", "HEAD", "--stat"], dir, None).or_else(|_| run("git", &["diff", "--stat"], dir, None))?;
+    let mut diff = run("git", &["diff", "HEAD"], dir, None).or_else(|_| run("git", &["diff"], dir, None))?;
+    if diff.chars().count() > MAX_DIFF_CHARS {
+        diff = format!("{}\n[diff truncated]", diff.chars().take(MAX_DIFF_CHARS).collect::<String>());
+    }
+    Ok(ChangeContext { status, diff_stat, diff, requests, actions })
+}
+
+pub fn draft_prompt(context: &ChangeContext) -> String {
+    let list = |items: &[String]| match items {
+        [] => "(none)".to_string(),
+        _ => items.iter().map(|item| format!("- {}", item)).collect::<Vec<_>>().join("\n"),
+    };
+    format!(
+        "Write a commit message and a pull request description for the changes below.\n\n\
+         What the user asked for this session:\n{}\n\n\
+         Actions that were run:\n{}\n\n\
+         git status --porcelain (untracked files marked ??):\n{}\n\n\
+         git diff --stat:\n{}\n\n\
+         git diff:\n{}\n\n\
+         Reply with exactly two fenced blocks and nothing else:\n\
+         ```commit\n<conventional commit subject, e.g. feat(parser): support heredocs, at most 72 characters>\n\n<body: what changed and why, wrapped at 72 columns>\n```\n\
+         ```pr\n<PR title>\n\n<PR description in Markdown: summary, notable changes, how it was tested>\n```\n\
+         Describe only what the diff shows; do not invent tests or issues.",
+        list(&context.requests),
+        list(&context.actions),
+        context.status,
+        context.diff_stat,
+        context.diff
+    )
+}
+
+/// Body of the first ```<tag> block in `text`
+fn fenced_block(text: &str, tag: &str) -> Option<String> {
+    let mut lines = text.lines().skip_while(|line| line.trim() != format!("```{}", tag));
+    lines.next()?;
+    let body: Vec<&str> = lines.take_while(|line| line.trim() != "```").collect();
+    Some(body.join("\n").trim().to_string())
+}
+
+pub fn parse_draft(reply: &str) -> Result<Draft> {
+    let block = |tag: &str| {
+        fenced_block(reply, tag).filter(|body| !body.is_empty()).ok_or_else(|| anyhow!("The model's reply had no ```{} block", tag))
+    };
+    let commit_message = block("commit")?;
+    let pr = block("pr")?;
+    let (pr_title, pr_body) = pr.split_once('\n').unwrap_or((&pr, ""));
+    Ok(Draft { commit_message, pr_title: pr_title.trim().to_string(), pr_body: pr_body.trim().to_string() })
+}
+
+/// Branch name derived from the commit subject, e.g. `prime/support-heredocs`
+pub fn branch_name(commit_subject: &str) -> String {
+    let description = commit_subject.split_once(": ").map_or(commit_subject, |(_, rest)| rest);
+    let slug: Vec<String> = description
+        .split(|c: char| !c.is_ascii_alphanumeric())
+        .filter(|word| !word.is_empty())
+        .take(6)
+        .map(str::to_lowercase)
+        .collect();
+    if slug.is_empty() {
+        "prime/changes".to_string()
+    } else {
+        format!("prime/{}", slug.join("-"))
+    }
+}
+
+/// Stages everything `pending_changes` lists and commits; moves off a default branch first.
+/// Returns the branch name.
+pub fn commit(dir: &Path, message: &str) -> Result<String> {
+    let mut branch = run("git", &["symbolic-ref", "--short", "HEAD"], dir, None)?;
+    if DEFAULT_BRANCHES.contains(&branch.as_str()) {
+        branch = branch_name(message.lines().next().unwrap_or_default());
+        run("git", &["switch", "-c", &branch], dir, None)?;
+    }
+    run("git", &["add", "-A"], dir, None)?;
+    run("git", &["commit", "-F", "-"], dir, Some(message))?;
+    Ok(branch)
+}
+
+/// Pushes the current branch and opens a PR with gh; returns the PR URL gh prints
+pub fn publish(dir: &Path, draft: &Draft) -> Result<String> {
+    run("git", &["push", "-u", "origin", "HEAD"], dir, None)?;
+    run("gh", &["pr", "create", "--title", &draft.pr_title, "--body-file", "-"], dir, Some(&draft.pr_body))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_parse_draft_reads_both_blocks() {
+        let reply = "Here you go.\n\n```commit\nfeat(cli): add memory subcommands\n\nLets scripts seed memory.\n```\n\n```pr\nAdd `prime memory` subcommands\n\n## Summary\n- add/read/search/clear\n```\n";
+        let draft = parse_draft(reply).unwrap();
+        assert_eq!(draft.commit_message, "feat(cli): add memory subcommands\n\nLets scripts seed memory.");
+        assert_eq!(draft.pr_title, "Add `prime memory` subcommands");
+        assert_eq!(draft.pr_body, "## Summary\n- add/read/search/clear");
+        assert!(parse_draft("```commit\nfix: x\n```").is_err());
+    }
+
+    #[test]
+    fn test_branch_name_from_subject() {
+        assert_eq!(branch_name("feat(parser): Support heredocs in shell actions"), "prime/support-heredocs-in-shell-actions");
+        assert_eq!(branch_name("!!!"), "prime/changes");
+    }
+
+    #[test]
+    fn test_commit_moves_off_default_branch() {
+        let dir = std::env::temp_dir().join(format!("prime_pr_{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        std::fs::create_dir_all(&dir).unwrap();
+        run("git", &["init", "-q", "-b", "main"], &dir, None).unwrap();
+        run("git", &["config", "user.email", "test@example.com"], &dir, None).unwrap();
+        run("git", &["config", "user.name", "Test"], &dir, None).unwrap();
+        assert!(gather(&dir, Vec::new(), Vec::new()).is_err());
+
+        std::fs::create_dir_all(dir.join("notes")).unwrap();
+        std::fs::write(dir.join("notes/b.txt"), "b").unwrap();
+        std::fs::write(dir.join("a.txt"), "a").unwrap();
+        let context = gather(&dir, vec!["add a".to_string()], Vec::new()).unwrap();
+        assert_eq!(context.status, "?? a.txt\n?? notes/b.txt");
+        assert!(draft_prompt(&context).contains("- add a"));
+        assert_eq!(commit(&dir, "feat: add a\n\nFirst file.").unwrap(), "prime/add-a");
+        assert_eq!(run("git", &["log", "--format=%s"], &dir, None).unwrap(), "feat: add a");
+        let _ = std::fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,82 @@
+//! Provenance labels on the sections of an assembled prompt
+//! Memory entries, history messages and file contents each start with a one-line
+//! `[src: ...]` label such as `[src: memory:long/Project]`, `[src: history:msg 14]` or
+//! `[src: workspace:src/main.rs lines 10-80]`. The model can cite where a fact came
+//! from, and `!context` lists the sections of the next prompt by the same labels.
+
+use std::fmt;
+
+const LABEL_PREFIX: &str = "[src: ";
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum Source {
+    /// An entry of `long_term` or `short_term` memory, or the file's free text without a category
+    Memory { memory_type: String, category: Option<String> },
+    /// Session history message, numbered as in `!pin`
+    History { number: usize },
+    /// A file read from the workspace, with the line range when one was requested
+    Workspace { path: String, lines: Option<(usize, usize)> },
+}
+
+impl fmt::Display for Source {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Source::Memory { memory_type, category } => {
+                write!(f, "memory:{}", memory_type.trim_end_matches("_term"))?;
+                match category {
+                    Some(category) => write!(f, "/{}", category),
+                    None => Ok(()),
+                }
+            }
+            Source::History { number } => write!(f, "history:msg {}", number),
+            Source::Workspace { path, lines: Some((start, end)) } => write!(f, "workspace:{} lines {}-{}", path, start, end),
+            Source::Workspace { path, lines: None } => write!(f, "workspace:{}", path),
+        }
+    }
+}
+
+/// `content` under the label line for `source`
+pub fn labeled(source: &Source, content: &str) -> String {
+    format!("{}{}]\n{}", LABEL_PREFIX, source, content)
+}
+
+fn label_of(line: &str) -> Option<&str> {
+    line.trim().strip_prefix(LABEL_PREFIX)?.strip_suffix(']')
+}
+
+/// Labelled sections of `text` as (label, section text); text before the first label is skipped
+pub fn sections(text: &str) -> Vec<(&str, String)> {
+    let mut sections: Vec<(&str, String)> = Vec::new();
+    for line in text.lines() {
+        match label_of(line) {
+            Some(label) => sections.push((label, String::new())),
+            None => {
+                if let Some((_, section)) = sections.last_mut() {
+                    section.push_str(line);
+                    section.push('\n');
+                }
+            }
+        }
+    }
+    sections
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_labels_render_and_split() {
+        let memory = Source::Memory { memory_type: "long_term".to_string(), category: Some("Project".to_string()) };
+        let file = Source::Workspace { path: "src/main.go".to_string(), lines: Some((10, 80)) };
+        assert_eq!(memory.to_string(), "memory:long/Project");
+        assert_eq!(Source::Memory { memory_type: "short_term".to_string(), category: None }.to_string(), "memory:short");
+        assert_eq!(Source::History { number: 14 }.to_string(), "history:msg 14");
+        assert_eq!(file.to_string(), "workspace:src/main.go lines 10-80");
+
+        let text = format!("<CONTEXT>\n{}\n{}\n", labeled(&memory, "Uses Go 1.22"), labeled(&file, "package main\nfunc main() {}"));
+        let sections = sections(&text);
+        assert_eq!(sections.iter().map(|(label, _)| *label).collect::<Vec<_>>(), vec!["memory:long/Project", "workspace:src/main.go lines 10-80"]);
+        assert_eq!(sections[1].1, "package main\nfunc main() {}\n");
+    }
+}
@@ -0,0 +1,183 @@
+//! Terminal recordings in asciinema format
+//! `!record start [file]` captures everything the REPL shows, as it is shown: streamed
+//! responses, action boxes, command results and the echoed input. `!record stop` (or
+//! the end of the session) closes the recording, an asciicast v2 file that
+//! `asciinema play` and the asciinema web player replay with the original timing. The
+//! markdown transcript records what was said; the cast shows how it looked.
+//!
+//! Output is captured below the print calls: stdout and stderr are pointed at a pipe
+//! whose reader copies every chunk to the terminal and appends it to the cast as an
+//! output event. Recording needs Unix file descriptors.
+
+use std::fs::File;
+use std::io::{self, BufWriter, Read, Write};
+use std::path::{Path, PathBuf};
+use std::thread::JoinHandle;
+use std::time::Instant;
+
+use anyhow::{anyhow, Context, Result};
+use serde_json::json;
+
+/// Header line of an asciicast v2 file
+fn header(width: u16, height: u16, title: &str) -> String {
+    let env = json!({
+        "TERM": std::env::var("TERM").unwrap_or_else(|_| "xterm-256color".to_string()),
+        "SHELL": std::env::var("SHELL").unwrap_or_default(),
+    });
+    json!({
+        "version": 2,
+        "width": width,
+        "height": height,
+        "timestamp": chrono::Utc::now().timestamp(),
+        "title": title,
+        "env": env,
+    })
+    .to_string()
+}
+
+/// Output event for `text`, `seconds` after the start. Bare newlines become CRLF, as
+/// a terminal's line discipline would send them, so the replay does not staircase.
+fn event(seconds: f64, text: &str) -> String {
+    let mut output = String::with_capacity(text.len());
+    let mut previous = '\0';
+    for c in text.chars() {
+        if c == '\n' && previous != '\r' {
+            output.push('\r');
+        }
+        output.push(c);
+        previous = c;
+    }
+    json!([(seconds * 1_000_000.0).round() / 1_000_000.0, "o", output]).to_string()
+}
+
+/// Splits `bytes` into the longest valid UTF-8 prefix and the start of a character cut
+/// off at the end of a read; invalid bytes in the middle are replaced
+fn split_utf8(bytes: &[u8]) -> (String, Vec<u8>) {
+    match std::str::from_utf8(bytes) {
+        Ok(text) => (text.to_string(), Vec::new()),
+        Err(e) if e.error_len().is_none() => {
+            let (valid, rest) = bytes.split_at(e.valid_up_to());
+            (String::from_utf8_lossy(valid).into_owned(), rest.to_vec())
+        }
+        Err(_) => (String::from_utf8_lossy(bytes).into_owned(), Vec::new()),
+    }
+}
+
+/// A recording in progress
+pub struct Recording {
+    pub path: PathBuf,
+    #[cfg(unix)]
+    saved: [std::os::fd::OwnedFd; 2],
+    copier: JoinHandle<Result<usize>>,
+}
+
+#[cfg(unix)]
+mod fd {
+    use std::io;
+    use std::os::fd::{AsRawFd, RawFd};
+
+    extern "C" {
+        fn dup2(source: RawFd, target: RawFd) -> i32;
+    }
+
+    /// Points descriptor `target` (1 or 2) at `source`
+    pub fn redirect(source: &impl AsRawFd, target: RawFd) -> io::Result<()> {
+        // SAFETY: dup2 only touches the descriptor table; both descriptors are valid for
+        // the duration of the call and `target` is one of the standard streams.
+        match unsafe { dup2(source.as_raw_fd(), target) } {
+            -1 => Err(io::Error::last_os_error()),
+            _ => Ok(()),
+        }
+    }
+}
+
+impl Recording {
+    /// Starts capturing stdout and stderr into a cast at `path`
+    #[cfg(unix)]
+    pub fn start(path: &Path, title: &str) -> Result<Self> {
+        use std::os::fd::AsFd;
+
+        let mut cast = BufWriter::new(File::create(path).with_context(|| format!("Failed to create {}", path.display()))?);
+        let (width, height) = crossterm::terminal::size().unwrap_or((80, 24));
+        writeln!(cast, "{}", header(width, height, title))?;
+
+        let saved = [io::stdout().as_fd().try_clone_to_owned()?, io::stderr().as_fd().try_clone_to_owned()?];
+        let mut screen = File::from(saved[0].try_clone()?);
+        let (mut reader, writer) = io::pipe().context("Failed to create the recording pipe")?;
+        io::stdout().flush()?;
+        fd::redirect(&writer, 1)?;
+        if let Err(e) = fd::redirect(&writer, 2) {
+            let _ = fd::redirect(&saved[0], 1);
+            return Err(e).context("Failed to capture stderr");
+        }
+        // Descriptors 1 and 2 now hold the only write ends; restoring them ends the copy.
+        drop(writer);
+
+        let started = Instant::now();
+        let copier = std::thread::spawn(move || -> Result<usize> {
+            let mut buffer = [0u8; 8192];
+            let mut pending = Vec::new();
+            let mut events = 0;
+            loop {
+                let read = match reader.read(&mut buffer) {
+                    Ok(0) => break,
+                    Ok(read) => read,
+                    Err(e) if e.kind() == io::ErrorKind::Interrupted => continue,
+                    Err(e) => return Err(e.into()),
+                };
+                screen.write_all(&buffer[..read])?;
+                screen.flush()?;
+                pending.extend_from_slice(&buffer[..read]);
+                let (text, rest) = split_utf8(&pending);
+                pending = rest;
+                if !text.is_empty() {
+                    writeln!(cast, "{}", event(started.elapsed().as_secs_f64(), &text))?;
+                    events += 1;
+                }
+            }
+            cast.flush()?;
+            Ok(events)
+        });
+        Ok(Self { path: path.to_path_buf(), saved, copier })
+    }
+
+    #[cfg(not(unix))]
+    pub fn start(_path: &Path, _title: &str) -> Result<Self> {
+        Err(anyhow!("Recording needs Unix file descriptors and is not available on this platform"))
+    }
+
+    /// Restores the terminal streams and finishes the cast; returns its path and event count
+    pub fn stop(self) -> Result<(PathBuf, usize)> {
+        #[cfg(unix)]
+        {
+            io::stdout().flush()?;
+            io::stderr().flush()?;
+            fd::redirect(&self.saved[0], 1)?;
+            fd::redirect(&self.saved[1], 2)?;
+        }
+        let events = self.copier.join().map_err(|_| anyhow!("The recording thread panicked"))??;
+        Ok((self.path, events))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_events_are_asciicast_lines() {
+        assert_eq!(event(1.5, "ok\nnext\r\n"), r#"[1.5,"o","ok\r\nnext\r\n"]"#);
+        let header: serde_json::Value = serde_json::from_str(&header(120, 40, "Prime session_1")).unwrap();
+        assert_eq!((header["version"].as_u64(), header["width"].as_u64()), (Some(2), Some(120)));
+    }
+
+    #[test]
+    fn test_characters_split_across_reads_are_kept_whole() {
+        let bytes = "né".as_bytes();
+        let (text, rest) = split_utf8(&bytes[..2]);
+        assert_eq!((text.as_str(), rest.len()), ("n", 1));
+        let mut joined = rest;
+        joined.extend_from_slice(&bytes[2..]);
+        assert_eq!(split_utf8(&joined), ("é".to_string(), Vec::new()));
+    }
+}
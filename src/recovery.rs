@@ -0,0 +1,185 @@
+//! Generation settings and failure summaries for error recovery
+//! Each consecutive failed attempt lowers the sampling temperature and tightens the
+//! response format, since re-running a failing model with identical settings rarely helps.
+//! Failed commands are condensed to a table of command, exit code and the error line
+//! pulled from their output, which is what the user sees and what leads the model's prompt.
+
+/// Output lines kept below the failure table; earlier lines are usually build noise
+pub const OUTPUT_TAIL_LINES: usize = 40;
+/// Words that mark the line explaining a failure
+const ERROR_MARKERS: &[&str] = &[
+    "error", "fatal", "panicked", "exception", "traceback", "not found", "no such file", "denied", "cannot", "failed",
+    "refused", "invalid", "unknown",
+];
+
+/// Temperature for the given recovery attempt (0 = normal generation)
+pub fn recovery_temperature(base: f32, attempt: usize) -> f32 {
+    let temperature = match attempt {
+        0 => base,
+        1 => base * 0.5,
+        _ => 0.0,
+    };
+    temperature.max(0.0)
+}
+
+/// Extra instructions sent with the request while recovering; None outside recovery
+pub fn recovery_constraints(attempt: usize) -> Option<String> {
+    if attempt == 0 {
+        return None;
+    }
+    let mut rules = format!(
+        "RECOVERY MODE (attempt {}): The previous action failed. Reply with at most two sentences diagnosing the error, \
+         then exactly one ```primeactions block with a corrected plan. Do not repeat a command that already failed. \
+         Use only the documented tool syntax, one action per line.",
+        attempt
+    );
+    if attempt >= 2 {
+        rules.push_str(
+            " Keep the plan to a single action, and prefer a read-only check (list_dir, read_file, a --version command) \
+             before changing anything again.",
+        );
+    }
+    Some(rules)
+}
+
+/// One failed action of the current recovery sequence
+#[derive(Debug, Clone, PartialEq)]
+pub struct FailedCommand {
+    pub attempt: usize,
+    pub command: String,
+    pub exit_code: Option<i32>,
+    pub error: String,
+}
+
+impl FailedCommand {
+    pub fn new(attempt: usize, command: &str, exit_code: Option<i32>, output: &str) -> Self {
+        Self { attempt, command: command.to_string(), exit_code, error: truncate(&extract_error(output), 160) }
+    }
+
+    /// Expected versus actual outcome, e.g. `0 → 127`
+    pub fn outcome(&self) -> String {
+        match self.exit_code {
+            Some(code) => format!("0 → {}", code),
+            None => "ok → failed".to_string(),
+        }
+    }
+}
+
+/// The line of `output` that best explains the failure: the first stderr line naming an
+/// error, else the last stderr line, else the last line of regular output
+pub fn extract_error(output: &str) -> String {
+    let (stdout, stderr) = match output.split_once("STDERR:") {
+        Some((stdout, stderr)) => (stdout, stderr),
+        None => (output, ""),
+    };
+    let lines = |text: &str| -> Vec<String> {
+        text.lines()
+            .map(str::trim)
+            .filter(|line| !line.is_empty() && !line.starts_with("Command failed with exit code") && *line != "Output:")
+            .map(str::to_string)
+            .collect()
+    };
+    let (stdout, stderr) = (lines(stdout), lines(stderr));
+    let names_error = |line: &&String| {
+        let lower = line.to_lowercase();
+        ERROR_MARKERS.iter().any(|marker| lower.contains(marker))
+    };
+    stderr
+        .iter()
+        .find(names_error)
+        .or_else(|| stderr.last())
+        .or_else(|| stdout.iter().rev().find(names_error))
+        .or_else(|| stdout.last())
+        .cloned()
+        .unwrap_or_else(|| "(no output)".to_string())
+}
+
+/// Rows of the failure table as aligned columns: attempt, command, outcome, error
+pub fn table_rows(failures: &[FailedCommand], command_width: usize) -> Vec<[String; 4]> {
+    failures
+        .iter()
+        .map(|failure| {
+            [
+                format!("#{}", failure.attempt),
+                truncate(&failure.command, command_width),
+                failure.outcome(),
+                failure.error.clone(),
+            ]
+        })
+        .collect()
+}
+
+/// Plain-text table sent to the model ahead of the failing output
+pub fn failure_table(failures: &[FailedCommand]) -> String {
+    let rows = table_rows(failures, 60);
+    let width = |col: usize| rows.iter().map(|row| row[col].chars().count()).max().unwrap_or(0);
+    let (w0, w1, w2) = (width(0), width(1).max("command".len()), width(2).max("exit".len()));
+    let mut out = format!("{:<w0$}  {:<w1$}  {:<w2$}  error", "", "command", "exit", w0 = w0, w1 = w1, w2 = w2);
+    for row in rows {
+        out.push_str(&format!("\n{:<w0$}  {:<w1$}  {:<w2$}  {}", row[0], row[1], row[2], row[3], w0 = w0, w1 = w1, w2 = w2));
+    }
+    out
+}
+
+/// The last `lines` lines of `output`, noting how many were left out
+pub fn output_tail(output: &str, lines: usize) -> String {
+    let all: Vec<&str> = output.trim().lines().collect();
+    if all.len() <= lines {
+        return all.join("\n");
+    }
+    format!("[{} earlier lines omitted]\n{}", all.len() - lines, all[all.len() - lines..].join("\n"))
+}
+
+fn truncate(text: &str, width: usize) -> String {
+    if text.chars().count() <= width {
+        return text.to_string();
+    }
+    format!("{}…", text.chars().take(width.saturating_sub(1)).collect::<String>())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_temperature_steps_down_to_zero() {
+        assert_eq!(recovery_temperature(0.6, 0), 0.6);
+        assert_eq!(recovery_temperature(0.6, 1), 0.3);
+        assert_eq!(recovery_temperature(0.6, 2), 0.0);
+        assert_eq!(recovery_temperature(0.6, 7), 0.0);
+    }
+
+    #[test]
+    fn test_constraints_tighten_with_attempts() {
+        assert!(recovery_constraints(0).is_none());
+        let first = recovery_constraints(1).unwrap();
+        let later = recovery_constraints(3).unwrap();
+        assert!(first.contains("exactly one ```primeactions block"));
+        assert!(!first.contains("single action"));
+        assert!(later.contains("single action"));
+    }
+
+    #[test]
+    fn test_error_line_prefers_stderr_error() {
+        let output = "Command failed with exit code 1\nOutput:\nCompiling app\n\nSTDERR:\nwarning: unused\nerror[E0425]: cannot find value `x`\n  --> src/main.rs:2:5";
+        assert_eq!(extract_error(output), "error[E0425]: cannot find value `x`");
+        assert_eq!(extract_error("Command failed with exit code 2\nOutput:\n\n\nSTDERR:\nusage: tool [-h]"), "usage: tool [-h]");
+        assert_eq!(extract_error("Failed to read file 'a.txt': No such file"), "Failed to read file 'a.txt': No such file");
+        assert_eq!(extract_error("Command failed with exit code 3\nOutput:\n"), "(no output)");
+    }
+
+    #[test]
+    fn test_failure_table_aligns_columns() {
+        let failures = vec![
+            FailedCommand::new(1, "shell: cargo build", Some(101), "STDERR:\nerror: could not compile `app`"),
+            FailedCommand::new(2, "read_file: Cargo.tom", None, "Failed to read file"),
+        ];
+        assert_eq!(
+            failure_table(&failures),
+            "    command               exit         error\n\
+             #1  shell: cargo build    0 → 101      error: could not compile `app`\n\
+             #2  read_file: Cargo.tom  ok → failed  Failed to read file"
+        );
+        assert_eq!(output_tail("a\nb\nc", 2), "[1 earlier lines omitted]\nb\nc");
+    }
+}
@@ -0,0 +1,115 @@
+//! Turn-scoped scratch directories
+//! Each turn gets an empty directory under the system temp dir, exported to commands as
+//! PRIME_TMP, so the model has somewhere to put intermediate files other than the
+//! workspace. A turn's directory is removed when the next turn starts or the session
+//! ends, unless the user keeps it with `!keep-tmp`.
+
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{Context, Result};
+
+pub const ENV_VAR: &str = "PRIME_TMP";
+
+#[derive(Debug)]
+pub struct TurnScratch {
+    root: PathBuf,
+    current: Option<PathBuf>,
+    kept: bool,
+}
+
+impl TurnScratch {
+    pub fn new(session_id: &str) -> Self {
+        Self::in_dir(std::env::temp_dir().join(format!("prime-{}", session_id)))
+    }
+
+    /// Scratch directories under `root` instead of the system temp dir
+    pub fn in_dir(root: PathBuf) -> Self {
+        Self { root, current: None, kept: false }
+    }
+
+    /// Discards the previous turn's directory and creates a fresh one for `turn`
+    pub fn begin(&mut self, turn: usize) -> Result<PathBuf> {
+        self.discard();
+        let dir = self.root.join(format!("turn_{}", turn));
+        fs::create_dir_all(&dir).with_context(|| format!("Failed to create scratch directory {}", dir.display()))?;
+        self.current = Some(dir.clone());
+        Ok(dir)
+    }
+
+    /// Ends the turn: an empty directory is removed at once; otherwise its path is returned
+    /// and it waits for the next turn in case the user wants to keep it
+    pub fn end_turn(&mut self) -> Option<PathBuf> {
+        let dir = self.current.clone()?;
+        let empty = fs::read_dir(&dir).map(|mut entries| entries.next().is_none()).unwrap_or(true);
+        if empty {
+            self.discard();
+            return None;
+        }
+        Some(dir)
+    }
+
+    /// Keeps the current directory past the next turn; returns its path
+    pub fn keep(&mut self) -> Option<PathBuf> {
+        let dir = self.current.clone()?;
+        self.kept = true;
+        Some(dir)
+    }
+
+    pub fn current(&self) -> Option<&Path> {
+        self.current.as_deref()
+    }
+
+    /// Removes the current directory unless it was kept
+    pub fn discard(&mut self) {
+        if let Some(dir) = self.current.take() {
+            if !self.kept {
+                let _ = fs::remove_dir_all(&dir);
+            }
+        }
+        self.kept = false;
+    }
+}
+
+impl Drop for TurnScratch {
+    fn drop(&mut self) {
+        self.discard();
+        // Only succeeds once no kept directory remains inside.
+        let _ = fs::remove_dir(&self.root);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn scratch(name: &str) -> TurnScratch {
+        TurnScratch::in_dir(std::env::temp_dir().join(format!("prime_scratch_{}_{}", name, std::process::id())))
+    }
+
+    #[test]
+    fn test_directories_are_cleaned_between_turns() {
+        let mut scratch = scratch("clean");
+        let first = scratch.begin(1).unwrap();
+        assert!(scratch.end_turn().is_none());
+        assert!(!first.exists());
+        let second = scratch.begin(2).unwrap();
+        fs::write(second.join("out.log"), "x").unwrap();
+        assert_eq!(scratch.end_turn(), Some(second.clone()));
+        assert!(second.exists());
+        scratch.begin(3).unwrap();
+        assert!(!second.exists());
+    }
+
+    #[test]
+    fn test_kept_directory_survives() {
+        let mut scratch = scratch("keep");
+        let dir = scratch.begin(1).unwrap();
+        fs::write(dir.join("report.html"), "x").unwrap();
+        scratch.end_turn();
+        assert_eq!(scratch.keep(), Some(dir.clone()));
+        drop(scratch);
+        assert!(dir.exists());
+        let _ = fs::remove_dir_all(dir.parent().unwrap());
+    }
+}
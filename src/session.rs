@@ -1,21 +1,73 @@
  
  
+use std::cell::OnceCell;
+use std::collections::hash_map::DefaultHasher;
+use std::collections::{HashMap, HashSet};
+use std::hash::{Hash, Hasher};
 use std::fmt;
-use std::fs::{self, OpenOptions};
+use std::fs;
 use std::io::{self, Write};
 use std::path::{Path, PathBuf};
+use std::time::{Duration, Instant};
 use anyhow::{anyhow, Context as AnyhowContext, Result};
-use crossterm::style::Stylize;
+use crate::terminal::{self, OutputMode, Stylize};
 use indicatif::{ProgressBar, ProgressStyle};
 use llm::chat::{ChatMessage, ChatMessageBuilder, ChatProvider, ChatRole};
+use serde::{Deserialize, Serialize};
 use textwrap::{wrap, Options};
+use crate::approval::ApprovalPolicy;
+use crate::analytics::{UsageEventKind, UsageRecorder};
+use crate::chatter;
+use crate::clarify;
+use crate::clock::{self, SharedClock};
 use crate::commands::CommandProcessor;
-use crate::memory::MemoryManager;
+use crate::config::{RiskConfig, VoiceConfig};
+use crate::continuation;
+use crate::dataset::{self, Rating};
+use crate::dependencies::DependencySummary;
+use crate::display;
+use crate::evidence;
+use crate::i18n::{tr, Msg};
+use crate::interactive::{self, Interactive};
+use crate::memory::{MemoryCache, MemoryManager};
+use crate::model_load;
+use crate::metadata::{JobGuard, MetadataStore};
+use crate::confine;
+use crate::opener;
+use crate::provenance::{self, Source};
+use crate::outcome::{self, Warnings};
 use crate::parser::{self, ToolCall};
+use crate::placeholders;
+use crate::pr;
+use crate::policy::{self, RiskPolicy, RiskTier, TierAction};
+use crate::recording::Recording;
+use crate::recovery::{self, FailedCommand};
+use crate::params::ModelParams;
+use crate::scratch::{self, TurnScratch};
+use crate::sessions;
+use crate::stdin;
+use crate::snapshot::{self, RestoreSummary, SnapshotInfo, SnapshotLimits, SnapshotStore};
+use crate::status::{ProviderHealth, TokenTally};
+use crate::tokens::Estimator;
+use crate::transcript::{self, LogFormat};
+use crate::streaming::{StreamHandler, StreamPrinter, StreamToken};
+use crate::turn_lock::{self, TurnLock};
+use crate::vfs::{self, SharedFs};
+use futures::StreamExt;
 use glob::glob;
 
+/// Builds a provider for a model with the given generation parameters; unset
+/// parameters fall back to the config
+pub type LlmFactory = Box<dyn Fn(&str, &ModelParams) -> Result<Box<dyn ChatProvider>> + Send + Sync>;
+
+/// How long a turn queues behind another client's turn on the same session
+const TURN_QUEUE_WAIT: Duration = Duration::from_secs(30);
+
 const SPINNER_TICKS: &[&str] = &["⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"];
 
+/// History messages sent with each request
+const HISTORY_WINDOW: usize = 10;
+
 fn wrap_text(text: &str, width: usize) -> String {
     wrap(text, Options::new(width).break_words(false)).join("\n")
 }
@@ -25,9 +77,268 @@ pub struct ToolExecutionResult {
     pub tool_call_str: String,
     pub success: bool,
     pub output: String,
+    /// Exit code of shell commands and script tools (-1 timeouts are not reported)
+    pub exit_code: Option<i32>,
+    /// Warnings printed by a command that still succeeded
+    pub warnings: Warnings,
+    /// Where the output came from, for results that carry workspace content
+    pub source: Option<Source>,
+}
+
+/// ` exit="N"` for results that carry an exit code, so the log records it as evidence
+fn exit_attribute(result: &ToolExecutionResult) -> String {
+    result.exit_code.map(|code| format!(" exit=\"{}\"", code)).unwrap_or_default()
+}
+
+/// ` warnings="N"` for successes that printed warnings
+fn warnings_attribute(result: &ToolExecutionResult) -> String {
+    match result.warnings.total {
+        0 => String::new(),
+        total => format!(" warnings=\"{}\"", total),
+    }
+}
+
+/// Prints decoration (boxes, bars, previews) that quiet mode drops
+fn decorate(line: impl std::fmt::Display) {
+    if !terminal::is_quiet() {
+        println!("{}", line);
+    }
+}
+
+/// Prints a status line that quiet mode keeps, on stderr, out of the piped output
+fn notice(line: impl std::fmt::Display) {
+    if terminal::is_quiet() {
+        eprintln!("{}", line);
+    } else {
+        println!("{}", line);
+    }
+}
+
+/// Drops the oldest history messages, keeping the system prompt and the latest message,
+/// until the estimate fits `budget` tokens; returns how many were dropped
+fn trim_history(messages: &mut Vec<ChatMessage>, budget: usize, estimator: &Estimator) -> usize {
+    let mut dropped = 0;
+    while messages.len() > 2 && messages.iter().map(|m| estimator.count(&m.content)).sum::<usize>() > budget {
+        messages.remove(1);
+        dropped += 1;
+    }
+    dropped
+}
+
+/// Prose of a response that was not streamed; quiet mode prints it unwrapped
+fn print_prose(text: &str, prefix: &str) {
+    if terminal::is_quiet() {
+        println!("{}", text);
+        return;
+    }
+    let width = display::layout_width() - prefix.chars().count();
+    for line in wrap_text(text, width).lines() {
+        println!("{}", format!("{}{}", prefix, line).white());
+    }
 }
 
+enum StallDecision {
+    Retry,
+    Cancel,
+    Wait,
+}
+
+/// How often a model wait checks the keyboard and the elapsed time
+const KEY_POLL: Duration = Duration::from_millis(100);
+
+/// Raw terminal mode for the length of a model wait, so Ctrl-C arrives as a key the
+/// wait can answer. A SIGINT handler would outlive the wait (tokio never uninstalls
+/// one) and stop Ctrl-C from ending Prime elsewhere, such as during the auto-run delay.
+/// Without a terminal nothing is watched and Ctrl-C keeps its default meaning.
+struct KeyWatch {
+    active: bool,
+}
+
+impl KeyWatch {
+    fn start() -> Self {
+        let active = stdin::answers_prompts() && crossterm::terminal::enable_raw_mode().is_ok();
+        Self { active }
+    }
+
+    /// Whether Ctrl-C was pressed since the last check; other keys are dropped
+    fn interrupted(&self) -> bool {
+        use crossterm::event::{self, Event, KeyCode, KeyEventKind, KeyModifiers};
+        let mut interrupted = false;
+        while self.active && matches!(event::poll(Duration::ZERO), Ok(true)) {
+            if let Ok(Event::Key(key)) = event::read() {
+                interrupted |= key.kind == KeyEventKind::Press && key.code == KeyCode::Char('c') && key.modifiers.contains(KeyModifiers::CONTROL);
+            }
+        }
+        interrupted
+    }
+
+    /// Runs `prompt` with the terminal back in line mode
+    fn paused<T>(&self, prompt: impl FnOnce() -> T) -> T {
+        let _ = crossterm::terminal::disable_raw_mode();
+        let answer = prompt();
+        let _ = crossterm::terminal::enable_raw_mode();
+        answer
+    }
+}
+
+impl Drop for KeyWatch {
+    fn drop(&mut self) {
+        if self.active {
+            let _ = crossterm::terminal::disable_raw_mode();
+        }
+    }
+}
+
+/// Awaits a fresh request from `make_request` while keeping the spinner honest: past
+/// `stall_after` it shows the elapsed time, and Ctrl-C offers retry, cancel or keep waiting.
+async fn wait_for_model<T, F, Fut>(mut make_request: F, spinner: &ProgressBar, stall_after: Duration) -> Result<T>
+where
+    F: FnMut() -> Fut,
+    Fut: std::future::Future<Output = T>,
+{
+    let keys = KeyWatch::start();
+    loop {
+        let started = Instant::now();
+        let request = make_request();
+        tokio::pin!(request);
+        let mut ticker = tokio::time::interval(KEY_POLL);
+        loop {
+            tokio::select! {
+                output = &mut request => return Ok(output),
+                _ = ticker.tick() => {
+                    if !keys.interrupted() {
+                        let elapsed = started.elapsed();
+                        if !stall_after.is_zero() && elapsed >= stall_after {
+                            spinner.set_message(format!(
+                                "{} · {}s · {}",
+                                tr(Msg::ModelStalled),
+                                elapsed.as_secs(),
+                                tr(Msg::StallHint)
+                            ));
+                        }
+                        continue;
+                    }
+                    match keys.paused(|| spinner.suspend(prompt_stall)) {
+                        StallDecision::Retry => {
+                            spinner.set_message(tr(Msg::GeneratingResponse));
+                            break;
+                        }
+                        StallDecision::Cancel => {
+                            spinner.finish_and_clear();
+                            return Err(anyhow!("{}", tr(Msg::ResponseCancelled)));
+                        }
+                        StallDecision::Wait => {}
+                    }
+                }
+            }
+        }
+    }
+}
+
+fn prompt_stall() -> StallDecision {
+    // Piped stdin holds the next prompts, not an answer; without a terminal there is nobody to ask.
+    if !stdin::answers_prompts() {
+        return StallDecision::Cancel;
+    }
+    let mut out = display::prompt_writer();
+    let _ = write!(out, "\n{}", tr(Msg::StallPrompt).yellow()).and_then(|_| out.flush());
+    let mut answer = String::new();
+    // EOF (Ctrl-D) cancels like an error does; only an actual empty line keeps waiting.
+    if !matches!(io::stdin().read_line(&mut answer), Ok(read) if read > 0) {
+        return StallDecision::Cancel;
+    }
+    match answer.trim().to_lowercase().as_str() {
+        "r" | "retry" => StallDecision::Retry,
+        "w" | "wait" | "" => StallDecision::Wait,
+        _ => StallDecision::Cancel,
+    }
+}
+
+enum StepDecision {
+    Continue,
+    Abort,
+    AskModel,
+}
+
+/// How a batch of actions ended when it did not hit a failing tool
 #[derive(Debug)]
+pub enum ActionsOutcome {
+    Completed(Vec<ToolExecutionResult>),
+    /// Step mode stopped before all actions ran; `ask_model` hands the partial results back to the model
+    Stopped { results: Vec<ToolExecutionResult>, remaining: usize, ask_model: bool },
+}
+
+/// How a turn ended, for callers that report it (the `-p` exit status)
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum TurnOutcome {
+    /// The model answered, and every action it ran last succeeded
+    Completed,
+    /// A plan was cancelled, aborted in step mode or held back for a question
+    Cancelled,
+    /// The turn ended on a failed action the model did not recover from
+    Failed,
+}
+
+/// One entry of a session log: a `## Title (timestamp)` section in markdown, an
+/// object in JSON
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct LogEntry {
+    pub title: String,
+    pub timestamp: String,
+    pub content: String,
+}
+
+/// A log entry as written to the session log; `parse_log_entries` reads it back
+pub fn format_log_entry(entry: &LogEntry) -> String {
+    format!("\n## {} ({})\n```\n{}\n```\n", entry.title, entry.timestamp, entry.content.trim())
+}
+
+/// Splits a session log into its entries. A header only counts when it follows a blank
+/// line and opens a fence, so markdown headings inside responses stay part of the content.
+pub fn parse_log_entries(log: &str) -> Vec<LogEntry> {
+    let lines: Vec<&str> = log.lines().collect();
+    let is_header = |i: usize| {
+        lines[i].starts_with("## ")
+            && lines[i].ends_with(')')
+            && lines[i].contains(" (")
+            && (i == 0 || lines[i - 1].trim().is_empty())
+            && lines.get(i + 1).map_or(false, |next| next.trim_end() == "```")
+    };
+    let starts: Vec<usize> = (0..lines.len()).filter(|&i| is_header(i)).collect();
+    let mut entries = Vec::new();
+    for (n, &start) in starts.iter().enumerate() {
+        let end = starts.get(n + 1).copied().unwrap_or(lines.len());
+        let header = &lines[start][3..];
+        let Some((title, timestamp)) = header.rsplit_once(" (") else {
+            continue;
+        };
+        let mut body: Vec<&str> = lines[start + 2..end].to_vec();
+        while body.last().map_or(false, |l| l.trim().is_empty()) {
+            body.pop();
+        }
+        if body.last().map_or(false, |l| l.trim_end() == "```") {
+            body.pop();
+        }
+        entries.push(LogEntry {
+            title: title.to_string(),
+            timestamp: timestamp.trim_end_matches(')').to_string(),
+            content: body.join("\n").trim().to_string(),
+        });
+    }
+    entries
+}
+
+/// First line of `content`, shortened to 60 characters
+fn preview(content: &str) -> String {
+    let line = content.lines().find(|l| !l.trim().is_empty()).unwrap_or_default().trim();
+    if line.chars().count() > 60 {
+        format!("{}…", line.chars().take(59).collect::<String>())
+    } else {
+        line.to_string()
+    }
+}
+
+#[derive(Debug, Hash)]
 pub struct DiscoveredTool {
     pub name: String,
     pub desc: String,
@@ -46,13 +357,14 @@ impl fmt::Display for ToolCall {
                     write!(f, "read_file: {}", path)
                 }
             }
-            ToolCall::WriteFile { path, content, append } => {
+            ToolCall::WriteFile { path, content, append, outside_workspace } => {
                 let content_snip = if content.len() > 30 {
                     format!("{}...", &content[..30].replace('\n', " "))
                 } else {
                     content.replace('\n', " ")
                 };
-                write!(f, "write_file: {} append={} (content: \"{}\")", path, append, content_snip)
+                let outside = if *outside_workspace { " outside_workspace=true" } else { "" };
+                write!(f, "write_file: {} append={}{} (content: \"{}\")", path, append, outside, content_snip)
             }
             ToolCall::ListDir { path } => write!(f, "list_dir: {}", path),
             ToolCall::ChangeDir { path } => write!(f, "cd: {}", path),
@@ -86,36 +398,504 @@ pub struct PrimeSession {
     pub command_processor: CommandProcessor,
     pub memory_manager: MemoryManager,
     pub working_dir: PathBuf,
+    /// Directory Prime was started in; .primeignore is resolved against it
+    pub workspace_root: PathBuf,
     pub discovered_tools: Vec<DiscoveredTool>,
+    /// Language the assistant should answer and write memory in (None = follow the user)
+    pub response_language: Option<String>,
+    /// Local usage analytics, present only when enabled in config
+    pub usage: Option<UsageRecorder>,
+    /// Typewriter pacing for streamed output in characters per second (0 = off)
+    pub typewriter_cps: u32,
+    /// Pause after every action and ask whether to continue
+    pub step_mode: bool,
+    /// Ask the user instead of acting when the model rates its plan as uncertain
+    pub clarify_first: bool,
+    /// asciinema recording started with `!record start`
+    pub recording: Option<Recording>,
+    /// Microphone and transcription settings for `!voice`
+    pub voice: VoiceConfig,
+    /// Text placed at the next prompt for the user to confirm, such as a `!voice` transcription
+    pub pending_input: Option<String>,
+    /// Follow-up requests allowed for a response cut off at the output limit
+    pub max_continuations: usize,
+    /// Pause before an auto-run plan executes, leaving time to read it and press Ctrl+C
+    pub auto_run_delay: Duration,
+    /// Seconds without a first token before the spinner reports a loading or stalled model (0 = never)
+    pub stall_warning_secs: u64,
+    turn_lock: TurnLock,
+    /// Decides on plans whose risk tier needs approval, including in headless runs
+    pub approval: ApprovalPolicy,
+    risk: RiskPolicy,
+    /// Files and URLs produced during the last turn, for `!open <n>`
+    pub open_targets: Vec<String>,
+    llm_factory: Option<LlmFactory>,
+    /// Temperature from the config, restored by `!set temperature none`
+    config_temperature: f32,
+    base_temperature: f32,
+    active_temperature: f32,
+    /// Consecutive failed tool runs in the current turn
+    recovery_attempt: usize,
+    /// Failed actions since recovery began, shown as a table and sent to the model
+    recovery_failures: Vec<FailedCommand>,
+    /// Notes for commands of the current plan that were put in non-interactive form, by the command that runs
+    rewritten_commands: HashMap<String, String>,
+    /// Shown by `!status`
+    pub model_name: String,
+    pub provider_name: String,
+    provider_health: ProviderHealth,
+    tokens: TokenTally,
+    /// File snapshots for non-git workspaces; None when disabled
+    snapshots: Option<SnapshotStore>,
+    /// Whether the workspace is inside a git repository, probed on the first snapshot
+    git_workspace: OnceCell<bool>,
+    pub snapshot_limits: SnapshotLimits,
+    /// User turns so far, numbering the snapshots
+    turn_number: usize,
+    /// PRIME_TMP directory of the current turn
+    pub scratch: TurnScratch,
+    /// Time source for the session id, log timestamps and memory entries
+    clock: SharedClock,
+    /// Filesystem for the session log, memory and file actions
+    fs: SharedFs,
+    /// History message numbers (1-based, as listed by `!pin msg`) kept in every prompt
+    pinned_messages: Vec<usize>,
+    /// Dependency versions from the working directory's manifests, for the system prompt
+    dependencies: DependencySummary,
+    /// Counters and job registry shared by all sessions
+    metadata: MetadataStore,
+    /// Turns across all sessions as of this session's last turn
+    turns_total: Option<u64>,
+    /// Generation parameters set with `!set`, stored with the session
+    params: ModelParams,
+    /// Latest session of this workspace before this one started, for `prime resume`
+    previous_session: Option<String>,
+    /// Ask the model about warnings printed by successful commands
+    pub warning_follow_up: bool,
+    /// Ollama server asked about loaded models before heavy prompts
+    load_check_host: Option<String>,
+    /// Turn whose load warnings were shown, with the history budget the user chose to trim to
+    load_checked: Option<(usize, Option<usize>)>,
+    memory_cache: MemoryCache,
+    /// Last system prompt with the hash of the inputs it was rendered from
+    system_prompt_cache: Option<(u64, String)>,
+}
+
+enum LoadChoice {
+    Trim,
+    Switch(String),
+    Continue,
+}
+
+fn prompt_load_choice(can_trim: bool, smaller: Option<&str>) -> LoadChoice {
+    let mut options = Vec::new();
+    if can_trim {
+        options.push(tr(Msg::LoadChoiceTrim).to_string());
+    }
+    if let Some(model) = smaller {
+        options.push(format!("{} {}", tr(Msg::LoadChoiceSwitch), model));
+    }
+    options.push(tr(Msg::LoadChoiceContinue).to_string());
+    let mut out = display::prompt_writer();
+    let _ = write!(out, "{}", format!("{}: ", options.join(", ")).yellow()).and_then(|_| out.flush());
+    let mut answer = String::new();
+    if io::stdin().read_line(&mut answer).is_err() {
+        return LoadChoice::Continue;
+    }
+    match (answer.trim().to_lowercase().as_str(), smaller) {
+        ("t" | "trim", _) if can_trim => LoadChoice::Trim,
+        ("s" | "switch", Some(model)) => LoadChoice::Switch(model.to_string()),
+        _ => LoadChoice::Continue,
+    }
 }
 
 impl PrimeSession {
     pub fn new(base_dir: PathBuf, llm: Box<dyn ChatProvider>) -> Result<Self> {
-        let session_id = format!("session_{}", chrono::Local::now().format("%Y%m%d_%H%M%S"));
+        Self::with_host(base_dir, llm, clock::system(), vfs::real())
+    }
+
+    /// A session whose time and files come from `clock` and `fs`, so tests and replays
+    /// get the same session id and log on every run
+    pub fn with_host(base_dir: PathBuf, llm: Box<dyn ChatProvider>, clock: SharedClock, fs: SharedFs) -> Result<Self> {
+        let session_id = format!("session_{}", clock.now().format("%Y%m%d_%H%M%S"));
         let conversations_dir = base_dir.join("conversations");
-        fs::create_dir_all(&conversations_dir)?;
         let session_log_path = conversations_dir.join(format!("{}.md", session_id));
+        let turn_lock = TurnLock::new(&conversations_dir, &session_id);
+        let scratch = TurnScratch::new(&session_id);
         let memory_dir = base_dir.join("memory");
-        let memory_manager = MemoryManager::new(memory_dir)?;
+        let memory_manager = MemoryManager::with_host(memory_dir, fs.clone(), clock.clone());
         let working_dir = std::env::current_dir().context("Failed to get current working directory")?;
         let discovered_tools = Self::discover_tools(&working_dir)?;
+        let mut command_processor = CommandProcessor::new(&base_dir, fs.clone());
+        if let Err(e) = command_processor.load_workspace_ignore(&working_dir) {
+            eprintln!("{}", format!("Warning: Failed to load .primeignore: {}", e).yellow());
+        }
+        for (key, value) in interactive::NON_INTERACTIVE_ENV {
+            command_processor.set_env(key, Some(value.to_string()));
+        }
+        let risk = RiskPolicy::from_config(&RiskConfig::default(), command_processor.ask_me_before_patterns())?;
+        let metadata = MetadataStore::new(&base_dir, clock.clone());
+        let previous_session = metadata.start_session(&working_dir, &session_id).unwrap_or_else(|e| {
+            eprintln!("{}", format!("Warning: Failed to update session metadata: {}", e).yellow());
+            None
+        });
         Ok(Self {
             base_dir,
             session_id,
             session_log_path,
             llm,
-            command_processor: CommandProcessor::new(),
+            command_processor,
             memory_manager,
+            workspace_root: working_dir.clone(),
             working_dir,
             discovered_tools,
+            response_language: None,
+            usage: None,
+            typewriter_cps: 0,
+            step_mode: false,
+            clarify_first: false,
+            recording: None,
+            voice: VoiceConfig::default(),
+            pending_input: None,
+            max_continuations: 2,
+            auto_run_delay: Duration::from_secs(2),
+            stall_warning_secs: 8,
+            turn_lock,
+            approval: ApprovalPolicy::default().with_clock(clock.clone()),
+            risk,
+            open_targets: Vec::new(),
+            llm_factory: None,
+            config_temperature: 0.0,
+            base_temperature: 0.0,
+            active_temperature: 0.0,
+            recovery_attempt: 0,
+            recovery_failures: Vec::new(),
+            rewritten_commands: HashMap::new(),
+            model_name: String::new(),
+            provider_name: String::new(),
+            provider_health: ProviderHealth::default(),
+            tokens: TokenTally::default(),
+            snapshots: None,
+            git_workspace: OnceCell::new(),
+            snapshot_limits: SnapshotLimits::default(),
+            turn_number: 0,
+            scratch,
+            clock,
+            fs,
+            pinned_messages: Vec::new(),
+            dependencies: DependencySummary::default(),
+            metadata,
+            turns_total: None,
+            params: ModelParams::default(),
+            previous_session,
+            warning_follow_up: false,
+            load_check_host: None,
+            load_checked: None,
+            memory_cache: MemoryCache::default(),
+            system_prompt_cache: None,
         })
     }
 
+    /// Label/value rows for `!status`
+    pub fn status_report(&self) -> Vec<(&'static str, String)> {
+        let health = match &self.provider_health {
+            ProviderHealth::Unknown => tr(Msg::HealthUnknown).to_string(),
+            ProviderHealth::Ok { elapsed } => format!("{} {:.1}s", tr(Msg::HealthOk), elapsed.as_secs_f32()),
+            ProviderHealth::Failed(error) => format!("{} {}", tr(Msg::HealthFailed), error),
+        };
+        let tokens = format!(
+            "~{} {} · ~{} {} · {} {}",
+            self.tokens.sent,
+            tr(Msg::TokensSent),
+            self.tokens.received,
+            tr(Msg::TokensReceived),
+            self.tokens.requests,
+            tr(Msg::TokensRequests)
+        );
+        let memory = format!(
+            "{} ({}) · {} {} · {} {}",
+            tr(Msg::MemoryGlobal),
+            self.memory_manager.memory_dir().display(),
+            self.memory_manager.entry_count("long_term"),
+            tr(Msg::MemoryLongTerm),
+            self.memory_manager.entry_count("short_term"),
+            tr(Msg::MemoryShortTerm)
+        );
+        let policy = format!(
+            "{} · step {} · headless {}",
+            self.risk.summary(),
+            if self.step_mode { "on" } else { "off" },
+            self.approval.headless_name()
+        );
+        let pinned = match self.pinned_messages.len() {
+            0 => "-".to_string(),
+            _ => self.pinned_messages.iter().map(|n| format!("#{}", n)).collect::<Vec<_>>().join(" "),
+        };
+        let turns = match self.turns_total {
+            Some(total) => format!("{} {} · {} {}", self.turn_number, tr(Msg::TurnsThisSession), total, tr(Msg::TurnsAllSessions)),
+            None => self.turn_number.to_string(),
+        };
+        let jobs = match self.metadata.load().map(|metadata| metadata.jobs) {
+            Ok(jobs) if !jobs.is_empty() => jobs.values().map(|job| job.summary()).collect::<Vec<_>>().join(" · "),
+            Ok(_) => "-".to_string(),
+            Err(e) => e.to_string(),
+        };
+        vec![
+            (tr(Msg::LabelSession), self.session_id.clone()),
+            (tr(Msg::LabelModel), self.model_name.clone()),
+            (tr(Msg::LabelProvider), self.provider_name.clone()),
+            (tr(Msg::LabelHealth), health),
+            (tr(Msg::LabelWorkspace), self.working_dir.display().to_string()),
+            (tr(Msg::LabelTokens), tokens),
+            (tr(Msg::LabelMemory), memory),
+            (tr(Msg::LabelPolicy), policy),
+            (tr(Msg::LabelPinned), pinned),
+            (tr(Msg::LabelTurns), turns),
+            (tr(Msg::LabelJobs), jobs),
+            (tr(Msg::LabelParams), self.params.to_string()),
+        ]
+    }
+
+    /// Turns on file snapshots before turns that execute actions
+    pub fn enable_file_snapshots(&mut self, limits: SnapshotLimits) {
+        self.snapshots = Some(SnapshotStore::new(&self.base_dir, &self.session_id, self.clock.clone()));
+        self.snapshot_limits = limits;
+    }
+
+    /// Snapshots the workspace unless it is a git repository, which has its own undo
+    fn snapshot_workspace(&self) {
+        let Some(store) = &self.snapshots else {
+            return;
+        };
+        if *self.git_workspace.get_or_init(|| snapshot::is_git_repo(&self.workspace_root)) {
+            return;
+        }
+        let exclude = |path: &Path| self.command_processor.is_path_excluded(path);
+        if let Err(e) = store.take(self.turn_number, &self.workspace_root, &exclude, self.snapshot_limits) {
+            eprintln!("{}", format!("Warning: File snapshot skipped: {}", e).yellow());
+        }
+    }
+
+    pub fn list_file_snapshots(&self) -> Result<Vec<SnapshotInfo>> {
+        match &self.snapshots {
+            Some(store) => store.list(),
+            None => Ok(Vec::new()),
+        }
+    }
+
+    /// Restores the workspace to the snapshot taken before `turn`
+    pub fn restore_files(&mut self, turn: usize) -> Result<RestoreSummary> {
+        let store = self.snapshots.as_ref().ok_or_else(|| anyhow!("File snapshots are disabled (file_snapshots = false)"))?;
+        let exclude = |path: &Path| self.command_processor.is_path_excluded(path);
+        let summary = store.restore(turn, &exclude)?;
+        self.save_log(
+            "System",
+            &format!("Workspace files restored to before turn {} ({} restored, {} removed).", turn, summary.restored, summary.removed),
+        )?;
+        Ok(summary)
+    }
+
+    /// Decides on plans with `approval`, stamping its audit records with the session clock
+    pub fn set_approval(&mut self, approval: ApprovalPolicy) {
+        self.approval = approval.with_clock(self.clock.clone());
+    }
+
+    /// Applies the `[risk]` section of the config
+    pub fn configure_risk(&mut self, config: &RiskConfig) -> Result<()> {
+        self.risk = RiskPolicy::from_config(config, self.command_processor.ask_me_before_patterns())?;
+        Ok(())
+    }
+
+    /// Lets recovery attempts rebuild the provider at lower temperatures, starting from `temperature`
+    pub fn enable_adaptive_recovery(&mut self, temperature: f32, factory: LlmFactory) {
+        self.config_temperature = temperature;
+        self.base_temperature = temperature;
+        self.active_temperature = temperature;
+        self.llm_factory = Some(factory);
+    }
+
+    /// Path to write for `path`, or the refusal. Targets outside the workspace need
+    /// `outside_workspace=true` on the action and the user's approval, asked through the
+    /// same policy (and audit log) as risky plans.
+    async fn write_target(&mut self, path: &str, outside_workspace: bool, action: &str) -> Result<PathBuf, String> {
+        let escape = match confine::resolve_write(&self.workspace_root, &self.working_dir, path) {
+            Ok(target) => return Ok(target),
+            Err(escape) if !outside_workspace => return Err(escape.refusal()),
+            Err(escape) => escape,
+        };
+        notice(format!("│ ⚠ {} {}", tr(Msg::OutsideWorkspaceWrite), escape).yellow());
+        match self.approval.decide(&self.session_id, &[action.to_string()], "outside-workspace", None).await {
+            Ok(decision) if decision.approved => Ok(escape.resolved),
+            Ok(decision) => Err(format!("Not written: the write outside the workspace was {}.", decision.reason)),
+            Err(e) => Err(format!("Not written: could not ask for approval: {}", e)),
+        }
+    }
+
+    /// Session parameters for the provider, at `temperature`
+    fn provider_params(&self, temperature: f32) -> ModelParams {
+        ModelParams { temperature: Some(temperature), ..self.params.clone() }
+    }
+
+    /// Generation parameters set for this session
+    pub fn params(&self) -> &ModelParams {
+        &self.params
+    }
+
+    /// Applies `!set <name> <value>` to this session and stores it, so resuming the
+    /// session restores it. Temperature changes move the recovery schedule as well.
+    pub fn set_param(&mut self, name: &str, value: &str) -> Result<()> {
+        let mut params = self.params.clone();
+        params.set(name, value)?;
+        self.apply_params(params, name == "temperature")?;
+        self.metadata.save_params(&self.session_id, &self.params)
+    }
+
+    /// Rebuilds the provider for `params` when they reach it
+    fn apply_params(&mut self, params: ModelParams, temperature_changed: bool) -> Result<()> {
+        let Some(factory) = &self.llm_factory else {
+            self.params = params;
+            return Ok(());
+        };
+        let base_temperature = match (temperature_changed, params.temperature) {
+            (false, _) => self.base_temperature,
+            (true, Some(temperature)) => temperature,
+            (true, None) => self.config_temperature,
+        };
+        let provider_params = ModelParams { temperature: Some(base_temperature), ..params.clone() };
+        self.llm = factory(&self.model_name, &provider_params)?;
+        self.base_temperature = base_temperature;
+        self.active_temperature = base_temperature;
+        self.params = params;
+        Ok(())
+    }
+
+    /// Continues `session` (by default the previous session in this workspace): its log
+    /// becomes the history and its stored parameters are applied again
+    pub fn resume(&mut self, session: Option<&str>) -> Result<String> {
+        let conversations_dir = self.base_dir.join("conversations");
+        let requested = session.map(str::to_string).or_else(|| self.previous_session.clone());
+        let requested = requested.ok_or_else(|| anyhow!("No earlier session in this workspace to resume"))?;
+        // A session that only tuned parameters has no log file yet but is still known.
+        let known = |id: &str| {
+            self.previous_session.as_deref() == Some(id)
+                || self.metadata.load().is_ok_and(|metadata| metadata.session_params.contains_key(id))
+        };
+        let path = match sessions::resolve(&conversations_dir, &requested) {
+            Ok(path) => path,
+            Err(_) if known(transcript::strip_extension(&requested)) => {
+                conversations_dir.join(format!("{}.{}", transcript::strip_extension(&requested), self.log_format().extension()))
+            }
+            Err(e) => return Err(e),
+        };
+        let session_id = sessions::session_id(&path);
+        self.turn_lock = TurnLock::new(&conversations_dir, &session_id);
+        self.scratch = TurnScratch::new(&session_id);
+        if self.snapshots.is_some() {
+            self.snapshots = Some(SnapshotStore::new(&self.base_dir, &session_id, self.clock.clone()));
+        }
+        let params = self.metadata.resume_session(&self.working_dir, &session_id)?;
+        self.session_id = session_id;
+        self.session_log_path = path;
+        // Turn numbers key the scratch dirs and file snapshots, so they carry on from the log.
+        let log = self.fs.read_to_string(&self.session_log_path).unwrap_or_default();
+        self.turn_number = self.log_format().parse(&log).iter().filter(|entry| entry.title == "User Input").count();
+        let temperature_changed = params.temperature.is_some();
+        self.apply_params(params, temperature_changed)?;
+        Ok(self.session_id.clone())
+    }
+
+    /// Token estimator for the current model
+    fn estimator(&self) -> Estimator {
+        Estimator::for_model(&self.model_name)
+    }
+
+    /// Checks Ollama's loaded models before heavy prompts
+    pub fn enable_load_checks(&mut self, host: String) {
+        self.load_check_host = Some(host);
+    }
+
+    /// Warns when a heavy prompt meets a cold, offloaded or too-small model and, at the
+    /// terminal, offers to trim the history or switch to a smaller loaded model
+    async fn check_model_load(&mut self, messages: &mut Vec<ChatMessage>) {
+        let Some(host) = &self.load_check_host else {
+            return;
+        };
+        let estimator = self.estimator();
+        // Warn and ask once per turn; later requests of the turn keep the answer.
+        if let Some((turn, budget)) = self.load_checked {
+            if turn == self.turn_number {
+                if let Some(budget) = budget {
+                    trim_history(messages, budget, &estimator);
+                }
+                return;
+            }
+        }
+        let prompt_tokens: usize = messages.iter().map(|m| estimator.count(&m.content)).sum();
+        if prompt_tokens < model_load::HEAVY_PROMPT_TOKENS {
+            return;
+        }
+        // The check is advisory; servers without /api/ps simply get no warning.
+        let Ok(loaded) = model_load::loaded_models(host).await else {
+            return;
+        };
+        let warnings = model_load::assess(&self.model_name, prompt_tokens, &loaded, model_load::available_memory());
+        if warnings.is_empty() {
+            return;
+        }
+        self.load_checked = Some((self.turn_number, None));
+        for warning in &warnings {
+            eprintln!("{}", format!("Warning: {}", warning).yellow());
+        }
+        let (num_ctx, smaller) = model_load::remedies(&warnings, model_load::smaller_model(&self.model_name, &loaded));
+        if (num_ctx.is_none() && smaller.is_none()) || terminal::is_quiet() || !stdin::answers_prompts() {
+            return;
+        }
+        match prompt_load_choice(num_ctx.is_some(), smaller.as_deref()) {
+            LoadChoice::Trim => {
+                let budget = num_ctx.unwrap_or(prompt_tokens) * 9 / 10;
+                let dropped = trim_history(messages, budget, &estimator);
+                self.load_checked = Some((self.turn_number, Some(budget)));
+                notice(format!("{} {}", tr(Msg::HistoryTrimmed), dropped).dark_grey());
+            }
+            LoadChoice::Switch(model) => {
+                let Some(factory) = &self.llm_factory else {
+                    return;
+                };
+                match factory(&model, &self.provider_params(self.active_temperature)) {
+                    Ok(llm) => {
+                        self.llm = llm;
+                        notice(format!("{} {}", tr(Msg::ModelSwitched), model).dark_grey());
+                        self.model_name = model;
+                    }
+                    Err(e) => eprintln!("{}", format!("Warning: Failed to switch model: {}", e).yellow()),
+                }
+            }
+            LoadChoice::Continue => {}
+        }
+    }
+
+    /// Switches the provider to the temperature scheduled for the current recovery attempt
+    fn apply_recovery_settings(&mut self) {
+        let Some(factory) = &self.llm_factory else {
+            return;
+        };
+        let target = recovery::recovery_temperature(self.base_temperature, self.recovery_attempt);
+        if (target - self.active_temperature).abs() < f32::EPSILON {
+            return;
+        }
+        match factory(&self.model_name, &self.provider_params(target)) {
+            Ok(llm) => {
+                self.llm = llm;
+                self.active_temperature = target;
+            }
+            Err(e) => eprintln!("{}", format!("Warning: Failed to adjust temperature for recovery: {}", e).yellow()),
+        }
+    }
+
     fn discover_tools(workspace: &Path) -> Result<Vec<DiscoveredTool>> {
+        // create_tool makes the directory when the first tool is written.
         let prime_dir = workspace.join("prime");
         if !prime_dir.exists() {
-            fs::create_dir_all(&prime_dir)
-                .with_context(|| format!("Failed to create ./prime directory: {}", prime_dir.display()))?;
             return Ok(Vec::new());
         }
         #[cfg(target_os = "windows")]
@@ -213,154 +993,516 @@ impl PrimeSession {
         Ok(())
     }
 
-    pub fn is_tool_destructive(&self, tool_call: &ToolCall) -> bool {
-        match tool_call {
-            ToolCall::Shell { command } => {
-                self.command_processor.is_command_destructive(command)
-            }
-            ToolCall::ScriptTool { name, args } => {
-                let ext = if cfg!(target_os = "windows") { "ps1" } else { "sh" };
-                let mut full_cmd = format!("./prime/tool_{}.{}", name, ext);
-                if !args.is_empty() {
-                    full_cmd.push_str(&format!(" {}", args.join(" ")));
+    /// Risk tier of each action in the plan. Commands no rule matched are optionally
+    /// put to the model, which can only raise their tier.
+    async fn assess_plan(&self, tool_calls: &[ToolCall]) -> Vec<(RiskTier, String)> {
+        let mut assessed = Vec::with_capacity(tool_calls.len());
+        for tool_call in tool_calls {
+            let classification = self.risk.classify(tool_call);
+            let mut tier = classification.tier;
+            if !classification.matched_rule && self.risk.model_classification {
+                if let Some(model_tier) = self.classify_with_model(&tool_call.to_string()).await {
+                    tier = tier.max(model_tier);
                 }
-                self.command_processor.is_command_destructive(&full_cmd)
             }
-            ToolCall::CreateTool { .. } => false,
-            _ => false,
+            assessed.push((tier, tool_call.to_string()));
+        }
+        assessed
+    }
+
+    /// Records `rating` for the latest turn of this session; returns a preview of its prompt
+    pub fn rate_last_turn(&self, rating: &Rating) -> Result<String> {
+        let log = self.fs.read_to_string(&self.session_log_path).unwrap_or_default();
+        let prompt = self.log_format().parse(&log)
+            .into_iter()
+            .rev()
+            .find(|entry| entry.title == "User Input")
+            .ok_or_else(|| anyhow!("{}", tr(Msg::NothingToRate)))?;
+        self.save_log(dataset::RATING_TITLE, &rating.to_log())?;
+        Ok(preview(&prompt.content))
+    }
+
+    /// Drafts a commit message and PR description from the workspace diff and this session's log
+    pub async fn draft_pr(&mut self) -> Result<pr::Draft> {
+        let log = self.fs.read_to_string(&self.session_log_path).unwrap_or_default();
+        let entries = self.log_format().parse(&log);
+        let requests = entries.iter().filter(|entry| entry.title == "User Input").map(|entry| preview(&entry.content)).collect();
+        let actions = entries
+            .iter()
+            .flat_map(evidence::collect_evidence)
+            .map(|evidence| format!("{} ({})", evidence.short_action(), if evidence.success { "ok" } else { "failed" }))
+            .collect();
+        let context = pr::gather(&self.working_dir, requests, actions)?;
+        let prompt = pr::draft_prompt(&context);
+        let estimator = self.estimator();
+        self.tokens.record_request(&estimator, &[&prompt]);
+        let reply = self.llm.chat(&[ChatMessage::user().content(prompt).build()]).await?.to_string();
+        self.tokens.record_response(&estimator, &reply);
+        pr::parse_draft(&reply)
+    }
+
+    async fn classify_with_model(&self, action: &str) -> Option<RiskTier> {
+        let messages = vec![ChatMessage::user().content(policy::model_classification_prompt(action)).build()];
+        match self.llm.chat(&messages).await {
+            Ok(reply) => policy::parse_model_tier(&reply.to_string()),
+            Err(e) => {
+                eprintln!("{}", format!("Warning: Risk classification by the model failed: {}", e).yellow());
+                None
+            }
         }
     }
 
-    pub async fn process_input(&mut self, input: &str) -> Result<()> {
+    pub async fn process_input(&mut self, input: &str) -> Result<TurnOutcome> {
+        // The log directory is created with the first turn rather than at startup.
+        if !self.fs.exists(&self.session_log_path) {
+            if let Some(dir) = self.session_log_path.parent() {
+                self.fs.create_dir_all(dir).with_context(|| format!("Failed to create {}", dir.display()))?;
+            }
+        }
+        // Held until the turn ends so another client on this session cannot interleave with it.
+        let _turn = self.turn_lock.acquire(&turn_lock::owner_label(self.clock.as_ref()), TURN_QUEUE_WAIT).await?;
         self.save_log("User Input", input)?;
+        self.turn_number += 1;
+        // Released explicitly on the way out; dropping it covers errors and cancellation.
+        let job = self.register_turn().await;
+        let scratch_dir = match self.scratch.begin(self.turn_number) {
+            Ok(dir) => Some(dir.display().to_string()),
+            Err(e) => {
+                eprintln!("{}", format!("Warning: {}", e).yellow());
+                None
+            }
+        };
+        self.command_processor.set_env(scratch::ENV_VAR, scratch_dir);
+        self.dependencies.refresh(&self.working_dir);
+        let mut snapshot_taken = false;
+        self.open_targets.clear();
+        self.recovery_attempt = 0;
+        self.recovery_failures.clear();
+        self.record_usage(UsageEventKind::Turn, true);
         self.reload_tools()?;
+        if let Err(e) = self.command_processor.load_workspace_ignore(&self.workspace_root) {
+            eprintln!("{}", format!("Warning: Failed to reload .primeignore: {}", e).yellow());
+        }
         const MAX_CONSECUTIVE_TOOL_TURNS: usize = 10;
         let mut tool_turn_count = 0;
         let mut has_displayed_actions = false;
+        let mut outcome = TurnOutcome::Completed;
         loop {
             if tool_turn_count >= MAX_CONSECUTIVE_TOOL_TURNS {
-                println!("{}", "Reached maximum tool execution turns. The session might be in a loop. Please try a new prompt.".red());
+                notice(tr(Msg::MaxTurnsReached).red());
+                break;
+            }
+            self.apply_recovery_settings();
+            let started = Instant::now();
+            let generated = self.generate_prime_response(has_displayed_actions).await;
+            self.provider_health = match &generated {
+                Ok(_) => ProviderHealth::Ok { elapsed: started.elapsed() },
+                Err(e) => ProviderHealth::Failed(e.to_string()),
+            };
+            let (response_text, streamed) = generated?;
+            for url in opener::extract_urls(&response_text) {
+                opener::push_target(&mut self.open_targets, url);
+            }
+            let mut parsed = parser::parse_llm_response(&response_text)?;
+            // Only the first plan of a turn is held back; later ones act on results the user asked for.
+            let clarification = match (self.clarify_first, has_displayed_actions) {
+                (true, false) if !parsed.tool_calls.is_empty() => {
+                    let assessment = parsed.assessment.clone().unwrap_or_else(clarify::Assessment::unstated);
+                    assessment.clarification().map(|question| (assessment.confidence, question.to_string()))
+                }
+                _ => None,
+            };
+            if let Some((confidence, question)) = clarification {
+                if !parsed.natural_language.is_empty() && !streamed {
+                    print_prose(&parsed.natural_language, "");
+                }
+                decorate(format!("{} {}%", tr(Msg::PlanHeldBack), confidence).yellow());
+                print_prose(&question, "");
+                self.save_log(
+                    "System",
+                    &format!("The planned actions were not run (confidence {}%). Prime asked the user: {}", confidence, question),
+                )?;
+                outcome = TurnOutcome::Cancelled;
                 break;
             }
-            let response_text = self.generate_prime_response().await?;
-            let parsed = parser::parse_llm_response(&response_text)?;
             if parsed.tool_calls.is_empty() {
                 if !parsed.natural_language.is_empty() {
                     if has_displayed_actions {
-                        println!();
-                        let wrapped = wrap_text(&parsed.natural_language, 68);
-                        for line in wrapped.lines() {
-                            println!("{}", format!("┃{}", line).white());
-                        }
-                        println!("{}", "┗━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━".white());
-                    } else {
-                        let wrapped = wrap_text(&parsed.natural_language, 70);
-                        for line in wrapped.lines() {
-                            println!("{}", line.white());
+                        if !streamed {
+                            decorate("");
+                            print_prose(&parsed.natural_language, "┃");
                         }
+                        decorate(display::box_bottom(display::layout_width()).white());
+                    } else if !streamed {
+                        print_prose(&parsed.natural_language, "");
                     }
                 }
                 break;
             }
             tool_turn_count += 1;
-            if !parsed.natural_language.is_empty() {
-                let wrapped = wrap_text(&parsed.natural_language, 70);
-                for line in wrapped.lines() {
-                    println!("{}", line.white());
-                }
+            if !parsed.natural_language.is_empty() && !streamed {
+                print_prose(&parsed.natural_language, "");
                 io::stdout().flush()?;
             }
-            println!();
-            println!("{}", "┏━ actions".yellow());
+            // Commands with a non-interactive form are rewritten before the plan is shown and
+            // approved, so what runs is what the user agreed to.
+            self.rewritten_commands.clear();
+            for tool in &mut parsed.tool_calls {
+                if let ToolCall::Shell { command } = tool {
+                    if let Some(Interactive::Rewritten { command: rewritten, reason }) = interactive::check(command) {
+                        let note = format!("Note: runs as `{}` instead of `{}` ({})\n", rewritten, command, reason);
+                        self.rewritten_commands.insert(rewritten.clone(), note);
+                        *command = rewritten;
+                    }
+                }
+            }
+            decorate("");
+            decorate(format!("┏━ {}", tr(Msg::Actions)).yellow());
+            let width = display::layout_width();
             for tool in &parsed.tool_calls {
-                match tool {
-                    ToolCall::Shell { command } => println!("{}", format!("┃ {}", command).yellow()),
+                let summary = match tool {
+                    ToolCall::Shell { command } => command.clone(),
                     ToolCall::ReadFile { path, lines } => {
                         if let Some((start, end)) = lines {
-                            println!("{}", format!("┃ read_file: {} lines={}-{}", path, start, end).yellow());
+                            format!("read_file: {} lines={}-{}", path, start, end)
                         } else {
-                            println!("{}", format!("┃ read_file: {}", path).yellow());
+                            format!("read_file: {}", path)
                         }
                     }
-                    ToolCall::WriteFile { path, .. } => println!("{}", format!("┃ write_file: {}", path).yellow()),
-                    ToolCall::ListDir { path } => println!("{}", format!("┃ list_dir: {}", path).yellow()),
-                    ToolCall::ChangeDir { path } => println!("{}", format!("┃ cd: {}", path).yellow()),
-                    ToolCall::WriteMemory { memory_type, .. } => println!("{}", format!("┃ write_memory: {}", memory_type).yellow()),
-                    ToolCall::ClearMemory { memory_type } => println!("{}", format!("┃ clear_memory: {}", memory_type).yellow()),
-                    ToolCall::ScriptTool { name, args } => println!("{}", format!("┃ {}: {}", name, args.join(" ")).yellow()),
-                    ToolCall::CreateTool { name, desc, args, .. } => println!("{}", format!("┃ create_tool: name={} desc=\"{}\" args=\"{}\"", name, desc, args).yellow()),
+                    ToolCall::WriteFile { path, .. } => format!("write_file: {}", path),
+                    ToolCall::ListDir { path } => format!("list_dir: {}", path),
+                    ToolCall::ChangeDir { path } => format!("cd: {}", path),
+                    ToolCall::WriteMemory { memory_type, .. } => format!("write_memory: {}", memory_type),
+                    ToolCall::ClearMemory { memory_type } => format!("clear_memory: {}", memory_type),
+                    ToolCall::ScriptTool { name, args } => format!("{}: {}", name, args.join(" ")),
+                    ToolCall::CreateTool { name, desc, args, .. } => format!("create_tool: name={} desc=\"{}\" args=\"{}\"", name, desc, args),
+                };
+                for line in display::wrap_prefixed(&summary, "┃ ", width) {
+                    decorate(line.yellow());
                 }
             }
-            let is_destructive = parsed.tool_calls.iter().any(|tc| self.is_tool_destructive(tc));
-            let should_execute = if is_destructive {
-                println!("{}", "┗━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━ destructive ━━━━━".red());
-                print!("{}", "Execute? (y/N): ".red());
-                io::stdout().flush().context("Failed to flush stdout")?;
-                let mut confirmation = String::new();
-                io::stdin().read_line(&mut confirmation).context("Failed to read user input")?;
-                confirmation.trim().eq_ignore_ascii_case("y")
-            } else {
-                println!("{}", "┗━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━ executing in 2s ━━━━━".yellow());
-                std::thread::sleep(std::time::Duration::from_secs(2));
+            let assessed = self.assess_plan(&parsed.tool_calls).await;
+            let plan_tier = assessed.iter().map(|(tier, _)| *tier).max().unwrap_or(RiskTier::ReadOnly);
+            let tier_action = self.risk.action_for(plan_tier);
+            let actions: Vec<String> = assessed
+                .into_iter()
+                .filter(|(tier, _)| self.risk.action_for(*tier) != TierAction::AutoRun)
+                .map(|(_, action)| action)
+                .collect();
+            let mut cancel_reason = String::new();
+            let should_execute = if tier_action == TierAction::AutoRun {
+                // The delay leaves time to read the preview and interrupt, in quiet mode too.
+                notice(display::box_bottom_with_label(tr(Msg::ExecutingIn2s), width).yellow());
+                std::thread::sleep(self.auto_run_delay);
                 true
+            } else {
+                notice(display::box_bottom_with_label(plan_tier.label(), width).red());
+                let decision = match tier_action {
+                    TierAction::Deny => self.approval.refuse(&self.session_id, &actions, plan_tier.id()),
+                    TierAction::TypedPhrase => self.approval.decide(&self.session_id, &actions, plan_tier.id(), Some(plan_tier.id())).await?,
+                    _ => self.approval.decide(&self.session_id, &actions, plan_tier.id(), None).await?,
+                };
+                if !decision.approved {
+                    cancel_reason = decision.reason;
+                }
+                decision.approved
             };
             if !should_execute {
-                println!();
-                println!("{}", "┃ Plan cancelled by user.".red());
-                println!("{}", "┗━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━".red());
-                self.save_log("System", "Plan cancelled by user.")?;
+                decorate("");
+                notice(format!("┃ {} ({})", tr(Msg::PlanCancelled), cancel_reason).red());
+                decorate(display::box_bottom(width).red());
+                self.save_log("System", &format!("Plan cancelled: {}.", cancel_reason))?;
+                outcome = TurnOutcome::Cancelled;
                 break;
             }
             has_displayed_actions = true;
+            if !snapshot_taken {
+                self.snapshot_workspace();
+                snapshot_taken = true;
+            }
             match self.execute_actions(parsed.tool_calls).await {
-                Ok(successful_results) => {
+                Ok(ActionsOutcome::Completed(successful_results)) => {
+                    outcome = TurnOutcome::Completed;
+                    self.end_recovery();
                     let results_prompt = self.format_tool_results_for_llm(&successful_results)?;
                     self.save_log("Tool Results", &results_prompt)?;
                 }
+                Ok(ActionsOutcome::Stopped { results, remaining, ask_model }) => {
+                    outcome = TurnOutcome::Completed;
+                    self.end_recovery();
+                    if !results.is_empty() {
+                        let results_prompt = self.format_tool_results_for_llm(&results)?;
+                        self.save_log("Tool Results", &results_prompt)?;
+                    }
+                    if ask_model {
+                        notice(format!("╰─ {}", tr(Msg::StepAskModel)).yellow());
+                        self.save_log("System", &format!(
+                            "The user paused step-by-step execution with {} action(s) not yet run. Review the results so far and propose how to continue.",
+                            remaining
+                        ))?;
+                    } else {
+                        notice(format!("╰─ {}", tr(Msg::StepAborted)).red());
+                        self.save_log("System", &format!("Plan aborted by user in step mode; {} action(s) skipped.", remaining))?;
+                        outcome = TurnOutcome::Cancelled;
+                        break;
+                    }
+                }
                 Err(failed_result) => {
+                    outcome = TurnOutcome::Failed;
+                    self.recovery_attempt += 1;
+                    self.record_usage(UsageEventKind::Recovery, false);
+                    self.recovery_failures.push(FailedCommand::new(
+                        self.recovery_attempt,
+                        &failed_result.tool_call_str,
+                        failed_result.exit_code,
+                        &failed_result.output,
+                    ));
                     let error_prompt = self.format_tool_failure_for_llm(&failed_result)?;
-                    println!();
-                    println!("{}", "┃ A tool failed. The AI will attempt to self-correct.".red());
-                    println!("{}", "┗━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━".red());
+                    decorate("");
+                    notice(format!("┃ {}", tr(Msg::ToolFailed)).red());
+                    self.print_failure_table();
+                    decorate(display::box_bottom(display::layout_width()).red());
                     self.save_log("Tool Failure", &error_prompt)?;
                 }
             }
         }
-        Ok(())
+        if let Some(dir) = self.scratch.end_turn() {
+            decorate(format!("{} {}", tr(Msg::ScratchPending), dir.display()).dark_grey());
+        }
+        if let Some(job) = job {
+            job.release().await;
+        }
+        Ok(outcome)
+    }
+
+    /// Counts the turn in the shared metadata and registers it as a running job
+    async fn register_turn(&mut self) -> Option<JobGuard> {
+        match self.metadata.start_turn(&self.session_id).await {
+            Ok((total, job)) => {
+                self.turns_total = Some(total);
+                Some(job)
+            }
+            Err(e) => {
+                eprintln!("{}", format!("Warning: Failed to update session metadata: {}", e).yellow());
+                None
+            }
+        }
+    }
+
+    fn end_recovery(&mut self) {
+        self.recovery_attempt = 0;
+        self.recovery_failures.clear();
+    }
+
+    /// Failed commands of this recovery with their exit codes and error lines, aligned
+    fn print_failure_table(&self) {
+        let width = display::layout_width();
+        let rows = recovery::table_rows(&self.recovery_failures, width / 3);
+        let column = |col: usize| rows.iter().map(|row: &[String; 4]| row[col].chars().count()).max().unwrap_or(0);
+        let (w0, w1, w2) = (column(0), column(1), column(2));
+        let error_width = width.saturating_sub(w0 + w1 + w2 + 8).max(20);
+        for row in &rows {
+            let error: String = row[3].chars().take(error_width).collect();
+            notice(format!(
+                "┃ {}  {}  {}  {}",
+                format!("{:<w$}", row[0], w = w0).dark_grey(),
+                format!("{:<w$}", row[1], w = w1).cyan(),
+                format!("{:<w$}", row[2], w = w2).red(),
+                error
+            ));
+        }
+    }
+
+    /// Keeps the last turn's scratch directory instead of removing it at the next turn
+    pub fn keep_scratch(&mut self) -> Option<PathBuf> {
+        self.scratch.keep()
+    }
+
+    fn record_usage(&self, kind: UsageEventKind, success: bool) {
+        if let Some(usage) = &self.usage {
+            usage.record(kind, success);
+        }
     }
 
     fn save_log(&self, title: &str, content: &str) -> Result<()> {
-        let mut file = OpenOptions::new().create(true).append(true).open(&self.session_log_path)?;
-        let timestamp = chrono::Local::now().format("%Y-%m-%d %H:%M:%S");
-        writeln!(file, "\n## {} ({})", title, timestamp)?;
-        writeln!(file, "```")?;
-        writeln!(file, "{}", content.trim())?;
-        writeln!(file, "```")?;
+        let timestamp = self.clock.now().format("%Y-%m-%d %H:%M:%S").to_string();
+        let entry = self.log_format().format_entry(&LogEntry { title: title.to_string(), timestamp, content: content.to_string() });
+        self.fs.write(&self.session_log_path, entry.as_bytes(), true)?;
         Ok(())
     }
 
-    async fn generate_prime_response(&mut self) -> Result<String> {
-        let history = self.get_history(Some(10))?;
+    /// Filesystem the session log and memory live on
+    pub fn fs(&self) -> &SharedFs {
+        &self.fs
+    }
+
+    /// Storage format of this session's log, from its extension
+    fn log_format(&self) -> LogFormat {
+        LogFormat::of(&self.session_log_path).unwrap_or(LogFormat::Markdown)
+    }
+
+    /// Stores this session's log in `format`; a log already written keeps its format
+    pub fn set_log_format(&mut self, format: LogFormat) {
+        if !self.fs.exists(&self.session_log_path) {
+            self.session_log_path.set_extension(format.extension());
+        }
+    }
+
+    /// Generates the next response, streaming its prose to the terminal when the
+    /// provider supports it. Returns the full text and whether it was already displayed.
+    async fn generate_prime_response(&mut self, after_actions: bool) -> Result<(String, bool)> {
+        let history = self.get_history(Some(HISTORY_WINDOW))?;
         let mut messages = vec![ChatMessage::user().content(self.get_system_prompt()?).build()];
         messages.extend(history);
-        let spinner = ProgressBar::new_spinner();
+        if let Some(constraints) = recovery::recovery_constraints(self.recovery_attempt) {
+            messages.push(ChatMessage::user().content(constraints).build());
+        }
+        self.check_model_load(&mut messages).await;
+        let estimator = self.estimator();
+        if let Some(budget) = self.params.context_budget {
+            let dropped = trim_history(&mut messages, budget, &estimator);
+            if dropped > 0 {
+                notice(format!("{} {}", tr(Msg::HistoryTrimmed), dropped).dark_grey());
+            }
+        }
+        self.tokens.record_request(&estimator, &messages.iter().map(|m| m.content.as_str()).collect::<Vec<_>>());
+        let (mut full_response, streamed) = self.request_response(&messages, after_actions).await?;
+        self.tokens.record_response(&estimator, &full_response);
+        // Responses cut off at the output limit are continued and stitched together
+        // before extraction, so a half-written action block never reaches the parser.
+        let mut continuations = 0;
+        while continuations < self.max_continuations && continuation::is_truncated(&full_response) {
+            continuations += 1;
+            decorate(tr(Msg::ContinuingResponse).dark_grey());
+            let mut follow_up = messages.clone();
+            follow_up.push(ChatMessage::assistant().content(full_response.clone()).build());
+            follow_up.push(ChatMessage::user().content(continuation::continuation_prompt(&full_response)).build());
+            self.tokens.record_request(&estimator, &follow_up.iter().map(|m| m.content.as_str()).collect::<Vec<_>>());
+            let (part, _) = self.request_response(&follow_up, after_actions).await?;
+            self.tokens.record_response(&estimator, &part);
+            full_response = continuation::stitch(&full_response, &part);
+        }
+        if let Some(end) = self.params.stop_at(&full_response) {
+            full_response.truncate(end);
+        }
+        self.save_log("Prime Response", &full_response)?;
+        Ok((full_response, streamed))
+    }
+
+    /// Sends `messages` and returns the reply, streaming it when the provider supports
+    /// that. The flag reports whether the prose was already printed.
+    async fn request_response(&self, messages: &[ChatMessage], after_actions: bool) -> Result<(String, bool)> {
+        let spinner = if terminal::is_quiet() { ProgressBar::hidden() } else { ProgressBar::new_spinner() };
         spinner.set_style(ProgressStyle::with_template("{spinner:.yellow.bold} {msg}").unwrap().tick_strings(&SPINNER_TICKS));
-        spinner.set_message("Generating response...");
+        spinner.set_message(tr(Msg::GeneratingResponse));
         spinner.enable_steady_tick(std::time::Duration::from_millis(120));
-        let response = self.llm.chat(&messages).await.map_err(|e| {
-            spinner.finish_and_clear();
-            e
-        })?;
-        spinner.finish_and_clear();
-        let full_response = response.to_string();
-        self.save_log("Prime Response", &full_response)?;
-        Ok(full_response)
+
+        let stall_after = Duration::from_secs(self.stall_warning_secs);
+        let llm = &self.llm;
+        // The first chunk is awaited together with the request so a cold model load
+        // is covered by the stall indicator, not just the time to response headers.
+        let opened = wait_for_model(
+            || async move {
+                let mut stream = llm.chat_stream(messages).await?;
+                let first = stream.next().await;
+                Ok::<_, llm::error::LLMError>((stream, first))
+            },
+            &spinner,
+            stall_after,
+        )
+        .await?;
+
+        let (full_response, streamed) = match opened {
+            Ok((mut stream, first)) => {
+                let mut handler = StreamHandler::new();
+                let quiet = terminal::is_quiet();
+                let prefix = if after_actions && !quiet { "┃" } else { "" };
+                // Quiet output is meant for other programs, so it is neither wrapped nor paced.
+                let (width, cps) = if quiet { (usize::MAX / 2, 0) } else { (display::layout_width(), self.typewriter_cps) };
+                let mut printer = StreamPrinter::new(io::stdout(), width).with_prefix(prefix).with_typewriter(cps);
+                let mut full_response = String::new();
+                let mut started = false;
+                let mut next = first;
+                while let Some(chunk) = next {
+                    let chunk = chunk.map_err(|e| {
+                        spinner.finish_and_clear();
+                        e
+                    })?;
+                    if !started {
+                        spinner.finish_and_clear();
+                        if after_actions {
+                            decorate("");
+                        }
+                        started = true;
+                    }
+                    full_response.push_str(&chunk);
+                    for token in handler.process_token(&chunk) {
+                        if let StreamToken::Text(text) = token {
+                            printer.write_chunk(&text)?;
+                        }
+                    }
+                    next = stream.next().await;
+                }
+                spinner.finish_and_clear();
+                if let Some(StreamToken::Text(text)) = handler.flush() {
+                    printer.write_chunk(&text)?;
+                }
+                printer.finish()?;
+                (full_response, started)
+            }
+            // Providers without streaming support fall back to a single request
+            Err(_) => {
+                let response = wait_for_model(|| llm.chat(messages), &spinner, stall_after).await?.map_err(|e| {
+                    spinner.finish_and_clear();
+                    e
+                })?;
+                spinner.finish_and_clear();
+                (response.to_string(), false)
+            }
+        };
+        Ok((full_response, streamed))
+    }
+
+    /// System prompt for the next request, rebuilt only when one of its inputs changed
+    fn get_system_prompt(&mut self) -> Result<String> {
+        let memory = self.memory_cache.get(&self.memory_manager)?.to_string();
+        let mut hasher = DefaultHasher::new();
+        (&memory, &self.working_dir, &self.response_language, self.scratch.current(), self.dependencies.text()).hash(&mut hasher);
+        (self.command_processor.has_workspace_ignore_rules(), &self.discovered_tools, self.clarify_first).hash(&mut hasher);
+        let key = hasher.finish();
+        if let Some((cached_key, prompt)) = &self.system_prompt_cache {
+            if *cached_key == key {
+                return Ok(prompt.clone());
+            }
+        }
+        let prompt = self.render_system_prompt(&memory);
+        self.system_prompt_cache = Some((key, prompt.clone()));
+        Ok(prompt)
     }
 
-    fn get_system_prompt(&self) -> Result<String> {
-        let memory = self.memory_manager.read_memory(None)?;
+    fn render_system_prompt(&self, memory: &str) -> String {
         let operating_system = std::env::consts::OS;
         let working_dir = self.working_dir.display().to_string();
+        let language_rule = match &self.response_language {
+            Some(language) => format!(
+                "Language: Respond to the user in {language} and write memory entries in {language}. Keep `primeactions` blocks, tool names, commands and file paths exactly as specified in English.",
+                language = language
+            ),
+            None => "Language: Respond in the language the user writes in. Keep `primeactions` blocks and tool names in English.".to_string(),
+        };
+        let ignore_rule = if self.command_processor.has_workspace_ignore_rules() {
+            "Excluded paths: The workspace has a .primeignore file. Paths it excludes are off-limits; do not read, list or print them, including through shell commands."
+        } else {
+            ""
+        };
+        let scratch_rule = match self.scratch.current() {
+            Some(dir) => format!(
+                "Scratch Directory: {} (also in the {} environment variable). Put temporary and intermediate files there, not in the workspace; it is deleted when the next turn starts, so nothing in it carries over.",
+                dir.display(),
+                scratch::ENV_VAR
+            ),
+            None => String::new(),
+        };
         let behavioral_prompt = r#"
 You are PRIME, an AI terminal assistant designed to help users accomplish tasks efficiently.
 CORE PRINCIPLES:
@@ -440,8 +1582,9 @@ Use create_tool proactively to build specialized tools for recurring or complex
 3. `read_file: <path> [lines=start-end]`
     - Reads a file. Optionally, you can specify a line range.
     - Example: `read_file: src/main.rs lines=1-20`
-4. `write_file: <path> [append=true]`
+4. `write_file: <path> [append=true] [outside_workspace=true]`
     - Writes content to a file. Overwrites by default. Use `append=true` to append.
+    - Paths must stay inside the workspace. Only when the user asks for a location outside it, add `outside_workspace=true`; the user is asked to confirm.
     - The content to write must follow on new lines, terminated by `EOF_PRIME`.
     - Example:
       ```primeactions
@@ -506,7 +1649,7 @@ Use create_tool proactively to build specialized tools for recurring or complex
         if !self.discovered_tools.is_empty() {
             tools_section.push_str("\nFor custom tools, use `tool_name: arg1 arg2` (space-separated).");
         }
-        let technical_prompt = format!(
+        format!(
             r#"
 You are an AI assistant. Your goal is to help the user by executing commands on their system.
 **RESPONSE FORMAT**
@@ -522,6 +1665,12 @@ After you provide a `primeactions` block, I will execute the tools and return th
 <CONTEXT>
 OS: {operating_system}
 Working Directory: {working_dir}
+{language_rule}
+{ignore_rule}
+{scratch_rule}
+{clarify_rule}
+Provenance: Memory entries, history messages and file contents start with a `[src: ...]` label (memory:long/<category>, history:msg <n>, workspace:<path> lines <a>-<b>). Cite the label when an answer relies on that source; never write labels yourself.
+{dependencies}
 {memory}
 </CONTEXT>
 --- BEGIN BEHAVIORAL PROMPT ---
@@ -532,33 +1681,76 @@ Now, begin.
             tools_section = tools_section,
             operating_system = operating_system,
             working_dir = working_dir,
+            language_rule = language_rule,
+            ignore_rule = ignore_rule,
+            scratch_rule = scratch_rule,
+            clarify_rule = if self.clarify_first { clarify::RULE } else { "" },
+            dependencies = self.dependencies.text(),
             memory = memory,
             behavioral_prompt = behavioral_prompt,
-        );
-        Ok(technical_prompt)
+        )
     }
 
     pub async fn execute_actions(
         &mut self,
         tool_calls: Vec<ToolCall>,
-    ) -> Result<Vec<ToolExecutionResult>, ToolExecutionResult> {
+    ) -> Result<ActionsOutcome, ToolExecutionResult> {
         let start_time = std::time::Instant::now();
+        let total = tool_calls.len();
         let mut all_results = Vec::new();
-        for tool_call in tool_calls.into_iter() {
+        for (idx, tool_call) in tool_calls.into_iter().enumerate() {
             let result = self.execute_tool(tool_call).await;
             if !result.success {
                 return Err(result);
             }
             all_results.push(result);
+            let remaining = total - idx - 1;
+            if self.step_mode && remaining > 0 {
+                match self.prompt_step(idx + 1, total) {
+                    StepDecision::Continue => {}
+                    StepDecision::Abort => {
+                        return Ok(ActionsOutcome::Stopped { results: all_results, remaining, ask_model: false });
+                    }
+                    StepDecision::AskModel => {
+                        return Ok(ActionsOutcome::Stopped { results: all_results, remaining, ask_model: true });
+                    }
+                }
+            }
         }
         let duration = start_time.elapsed();
         let duration_str = format!("{:.1}s", duration.as_secs_f32());
-        println!("{}", format!("╰────────────────────────────────────── completed in {} ────────", duration_str).green());
-        Ok(all_results)
+        let label = format!("{} {}", tr(Msg::CompletedIn), duration_str);
+        decorate(display::completion_bar(&label, display::layout_width()).green());
+        Ok(ActionsOutcome::Completed(all_results))
+    }
+
+    fn prompt_step(&self, done: usize, total: usize) -> StepDecision {
+        if !stdin::answers_prompts() {
+            eprintln!("{}", tr(Msg::StepNeedsTerminal).yellow());
+            return StepDecision::Abort;
+        }
+        let mut out = display::prompt_writer();
+        let _ = write!(out, "{}", format!("├─ {}/{} · {}", done, total, tr(Msg::StepPrompt)).yellow()).and_then(|_| out.flush());
+        let mut answer = String::new();
+        // EOF (Ctrl-D) stops like an error does; only an actual empty line continues.
+        if !matches!(io::stdin().read_line(&mut answer), Ok(read) if read > 0) {
+            return StepDecision::Abort;
+        }
+        match answer.trim().to_lowercase().as_str() {
+            "" | "c" | "continue" => StepDecision::Continue,
+            "m" | "ask" | "ask-model" => StepDecision::AskModel,
+            _ => StepDecision::Abort,
+        }
     }
 
     async fn execute_tool(&mut self, tool_call: ToolCall) -> ToolExecutionResult {
         let tool_call_str = tool_call.to_string();
+        let is_command = matches!(tool_call, ToolCall::Shell { .. } | ToolCall::ScriptTool { .. });
+        let source = match &tool_call {
+            ToolCall::ReadFile { path, lines } => Some(Source::Workspace { path: path.clone(), lines: *lines }),
+            _ => None,
+        };
+        let mut exit_code = None;
         let (success, output) = match tool_call {
             ToolCall::ChangeDir { path } => {
                 let new_path = self.working_dir.join(&path);
@@ -574,15 +1766,26 @@ Now, begin.
                     (false, format!("Directory not found: {}", new_path.display()))
                 }
             }
-            ToolCall::Shell { command } => {
-                match self.command_processor.execute_command(&command, Some(&self.working_dir)) {
-                    Ok((0, out)) => (true, out),
-                    Ok((code, out)) => {
-                        if code == -1 { (false, out) } else { (false, format!("Command failed with exit code {}\nOutput:\n{}", code, out)) }
+            ToolCall::Shell { command } => match interactive::check(&command) {
+                Some(Interactive::Blocking { reason }) => (false, format!("Not run, it would wait for terminal input: {}", reason)),
+                _ => {
+                    // Rewrites happened before approval; the command runs exactly as shown.
+                    let note = self.rewritten_commands.remove(&command).unwrap_or_default();
+                    let result = self.command_processor.execute_command(&command, Some(&self.working_dir));
+                    exit_code = result.as_ref().ok().map(|(code, _)| *code).filter(|code| *code != -1);
+                    match result {
+                        Ok((0, out)) => (true, format!("{}{}", note, out)),
+                        Ok((code, out)) => {
+                            if code == -1 {
+                                (false, format!("{}{}", note, out))
+                            } else {
+                                (false, format!("{}Command failed with exit code {}\nOutput:\n{}", note, code, out))
+                            }
+                        }
+                        Err(e) => (false, format!("{}Failed to execute command: {}", note, e)),
                     }
-                    Err(e) => (false, format!("Failed to execute command: {}", e)),
                 }
-            }
+            },
             ToolCall::ReadFile { path, lines } => {
                 let absolute_path = self.working_dir.join(&path);
                 match self.command_processor.read_file_to_string_with_limit(&absolute_path, lines) {
@@ -593,11 +1796,20 @@ Now, begin.
                     Err(e) => (false, format!("Failed to read file '{}': {}", absolute_path.display(), e)),
                 }
             }
-            ToolCall::WriteFile { path, content, append } => {
-                let absolute_path = self.working_dir.join(&path);
-                match self.command_processor.write_file_to_path(&absolute_path, &content, append) {
-                    Ok(()) => (true, format!("Successfully wrote to {}", absolute_path.display())),
-                    Err(e) => (false, format!("Failed to write file '{}': {}", absolute_path.display(), e)),
+            ToolCall::WriteFile { path, content, append, outside_workspace } => {
+                if let Some(placeholder) = placeholders::find_placeholder(&path, &content) {
+                    (false, placeholders::refusal_message(&path, &placeholder))
+                } else {
+                    match self.write_target(&path, outside_workspace, &tool_call_str).await {
+                        Err(refusal) => (false, refusal),
+                        Ok(absolute_path) => match self.command_processor.write_file_to_path(&absolute_path, &content, append) {
+                            Ok(()) => {
+                                opener::push_target(&mut self.open_targets, absolute_path.display().to_string());
+                                (true, format!("Successfully wrote to {}", absolute_path.display()))
+                            }
+                            Err(e) => (false, format!("Failed to write file '{}': {}", absolute_path.display(), e)),
+                        },
+                    }
                 }
             }
             ToolCall::ListDir { path } => {
@@ -627,7 +1839,9 @@ Now, begin.
                     if !args.is_empty() {
                         cmd.push_str(&format!(" {}", args.join(" ")));
                     }
-                    match self.command_processor.execute_command(&cmd, Some(&self.working_dir)) {
+                    let result = self.command_processor.execute_command(&cmd, Some(&self.working_dir));
+                    exit_code = result.as_ref().ok().map(|(code, _)| *code).filter(|code| *code != -1);
+                    match result {
                         Ok((0, out)) => (true, out),
                         Ok((code, out)) => (false, format!("Script failed with exit code {}\nOutput:\n{}", code, out)),
                         Err(e) => (false, format!("Failed to execute script: {}", e)),
@@ -636,7 +1850,6 @@ Now, begin.
             }
             ToolCall::CreateTool { name, desc, args, script_content } => {
                 let ext = if cfg!(target_os = "windows") { "ps1" } else { "sh" };
-                let tool_path = self.working_dir.join("prime").join(format!("tool_{}.{}", name, ext));
                 let arg_parts: Vec<&str> = args.split_whitespace().collect();
                 let params_str = if cfg!(target_os = "windows") {
                     if arg_parts.is_empty() {
@@ -654,86 +1867,239 @@ Now, begin.
                 };
                 let header = format!("## TOOL: name={} desc=\"{}\" args=\"{}\"\n", name, desc, args);
                 let full_content = format!("{}{}{}", shebang, header, script_content);
-                match self.command_processor.write_file_to_path(&tool_path, &full_content, false) {
-                    Ok(()) => {
-                        #[cfg(unix)]
-                        {
-                            if let Err(e) = fs::set_permissions(&tool_path, fs::Permissions::from_mode(0o755)) {
-                                eprintln!("Warning: Failed to set executable bit: {}", e);
+                // An executable script is a file write like any other, confined to the workspace.
+                match self.write_target(&format!("prime/tool_{}.{}", name, ext), false, &tool_call_str).await {
+                    Err(refusal) => (false, refusal),
+                    Ok(tool_path) => match self.command_processor.write_file_to_path(&tool_path, &full_content, false) {
+                        Ok(()) => {
+                            #[cfg(unix)]
+                            {
+                                use std::os::unix::fs::PermissionsExt;
+                                if let Err(e) = fs::set_permissions(&tool_path, fs::Permissions::from_mode(0o755)) {
+                                    eprintln!("Warning: Failed to set executable bit: {}", e);
+                                }
                             }
+                            self.reload_tools().ok();
+                            opener::push_target(&mut self.open_targets, tool_path.display().to_string());
+                            (true, format!("Created and loaded new tool: {} at {}", name, tool_path.display()))
                         }
-                        self.reload_tools().ok();
-                        (true, format!("Created and loaded new tool: {} at {}", name, tool_path.display()))
-                    }
-                    Err(e) => (false, format!("Failed to create tool '{}': {}", tool_path.display(), e)),
+                        Err(e) => (false, format!("Failed to create tool '{}': {}", tool_path.display(), e)),
+                    },
                 }
             }
         };
-        if !output.trim().is_empty() {
-            for line in output.trim().lines() {
-                println!("{}", format!("│ {}", line).dim());
+        // Failures are summarized in the recovery table instead.
+        if success && !output.trim().is_empty() {
+            match terminal::output_mode() {
+                OutputMode::Full => {
+                    for line in display::wrap_prefixed(output.trim(), "│ ", display::layout_width()) {
+                        println!("{}", line.dim());
+                    }
+                }
+                OutputMode::Quiet { command_results: true } => println!("{}", output.trim()),
+                OutputMode::Quiet { command_results: false } => {}
+            }
+        }
+        let warnings = if success && is_command { outcome::find_warnings(&output) } else { Warnings::default() };
+        if !warnings.is_empty() {
+            notice(format!("│ ⚠ {} {}", warnings.total, tr(Msg::CommandWarnings)).yellow());
+            for line in &warnings.lines {
+                notice(format!("│   {}", line).yellow());
             }
         }
-        ToolExecutionResult { tool_call_str, success, output }
+        if is_command {
+            self.record_usage(UsageEventKind::Command, success);
+        }
+        for url in opener::extract_urls(&output) {
+            opener::push_target(&mut self.open_targets, url);
+        }
+        ToolExecutionResult { tool_call_str, success, output, exit_code, warnings, source }
     }
 
     pub fn format_tool_results_for_llm(&self, results: &[ToolExecutionResult]) -> Result<String> {
         let formatted_results = results.iter().enumerate().map(|(idx, result)| {
-            let status = if result.success { "SUCCESS" } else { "FAILURE" };
-            format!("<tool_output id=\"{}\" for=\"{}\" status=\"{}\">\n{}\n</tool_output>", idx, result.tool_call_str, status, result.output.trim())
+            let status = match (result.success, result.warnings.is_empty()) {
+                (true, true) => "SUCCESS",
+                (true, false) => "SUCCESS_WITH_WARNINGS",
+                (false, _) => "FAILURE",
+            };
+            let output = match (&result.source, result.success) {
+                (Some(source), true) => provenance::labeled(source, result.output.trim()),
+                _ => result.output.trim().to_string(),
+            };
+            format!(
+                "<tool_output id=\"{}\" for=\"{}\" status=\"{}\"{}{}>\n{}\n</tool_output>",
+                idx,
+                result.tool_call_str,
+                status,
+                exit_attribute(result),
+                warnings_attribute(result),
+                output
+            )
         }).collect::<Vec<String>>().join("\n");
-        Ok(formatted_results)
+        let warned: Vec<String> = results
+            .iter()
+            .filter(|result| !result.warnings.is_empty())
+            .flat_map(|result| result.warnings.lines.iter().map(move |line| format!("{}: {}", result.tool_call_str, line)))
+            .collect();
+        if !self.warning_follow_up || warned.is_empty() {
+            return Ok(formatted_results);
+        }
+        Ok(format!(
+            "{}\n<warnings>\n{}\n</warnings>\nThese commands succeeded but printed warnings. Before continuing, say briefly whether any of them need follow-up; if so, include it in your plan, otherwise explain why they can be ignored.",
+            formatted_results,
+            warned.join("\n")
+        ))
     }
 
+    /// The failure table of this recovery followed by the tail of the failing output
     pub fn format_tool_failure_for_llm(&self, result: &ToolExecutionResult) -> Result<String> {
-        let formatted_result = format!("<tool_output for=\"{}\" status=\"FAILURE\">\n{}\n</tool_output>", result.tool_call_str, result.output.trim());
+        let tail = recovery::output_tail(&result.output, recovery::OUTPUT_TAIL_LINES);
+        let formatted_result = format!(
+            "<failures>\n{}\n</failures>\n<tool_output for=\"{}\" status=\"FAILURE\"{}>\n{}\n</tool_output>",
+            recovery::failure_table(&self.recovery_failures),
+            result.tool_call_str,
+            exit_attribute(result),
+            tail
+        );
         Ok(formatted_result)
     }
 
-    pub fn get_history(&self, limit: Option<usize>) -> Result<Vec<ChatMessage>> {
-        let log_content = fs::read_to_string(&self.session_log_path).unwrap_or_default();
-        let mut messages = Vec::new();
-        for section in log_content.split("\n## ").filter(|s| !s.trim().is_empty()) {
-            if let Some((header, content_part)) = section.split_once('\n') {
-                let role = if header.starts_with("User Input") {
-                    Some(ChatRole::User)
-                } else if header.starts_with("Prime Response") {
-                    Some(ChatRole::Assistant)
-                } else if header.starts_with("Tool Results") || header.starts_with("Tool Failure") || header.starts_with("System") {
-                    Some(ChatRole::User)
-                } else {
-                    None
-                };
-                if let Some(role) = role {
-                    let content = content_part.trim_start_matches("```\n").trim_end_matches("\n```").trim().to_string();
-                    if !content.is_empty() {
-                        messages.push(ChatMessageBuilder::new(role).content(content).build());
-                    }
-                }
+    /// Title and content of each log entry that goes into the prompt, numbered from 1 by position
+    fn history_entries(&self) -> Vec<(String, String)> {
+        let log_content = self.fs.read_to_string(&self.session_log_path).unwrap_or_default();
+        let mut entries = Vec::new();
+        // Responses are compacted against earlier ones so restated plans and pleasantries
+        // don't cost context; the log itself keeps the full text.
+        let mut earlier_responses = HashSet::new();
+        for entry in self.log_format().parse(&log_content) {
+            if !matches!(entry.title.as_str(), "User Input" | "Prime Response" | "Tool Results" | "Tool Failure" | "System") {
+                continue;
+            }
+            let content = if entry.title == "Prime Response" {
+                let compacted = chatter::compact_response(&entry.content, &earlier_responses);
+                earlier_responses.extend(chatter::fingerprints(&entry.content));
+                compacted
+            } else {
+                entry.content
+            };
+            if !content.is_empty() {
+                entries.push((entry.title, content));
             }
         }
-        if let Some(limit_val) = limit {
-            if messages.len() > limit_val {
-                let start = messages.len() - limit_val;
-                messages = messages.into_iter().skip(start).collect();
+        entries
+    }
+
+    /// Sections of the next prompt by provenance label, with estimated tokens
+    pub fn context_outline(&mut self) -> Result<Vec<(String, usize)>> {
+        let estimator = self.estimator();
+        let memory = self.memory_cache.get(&self.memory_manager)?.to_string();
+        let memory_sections: Vec<(String, usize)> =
+            provenance::sections(&memory).into_iter().map(|(label, text)| (label.to_string(), estimator.count(&text))).collect();
+        let system_tokens = estimator.count(&self.get_system_prompt()?).saturating_sub(estimator.count(&memory));
+        let mut outline = vec![("system".to_string(), system_tokens)];
+        outline.extend(memory_sections);
+        for message in self.get_history(Some(HISTORY_WINDOW))? {
+            let sections = provenance::sections(&message.content);
+            if sections.is_empty() {
+                outline.push(("response".to_string(), estimator.count(&message.content)));
             }
+            // Labels after the message's own, such as files in tool results, are nested in it.
+            for (i, (label, text)) in sections.into_iter().enumerate() {
+                let label = if i == 0 { label.to_string() } else { format!("  {}", label) };
+                outline.push((label, estimator.count(&text)));
+            }
+        }
+        Ok(outline)
+    }
+
+    pub fn get_history(&self, limit: Option<usize>) -> Result<Vec<ChatMessage>> {
+        let entries = self.history_entries();
+        let start = limit.map_or(0, |limit_val| entries.len().saturating_sub(limit_val));
+        let mut messages = Vec::new();
+        // Pinned messages the window has slid past are restated ahead of it.
+        for &number in self.pinned_messages.iter().filter(|&&n| n >= 1 && n <= start) {
+            let (title, content) = &entries[number - 1];
+            let pinned = format!("[Pinned by the user: message {} ({}) from earlier in this session]\n{}", number, title, content);
+            let pinned = provenance::labeled(&Source::History { number }, &pinned);
+            messages.push(ChatMessageBuilder::new(ChatRole::User).content(pinned).build());
+        }
+        // The model's own responses stay unlabelled so it does not start echoing labels.
+        for (i, (title, content)) in entries.into_iter().enumerate().skip(start) {
+            let message = if title == "Prime Response" {
+                ChatMessageBuilder::new(ChatRole::Assistant).content(content)
+            } else {
+                ChatMessageBuilder::new(ChatRole::User).content(provenance::labeled(&Source::History { number: i + 1 }, &content))
+            };
+            messages.push(message.build());
         }
         Ok(messages)
     }
 
+    /// Numbered one-line previews of the history messages, for choosing what to pin
+    pub fn message_outline(&self) -> Vec<(usize, String, String)> {
+        self.history_entries()
+            .into_iter()
+            .enumerate()
+            .map(|(i, (title, content))| (i + 1, title, preview(&content)))
+            .collect()
+    }
+
+    /// Keeps history message `number` in every prompt; returns its preview
+    pub fn pin_message(&mut self, number: usize) -> Result<String> {
+        let entries = self.history_entries();
+        let (_, content) = number
+            .checked_sub(1)
+            .and_then(|i| entries.get(i))
+            .ok_or_else(|| anyhow!("No message {} (this session has {})", number, entries.len()))?;
+        if !self.pinned_messages.contains(&number) {
+            self.pinned_messages.push(number);
+            self.pinned_messages.sort_unstable();
+        }
+        Ok(preview(content))
+    }
+
+    /// Returns false when `number` was not pinned
+    pub fn unpin_message(&mut self, number: usize) -> bool {
+        let before = self.pinned_messages.len();
+        self.pinned_messages.retain(|&n| n != number);
+        self.pinned_messages.len() != before
+    }
+
+    pub fn pinned_messages(&self) -> Vec<(usize, String, String)> {
+        self.message_outline().into_iter().filter(|(n, _, _)| self.pinned_messages.contains(n)).collect()
+    }
+
     pub fn list_messages(&self) -> Result<String> {
-        fs::read_to_string(&self.session_log_path).context("Could not read session log file.")
+        let log = self.fs.read_to_string(&self.session_log_path).context("Could not read session log file.")?;
+        Ok(evidence::render_transcript(&self.log_format().parse(&log)))
+    }
+
+    /// Writes the annotated transcript to `path`, or to `<session_id>.md` in the working directory
+    pub fn export_transcript(&self, path: Option<&str>) -> Result<PathBuf> {
+        let target = match path {
+            Some(p) => self.working_dir.join(p),
+            None => self.working_dir.join(format!("{}.md", self.session_id)),
+        };
+        let mut transcript = self.list_messages()?;
+        if !self.params.is_empty() {
+            transcript = format!("Parameters: {}\n\n{}", self.params, transcript);
+        }
+        self.fs.write(&target, transcript.as_bytes(), false).with_context(|| format!("Failed to write transcript: {}", target.display()))?;
+        Ok(target)
     }
 
     pub fn read_memory(&self, memory_type: Option<&str>) -> Result<String> {
         self.memory_manager.read_memory(memory_type)
     }
 
-    pub fn write_memory(&self, memory_type: &str, content: &str) -> Result<()> {
+    pub fn write_memory(&mut self, memory_type: &str, content: &str) -> Result<()> {
+        self.memory_cache.invalidate();
         self.memory_manager.write_memory(memory_type, content)
     }
 
-    pub fn clear_memory(&self, memory_type: &str) -> Result<()> {
+    pub fn clear_memory(&mut self, memory_type: &str) -> Result<()> {
+        self.memory_cache.invalidate();
         self.memory_manager.clear_memory(memory_type)
     }
 
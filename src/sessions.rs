@@ -0,0 +1,144 @@
+//! Merging of two session logs
+//! Work on one task sometimes continues on another machine or branch in a separate
+//! session. `prime sessions merge <a> <b>` interleaves both logs by entry timestamp
+//! into a new session, so history, ratings and exports see one conversation. Each
+//! log keeps its own order; message numbers (`!pin msg`) follow the merged order.
+
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Context, Result};
+use chrono::{Duration, Local};
+use crate::terminal::Stylize;
+
+use crate::session::LogEntry;
+use crate::transcript::{self, LogFormat};
+
+#[derive(Debug)]
+pub struct MergeSummary {
+    pub session_id: String,
+    pub path: PathBuf,
+    pub entries: usize,
+}
+
+/// Path of a session given by id (with or without its extension) or by path
+pub fn resolve(conversations_dir: &Path, session: &str) -> Result<PathBuf> {
+    let given = Path::new(session);
+    if given.is_file() {
+        return Ok(given.to_path_buf());
+    }
+    transcript::find_log(conversations_dir, session)
+        .ok_or_else(|| anyhow!("No session '{}' in {}", session, conversations_dir.display()))
+}
+
+pub fn session_id(path: &Path) -> String {
+    path.file_stem().and_then(|stem| stem.to_str()).unwrap_or("session").to_string()
+}
+
+/// What each source session was about, from its first prompt
+fn summary(id: &str, entries: &[LogEntry]) -> String {
+    let first = entries
+        .iter()
+        .find(|entry| entry.title == "User Input")
+        .and_then(|entry| entry.content.lines().next())
+        .unwrap_or("(no prompt)");
+    format!("- {} ({} entries): {}", id, entries.len(), first)
+}
+
+/// Interleaves `a` and `b` by timestamp; on equal timestamps `a` comes first
+pub fn interleave(a: Vec<LogEntry>, b: Vec<LogEntry>) -> Vec<LogEntry> {
+    let mut merged = Vec::with_capacity(a.len() + b.len());
+    let (mut a, mut b) = (a.into_iter().peekable(), b.into_iter().peekable());
+    loop {
+        let take_a = match (a.peek(), b.peek()) {
+            (Some(x), Some(y)) => x.timestamp <= y.timestamp,
+            (Some(_), None) => true,
+            (None, Some(_)) => false,
+            (None, None) => break,
+        };
+        merged.extend(if take_a { a.next() } else { b.next() });
+    }
+    merged
+}
+
+/// Writes the merge of sessions `a` and `b` as a new session in `conversations_dir`
+pub fn merge(conversations_dir: &Path, a: &str, b: &str) -> Result<MergeSummary> {
+    let (path_a, path_b) = (resolve(conversations_dir, a)?, resolve(conversations_dir, b)?);
+    if path_a == path_b {
+        return Err(anyhow!("Cannot merge a session with itself"));
+    }
+    let (entries_a, entries_b) = (transcript::read_entries(&path_a)?, transcript::read_entries(&path_b)?);
+    let (id_a, id_b) = (session_id(&path_a), session_id(&path_b));
+    let header = LogEntry {
+        title: "System".to_string(),
+        timestamp: Local::now().format("%Y-%m-%d %H:%M:%S").to_string(),
+        content: format!(
+            "This session merges two sessions on the same task, interleaved by time:\n{}\n{}",
+            summary(&id_a, &entries_a),
+            summary(&id_b, &entries_b)
+        ),
+    };
+    let merged = interleave(entries_a, entries_b);
+    let entries = merged.len();
+
+    // Session ids carry their start time; step forward past ids already taken. The
+    // merge is stored in the format of the first session.
+    let format = LogFormat::of(&path_a).unwrap_or(LogFormat::Markdown);
+    let mut started = Local::now();
+    let (session_id, path) = loop {
+        let id = format!("session_{}", started.format("%Y%m%d_%H%M%S"));
+        if transcript::find_log(conversations_dir, &id).is_none() {
+            break (id.clone(), conversations_dir.join(format!("{}.{}", id, format.extension())));
+        }
+        started += Duration::seconds(1);
+    };
+    let log: String = std::iter::once(&header).chain(&merged).map(|entry| format.format_entry(entry)).collect();
+    fs::write(&path, log).with_context(|| format!("Failed to write {}", path.display()))?;
+    Ok(MergeSummary { session_id, path, entries })
+}
+
+pub fn run_merge(base_dir: &Path, a: &str, b: &str) -> Result<()> {
+    let summary = merge(&base_dir.join("conversations"), a, b)?;
+    println!(
+        "{}",
+        format!("Merged {} entries into {} ({})", summary.entries, summary.session_id, summary.path.display()).green()
+    );
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entry(title: &str, timestamp: &str, content: &str) -> LogEntry {
+        LogEntry { title: title.to_string(), timestamp: timestamp.to_string(), content: content.to_string() }
+    }
+
+    #[test]
+    fn test_merge_interleaves_by_time() {
+        let dir = std::env::temp_dir().join(format!("prime_sessions_merge_{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        let write = |id: &str, entries: &[LogEntry]| {
+            fs::write(dir.join(format!("{}.md", id)), LogFormat::Markdown.render(entries)).unwrap();
+        };
+        write(
+            "session_20250607_100000",
+            &[entry("User Input", "2025-06-07 10:00:00", "laptop: fix parser"), entry("Prime Response", "2025-06-07 10:05:00", "a2")],
+        );
+        write(
+            "session_20250607_100200",
+            &[entry("User Input", "2025-06-07 10:02:00", "desktop: add tests"), entry("Prime Response", "2025-06-07 10:05:00", "b2")],
+        );
+
+        let summary = merge(&dir, "session_20250607_100000", "session_20250607_100200.md").unwrap();
+        let merged = transcript::read_entries(&summary.path).unwrap();
+        let contents: Vec<&str> = merged.iter().skip(1).map(|e| e.content.as_str()).collect();
+        assert_eq!(contents, vec!["laptop: fix parser", "desktop: add tests", "a2", "b2"]);
+        assert!(merged[0].content.contains("- session_20250607_100200 (2 entries): desktop: add tests"));
+        assert_eq!(summary.entries, 4);
+        assert!(merge(&dir, "session_20250607_100000", "session_20250607_100000").is_err());
+        assert!(merge(&dir, "session_20250607_100000", "missing").is_err());
+        let _ = fs::remove_dir_all(&dir);
+    }
+}
@@ -0,0 +1,262 @@
+//! Workspace snapshots for directories that are not git repositories
+//! Before a turn first executes actions, files under the size limit are copied into a
+//! content-addressed store (~/.prime/snapshots/<session>/objects/<sha256>) and a manifest
+//! records the tree. `!restore-files <turn>` puts those files back and removes files
+//! created since, leaving the workspace as it was before that turn.
+
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Context, Result};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+use crate::clock::SharedClock;
+
+#[derive(Debug, Clone, Copy)]
+pub struct SnapshotLimits {
+    /// Larger files are listed as skipped and left alone by restore
+    pub max_file_bytes: u64,
+    /// Snapshots of bigger trees are refused rather than slowing every turn
+    pub max_files: usize,
+}
+
+impl Default for SnapshotLimits {
+    fn default() -> Self {
+        Self { max_file_bytes: 1_000_000, max_files: 5_000 }
+    }
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+struct FileEntry {
+    hash: String,
+    size: u64,
+}
+
+#[derive(Debug, Serialize, Deserialize)]
+struct Manifest {
+    turn: usize,
+    taken_at: String,
+    root: PathBuf,
+    files: BTreeMap<String, FileEntry>,
+    skipped: Vec<String>,
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub struct SnapshotInfo {
+    pub turn: usize,
+    pub taken_at: String,
+    pub files: usize,
+}
+
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct RestoreSummary {
+    pub restored: usize,
+    pub removed: usize,
+}
+
+/// True when `root` or one of its parents holds a `.git` entry
+pub fn is_git_repo(root: &Path) -> bool {
+    root.ancestors().any(|dir| dir.join(".git").exists())
+}
+
+/// Workspace-relative path with `/` separators, used as the manifest key
+fn relative_key(root: &Path, path: &Path) -> Option<String> {
+    let relative = path.strip_prefix(root).ok()?;
+    Some(relative.components().map(|c| c.as_os_str().to_string_lossy()).collect::<Vec<_>>().join("/"))
+}
+
+/// Files under `root`, skipping symlinks and anything `exclude` rejects
+fn walk(root: &Path, exclude: &dyn Fn(&Path) -> bool) -> Result<Vec<PathBuf>> {
+    let mut files = Vec::new();
+    let mut pending = vec![root.to_path_buf()];
+    while let Some(dir) = pending.pop() {
+        let entries = fs::read_dir(&dir).with_context(|| format!("Failed to read directory: {}", dir.display()))?;
+        for entry in entries.flatten() {
+            let path = entry.path();
+            let Ok(file_type) = entry.file_type() else { continue };
+            if file_type.is_symlink() || exclude(&path) {
+                continue;
+            }
+            if file_type.is_dir() {
+                pending.push(path);
+            } else if file_type.is_file() {
+                files.push(path);
+            }
+        }
+    }
+    files.sort();
+    Ok(files)
+}
+
+fn hash_bytes(bytes: &[u8]) -> String {
+    hex::encode(Sha256::digest(bytes))
+}
+
+#[derive(Debug, Clone)]
+pub struct SnapshotStore {
+    dir: PathBuf,
+    /// Stamps the manifests
+    clock: SharedClock,
+}
+
+impl SnapshotStore {
+    /// Store for one session under `<base_dir>/snapshots/<session_id>`
+    pub fn new(base_dir: &Path, session_id: &str, clock: SharedClock) -> Self {
+        Self { dir: base_dir.join("snapshots").join(session_id), clock }
+    }
+
+    fn object_path(&self, hash: &str) -> PathBuf {
+        self.dir.join("objects").join(&hash[..2]).join(hash)
+    }
+
+    fn manifest_path(&self, turn: usize) -> PathBuf {
+        self.dir.join("turns").join(format!("{:04}.json", turn))
+    }
+
+    /// Records the state of `root` before `turn`; returns the number of files stored
+    pub fn take(&self, turn: usize, root: &Path, exclude: &dyn Fn(&Path) -> bool, limits: SnapshotLimits) -> Result<usize> {
+        let paths = walk(root, exclude)?;
+        if paths.len() > limits.max_files {
+            return Err(anyhow!(
+                "{} has {} files, more than the snapshot limit of {}",
+                root.display(),
+                paths.len(),
+                limits.max_files
+            ));
+        }
+        let mut files = BTreeMap::new();
+        let mut skipped = Vec::new();
+        for path in paths {
+            let Some(key) = relative_key(root, &path) else { continue };
+            let size = fs::metadata(&path).map(|m| m.len()).unwrap_or(u64::MAX);
+            if size > limits.max_file_bytes {
+                skipped.push(key);
+                continue;
+            }
+            let bytes = fs::read(&path).with_context(|| format!("Failed to read {}", path.display()))?;
+            let hash = hash_bytes(&bytes);
+            let object = self.object_path(&hash);
+            if !object.exists() {
+                fs::create_dir_all(object.parent().unwrap())?;
+                fs::write(&object, &bytes).with_context(|| format!("Failed to store snapshot object {}", hash))?;
+            }
+            files.insert(key, FileEntry { hash, size });
+        }
+        let count = files.len();
+        let manifest = Manifest { turn, taken_at: self.clock.now().to_rfc3339(), root: root.to_path_buf(), files, skipped };
+        let path = self.manifest_path(turn);
+        fs::create_dir_all(path.parent().unwrap())?;
+        fs::write(&path, serde_json::to_string(&manifest)?).with_context(|| format!("Failed to write {}", path.display()))?;
+        Ok(count)
+    }
+
+    fn load(&self, turn: usize) -> Result<Manifest> {
+        let path = self.manifest_path(turn);
+        let content = fs::read_to_string(&path).map_err(|_| anyhow!("No file snapshot for turn {}", turn))?;
+        serde_json::from_str(&content).with_context(|| format!("Corrupt snapshot manifest: {}", path.display()))
+    }
+
+    /// Snapshots of this session, oldest first
+    pub fn list(&self) -> Result<Vec<SnapshotInfo>> {
+        let Ok(entries) = fs::read_dir(self.dir.join("turns")) else {
+            return Ok(Vec::new());
+        };
+        let mut infos = Vec::new();
+        for entry in entries.flatten() {
+            let Some(turn) = entry.path().file_stem().and_then(|s| s.to_str()).and_then(|s| s.parse().ok()) else {
+                continue;
+            };
+            let manifest = self.load(turn)?;
+            infos.push(SnapshotInfo { turn, taken_at: manifest.taken_at, files: manifest.files.len() });
+        }
+        infos.sort_by_key(|info| info.turn);
+        Ok(infos)
+    }
+
+    /// Brings the workspace back to the snapshot taken before `turn`: changed and deleted
+    /// files are rewritten, files created since are removed, skipped large files stay
+    pub fn restore(&self, turn: usize, exclude: &dyn Fn(&Path) -> bool) -> Result<RestoreSummary> {
+        let manifest = self.load(turn)?;
+        let root = &manifest.root;
+        let mut summary = RestoreSummary::default();
+        for path in walk(root, exclude)? {
+            let Some(key) = relative_key(root, &path) else { continue };
+            if !manifest.files.contains_key(&key) && !manifest.skipped.contains(&key) {
+                fs::remove_file(&path).with_context(|| format!("Failed to remove {}", path.display()))?;
+                summary.removed += 1;
+            }
+        }
+        for (key, entry) in &manifest.files {
+            let path = root.join(key);
+            let unchanged = fs::read(&path).map(|bytes| hash_bytes(&bytes) == entry.hash).unwrap_or(false);
+            if unchanged {
+                continue;
+            }
+            let bytes = fs::read(self.object_path(&entry.hash))
+                .with_context(|| format!("Snapshot object missing for {}", key))?;
+            if let Some(parent) = path.parent() {
+                fs::create_dir_all(parent)?;
+            }
+            fs::write(&path, bytes).with_context(|| format!("Failed to restore {}", path.display()))?;
+            summary.restored += 1;
+        }
+        Ok(summary)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::clock::{self, FixedClock};
+    use std::sync::Arc;
+
+    fn temp(name: &str) -> PathBuf {
+        let dir = std::env::temp_dir().join(format!("prime_snapshot_{}_{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        dir
+    }
+
+    #[test]
+    fn test_restore_reverts_edits_deletions_and_new_files() {
+        let (root, home) = (temp("ws"), temp("home"));
+        fs::create_dir_all(root.join("src")).unwrap();
+        fs::write(root.join("src/main.rs"), "fn main() {}").unwrap();
+        fs::write(root.join("notes.txt"), "keep").unwrap();
+        fs::write(root.join("big.bin"), vec![0u8; 64]).unwrap();
+        let store = SnapshotStore::new(&home, "session_test", Arc::new(FixedClock::at(2025, 6, 7, 17, 54, 46)));
+        let limits = SnapshotLimits { max_file_bytes: 32, max_files: 10 };
+        let no_exclude = |_: &Path| false;
+        assert_eq!(store.take(1, &root, &no_exclude, limits).unwrap(), 2);
+
+        fs::write(root.join("src/main.rs"), "broken").unwrap();
+        fs::remove_file(root.join("notes.txt")).unwrap();
+        fs::write(root.join("scratch.tmp"), "junk").unwrap();
+        let summary = store.restore(1, &no_exclude).unwrap();
+        assert_eq!(summary, RestoreSummary { restored: 2, removed: 1 });
+        assert_eq!(fs::read_to_string(root.join("src/main.rs")).unwrap(), "fn main() {}");
+        assert_eq!(fs::read_to_string(root.join("notes.txt")).unwrap(), "keep");
+        assert!(!root.join("scratch.tmp").exists());
+        assert!(root.join("big.bin").exists());
+        let info = &store.list().unwrap()[0];
+        assert_eq!((info.turn, &info.taken_at[..19]), (1, "2025-06-07T17:54:46"));
+        let _ = (fs::remove_dir_all(&root), fs::remove_dir_all(&home));
+    }
+
+    #[test]
+    fn test_excluded_paths_and_file_limit() {
+        let (root, home) = (temp("ex"), temp("exhome"));
+        fs::create_dir_all(root.join("target")).unwrap();
+        fs::write(root.join("target/out"), "x").unwrap();
+        fs::write(root.join("a.txt"), "a").unwrap();
+        let store = SnapshotStore::new(&home, "s", clock::system());
+        let exclude = |p: &Path| p.ends_with("target");
+        assert_eq!(store.take(1, &root, &exclude, SnapshotLimits::default()).unwrap(), 1);
+        let tight = SnapshotLimits { max_file_bytes: 10, max_files: 1 };
+        assert!(store.take(2, &root, &|_: &Path| false, tight).is_err());
+        assert!(store.restore(7, &exclude).is_err());
+        let _ = (fs::remove_dir_all(&root), fs::remove_dir_all(&home));
+    }
+}
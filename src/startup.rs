@@ -0,0 +1,63 @@
+//! Startup phases and their cost
+//! Startup reads the config, stamps the data directory, renders the banner and builds
+//! the session before the first prompt goes out; on a network home directory each of
+//! those touches adds up. `prime -p "..." --fast` skips everything a one-shot answer
+//! does not need (banner, init info, model load probes) but still brings an old data
+//! directory up to date. Environment probes are not cached between runs; the fast path
+//! skips them, and a full run defers the git check to the first snapshot. With
+//! PRIME_STARTUP_TIMING set the time spent in each phase is printed to stderr, so the
+//! fast path can be measured against the full one.
+
+use std::time::{Duration, Instant};
+
+/// Environment variable that turns on the timing report
+pub const TIMING_VAR: &str = "PRIME_STARTUP_TIMING";
+
+/// Time spent in each startup phase, in order
+pub struct StartupTimer {
+    started: Instant,
+    last: Instant,
+    phases: Vec<(&'static str, Duration)>,
+}
+
+impl StartupTimer {
+    pub fn start() -> Self {
+        let now = Instant::now();
+        Self { started: now, last: now, phases: Vec::new() }
+    }
+
+    /// Ends the phase called `name`; the next phase starts now
+    pub fn mark(&mut self, name: &'static str) {
+        let now = Instant::now();
+        self.phases.push((name, now - self.last));
+        self.last = now;
+    }
+
+    /// Prints the phases to stderr when PRIME_STARTUP_TIMING is set
+    pub fn report(&self) {
+        if std::env::var_os(TIMING_VAR).is_some_and(|value| !value.is_empty()) {
+            eprintln!("{}", format_phases(&self.phases, self.last - self.started));
+        }
+    }
+}
+
+fn format_phases(phases: &[(&str, Duration)], total: Duration) -> String {
+    let millis = |duration: Duration| format!("{:.1} ms", duration.as_secs_f64() * 1000.0);
+    let mut lines: Vec<String> = phases.iter().map(|(name, duration)| format!("startup {:<10} {:>10}", name, millis(*duration))).collect();
+    lines.push(format!("startup {:<10} {:>10}", "total", millis(total)));
+    lines.join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_phases_are_listed_with_the_total() {
+        let phases = [("config", Duration::from_micros(1500)), ("session", Duration::from_millis(12))];
+        assert_eq!(
+            format_phases(&phases, Duration::from_micros(13500)),
+            "startup config         1.5 ms\nstartup session       12.0 ms\nstartup total         13.5 ms"
+        );
+    }
+}
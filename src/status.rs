@@ -0,0 +1,61 @@
+//! Session state for `!status`: provider health and a running token estimate
+//! Token counts come from the offline estimator in `tokens`, since providers do not
+//! report usage through the chat interface.
+
+use std::time::Duration;
+
+use crate::tokens::Estimator;
+
+/// Outcome of the most recent model request
+#[derive(Debug, Clone, PartialEq, Default)]
+pub enum ProviderHealth {
+    #[default]
+    Unknown,
+    Ok { elapsed: Duration },
+    Failed(String),
+}
+
+/// Estimated tokens exchanged with the model this session
+#[derive(Debug, Clone, Copy, Default, PartialEq)]
+pub struct TokenTally {
+    pub sent: usize,
+    pub received: usize,
+    pub requests: usize,
+}
+
+impl TokenTally {
+    pub fn record_request(&mut self, estimator: &Estimator, prompt: &[&str]) {
+        self.requests += 1;
+        self.sent += prompt.iter().map(|text| estimator.count(text)).sum::<usize>();
+    }
+
+    pub fn record_response(&mut self, estimator: &Estimator, text: &str) {
+        self.received += estimator.count(text);
+    }
+}
+
+/// Aligns `label: value` rows on the longest label
+pub fn format_rows(rows: &[(&str, String)]) -> Vec<String> {
+    let width = rows.iter().map(|(label, _)| label.chars().count()).max().unwrap_or(0);
+    rows.iter().map(|(label, value)| format!(" {:<width$}  {}", label, value, width = width)).collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_tally_accumulates_estimates() {
+        let estimator = Estimator::default();
+        let mut tally = TokenTally::default();
+        tally.record_request(&estimator, &["list the files", "ls -la"]);
+        tally.record_response(&estimator, "Done.");
+        assert_eq!(tally, TokenTally { sent: 6, received: 2, requests: 1 });
+    }
+
+    #[test]
+    fn test_rows_align_on_longest_label() {
+        let rows = format_rows(&[("model", "gemma2".to_string()), ("workspace", "/tmp".to_string())]);
+        assert_eq!(rows, vec![" model      gemma2", " workspace  /tmp"]);
+    }
+}
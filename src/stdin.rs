@@ -0,0 +1,113 @@
+//! Standard input that is not a terminal
+//! Under supervisors, cron and service managers Prime gets a pipe, a file or /dev/null
+//! instead of a terminal, and the line editor is the wrong tool for any of them. Piped
+//! input is read as one prompt or `!command` per line until EOF; a closed stdin (or
+//! /dev/null) has nothing to read, so the session ends at once instead of waiting on
+//! input that will never come. Reads retry interrupted calls and back off on
+//! non-blocking descriptors, so an idle stdin never spins. A stdin that is detached
+//! and later reattached is not followed: the first EOF ends the session.
+//!
+//! Prompts that would wait for an answer (step mode, `!pr` confirmations, the stalled
+//! model prompt) ask `answers_prompts` first and do not read from a piped stdin, where
+//! the next line is a prompt rather than a reply; they stop, decline or cancel instead,
+//! and plan approval goes through the headless strategy.
+
+use std::io::{self, BufRead, IsTerminal};
+use std::time::Duration;
+
+/// Pause before retrying a read on a non-blocking stdin with nothing to read
+const WOULD_BLOCK_BACKOFF: Duration = Duration::from_millis(200);
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum InputSource {
+    /// Interactive terminal; the REPL runs
+    Terminal,
+    /// Pipe or file; prompts are read line by line
+    Stream,
+    /// Closed descriptor or /dev/null; there is no input at all
+    Closed,
+}
+
+impl InputSource {
+    pub fn detect() -> Self {
+        if io::stdin().is_terminal() {
+            InputSource::Terminal
+        } else if stdin_is_closed() {
+            InputSource::Closed
+        } else {
+            InputSource::Stream
+        }
+    }
+}
+
+/// Whether a prompt may wait for a typed answer on stdin
+pub fn answers_prompts() -> bool {
+    InputSource::detect() == InputSource::Terminal
+}
+
+#[cfg(unix)]
+fn stdin_is_closed() -> bool {
+    use std::os::unix::fs::MetadataExt;
+    // /dev/stdin resolves to whatever descriptor 0 refers to, and fails when it is closed.
+    match (std::fs::metadata("/dev/stdin"), std::fs::metadata("/dev/null")) {
+        (Err(_), _) => true,
+        (Ok(stdin), Ok(null)) => stdin.dev() == null.dev() && stdin.ino() == null.ino(),
+        (Ok(_), Err(_)) => false,
+    }
+}
+
+#[cfg(not(unix))]
+fn stdin_is_closed() -> bool {
+    false
+}
+
+/// Next non-empty line, trimmed; `None` at EOF
+pub fn next_line(reader: &mut impl BufRead) -> io::Result<Option<String>> {
+    let mut line = String::new();
+    loop {
+        line.clear();
+        match reader.read_line(&mut line) {
+            Ok(0) => return Ok(None),
+            Ok(_) if line.trim().is_empty() => continue,
+            Ok(_) => return Ok(Some(line.trim().to_string())),
+            Err(e) if e.kind() == io::ErrorKind::Interrupted => continue,
+            Err(e) if e.kind() == io::ErrorKind::WouldBlock => std::thread::sleep(WOULD_BLOCK_BACKOFF),
+            Err(e) => return Err(e),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Fails reads with the given errors before serving the text
+    struct Flaky<'a> {
+        errors: Vec<io::ErrorKind>,
+        inner: io::Cursor<&'a str>,
+    }
+
+    impl io::Read for Flaky<'_> {
+        fn read(&mut self, buf: &mut [u8]) -> io::Result<usize> {
+            match self.errors.pop() {
+                Some(kind) => Err(io::Error::from(kind)),
+                None => self.inner.read(buf),
+            }
+        }
+    }
+
+    #[test]
+    fn test_lines_skip_blanks_and_survive_interrupts() {
+        let flaky = Flaky {
+            errors: vec![io::ErrorKind::WouldBlock, io::ErrorKind::Interrupted],
+            inner: io::Cursor::new("\n  list files \n\n!status\n"),
+        };
+        let mut reader = io::BufReader::new(flaky);
+        assert_eq!(next_line(&mut reader).unwrap().as_deref(), Some("list files"));
+        assert_eq!(next_line(&mut reader).unwrap().as_deref(), Some("!status"));
+        assert_eq!(next_line(&mut reader).unwrap(), None);
+
+        let broken = Flaky { errors: vec![io::ErrorKind::BrokenPipe], inner: io::Cursor::new("") };
+        assert!(next_line(&mut io::BufReader::new(broken)).is_err());
+    }
+}
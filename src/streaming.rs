@@ -1,8 +1,11 @@
 //! Streaming response handler for real-time LLM output
 //! Provides intelligent buffering for tool detection while maintaining simple protocol
 
+use std::io::{self, Write};
 use std::time::{Duration, Instant};
 
+use textwrap::core::display_width;
+
 /// Token received from streaming LLM response
 #[derive(Debug, Clone)]
 pub enum StreamToken {
@@ -14,9 +17,20 @@ pub enum StreamToken {
     Done,
 }
 
-/// Streaming response handler with intelligent buffering
+/// Streaming response handler with intelligent buffering.
+/// Fences are only recognised at the start of a line, so chunk boundaries that split
+/// a fence or its language tag do not leak `primeactions` content into the display.
 pub struct StreamHandler {
+    /// Text ready for display but not yet flushed
     buffer: String,
+    /// Current incomplete line, held back while it could still turn into a fence
+    line: String,
+    /// True once the current line is known to be plain text
+    line_is_text: bool,
+    /// True after a fence closed before its line ended; the rest of that line is discarded
+    skip_line: bool,
+    /// Contents of the primeactions block being collected
+    block: String,
     in_code_block: bool,
     code_block_lang: Option<String>,
     last_flush: Instant,
@@ -27,6 +41,10 @@ impl StreamHandler {
     pub fn new() -> Self {
         Self {
             buffer: String::new(),
+            line: String::new(),
+            line_is_text: false,
+            skip_line: false,
+            block: String::new(),
             in_code_block: false,
             code_block_lang: None,
             last_flush: Instant::now(),
@@ -34,74 +52,111 @@ impl StreamHandler {
         }
     }
 
+    fn in_primeactions(&self) -> bool {
+        self.in_code_block && self.code_block_lang.as_deref() == Some("primeactions")
+    }
+
     /// Process incoming token and determine if it should be displayed or buffered
     pub fn process_token(&mut self, token: &str) -> Vec<StreamToken> {
         let mut output = Vec::new();
-        self.buffer.push_str(token);
-
-        // Check for code block markers
-        if self.buffer.contains("```") {
-            if let Some(idx) = self.buffer.rfind("```") {
-                let before = &self.buffer[..idx];
-                let after = &self.buffer[idx..];
-                
-                if !self.in_code_block {
-                    // Starting code block
-                    if let Some(newline_idx) = after.find('\n') {
-                        let lang = after[3..newline_idx].trim().to_string();
-                        self.code_block_lang = Some(lang.clone());
-                        self.in_code_block = true;
-                        
-                        // Check if this is a primeactions block
-                        if lang == "primeactions" {
-                            // Buffer the entire block for tool parsing
-                            return output;
-                        }
-                        
-                        // Flush everything before the code block
-                        if !before.is_empty() {
-                            output.push(StreamToken::Text(before.to_string()));
-                        }
-                        output.push(StreamToken::Text(after[..=newline_idx].to_string()));
-                        self.buffer = after[newline_idx + 1..].to_string();
-                    }
-                } else {
-                    // Ending code block
-                    self.in_code_block = false;
-                    
-                    // If it was a primeactions block, emit as tool call
-                    if self.code_block_lang.as_deref() == Some("primeactions") {
-                        output.push(StreamToken::ToolCall(before.to_string()));
-                        self.buffer.clear();
-                        self.code_block_lang = None;
-                        return output;
-                    }
-                    
-                    // Regular code block - flush it
-                    output.push(StreamToken::Text(self.buffer.clone()));
-                    self.buffer.clear();
-                    self.code_block_lang = None;
+
+        // Flush what earlier tokens buffered once the display interval has passed
+        if self.last_flush.elapsed() >= self.flush_interval {
+            self.flush_text(&mut output);
+        }
+
+        for ch in token.chars() {
+            if self.skip_line {
+                if ch == '\n' {
+                    self.skip_line = false;
+                }
+                continue;
+            }
+            if self.line_is_text {
+                self.buffer.push(ch);
+                if ch == '\n' {
+                    self.line_is_text = false;
+                }
+                continue;
+            }
+            self.line.push(ch);
+            if ch == '\n' {
+                let line = std::mem::take(&mut self.line);
+                self.handle_line(&line, &mut output);
+            } else if !self.in_primeactions() {
+                let trimmed = self.line.trim_start();
+                if !trimmed.is_empty() && !"```".starts_with(trimmed) && !trimmed.starts_with("```") {
+                    // Cannot become a fence any more; show it without waiting for the newline
+                    self.buffer.push_str(&std::mem::take(&mut self.line));
+                    self.line_is_text = true;
                 }
             }
         }
 
-        // Flush buffer periodically for smooth display (but not during primeactions)
-        if !self.in_code_block || self.code_block_lang.as_deref() != Some("primeactions") {
-            if self.last_flush.elapsed() >= self.flush_interval && !self.buffer.is_empty() {
-                output.push(StreamToken::Text(self.buffer.clone()));
-                self.buffer.clear();
-                self.last_flush = Instant::now();
-            }
+        // A bare closing fence does not need to wait for its newline
+        if self.in_code_block && self.line.trim() == "```" {
+            let line = std::mem::take(&mut self.line);
+            self.handle_line(&line, &mut output);
+            self.skip_line = true;
         }
 
         output
     }
 
+    fn handle_line(&mut self, line: &str, output: &mut Vec<StreamToken>) {
+        let trimmed = line.trim();
+        if !self.in_code_block {
+            if let Some(lang) = trimmed.strip_prefix("```") {
+                self.in_code_block = true;
+                self.code_block_lang = Some(lang.trim().to_string());
+                if self.in_primeactions() {
+                    // Buffer the entire block for tool parsing
+                    self.flush_text(output);
+                    return;
+                }
+            }
+            self.buffer.push_str(line);
+            return;
+        }
+
+        if trimmed.starts_with("```") && trimmed.trim_start_matches('`').is_empty() {
+            if self.in_primeactions() {
+                output.push(StreamToken::ToolCall(std::mem::take(&mut self.block)));
+            } else {
+                // Regular code block - flush it
+                self.buffer.push_str(line);
+                self.flush_text(output);
+            }
+            self.in_code_block = false;
+            self.code_block_lang = None;
+            return;
+        }
+
+        if self.in_primeactions() {
+            self.block.push_str(line);
+        } else {
+            self.buffer.push_str(line);
+        }
+    }
+
+    fn flush_text(&mut self, output: &mut Vec<StreamToken>) {
+        if !self.buffer.is_empty() {
+            output.push(StreamToken::Text(std::mem::take(&mut self.buffer)));
+        }
+        self.last_flush = Instant::now();
+    }
+
     /// Flush any remaining buffered content
     pub fn flush(&mut self) -> Option<StreamToken> {
-        if !self.buffer.is_empty() {
-            let content = self.buffer.clone();
-            self.buffer.clear();
+        if self.in_primeactions() {
+            // Unterminated block: hand it to the parser rather than the display
+            let mut block = std::mem::take(&mut self.block);
+            block.push_str(&std::mem::take(&mut self.line));
+            return Some(StreamToken::ToolCall(block));
+        }
+        let mut content = std::mem::take(&mut self.buffer);
+        content.push_str(&std::mem::take(&mut self.line));
+        if !content.is_empty() {
             Some(StreamToken::Text(content))
         } else {
             None
@@ -115,6 +170,160 @@ impl Default for StreamHandler {
     }
 }
 
+/// Append-only, wrap-aware printer for streamed text.
+/// Never moves the cursor backwards: words are buffered until complete and then
+/// placed on the current line or wrapped onto a new one, so chunk boundaries,
+/// embedded newlines and long lines cannot corrupt earlier output.
+pub struct StreamPrinter<W: Write> {
+    out: W,
+    width: usize,
+    prefix: String,
+    column: usize,
+    word: String,
+    /// Spaces seen since the last word; written only once the next word is placed
+    pending_spaces: usize,
+    /// Set when the current line was started by wrapping, so leading spaces are dropped
+    wrapped: bool,
+    typewriter_delay: Option<Duration>,
+}
+
+impl<W: Write> StreamPrinter<W> {
+    pub fn new(out: W, width: usize) -> Self {
+        Self {
+            out,
+            width,
+            prefix: String::new(),
+            column: 0,
+            word: String::new(),
+            pending_spaces: 0,
+            wrapped: false,
+            typewriter_delay: None,
+        }
+    }
+
+    /// Text printed at the start of every output line (e.g. "┃")
+    pub fn with_prefix(mut self, prefix: impl Into<String>) -> Self {
+        self.prefix = prefix.into();
+        self
+    }
+
+    /// Paces output to roughly `chars_per_second`; 0 disables the limiter
+    pub fn with_typewriter(mut self, chars_per_second: u32) -> Self {
+        self.typewriter_delay = (chars_per_second > 0)
+            .then(|| Duration::from_secs_f64(1.0 / chars_per_second as f64));
+        self
+    }
+
+    fn available(&self) -> usize {
+        self.width.saturating_sub(display_width(&self.prefix)).max(1)
+    }
+
+    pub fn write_chunk(&mut self, chunk: &str) -> io::Result<()> {
+        for ch in chunk.chars() {
+            match ch {
+                // Carriage returns would rewind over already printed text
+                '\r' => {}
+                '\n' => {
+                    self.flush_word()?;
+                    self.start_line_if_needed()?;
+                    self.newline(false)?;
+                }
+                '\t' => {
+                    self.flush_word()?;
+                    self.add_spaces(4);
+                }
+                c if c.is_whitespace() => {
+                    self.flush_word()?;
+                    self.add_spaces(1);
+                }
+                c => self.word.push(c),
+            }
+        }
+        self.out.flush()
+    }
+
+    /// Writes any pending word and terminates the current line
+    pub fn finish(&mut self) -> io::Result<()> {
+        self.flush_word()?;
+        if self.column > 0 {
+            self.newline(false)?;
+        }
+        self.out.flush()
+    }
+
+    fn newline(&mut self, wrapped: bool) -> io::Result<()> {
+        self.out.write_all(b"\n")?;
+        self.column = 0;
+        self.pending_spaces = 0;
+        self.wrapped = wrapped;
+        Ok(())
+    }
+
+    fn start_line_if_needed(&mut self) -> io::Result<()> {
+        if self.column == 0 && !self.prefix.is_empty() {
+            self.out.write_all(self.prefix.as_bytes())?;
+        }
+        Ok(())
+    }
+
+    fn add_spaces(&mut self, count: usize) {
+        // Spaces that only separated a wrapped word from the previous line are dropped
+        if !(self.column == 0 && self.wrapped) {
+            self.pending_spaces += count;
+        }
+    }
+
+    fn flush_word(&mut self) -> io::Result<()> {
+        if self.word.is_empty() {
+            return Ok(());
+        }
+        let word = std::mem::take(&mut self.word);
+        let available = self.available();
+        let word_width = display_width(&word);
+        if self.column > 0 && self.column + self.pending_spaces + word_width > available {
+            self.newline(true)?;
+        }
+        if self.pending_spaces > 0 {
+            let spaces = self.pending_spaces.min(available.saturating_sub(self.column));
+            self.pending_spaces = 0;
+            self.start_line_if_needed()?;
+            self.emit(&" ".repeat(spaces))?;
+            self.column += spaces;
+        }
+        if self.column + word_width <= available {
+            self.start_line_if_needed()?;
+            self.emit(&word)?;
+            self.column += word_width;
+            return Ok(());
+        }
+        // Longer than a full line (URLs, hashes): hard-break it
+        for ch in word.chars() {
+            let ch_width = display_width(ch.encode_utf8(&mut [0u8; 4]));
+            if self.column > 0 && self.column + ch_width > available {
+                self.newline(true)?;
+            }
+            self.start_line_if_needed()?;
+            self.emit(ch.encode_utf8(&mut [0u8; 4]))?;
+            self.column += ch_width;
+        }
+        Ok(())
+    }
+
+    fn emit(&mut self, text: &str) -> io::Result<()> {
+        match self.typewriter_delay {
+            Some(delay) => {
+                for ch in text.chars() {
+                    self.out.write_all(ch.encode_utf8(&mut [0u8; 4]).as_bytes())?;
+                    self.out.flush()?;
+                    std::thread::sleep(delay);
+                }
+                Ok(())
+            }
+            None => self.out.write_all(text.as_bytes()),
+        }
+    }
+}
+
 #[cfg(test)]
 mod tests {
     use super::*;
@@ -122,14 +331,14 @@ mod tests {
     #[test]
     fn test_regular_text_streaming() {
         let mut handler = StreamHandler::new();
-        
+
         let tokens = handler.process_token("Hello ");
         assert!(tokens.is_empty()); // Buffered
-        
+
         std::thread::sleep(Duration::from_millis(60));
         let tokens = handler.process_token("world");
         assert_eq!(tokens.len(), 1);
-        
+
         if let StreamToken::Text(text) = &tokens[0] {
             assert_eq!(text, "Hello ");
         }
@@ -138,11 +347,11 @@ mod tests {
     #[test]
     fn test_primeactions_buffering() {
         let mut handler = StreamHandler::new();
-        
+
         handler.process_token("```primeactions\n");
         handler.process_token("shell: ls\n");
         let tokens = handler.process_token("```");
-        
+
         assert_eq!(tokens.len(), 1);
         if let StreamToken::ToolCall(content) = &tokens[0] {
             assert!(content.contains("shell: ls"));
@@ -152,12 +361,79 @@ mod tests {
     #[test]
     fn test_regular_code_block() {
         let mut handler = StreamHandler::new();
-        
+
         handler.process_token("```python\n");
         handler.process_token("print('hello')\n");
         let tokens = handler.process_token("```");
-        
+
         // Regular code blocks are flushed as text
         assert!(tokens.iter().any(|t| matches!(t, StreamToken::Text(_))));
     }
+
+    fn collect_text(chunks: &[&str]) -> (String, Vec<String>) {
+        let mut handler = StreamHandler::new();
+        let mut text = String::new();
+        let mut tool_calls = Vec::new();
+        let mut tokens = Vec::new();
+        for chunk in chunks {
+            tokens.extend(handler.process_token(chunk));
+        }
+        tokens.extend(handler.flush());
+        for token in tokens {
+            match token {
+                StreamToken::Text(t) => text.push_str(&t),
+                StreamToken::ToolCall(t) => tool_calls.push(t),
+                StreamToken::Done => {}
+            }
+        }
+        (text, tool_calls)
+    }
+
+    #[test]
+    fn test_fence_split_across_chunks_is_hidden() {
+        let (text, tool_calls) = collect_text(&["I will list files.\n`", "``prime", "actions\nshell: l", "s\n``", "`\nDone."]);
+        assert_eq!(text, "I will list files.\nDone.");
+        assert_eq!(tool_calls, vec!["shell: ls\n".to_string()]);
+    }
+
+    fn print_chunks(chunks: &[&str], width: usize, prefix: &str) -> String {
+        let mut out = Vec::new();
+        {
+            let mut printer = StreamPrinter::new(&mut out, width).with_prefix(prefix);
+            for chunk in chunks {
+                printer.write_chunk(chunk).unwrap();
+            }
+            printer.finish().unwrap();
+        }
+        String::from_utf8(out).unwrap()
+    }
+
+    #[test]
+    fn test_printer_wraps_to_width_without_rewinds() {
+        let text = "The quick brown fox jumps over the lazy dog and keeps running far away";
+        let output = print_chunks(&[text], 20, "┃");
+        assert!(!output.contains('\r'));
+        for line in output.lines() {
+            assert!(display_width(line) <= 20, "line too wide: {:?}", line);
+            assert!(line.starts_with('┃'));
+        }
+        assert_eq!(output.lines().map(|l| l.trim_start_matches('┃')).collect::<Vec<_>>().join(" "), text);
+    }
+
+    #[test]
+    fn test_printer_is_independent_of_chunk_boundaries() {
+        let text = "Line one has words.\nLine two\r\nhas a verylongunbreakabletokenthatexceedswidth end";
+        let whole = print_chunks(&[text], 16, "");
+        let chars: Vec<String> = text.chars().map(|c| c.to_string()).collect();
+        let pieces: Vec<&str> = chars.iter().map(|s| s.as_str()).collect();
+        assert_eq!(print_chunks(&pieces, 16, ""), whole);
+        assert!(whole.lines().all(|l| display_width(l) <= 16));
+        assert!(whole.starts_with("Line one has\nwords.\nLine two\n"));
+    }
+
+    #[test]
+    fn test_printer_keeps_indentation_after_newline() {
+        let output = print_chunks(&["fn main() {\n    run();\n}"], 40, "");
+        assert_eq!(output, "fn main() {\n    run();\n}\n");
+    }
 }
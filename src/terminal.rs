@@ -0,0 +1,268 @@
+//! Console capabilities and color mode
+//! Windows consoles only interpret ANSI escapes once virtual terminal processing is
+//! switched on, and older ones (legacy conhost, Windows 8 and earlier) cannot switch it
+//! on at all, so escapes print literally. Output falls back to plain text there, when
+//! NO_COLOR is set, on TERM=dumb, or when the `color` setting asks for it. Cursor and
+//! screen control goes through crossterm commands, which use the console API when
+//! escapes are unavailable.
+//!
+//! `--quiet` switches the output mode so stdout carries only the model's prose (and,
+//! when asked, command results); decoration is dropped and prompts move to stderr.
+//!
+//! Text is styled through this module's `Stylize` rather than crossterm's: crossterm
+//! still writes attribute and reset sequences with colors disabled, while `Styled`
+//! prints the bare content in plain mode.
+
+use std::fmt::{self, Display};
+use std::sync::atomic::{AtomicBool, AtomicU8, Ordering};
+
+use anyhow::{anyhow, Result};
+use crossterm::style::{Attribute, Color, ContentStyle, StyledContent};
+
+static PLAIN: AtomicBool = AtomicBool::new(false);
+static OUTPUT: AtomicU8 = AtomicU8::new(0);
+
+/// The `color` setting in config.toml
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum ColorSetting {
+    Auto,
+    Always,
+    Never,
+}
+
+impl ColorSetting {
+    pub fn from_config(value: &str) -> Result<Self> {
+        match value.trim().to_lowercase().as_str() {
+            "auto" | "" => Ok(Self::Auto),
+            "always" | "on" => Ok(Self::Always),
+            "never" | "off" | "plain" => Ok(Self::Never),
+            other => Err(anyhow!("Unknown color setting '{}' (use auto, always or never)", other)),
+        }
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+pub enum ColorMode {
+    Ansi,
+    /// No escapes; the reason is shown when it is not the user's own choice
+    Plain { reason: Option<&'static str> },
+}
+
+/// What color detection needs from the console, so it can be exercised without one
+pub trait Console {
+    fn var(&self, name: &str) -> Option<String>;
+    /// Makes the console interpret ANSI escapes; false when it cannot
+    fn enable_vt_processing(&self) -> bool;
+}
+
+/// The console of this process
+pub struct SystemConsole;
+
+impl Console for SystemConsole {
+    fn var(&self, name: &str) -> Option<String> {
+        std::env::var(name).ok()
+    }
+
+    #[cfg(windows)]
+    fn enable_vt_processing(&self) -> bool {
+        // Sets ENABLE_VIRTUAL_TERMINAL_PROCESSING on the output handle, and accepts
+        // terminals such as mintty that set TERM but have no console handle.
+        crossterm::ansi_support::supports_ansi()
+    }
+
+    #[cfg(not(windows))]
+    fn enable_vt_processing(&self) -> bool {
+        true
+    }
+}
+
+pub fn detect(setting: ColorSetting, console: &dyn Console) -> ColorMode {
+    match setting {
+        ColorSetting::Never => ColorMode::Plain { reason: None },
+        ColorSetting::Always => {
+            console.enable_vt_processing();
+            ColorMode::Ansi
+        }
+        ColorSetting::Auto => {
+            if console.var("NO_COLOR").is_some_and(|value| !value.is_empty()) {
+                ColorMode::Plain { reason: None }
+            } else if console.var("TERM").as_deref() == Some("dumb") {
+                ColorMode::Plain { reason: None }
+            } else if !console.enable_vt_processing() {
+                ColorMode::Plain { reason: Some("This console does not support ANSI escapes; using plain output.") }
+            } else {
+                ColorMode::Ansi
+            }
+        }
+    }
+}
+
+/// Detects the color mode for this process and applies it to all styled output
+pub fn init(setting: ColorSetting) -> ColorMode {
+    let mode = detect(setting, &SystemConsole);
+    PLAIN.store(matches!(mode, ColorMode::Plain { .. }), Ordering::SeqCst);
+    mode
+}
+
+/// True when styled output is turned off
+pub fn is_plain() -> bool {
+    PLAIN.load(Ordering::SeqCst)
+}
+
+/// What goes to stdout
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum OutputMode {
+    /// Banner, boxes, spinners, action previews and tool output
+    Full,
+    /// The model's prose only, plus command results when `command_results` is set
+    Quiet { command_results: bool },
+}
+
+pub fn set_output_mode(mode: OutputMode) {
+    let code = match mode {
+        OutputMode::Full => 0,
+        OutputMode::Quiet { command_results: false } => 1,
+        OutputMode::Quiet { command_results: true } => 2,
+    };
+    OUTPUT.store(code, Ordering::SeqCst);
+}
+
+pub fn output_mode() -> OutputMode {
+    match OUTPUT.load(Ordering::SeqCst) {
+        0 => OutputMode::Full,
+        code => OutputMode::Quiet { command_results: code == 2 },
+    }
+}
+
+pub fn is_quiet() -> bool {
+    output_mode() != OutputMode::Full
+}
+
+/// Content with a style that is applied only while output is not plain
+pub struct Styled<D: Display> {
+    content: D,
+    style: ContentStyle,
+}
+
+macro_rules! colors {
+    ($($name:ident => $field:ident: $color:expr),* $(,)?) => {
+        $(
+            pub fn $name(mut self) -> Self {
+                self.style.$field = Some($color);
+                self
+            }
+        )*
+    };
+}
+
+macro_rules! attributes {
+    ($($name:ident => $attribute:expr),* $(,)?) => {
+        $(
+            pub fn $name(mut self) -> Self {
+                self.style.attributes.set($attribute);
+                self
+            }
+        )*
+    };
+}
+
+impl<D: Display> Styled<D> {
+    pub fn new(content: D) -> Self {
+        Self { content, style: ContentStyle::new() }
+    }
+
+    colors! {
+        red => foreground_color: Color::Red,
+        green => foreground_color: Color::Green,
+        yellow => foreground_color: Color::Yellow,
+        cyan => foreground_color: Color::Cyan,
+        white => foreground_color: Color::White,
+        black => foreground_color: Color::Black,
+        dark_grey => foreground_color: Color::DarkGrey,
+        on_white => background_color: Color::White,
+    }
+
+    attributes! {
+        bold => Attribute::Bold,
+        dim => Attribute::Dim,
+    }
+}
+
+impl<D: Display> Display for Styled<D> {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        if is_plain() {
+            self.content.fmt(f)
+        } else {
+            StyledContent::new(self.style, &self.content).fmt(f)
+        }
+    }
+}
+
+/// Styling methods for anything printable; chained calls continue on `Styled`'s own methods
+pub trait Stylize: Display + Sized {
+    fn red(self) -> Styled<Self> { Styled::new(self).red() }
+    fn green(self) -> Styled<Self> { Styled::new(self).green() }
+    fn yellow(self) -> Styled<Self> { Styled::new(self).yellow() }
+    fn cyan(self) -> Styled<Self> { Styled::new(self).cyan() }
+    fn white(self) -> Styled<Self> { Styled::new(self).white() }
+    fn dark_grey(self) -> Styled<Self> { Styled::new(self).dark_grey() }
+    fn on_white(self) -> Styled<Self> { Styled::new(self).on_white() }
+    fn bold(self) -> Styled<Self> { Styled::new(self).bold() }
+    fn dim(self) -> Styled<Self> { Styled::new(self).dim() }
+}
+
+impl<D: Display> Stylize for D {}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+
+    struct FakeConsole {
+        vars: HashMap<&'static str, &'static str>,
+        vt: bool,
+        vt_requested: std::cell::Cell<bool>,
+    }
+
+    fn console(vars: &[(&'static str, &'static str)], vt: bool) -> FakeConsole {
+        FakeConsole { vars: vars.iter().copied().collect(), vt, vt_requested: std::cell::Cell::new(false) }
+    }
+
+    impl Console for FakeConsole {
+        fn var(&self, name: &str) -> Option<String> {
+            self.vars.get(name).map(|value| value.to_string())
+        }
+
+        fn enable_vt_processing(&self) -> bool {
+            self.vt_requested.set(true);
+            self.vt
+        }
+    }
+
+    #[test]
+    fn test_legacy_console_falls_back_to_plain() {
+        let legacy = console(&[], false);
+        assert!(matches!(detect(ColorSetting::Auto, &legacy), ColorMode::Plain { reason: Some(_) }));
+        assert!(legacy.vt_requested.get());
+        assert_eq!(detect(ColorSetting::Auto, &console(&[("TERM", "xterm-256color")], true)), ColorMode::Ansi);
+    }
+
+    #[test]
+    fn test_styles_chain_onto_one_style() {
+        let styled = "ok".white().bold();
+        assert_eq!(styled.style.foreground_color, Some(Color::White));
+        assert!(styled.style.attributes.has(Attribute::Bold));
+        assert_eq!(StyledContent::new(styled.style, "ok").to_string(), "\x1b[38;5;15m\x1b[1mok\x1b[0m");
+    }
+
+    #[test]
+    fn test_environment_and_setting_overrides() {
+        assert_eq!(detect(ColorSetting::Auto, &console(&[("NO_COLOR", "1")], true)), ColorMode::Plain { reason: None });
+        assert_eq!(detect(ColorSetting::Auto, &console(&[("NO_COLOR", "")], true)), ColorMode::Ansi);
+        assert_eq!(detect(ColorSetting::Auto, &console(&[("TERM", "dumb")], true)), ColorMode::Plain { reason: None });
+        assert_eq!(detect(ColorSetting::Always, &console(&[("NO_COLOR", "1")], false)), ColorMode::Ansi);
+        assert_eq!(detect(ColorSetting::Never, &console(&[], true)), ColorMode::Plain { reason: None });
+        assert_eq!(ColorSetting::from_config("OFF").unwrap(), ColorSetting::Never);
+        assert!(ColorSetting::from_config("sometimes").is_err());
+    }
+}
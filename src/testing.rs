@@ -0,0 +1,318 @@
+//! End-to-end turn tests against a fake LLM server
+//! The server speaks Ollama's /api/chat and replays recorded NDJSON streams from
+//! tests/fixtures/<scenario>/ in order, one file per request. Streaming requests get the
+//! lines as recorded; non-streaming ones get the chunks joined into a single reply.
+//! Each test drives real turns through PrimeSession and compares the requests the model
+//! saw plus the session log with a golden file in tests/snapshots/. Run with
+//! UPDATE_SNAPSHOTS=1 to re-record after an intended change.
+
+use std::fs;
+use std::io::{BufRead, BufReader, Read, Write};
+use std::net::{TcpListener, TcpStream};
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::thread;
+use std::time::Duration;
+
+use anyhow::{anyhow, Context, Result};
+use llm::builder::{LLMBackend, LLMBuilder};
+use serde_json::{json, Value};
+
+use crate::clock::FixedClock;
+use crate::session::{parse_log_entries, PrimeSession, TurnOutcome};
+use crate::scratch::TurnScratch;
+use crate::vfs;
+
+fn manifest_dir() -> PathBuf {
+    PathBuf::from(env!("CARGO_MANIFEST_DIR"))
+}
+
+/// Recorded responses of a scenario, in request order
+fn load_fixtures(scenario: &str) -> Result<Vec<String>> {
+    let dir = manifest_dir().join("tests/fixtures").join(scenario);
+    let mut files: Vec<PathBuf> = fs::read_dir(&dir)
+        .with_context(|| format!("Missing fixtures: {}", dir.display()))?
+        .filter_map(|entry| entry.ok().map(|e| e.path()))
+        .filter(|path| path.extension().map_or(false, |ext| ext == "ndjson"))
+        .collect();
+    files.sort();
+    files.iter().map(|path| fs::read_to_string(path).with_context(|| format!("Failed to read {}", path.display()))).collect()
+}
+
+/// Joins the `message.content` of every chunk into one non-streaming reply
+fn collapse_stream(ndjson: &str) -> Value {
+    let content: String = ndjson
+        .lines()
+        .filter_map(|line| serde_json::from_str::<Value>(line).ok())
+        .filter_map(|chunk| chunk["message"]["content"].as_str().map(str::to_string))
+        .collect();
+    json!({ "model": "fake", "message": { "role": "assistant", "content": content }, "done": true })
+}
+
+pub struct FakeLlmServer {
+    url: String,
+    requests: Arc<Mutex<Vec<Value>>>,
+}
+
+impl FakeLlmServer {
+    /// Serves `responses` in order on a free local port; later requests get HTTP 500
+    pub fn start(responses: Vec<String>) -> Result<Self> {
+        let listener = TcpListener::bind("127.0.0.1:0").context("Failed to bind fake LLM server")?;
+        let url = format!("http://{}", listener.local_addr()?);
+        let requests = Arc::new(Mutex::new(Vec::new()));
+        let recorded = Arc::clone(&requests);
+        thread::spawn(move || {
+            let mut queue = responses.into_iter();
+            for stream in listener.incoming().flatten() {
+                if let Err(e) = serve(stream, &mut queue, &recorded) {
+                    eprintln!("fake LLM server: {}", e);
+                }
+            }
+        });
+        Ok(Self { url, requests })
+    }
+
+    pub fn url(&self) -> &str {
+        &self.url
+    }
+
+    /// Request bodies received so far
+    pub fn requests(&self) -> Vec<Value> {
+        self.requests.lock().unwrap().clone()
+    }
+}
+
+/// Answers one request and closes the connection
+fn serve(stream: TcpStream, queue: &mut impl Iterator<Item = String>, recorded: &Mutex<Vec<Value>>) -> Result<()> {
+    stream.set_read_timeout(Some(Duration::from_secs(10)))?;
+    let mut reader = BufReader::new(stream.try_clone()?);
+    let mut content_length = 0;
+    loop {
+        let mut line = String::new();
+        if reader.read_line(&mut line)? == 0 || line.trim().is_empty() {
+            break;
+        }
+        if let Some((name, value)) = line.split_once(':') {
+            if name.eq_ignore_ascii_case("content-length") {
+                content_length = value.trim().parse().unwrap_or(0);
+            }
+        }
+    }
+    let mut body = vec![0; content_length];
+    reader.read_exact(&mut body)?;
+    let request: Value = serde_json::from_slice(&body).unwrap_or(Value::Null);
+    let streaming = request["stream"].as_bool().unwrap_or(false);
+    recorded.lock().unwrap().push(request);
+
+    let (status, content_type, payload) = match queue.next() {
+        Some(ndjson) if streaming => ("200 OK", "application/x-ndjson", ndjson),
+        Some(ndjson) => ("200 OK", "application/json", collapse_stream(&ndjson).to_string()),
+        None => ("500 Internal Server Error", "application/json", json!({ "error": "no recorded response left" }).to_string()),
+    };
+    let mut stream = stream;
+    write!(
+        stream,
+        "HTTP/1.1 {}\r\nContent-Type: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{}",
+        status,
+        content_type,
+        payload.len(),
+        payload
+    )?;
+    stream.flush()?;
+    Ok(())
+}
+
+/// A session in a fresh directory under the system temp dir, talking to `server`
+pub fn fake_session(server: &FakeLlmServer, root: &Path) -> Result<PrimeSession> {
+    let workspace = root.join("workspace");
+    fs::create_dir_all(&workspace)?;
+    let llm = LLMBuilder::new()
+        .backend(LLMBackend::Ollama)
+        .base_url(server.url())
+        .model("fake")
+        .temperature(0.0)
+        .build()
+        .map_err(|e| anyhow!("Failed to build fake LLM client: {}", e))?;
+    // A fixed clock gives every run the same session id, so each test needs its own
+    // scratch root or parallel tests would clear each other's PRIME_TMP.
+    let clock = Arc::new(FixedClock::at(2025, 6, 7, 17, 54, 46));
+    let mut session = PrimeSession::with_host(root.join("home"), llm, clock, vfs::real())?;
+    session.scratch = TurnScratch::in_dir(root.join("tmp"));
+    session.working_dir = workspace.clone();
+    session.workspace_root = workspace;
+    session.auto_run_delay = Duration::ZERO;
+    session.stall_warning_secs = 0;
+    session.reload_tools()?;
+    Ok(session)
+}
+
+/// Requests as the model saw them (system prompt left out, it embeds the date and OS)
+/// followed by the session log without timestamps
+pub fn render_turns(session: &PrimeSession, requests: &[Value], root: &Path) -> Result<String> {
+    let mut out = String::new();
+    for (i, request) in requests.iter().enumerate() {
+        out.push_str(&format!("=== request {} ===\n", i + 1));
+        let messages = request["messages"].as_array().cloned().unwrap_or_default();
+        for message in messages.iter().skip(1) {
+            out.push_str(&format!(
+                "[{}]\n{}\n",
+                message["role"].as_str().unwrap_or("?"),
+                message["content"].as_str().unwrap_or_default().trim()
+            ));
+        }
+    }
+    out.push_str("=== session log ===\n");
+    let log = fs::read_to_string(&session.session_log_path).context("Could not read session log file.")?;
+    for entry in parse_log_entries(&log) {
+        out.push_str(&format!("## {}\n{}\n", entry.title, entry.content));
+    }
+    let root = root.display().to_string();
+    let mut out = out.replace(&root, "<ROOT>");
+    // A table cell may be cut inside the root, leaving a machine-specific prefix of it.
+    for end in (1..root.len()).rev().filter(|&end| root.is_char_boundary(end)) {
+        out = out.replace(&format!(" {}…", &root[..end]), " <ROOT>…");
+    }
+    Ok(out)
+}
+
+/// Compares `actual` with tests/snapshots/<name>.snap, rewriting it when UPDATE_SNAPSHOTS is set
+pub fn assert_snapshot(name: &str, actual: &str) {
+    let path = manifest_dir().join("tests/snapshots").join(format!("{}.snap", name));
+    if std::env::var_os("UPDATE_SNAPSHOTS").is_some() {
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(&path, actual).unwrap();
+        return;
+    }
+    let expected = fs::read_to_string(&path)
+        .unwrap_or_else(|_| panic!("Missing snapshot {}; run with UPDATE_SNAPSHOTS=1 to record it", path.display()));
+    assert_eq!(expected.replace("\r\n", "\n"), actual, "Snapshot {} differs; run with UPDATE_SNAPSHOTS=1 if intended", name);
+}
+
+/// Length scratch directory paths are padded to. Table cells are truncated before
+/// `render_turns` sees them, so the root must be as long on every machine for the
+/// cells to be cut at the same place; temp dirs up to ~60 characters fit.
+const ROOT_LEN: usize = 100;
+
+/// Scratch directory unique to one test, its path padded to `ROOT_LEN` characters
+fn scratch_dir(name: &str) -> PathBuf {
+    // Canonical, so paths resolved through a symlinked temp dir still match the root.
+    let base = std::env::temp_dir().canonicalize().unwrap_or_else(|_| std::env::temp_dir());
+    let mut dir_name = format!("prime_e2e_{}_{}_", name, std::process::id());
+    let length = base.join(&dir_name).display().to_string().chars().count();
+    dir_name.push_str(&"x".repeat(ROOT_LEN.saturating_sub(length)));
+    let dir = base.join(dir_name);
+    let _ = fs::remove_dir_all(&dir);
+    fs::create_dir_all(&dir).unwrap();
+    dir
+}
+
+/// Runs the user inputs of a scenario as consecutive turns and checks its snapshot
+async fn run_scenario(scenario: &str, inputs: &[&str]) -> PathBuf {
+    let root = scratch_dir(scenario);
+    let server = FakeLlmServer::start(load_fixtures(scenario).unwrap()).unwrap();
+    let mut session = fake_session(&server, &root).unwrap();
+    for input in inputs {
+        session.process_input(input).await.unwrap();
+    }
+    let rendered = render_turns(&session, &server.requests(), &root).unwrap();
+    assert_snapshot(scenario, &rendered);
+    root
+}
+
+#[tokio::test]
+async fn test_write_file_turn() {
+    let root = run_scenario("write_file", &["create notes.txt with a short todo list"]).await;
+    let written = fs::read_to_string(root.join("workspace/notes.txt")).unwrap();
+    assert_eq!(written, "- buy milk\n- write tests");
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_failed_command_enters_recovery() {
+    let root = run_scenario("failed_command", &["run the build"]).await;
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_elided_write_is_refused_and_resent() {
+    let root = run_scenario("elided_write", &["make main print hello"]).await;
+    let written = fs::read_to_string(root.join("workspace/main.rs")).unwrap();
+    assert!(!written.contains("rest of the code"));
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_write_outside_workspace_is_refused() {
+    let root = run_scenario("outside_write", &["save a note that says keep this"]).await;
+    assert!(!root.join("escape.txt").exists());
+    assert_eq!(fs::read_to_string(root.join("workspace/escape.txt")).unwrap(), "keep this");
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_tool_outside_workspace_is_refused() {
+    let root = run_scenario("outside_tool", &["add a tool that greets someone"]).await;
+    assert!(!root.join("prime").exists());
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_uncertain_plan_asks_before_acting() {
+    let root = scratch_dir("clarify_first");
+    let server = FakeLlmServer::start(load_fixtures("clarify_first").unwrap()).unwrap();
+    let mut session = fake_session(&server, &root).unwrap();
+    session.clarify_first = true;
+    fs::write(root.join("workspace/notes.txt"), "old notes").unwrap();
+    assert_eq!(session.process_input("clean up the file").await.unwrap(), TurnOutcome::Cancelled);
+    assert_eq!(fs::read_to_string(root.join("workspace/notes.txt")).unwrap(), "old notes");
+    assert_eq!(session.process_input("notes.txt").await.unwrap(), TurnOutcome::Completed);
+    assert_eq!(fs::read_to_string(root.join("workspace/notes.txt")).unwrap(), "cleared");
+    assert_snapshot("clarify_first", &render_turns(&session, &server.requests(), &root).unwrap());
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_truncated_response_is_continued() {
+    let root = run_scenario("truncated_response", &["write a script that prints two lines"]).await;
+    assert_eq!(fs::read_to_string(root.join("workspace/hello.sh")).unwrap(), "echo one\necho two");
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_pinned_message_outlives_history_window() {
+    let root = scratch_dir("pinned");
+    let server = FakeLlmServer::start(Vec::new()).unwrap();
+    let mut session = fake_session(&server, &root).unwrap();
+    let log: String = (1..=12)
+        .map(|n| format!("\n## User Input (2024-01-01 00:00:00)\n```\nrequirement {}\n```\n", n))
+        .collect();
+    fs::create_dir_all(session.session_log_path.parent().unwrap()).unwrap();
+    fs::write(&session.session_log_path, log).unwrap();
+    assert_eq!(session.pin_message(1).unwrap(), "requirement 1");
+    assert!(session.pin_message(13).is_err());
+
+    let history = session.get_history(Some(10)).unwrap();
+    assert_eq!(history.len(), 11);
+    assert!(history[0].content.starts_with("[src: history:msg 1]\n[Pinned by the user: message 1 (User Input)"));
+    assert!(history[0].content.ends_with("requirement 1"));
+    assert_eq!(history[1].content, "[src: history:msg 3]\nrequirement 3");
+
+    assert!(session.unpin_message(1));
+    assert_eq!(session.get_history(Some(10)).unwrap().len(), 10);
+    let _ = fs::remove_dir_all(&root);
+}
+
+#[tokio::test]
+async fn test_resumed_session_continues_turn_count() {
+    let root = scratch_dir("resume");
+    let server = FakeLlmServer::start(load_fixtures("write_file").unwrap()).unwrap();
+    let mut first = fake_session(&server, &root).unwrap();
+    first.process_input("create notes.txt with a short todo list").await.unwrap();
+
+    let mut resumed = fake_session(&server, &root).unwrap();
+    resumed.resume(Some(&first.session_id)).unwrap();
+    let report = resumed.status_report();
+    let turns = report.iter().find(|(label, _)| *label == crate::i18n::tr(crate::i18n::Msg::LabelTurns)).unwrap();
+    assert_eq!(turns.1, "1");
+    let _ = fs::remove_dir_all(&root);
+}
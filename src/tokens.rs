@@ -0,0 +1,152 @@
+//! Offline token estimates, calibrated per model family
+//! Budgeting, history trimming and `!status` need token counts before a request is
+//! sent, and no provider exposes its tokenizer through the chat interface. Text is
+//! split the way BPE pre-tokenizers split it (words, digit runs, punctuation,
+//! whitespace) and each piece is costed with the vocabulary traits of the model's
+//! family: how long a word stays a single token, how digits are grouped, and how
+//! much CJK text a token covers. This tracks code, numbers and non-English text far
+//! better than a flat four characters per token.
+
+/// Tokenizer traits shared by a family of models
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub struct Estimator {
+    /// Words up to this many characters are usually a single token
+    whole_word: usize,
+    /// Characters per token in longer words, which split into pieces
+    piece: usize,
+    /// Digits per token; small vocabularies split numbers into single digits
+    digit_group: usize,
+    /// CJK characters per token
+    cjk_per_token: f32,
+}
+
+/// cl100k/o200k-style vocabularies: GPT, Claude, Llama 3, DeepSeek
+const LARGE_VOCAB: Estimator = Estimator { whole_word: 8, piece: 4, digit_group: 3, cjk_per_token: 0.8 };
+/// SentencePiece with 32k entries: Llama 2, Mistral, Mixtral, Phi-3, CodeLlama
+const SMALL_VOCAB: Estimator = Estimator { whole_word: 5, piece: 3, digit_group: 1, cjk_per_token: 0.6 };
+/// Gemma's 256k vocabulary keeps long words whole but splits digits
+const GEMMA: Estimator = Estimator { whole_word: 10, piece: 5, digit_group: 1, cjk_per_token: 1.2 };
+/// Qwen's vocabulary is tuned for Chinese and splits digits
+const QWEN: Estimator = Estimator { whole_word: 8, piece: 4, digit_group: 1, cjk_per_token: 1.4 };
+
+impl Default for Estimator {
+    fn default() -> Self {
+        LARGE_VOCAB
+    }
+}
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+enum Piece {
+    Word,
+    Digits,
+    Cjk,
+    Space,
+    Newline,
+    Symbol,
+}
+
+fn is_cjk(c: char) -> bool {
+    matches!(c as u32,
+        0x3040..=0x30FF // kana
+        | 0x3400..=0x4DBF | 0x4E00..=0x9FFF | 0xF900..=0xFAFF // han
+        | 0xAC00..=0xD7AF // hangul
+    )
+}
+
+fn kind(c: char) -> Piece {
+    match c {
+        '\n' | '\r' => Piece::Newline,
+        c if c.is_whitespace() => Piece::Space,
+        c if c.is_ascii_digit() => Piece::Digits,
+        c if is_cjk(c) => Piece::Cjk,
+        c if c.is_alphabetic() || c == '\'' => Piece::Word,
+        _ => Piece::Symbol,
+    }
+}
+
+impl Estimator {
+    /// Estimator for a model name such as `llama3.1:8b`, `gpt-4o` or `mistral-large-latest`
+    pub fn for_model(model: &str) -> Self {
+        let model = model.to_lowercase();
+        let has = |names: &[&str]| names.iter().any(|name| model.contains(name));
+        if has(&["gemma"]) {
+            GEMMA
+        } else if has(&["qwen"]) {
+            QWEN
+        } else if has(&["llama3", "llama-3", "llama 3"]) {
+            LARGE_VOCAB
+        } else if has(&["llama", "mistral", "mixtral", "phi", "vicuna", "orca"]) {
+            SMALL_VOCAB
+        } else {
+            LARGE_VOCAB
+        }
+    }
+
+    fn cost(&self, piece: Piece, text: &str) -> usize {
+        let chars = text.chars().count();
+        match piece {
+            // Non-ASCII letters take several bytes and rarely merge well.
+            Piece::Word => {
+                let weight = text.chars().map(|c| if c.is_ascii() { 1 } else { 2 }).sum::<usize>();
+                if weight <= self.whole_word { 1 } else { weight.div_ceil(self.piece) }
+            }
+            Piece::Digits => chars.div_ceil(self.digit_group),
+            Piece::Cjk => (chars as f32 / self.cjk_per_token).ceil() as usize,
+            // A single space merges into the following word; indentation is one token.
+            Piece::Space => usize::from(chars > 1),
+            Piece::Newline => 1,
+            // Common operators and fences (`->`, `==`, "```") are single tokens.
+            Piece::Symbol => chars.div_ceil(2),
+        }
+    }
+
+    /// Estimated tokens in `text`
+    pub fn count(&self, text: &str) -> usize {
+        let mut total = 0;
+        let mut start = 0;
+        let mut current: Option<Piece> = None;
+        for (i, c) in text.char_indices() {
+            let piece = kind(c);
+            if current != Some(piece) {
+                if let Some(previous) = current {
+                    total += self.cost(previous, &text[start..i]);
+                }
+                start = i;
+                current = Some(piece);
+            }
+        }
+        if let Some(last) = current {
+            total += self.cost(last, &text[start..]);
+        }
+        total
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_families_from_model_names() {
+        assert_eq!(Estimator::for_model("gemma2:9b"), GEMMA);
+        assert_eq!(Estimator::for_model("qwen2.5-coder:7b"), QWEN);
+        assert_eq!(Estimator::for_model("llama3.1:8b"), LARGE_VOCAB);
+        assert_eq!(Estimator::for_model("llama2:13b"), SMALL_VOCAB);
+        assert_eq!(Estimator::for_model("mistral-large-latest"), SMALL_VOCAB);
+        assert_eq!(Estimator::for_model("gpt-4o"), LARGE_VOCAB);
+        assert_eq!(Estimator::for_model(""), Estimator::default());
+    }
+
+    #[test]
+    fn test_counts_follow_pre_tokenization() {
+        let large = LARGE_VOCAB;
+        assert_eq!(large.count(""), 0);
+        assert_eq!(large.count("Hello, world!"), 4);
+        assert_eq!(large.count("internationalization"), 5);
+        assert_eq!(large.count("    let x = 12345;\n"), 8);
+        assert_eq!(SMALL_VOCAB.count("12345"), 5);
+        assert_eq!(large.count("12345"), 2);
+        assert_eq!(QWEN.count("你好世界"), 3);
+        assert!(SMALL_VOCAB.count("naïve café") > large.count("naive cafe"));
+    }
+}
@@ -0,0 +1,185 @@
+//! Storage formats of session logs
+//! Sessions are stored as markdown by default (`<id>.md`, one `## Title (timestamp)`
+//! section per entry) or, with `log_format = "json"`, as JSON Lines (`<id>.jsonl`, one
+//! `{"title", "timestamp", "content"}` object per entry) for tools that parse
+//! transcripts. The format is chosen per installation and recognised per file by its
+//! extension, so both kinds can sit side by side; history, exports and merges read
+//! either. Display and `!export` always render markdown. `prime sessions convert`
+//! rewrites existing logs from one format to the other.
+
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{anyhow, Context, Result};
+use crate::terminal::Stylize;
+
+use crate::session::{format_log_entry, parse_log_entries, LogEntry};
+
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum LogFormat {
+    Markdown,
+    Json,
+}
+
+/// Every format, in the order a session id is looked up
+const FORMATS: [LogFormat; 2] = [LogFormat::Markdown, LogFormat::Json];
+
+impl LogFormat {
+    /// The `log_format` setting in config.toml
+    pub fn from_config(value: &str) -> Result<Self> {
+        match value.trim().to_lowercase().as_str() {
+            "markdown" | "md" | "" => Ok(Self::Markdown),
+            "json" | "jsonl" => Ok(Self::Json),
+            other => Err(anyhow!("Unknown log format '{}' (use markdown or json)", other)),
+        }
+    }
+
+    pub fn name(self) -> &'static str {
+        match self {
+            Self::Markdown => "markdown",
+            Self::Json => "json",
+        }
+    }
+
+    pub fn extension(self) -> &'static str {
+        match self {
+            Self::Markdown => "md",
+            Self::Json => "jsonl",
+        }
+    }
+
+    /// Format of a log file, from its extension
+    pub fn of(path: &Path) -> Option<Self> {
+        let extension = path.extension()?.to_str()?;
+        FORMATS.into_iter().find(|format| format.extension() == extension)
+    }
+
+    /// One entry as appended to a log of this format
+    pub fn format_entry(self, entry: &LogEntry) -> String {
+        match self {
+            Self::Markdown => format_log_entry(entry),
+            Self::Json => {
+                let entry = LogEntry { content: entry.content.trim().to_string(), ..entry.clone() };
+                format!("{}\n", serde_json::to_string(&entry).unwrap_or_default())
+            }
+        }
+    }
+
+    /// Entries of a log of this format; JSON lines that do not parse are skipped
+    pub fn parse(self, log: &str) -> Vec<LogEntry> {
+        match self {
+            Self::Markdown => parse_log_entries(log),
+            Self::Json => log.lines().filter_map(|line| serde_json::from_str(line).ok()).collect(),
+        }
+    }
+
+    pub fn render(self, entries: &[LogEntry]) -> String {
+        entries.iter().map(|entry| self.format_entry(entry)).collect()
+    }
+}
+
+/// `id` without a log extension
+pub fn strip_extension(id: &str) -> &str {
+    FORMATS.into_iter().find_map(|format| id.strip_suffix(format.extension())?.strip_suffix('.')).unwrap_or(id)
+}
+
+/// Existing log of session `id` in `conversations_dir`, in whichever format it was written
+pub fn find_log(conversations_dir: &Path, id: &str) -> Option<PathBuf> {
+    let id = strip_extension(id);
+    FORMATS.into_iter().map(|format| conversations_dir.join(format!("{}.{}", id, format.extension()))).find(|path| path.is_file())
+}
+
+/// Entries of the log at `path`; a log that does not exist yet has none
+pub fn read_entries(path: &Path) -> Result<Vec<LogEntry>> {
+    let format = LogFormat::of(path).unwrap_or(LogFormat::Markdown);
+    match fs::read_to_string(path) {
+        Ok(log) => Ok(format.parse(&log)),
+        Err(e) if e.kind() == std::io::ErrorKind::NotFound => Ok(Vec::new()),
+        Err(e) => Err(e).with_context(|| format!("Failed to read {}", path.display())),
+    }
+}
+
+/// Rewrites the log at `path` in `format`; returns the new path, or None when it
+/// already is in that format
+pub fn convert(path: &Path, format: LogFormat) -> Result<Option<PathBuf>> {
+    if LogFormat::of(path) == Some(format) {
+        return Ok(None);
+    }
+    let target = path.with_extension(format.extension());
+    if target.exists() {
+        return Err(anyhow!("{} already exists", target.display()));
+    }
+    let entries = read_entries(path)?;
+    fs::write(&target, format.render(&entries)).with_context(|| format!("Failed to write {}", target.display()))?;
+    fs::remove_file(path).with_context(|| format!("Failed to remove {}", path.display()))?;
+    Ok(Some(target))
+}
+
+/// CLI entry point for `prime sessions convert`: one session, or all of them
+pub fn run_convert(base_dir: &Path, format: &str, session: Option<&str>) -> Result<()> {
+    let format = LogFormat::from_config(format)?;
+    let conversations_dir = base_dir.join("conversations");
+    let paths: Vec<PathBuf> = match session {
+        Some(session) => vec![crate::sessions::resolve(&conversations_dir, session)?],
+        None => match fs::read_dir(&conversations_dir) {
+            Ok(entries) => entries.flatten().map(|entry| entry.path()).filter(|path| LogFormat::of(path).is_some()).collect(),
+            Err(_) => Vec::new(),
+        },
+    };
+    let mut converted = 0;
+    for path in &paths {
+        if let Some(target) = convert(path, format)? {
+            println!("{} → {}", path.display(), target.display());
+            converted += 1;
+        }
+    }
+    println!("{}", format!("Converted {} of {} sessions to {}", converted, paths.len(), format.name()).green());
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn entries() -> Vec<LogEntry> {
+        vec![
+            LogEntry { title: "User Input".to_string(), timestamp: "2025-06-07 17:54:46".to_string(), content: "list files".to_string() },
+            LogEntry {
+                title: "Prime Response".to_string(),
+                timestamp: "2025-06-07 17:54:50".to_string(),
+                content: "## Files\n```primeactions\nlist_dir: .\n```".to_string(),
+            },
+        ]
+    }
+
+    #[test]
+    fn test_formats_round_trip() {
+        for format in FORMATS {
+            assert_eq!(format.parse(&format.render(&entries())), entries());
+        }
+        let json = LogFormat::Json.render(&entries()[..1]);
+        assert_eq!(json, "{\"title\":\"User Input\",\"timestamp\":\"2025-06-07 17:54:46\",\"content\":\"list files\"}\n");
+        assert_eq!(LogFormat::of(Path::new("session_1.jsonl")), Some(LogFormat::Json));
+        assert_eq!(LogFormat::of(Path::new("session_1.lock")), None);
+        assert_eq!(strip_extension("session_1.jsonl"), "session_1");
+        assert!(LogFormat::from_config("yaml").is_err());
+    }
+
+    #[test]
+    fn test_convert_replaces_the_log() {
+        let dir = std::env::temp_dir().join(format!("prime_transcript_{}", std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        let markdown = dir.join("session_1.md");
+        fs::write(&markdown, LogFormat::Markdown.render(&entries())).unwrap();
+
+        let json = convert(&markdown, LogFormat::Json).unwrap().unwrap();
+        assert!(!markdown.exists());
+        assert_eq!(find_log(&dir, "session_1"), Some(json.clone()));
+        assert_eq!(read_entries(&json).unwrap(), entries());
+        assert_eq!(convert(&json, LogFormat::Json).unwrap(), None);
+        assert_eq!(convert(&json, LogFormat::Markdown).unwrap(), Some(markdown.clone()));
+        assert_eq!(read_entries(&markdown).unwrap(), entries());
+        let _ = fs::remove_dir_all(&dir);
+    }
+}
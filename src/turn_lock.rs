@@ -0,0 +1,324 @@
+//! Per-session turn locking
+//! A turn (user input through the final response) holds `<session_id>.lock` next to the
+//! session log, so two clients driving the same session cannot interleave their turns.
+//! Contenders wait in a queue for a bounded time and then get a busy error.
+//!
+//! The holder touches the lock while its turn runs, so only a lock left behind by a
+//! crashed process goes stale. Contenders that find a stale lock take turns through a
+//! `.takeover` marker; the one holding it removes the lock only while it is still the stale
+//! one, and the path is then claimed with the same `create_new` as any other acquisition.
+//! Each lock carries a token so a guard never removes a lock that has since passed to
+//! someone else.
+
+use std::fmt;
+use std::fs::{self, File, OpenOptions};
+use std::io::{ErrorKind, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::sync::mpsc::{self, RecvTimeoutError, Sender};
+use std::thread::{self, JoinHandle};
+use std::time::{Duration, Instant, SystemTime};
+
+use anyhow::{Context, Result};
+
+use crate::clock::Clock;
+
+/// Locks not touched for this long are assumed to belong to a crashed process
+const STALE_AFTER: Duration = Duration::from_secs(5 * 60);
+/// How often a held lock is touched
+const REFRESH_EVERY: Duration = Duration::from_secs(30);
+const POLL_INTERVAL: Duration = Duration::from_millis(50);
+
+/// Returned when another client keeps the session busy past the wait limit
+#[derive(Debug)]
+pub struct SessionBusy {
+    pub session_id: String,
+    pub holder: String,
+}
+
+impl fmt::Display for SessionBusy {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "Session {} is busy with another turn ({}). Try again when it finishes.", self.session_id, self.holder)
+    }
+}
+
+impl std::error::Error for SessionBusy {}
+
+/// Token unique to one acquisition, written on the lock's second line
+fn new_token() -> String {
+    static COUNTER: AtomicU64 = AtomicU64::new(0);
+    let nanos = SystemTime::now().duration_since(SystemTime::UNIX_EPOCH).map_or(0, |d| d.as_nanos());
+    format!("{}-{}-{}", std::process::id(), nanos, COUNTER.fetch_add(1, Ordering::Relaxed))
+}
+
+/// Owner label and token of a lock file
+fn read_lock(path: &Path) -> Option<(String, String)> {
+    let content = fs::read_to_string(path).ok()?;
+    let mut lines = content.lines();
+    Some((lines.next().unwrap_or_default().to_string(), lines.next().unwrap_or_default().to_string()))
+}
+
+fn holds(path: &Path, token: &str) -> bool {
+    read_lock(path).is_some_and(|(_, held)| held == token)
+}
+
+/// Held for the duration of one turn; keeps the lock fresh and removes it on drop
+#[derive(Debug)]
+pub struct TurnGuard {
+    path: PathBuf,
+    token: String,
+    stop: Option<Sender<()>>,
+    heartbeat: Option<JoinHandle<()>>,
+}
+
+impl TurnGuard {
+    fn new(path: PathBuf, token: String, refresh_every: Duration) -> Self {
+        let (stop, stopped) = mpsc::channel::<()>();
+        let (heartbeat_path, heartbeat_token) = (path.clone(), token.clone());
+        let heartbeat = thread::spawn(move || {
+            while let Err(RecvTimeoutError::Timeout) = stopped.recv_timeout(refresh_every) {
+                if !holds(&heartbeat_path, &heartbeat_token) {
+                    break;
+                }
+                let _ = File::options().append(true).open(&heartbeat_path).and_then(|file| file.set_modified(SystemTime::now()));
+            }
+        });
+        Self { path, token, stop: Some(stop), heartbeat: Some(heartbeat) }
+    }
+}
+
+impl Drop for TurnGuard {
+    fn drop(&mut self) {
+        drop(self.stop.take());
+        if let Some(heartbeat) = self.heartbeat.take() {
+            let _ = heartbeat.join();
+        }
+        if holds(&self.path, &self.token) {
+            let _ = fs::remove_file(&self.path);
+        }
+    }
+}
+
+#[derive(Debug, Clone)]
+pub struct TurnLock {
+    path: PathBuf,
+    session_id: String,
+    refresh_every: Duration,
+}
+
+impl TurnLock {
+    pub fn new(conversations_dir: &Path, session_id: &str) -> Self {
+        Self {
+            path: conversations_dir.join(format!("{}.lock", session_id)),
+            session_id: session_id.to_string(),
+            refresh_every: REFRESH_EVERY,
+        }
+    }
+
+    /// Takes the lock without waiting; `Ok(None)` means another turn is in progress
+    pub fn try_acquire(&self, owner: &str) -> Result<Option<TurnGuard>> {
+        match OpenOptions::new().write(true).create_new(true).open(&self.path) {
+            Ok(mut file) => {
+                let token = new_token();
+                writeln!(file, "{}\n{}", owner, token)
+                    .with_context(|| format!("Failed to write turn lock: {}", self.path.display()))?;
+                Ok(Some(TurnGuard::new(self.path.clone(), token, self.refresh_every)))
+            }
+            Err(e) if e.kind() == ErrorKind::AlreadyExists => {
+                if self.is_stale() && self.clear_stale() {
+                    return self.try_acquire(owner);
+                }
+                Ok(None)
+            }
+            Err(e) => Err(e).with_context(|| format!("Failed to create turn lock: {}", self.path.display())),
+        }
+    }
+
+    /// Removes a stale lock so it can be claimed; true when the path is free afterwards.
+    /// Only the contender that creates the takeover marker may remove the lock, and only
+    /// if it still holds the token read before, so a lock taken in the meantime stays.
+    fn clear_stale(&self) -> bool {
+        let Some(stale) = read_lock(&self.path) else {
+            return true;
+        };
+        let marker = self.takeover_marker();
+        if OpenOptions::new().write(true).create_new(true).open(&marker).is_err() {
+            // A takeover holds the marker for a moment; one this old was cut short by a crash
+            if older_than(&marker, STALE_AFTER) {
+                let _ = fs::remove_file(&marker);
+            }
+            return false;
+        }
+        let cleared = match read_lock(&self.path) {
+            None => true,
+            Some(current) if current == stale && self.is_stale() => fs::remove_file(&self.path).is_ok(),
+            Some(_) => false,
+        };
+        let _ = fs::remove_file(&marker);
+        cleared
+    }
+
+    fn takeover_marker(&self) -> PathBuf {
+        self.path.with_extension("lock.takeover")
+    }
+
+    /// Queues behind the current turn for up to `wait`, then reports the session as busy
+    pub async fn acquire(&self, owner: &str, wait: Duration) -> Result<TurnGuard> {
+        let deadline = Instant::now() + wait;
+        loop {
+            if let Some(guard) = self.try_acquire(owner)? {
+                return Ok(guard);
+            }
+            if Instant::now() >= deadline {
+                return Err(self.busy().into());
+            }
+            tokio::time::sleep(POLL_INTERVAL).await;
+        }
+    }
+
+    /// `acquire` for callers outside the runtime whose critical section is a quick file update
+    pub fn acquire_blocking(&self, owner: &str, wait: Duration) -> Result<TurnGuard> {
+        let deadline = Instant::now() + wait;
+        loop {
+            if let Some(guard) = self.try_acquire(owner)? {
+                return Ok(guard);
+            }
+            if Instant::now() >= deadline {
+                return Err(self.busy().into());
+            }
+            thread::sleep(POLL_INTERVAL);
+        }
+    }
+
+    fn busy(&self) -> SessionBusy {
+        let holder = read_lock(&self.path).map(|(owner, _)| owner).unwrap_or_default();
+        SessionBusy { session_id: self.session_id.clone(), holder }
+    }
+
+    fn is_stale(&self) -> bool {
+        older_than(&self.path, STALE_AFTER)
+    }
+}
+
+fn older_than(path: &Path, limit: Duration) -> bool {
+    fs::metadata(path)
+        .and_then(|m| m.modified())
+        .ok()
+        .and_then(|modified| SystemTime::now().duration_since(modified).ok())
+        .map_or(false, |age| age > limit)
+}
+
+/// Identifies this process in the lock file so a busy error can say who holds it
+pub fn owner_label(clock: &dyn Clock) -> String {
+    format!("pid {} since {}", std::process::id(), clock.now().format("%H:%M:%S"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::sync::atomic::AtomicUsize;
+    use std::sync::Arc;
+
+    fn temp_dir(name: &str) -> PathBuf {
+        let dir = std::env::temp_dir().join(format!("prime_turn_lock_{}_{}", name, std::process::id()));
+        let _ = fs::remove_dir_all(&dir);
+        fs::create_dir_all(&dir).unwrap();
+        dir
+    }
+
+    fn age(path: &Path, by: Duration) {
+        File::options().append(true).open(path).unwrap().set_modified(SystemTime::now() - by).unwrap();
+    }
+
+    #[tokio::test]
+    async fn test_second_client_is_busy_until_release() {
+        let dir = temp_dir("busy");
+        let lock = TurnLock::new(&dir, "session_1");
+        let guard = lock.try_acquire("client a").unwrap().expect("first client gets the lock");
+        assert!(lock.try_acquire("client b").unwrap().is_none());
+        let err = lock.acquire("client b", Duration::from_millis(120)).await.unwrap_err();
+        assert!(err.to_string().contains("client a"));
+        drop(guard);
+        assert!(lock.try_acquire("client b").unwrap().is_some());
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[tokio::test(flavor = "multi_thread", worker_threads = 4)]
+    async fn test_concurrent_clients_never_overlap() {
+        let dir = temp_dir("concurrent");
+        let clients = 4;
+        let active = Arc::new(AtomicUsize::new(0));
+        let turns = Arc::new(AtomicUsize::new(0));
+        let tasks: Vec<_> = (0..clients)
+            .map(|i| {
+                let (dir, active, turns) = (dir.clone(), active.clone(), turns.clone());
+                tokio::spawn(async move {
+                    let lock = TurnLock::new(&dir, "session_shared");
+                    let _guard = lock.acquire(&format!("client {}", i), Duration::from_secs(10)).await.unwrap();
+                    assert_eq!(active.fetch_add(1, Ordering::SeqCst), 0, "two turns ran at once");
+                    tokio::time::sleep(Duration::from_millis(30)).await;
+                    active.fetch_sub(1, Ordering::SeqCst);
+                    turns.fetch_add(1, Ordering::SeqCst);
+                })
+            })
+            .collect();
+        for task in tasks {
+            task.await.unwrap();
+        }
+        assert_eq!(turns.load(Ordering::SeqCst), clients);
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_sessions_lock_independently() {
+        let dir = temp_dir("independent");
+        let _a = TurnLock::new(&dir, "session_a").try_acquire("a").unwrap().unwrap();
+        assert!(TurnLock::new(&dir, "session_b").try_acquire("b").unwrap().is_some());
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_stale_lock_is_taken_over_and_kept_from_its_old_guard() {
+        let dir = temp_dir("stale");
+        let lock = TurnLock::new(&dir, "session_1");
+        let crashed = lock.try_acquire("client a").unwrap().unwrap();
+        age(&lock.path, STALE_AFTER * 2);
+        let guard = lock.try_acquire("client b").unwrap().expect("a stale lock is taken over");
+        drop(crashed);
+        assert_eq!(read_lock(&lock.path).map(|(owner, _)| owner).as_deref(), Some("client b"));
+        assert!(lock.try_acquire("client c").unwrap().is_none());
+        drop(guard);
+        assert!(!lock.path.exists());
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_stale_lock_is_left_alone_during_another_takeover() {
+        let dir = temp_dir("takeover");
+        let lock = TurnLock::new(&dir, "session_1");
+        let _crashed = lock.try_acquire("client a").unwrap().unwrap();
+        age(&lock.path, STALE_AFTER * 2);
+        File::create(lock.takeover_marker()).unwrap();
+        assert!(lock.try_acquire("client b").unwrap().is_none());
+        assert_eq!(read_lock(&lock.path).map(|(owner, _)| owner).as_deref(), Some("client a"));
+
+        // A marker left by a crashed takeover only delays the next one
+        age(&lock.takeover_marker(), STALE_AFTER * 2);
+        assert!(lock.try_acquire("client b").unwrap().is_none());
+        assert!(!lock.takeover_marker().exists());
+        assert!(lock.try_acquire("client b").unwrap().is_some());
+        let _ = fs::remove_dir_all(&dir);
+    }
+
+    #[test]
+    fn test_held_lock_is_kept_fresh() {
+        let dir = temp_dir("fresh");
+        let lock = TurnLock { refresh_every: Duration::from_millis(20), ..TurnLock::new(&dir, "session_1") };
+        let _guard = lock.try_acquire("long turn").unwrap().unwrap();
+        age(&lock.path, STALE_AFTER * 2);
+        thread::sleep(Duration::from_millis(200));
+        assert!(!lock.is_stale());
+        assert!(lock.try_acquire("client b").unwrap().is_none());
+        let _ = fs::remove_dir_all(&dir);
+    }
+}
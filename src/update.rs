@@ -0,0 +1,256 @@
+//! Self-update for Prime
+//! Looks up the latest GitHub release, checks the downloaded binary against the
+//! published SHA256SUMS file, swaps the executable in place and lets the new binary
+//! migrate anything under ~/.prime that changed format.
+//!
+//! The checksum catches a truncated or corrupted download. It is not a signature: the
+//! sums come from the same release as the binary, so they vouch for nothing the
+//! release page itself does not.
+
+use std::env;
+use std::fs;
+use std::path::Path;
+use std::process::Command;
+
+use anyhow::{anyhow, Context, Result};
+use crate::terminal::Stylize;
+use serde::Deserialize;
+use sha2::{Digest, Sha256};
+
+const RELEASES_URL: &str = "https://api.github.com/repos/incredimo/prime/releases/latest";
+const CHECKSUMS_ASSET: &str = "SHA256SUMS";
+const DATA_VERSION_FILENAME: &str = "data_version";
+
+/// Layout version of the ~/.prime directory. Bump together with a new step in `migrate_data_dir`.
+pub const DATA_VERSION: u32 = 1;
+
+#[derive(Deserialize, Debug)]
+struct Release {
+    tag_name: String,
+    assets: Vec<ReleaseAsset>,
+}
+
+#[derive(Deserialize, Debug)]
+struct ReleaseAsset {
+    name: String,
+    browser_download_url: String,
+}
+
+/// Name of the release asset built for this platform (matches bump.ps1)
+fn platform_asset_name() -> Option<&'static str> {
+    if cfg!(target_os = "windows") {
+        Some("prime-windows.exe")
+    } else if cfg!(target_os = "linux") {
+        Some("prime-linux")
+    } else if cfg!(target_os = "macos") {
+        Some("prime-macos")
+    } else {
+        None
+    }
+}
+
+fn parse_version(version: &str) -> Option<(u64, u64, u64)> {
+    let mut parts = version.trim().trim_start_matches('v').split('.').map(|p| p.parse::<u64>().ok());
+    Some((parts.next()??, parts.next()??, parts.next()??))
+}
+
+fn is_newer(latest: &str, current: &str) -> bool {
+    match (parse_version(latest), parse_version(current)) {
+        (Some(l), Some(c)) => l > c,
+        _ => latest.trim_start_matches('v') != current.trim_start_matches('v'),
+    }
+}
+
+/// Finds the expected hash for `asset_name` in a `sha256sum`-style listing
+fn find_checksum(sums: &str, asset_name: &str) -> Option<String> {
+    sums.lines().find_map(|line| {
+        let mut parts = line.split_whitespace();
+        let hash = parts.next()?;
+        let name = parts.next()?.trim_start_matches('*');
+        (name == asset_name).then(|| hash.to_lowercase())
+    })
+}
+
+fn verify_checksum(data: &[u8], expected: &str) -> Result<()> {
+    let actual = hex::encode(Sha256::digest(data));
+    if actual != expected {
+        return Err(anyhow!("Checksum mismatch: expected {}, got {}", expected, actual));
+    }
+    Ok(())
+}
+
+async fn download(client: &reqwest::Client, url: &str) -> Result<Vec<u8>> {
+    let bytes = client
+        .get(url)
+        .send()
+        .await
+        .with_context(|| format!("Failed to download {}", url))?
+        .error_for_status()
+        .with_context(|| format!("Download failed for {}", url))?
+        .bytes()
+        .await
+        .with_context(|| format!("Failed to read download body from {}", url))?;
+    Ok(bytes.to_vec())
+}
+
+pub async fn run_update(check_only: bool) -> Result<()> {
+    let current = env!("CARGO_PKG_VERSION");
+    println!("{}", format!("Checking for updates (current v{})...", current).dark_grey());
+
+    let client = reqwest::Client::builder()
+        .user_agent(format!("{}/{}", crate::APP_NAME, current))
+        .build()
+        .context("Failed to build HTTP client")?;
+
+    let release: Release = client
+        .get(RELEASES_URL)
+        .send()
+        .await
+        .context("Failed to reach the release endpoint")?
+        .error_for_status()
+        .context("Release endpoint returned an error")?
+        .json()
+        .await
+        .context("Failed to parse release metadata")?;
+
+    let latest = release.tag_name.trim_start_matches('v').to_string();
+    if !is_newer(&latest, current) {
+        println!("{}", format!("Prime v{} is up to date.", current).green());
+        return Ok(());
+    }
+    println!("{}", format!("New version available: v{}", latest).yellow());
+    if check_only {
+        println!("Run {} to install it.", "prime update".cyan());
+        return Ok(());
+    }
+
+    let asset_name = platform_asset_name()
+        .ok_or_else(|| anyhow!("No prebuilt binary is published for {}", env::consts::OS))?;
+    let asset = release
+        .assets
+        .iter()
+        .find(|a| a.name == asset_name)
+        .ok_or_else(|| anyhow!("Release v{} has no asset named {}", latest, asset_name))?;
+    let checksums_asset = release
+        .assets
+        .iter()
+        .find(|a| a.name == CHECKSUMS_ASSET)
+        .ok_or_else(|| anyhow!("Release v{} has no {} file; refusing to install a binary without a checksum", latest, CHECKSUMS_ASSET))?;
+
+    let checksums = download(&client, &checksums_asset.browser_download_url).await?;
+    let expected = find_checksum(&String::from_utf8_lossy(&checksums), asset_name)
+        .ok_or_else(|| anyhow!("{} does not list {}", CHECKSUMS_ASSET, asset_name))?;
+
+    println!("{}", format!("Downloading {}...", asset_name).dark_grey());
+    let binary = download(&client, &asset.browser_download_url).await?;
+    verify_checksum(&binary, &expected)?;
+
+    let exe = env::current_exe().context("Failed to locate the running executable")?;
+    replace_executable(&exe, &binary)?;
+    println!("{}", format!("Updated Prime to v{} ({})", latest, exe.display()).green());
+
+    // Only the new binary knows about its data layout, so let it run the migrations.
+    match Command::new(&exe).args(["update", "--migrate"]).status() {
+        Ok(status) if status.success() => {}
+        Ok(status) => eprintln!("{}", format!("Warning: data migration exited with {}", status).yellow()),
+        Err(e) => eprintln!("{}", format!("Warning: failed to run data migration: {}", e).yellow()),
+    }
+    Ok(())
+}
+
+/// Atomically replaces `exe` with `binary`, staging the new file next to it first
+fn replace_executable(exe: &Path, binary: &[u8]) -> Result<()> {
+    let dir = exe
+        .parent()
+        .ok_or_else(|| anyhow!("Executable has no parent directory: {}", exe.display()))?;
+    let staged = dir.join(format!(".{}-update.tmp", crate::APP_NAME));
+    fs::write(&staged, binary)
+        .with_context(|| format!("Failed to stage update at {}", staged.display()))?;
+
+    #[cfg(unix)]
+    {
+        use std::os::unix::fs::PermissionsExt;
+        fs::set_permissions(&staged, fs::Permissions::from_mode(0o755))
+            .with_context(|| format!("Failed to mark {} executable", staged.display()))?;
+    }
+
+    // Windows refuses to overwrite a running executable but allows renaming it,
+    // so the old binary is moved aside and removed on the next start.
+    #[cfg(windows)]
+    {
+        let old = exe.with_extension("exe.old");
+        let _ = fs::remove_file(&old);
+        fs::rename(exe, &old)
+            .with_context(|| format!("Failed to move the current binary aside: {}", exe.display()))?;
+    }
+
+    if let Err(e) = fs::rename(&staged, exe) {
+        #[cfg(windows)]
+        {
+            let _ = fs::rename(exe.with_extension("exe.old"), exe);
+        }
+        let _ = fs::remove_file(&staged);
+        return Err(anyhow!("Failed to install the new binary at {}: {}", exe.display(), e));
+    }
+    Ok(())
+}
+
+/// Removes the binary left behind by a previous Windows update
+pub fn cleanup_previous_binary() {
+    if cfg!(target_os = "windows") {
+        if let Ok(exe) = env::current_exe() {
+            let _ = fs::remove_file(exe.with_extension("exe.old"));
+        }
+    }
+}
+
+/// Brings the ~/.prime layout up to `DATA_VERSION`, one step at a time
+pub fn migrate_data_dir(base_dir: &Path) -> Result<()> {
+    let version_path = base_dir.join(DATA_VERSION_FILENAME);
+    let found = fs::read_to_string(&version_path)
+        .ok()
+        .and_then(|s| s.trim().parse::<u32>().ok())
+        .unwrap_or(0);
+    if found >= DATA_VERSION {
+        return Ok(());
+    }
+
+    for version in found..DATA_VERSION {
+        match version {
+            // Installs that predate versioning share the v1 layout; stamping is enough.
+            0 => {}
+            _ => return Err(anyhow!("No migration defined from data version {}", version)),
+        }
+    }
+
+    fs::create_dir_all(base_dir)
+        .with_context(|| format!("Failed to create Prime config directory: {}", base_dir.display()))?;
+    fs::write(&version_path, DATA_VERSION.to_string())
+        .with_context(|| format!("Failed to write data version to {}", version_path.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_version_comparison() {
+        assert!(is_newer("v0.2.10", "0.2.9"));
+        assert!(!is_newer("0.2.9", "0.2.9"));
+        assert!(!is_newer("v0.1.0", "0.2.9"));
+    }
+
+    #[test]
+    fn test_find_checksum() {
+        let sums = "abc123  prime-linux\nDEF456 *prime-windows.exe\n";
+        assert_eq!(find_checksum(sums, "prime-windows.exe").as_deref(), Some("def456"));
+        assert_eq!(find_checksum(sums, "prime-macos"), None);
+    }
+
+    #[test]
+    fn test_verify_checksum() {
+        let expected = hex::encode(Sha256::digest(b"prime"));
+        assert!(verify_checksum(b"prime", &expected).is_ok());
+        assert!(verify_checksum(b"tampered", &expected).is_err());
+    }
+}
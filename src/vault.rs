@@ -0,0 +1,343 @@
+//! Export of sessions and memory into an Obsidian-style vault
+//! Everything is written below `<vault>/Prime/` and regenerated on each export:
+//! one note per session (with previous/next wikilinks), one daily note per day
+//! that had sessions or memory entries, and one note per memory file.
+
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use anyhow::{Context, Result};
+use chrono::NaiveDateTime;
+use crate::terminal::Stylize;
+
+use crate::clock;
+use crate::evidence;
+use crate::metadata::MetadataStore;
+use crate::params::ModelParams;
+use crate::session::LogEntry;
+use crate::transcript::LogFormat;
+
+/// Folder inside the vault owned by the exporter
+pub const VAULT_FOLDER: &str = "Prime";
+
+/// A session log, parsed
+#[derive(Debug, Clone)]
+pub struct SessionNote {
+    pub id: String,
+    started: Option<NaiveDateTime>,
+    pub entries: Vec<LogEntry>,
+}
+
+impl SessionNote {
+    fn date(&self) -> Option<String> {
+        self.started.map(|t| t.format("%Y-%m-%d").to_string())
+    }
+
+    /// First user prompt, used as the note's title in listings
+    fn summary(&self) -> String {
+        let first = self
+            .entries
+            .iter()
+            .find(|e| e.title == "User Input")
+            .and_then(|e| e.content.lines().next())
+            .unwrap_or("(no prompt)");
+        let mut summary: String = first.chars().take(80).collect();
+        if first.chars().count() > 80 {
+            summary.push('…');
+        }
+        summary
+    }
+
+    fn message_count(&self) -> usize {
+        self.entries.iter().filter(|e| e.title == "User Input" || e.title == "Prime Response").count()
+    }
+}
+
+#[derive(Debug, Clone, PartialEq)]
+struct MemoryEntry {
+    timestamp: String,
+    content: String,
+}
+
+impl MemoryEntry {
+    fn date(&self) -> Option<&str> {
+        self.timestamp.get(..10).filter(|d| d.as_bytes().get(4) == Some(&b'-'))
+    }
+}
+
+#[derive(Debug, Default)]
+pub struct VaultExportSummary {
+    pub root: PathBuf,
+    pub sessions: usize,
+    pub daily_notes: usize,
+    pub memory_entries: usize,
+}
+
+/// Parses the start time out of a `session_YYYYMMDD_HHMMSS` id
+fn session_start(id: &str) -> Option<NaiveDateTime> {
+    let stamp = id.strip_prefix("session_")?;
+    NaiveDateTime::parse_from_str(stamp, "%Y%m%d_%H%M%S").ok()
+}
+
+fn daily_note_name(date: &str) -> String {
+    format!("Prime {}", date)
+}
+
+/// Quotes a value for YAML front-matter (a JSON string is valid YAML)
+fn yaml_string(value: &str) -> String {
+    serde_json::to_string(value).unwrap_or_else(|_| "\"\"".to_string())
+}
+
+/// Splits a memory file into its `## Entry (timestamp)` sections
+fn parse_memory_entries(content: &str) -> Vec<MemoryEntry> {
+    let mut entries = Vec::new();
+    let mut current: Option<MemoryEntry> = None;
+    for line in content.lines() {
+        if let Some(timestamp) = line.strip_prefix("## Entry (").and_then(|rest| rest.strip_suffix(')')) {
+            entries.extend(current.take());
+            current = Some(MemoryEntry { timestamp: timestamp.to_string(), content: String::new() });
+        } else if let Some(entry) = current.as_mut() {
+            entry.content.push_str(line);
+            entry.content.push('\n');
+        }
+    }
+    entries.extend(current);
+    for entry in &mut entries {
+        entry.content = entry.content.trim().to_string();
+    }
+    entries
+}
+
+fn render_entry(entry: &LogEntry) -> String {
+    let time = entry.timestamp.get(11..).unwrap_or(&entry.timestamp);
+    match entry.title.as_str() {
+        "User Input" => {
+            let quoted: Vec<String> = entry.content.lines().map(|l| format!("> {}", l)).collect();
+            format!("### User · {}\n\n{}\n", time, quoted.join("\n"))
+        }
+        "Prime Response" => format!("### Prime · {}\n\n{}\n", time, entry.content),
+        // Four backticks so fences inside tool output cannot close the block early.
+        other => format!("### {} · {}\n\n````\n{}\n````\n", other, time, entry.content),
+    }
+}
+
+fn render_session(session: &SessionNote, params: Option<&ModelParams>, prev: Option<&SessionNote>, next: Option<&SessionNote>) -> String {
+    let mut note = String::from("---\n");
+    note.push_str("type: prime-session\n");
+    note.push_str(&format!("session: {}\n", session.id));
+    if let Some(started) = session.started {
+        note.push_str(&format!("date: {}\n", started.format("%Y-%m-%d")));
+        note.push_str(&format!("started: {}\n", yaml_string(&started.format("%Y-%m-%d %H:%M:%S").to_string())));
+    }
+    note.push_str(&format!("messages: {}\n", session.message_count()));
+    if let Some(params) = params {
+        note.push_str(&format!("params: {}\n", yaml_string(&params.to_string())));
+    }
+    note.push_str(&format!("summary: {}\n", yaml_string(&session.summary())));
+    note.push_str("tags: [prime, prime/session]\n---\n\n");
+    note.push_str(&format!("# {}\n\n", session.summary()));
+
+    let mut links = Vec::new();
+    if let Some(prev) = prev {
+        links.push(format!("← [[{}]]", prev.id));
+    }
+    if let Some(date) = session.date() {
+        links.push(format!("[[{}]]", daily_note_name(&date)));
+    }
+    if let Some(next) = next {
+        links.push(format!("[[{}]] →", next.id));
+    }
+    if !links.is_empty() {
+        note.push_str(&links.join(" · "));
+        note.push_str("\n\n");
+    }
+    for entry in &evidence::annotate_entries(&session.entries) {
+        note.push_str(&render_entry(entry));
+        note.push('\n');
+    }
+    note
+}
+
+fn render_daily(date: &str, sessions: &[&SessionNote], memory: &[(&str, &MemoryEntry)]) -> String {
+    let mut note = format!("---\ntype: prime-daily\ndate: {}\ntags: [prime, prime/daily]\n---\n\n", date);
+    // Link to the user's own daily note so the export joins an existing journal.
+    note.push_str(&format!("# Prime · {}\n\nJournal: [[{}]]\n", date, date));
+    if !sessions.is_empty() {
+        note.push_str("\n## Sessions\n\n");
+        for session in sessions {
+            let time = session.started.map(|t| t.format("%H:%M").to_string()).unwrap_or_default();
+            note.push_str(&format!("- {} [[{}]] — {}\n", time, session.id, session.summary()));
+        }
+    }
+    if !memory.is_empty() {
+        note.push_str("\n## Memory\n\n");
+        for (kind, entry) in memory {
+            let first = entry.content.lines().next().unwrap_or_default();
+            note.push_str(&format!("- [[{}]]: {}\n", kind, first));
+        }
+    }
+    note
+}
+
+fn render_memory(kind: &str, entries: &[MemoryEntry]) -> String {
+    let mut note = format!(
+        "---\ntype: prime-memory\nmemory: {}\nentries: {}\ntags: [prime, prime/memory]\n---\n\n# {}\n",
+        yaml_string(kind),
+        entries.len(),
+        kind
+    );
+    for entry in entries {
+        note.push_str(&format!("\n## {}\n", entry.timestamp));
+        if let Some(date) = entry.date() {
+            note.push_str(&format!("[[{}]]\n", daily_note_name(date)));
+        }
+        note.push_str(&format!("\n{}\n", entry.content));
+    }
+    note
+}
+
+/// Session logs in `conversations_dir`, oldest first
+pub fn load_sessions(conversations_dir: &Path) -> Result<Vec<SessionNote>> {
+    let mut sessions = Vec::new();
+    if !conversations_dir.exists() {
+        return Ok(sessions);
+    }
+    let entries = fs::read_dir(conversations_dir)
+        .with_context(|| format!("Failed to read {}", conversations_dir.display()))?;
+    for entry in entries.flatten() {
+        let path = entry.path();
+        let Some(format) = LogFormat::of(&path) else {
+            continue;
+        };
+        let Some(id) = path.file_stem().and_then(|s| s.to_str()).map(str::to_string) else {
+            continue;
+        };
+        let content = fs::read_to_string(&path).with_context(|| format!("Failed to read {}", path.display()))?;
+        let entries = format.parse(&content);
+        if entries.is_empty() {
+            continue;
+        }
+        sessions.push(SessionNote { started: session_start(&id), id, entries });
+    }
+    sessions.sort_by(|a, b| a.started.cmp(&b.started).then_with(|| a.id.cmp(&b.id)));
+    Ok(sessions)
+}
+
+fn write_note(path: &Path, content: &str) -> Result<()> {
+    fs::write(path, content).with_context(|| format!("Failed to write {}", path.display()))
+}
+
+/// Writes the Prime data under `base_dir` into `<vault_dir>/Prime/`
+pub fn export_vault(base_dir: &Path, vault_dir: &Path) -> Result<VaultExportSummary> {
+    let root = vault_dir.join(VAULT_FOLDER);
+    let sessions_dir = root.join("Sessions");
+    let daily_dir = root.join("Daily");
+    let memory_dir = root.join("Memory");
+    for dir in [&sessions_dir, &daily_dir, &memory_dir] {
+        fs::create_dir_all(dir).with_context(|| format!("Failed to create {}", dir.display()))?;
+    }
+
+    let sessions = load_sessions(&base_dir.join("conversations"))?;
+    let session_params = MetadataStore::new(base_dir, clock::system()).load()?.session_params;
+    for (i, session) in sessions.iter().enumerate() {
+        let prev = i.checked_sub(1).and_then(|p| sessions.get(p));
+        let note = render_session(session, session_params.get(&session.id), prev, sessions.get(i + 1));
+        write_note(&sessions_dir.join(format!("{}.md", session.id)), &note)?;
+    }
+
+    let mut memory_notes = Vec::new();
+    for (file, kind) in [("long_term.md", "Long-term Memory"), ("short_term.md", "Short-term Memory")] {
+        let path = base_dir.join("memory").join(file);
+        let content = if path.exists() {
+            fs::read_to_string(&path).with_context(|| format!("Failed to read {}", path.display()))?
+        } else {
+            String::new()
+        };
+        let entries = parse_memory_entries(&content);
+        write_note(&memory_dir.join(format!("{}.md", kind)), &render_memory(kind, &entries))?;
+        memory_notes.push((kind, entries));
+    }
+
+    let mut days: BTreeMap<String, (Vec<&SessionNote>, Vec<(&str, &MemoryEntry)>)> = BTreeMap::new();
+    for session in &sessions {
+        if let Some(date) = session.date() {
+            days.entry(date).or_default().0.push(session);
+        }
+    }
+    for (kind, entries) in &memory_notes {
+        for entry in entries {
+            if let Some(date) = entry.date() {
+                days.entry(date.to_string()).or_default().1.push((*kind, entry));
+            }
+        }
+    }
+    for (date, (day_sessions, day_memory)) in &days {
+        let note = render_daily(date, day_sessions, day_memory);
+        write_note(&daily_dir.join(format!("{}.md", daily_note_name(date))), &note)?;
+    }
+
+    Ok(VaultExportSummary {
+        root,
+        sessions: sessions.len(),
+        daily_notes: days.len(),
+        memory_entries: memory_notes.iter().map(|(_, e)| e.len()).sum(),
+    })
+}
+
+/// CLI entry point for `prime export obsidian`
+pub fn run_export(base_dir: &Path, vault_dir: &Path) -> Result<()> {
+    let summary = export_vault(base_dir, vault_dir)?;
+    println!(
+        "{}",
+        format!(
+            "Exported {} sessions, {} daily notes and {} memory entries to {}",
+            summary.sessions,
+            summary.daily_notes,
+            summary.memory_entries,
+            summary.root.display()
+        )
+        .green()
+    );
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::session::parse_log_entries;
+
+    const LOG: &str = "\n## User Input (2025-06-07 17:54:46)\n```\nwhats the time\n```\n\n## Prime Response (2025-06-07 17:54:50)\n```\n## Answer (soon)\nIt is late.\n```\n\n## Tool Results (2025-06-07 17:54:52)\n```\nok\n```\n";
+
+    #[test]
+    fn test_parse_log_entries_keeps_headings_inside_content() {
+        let entries = parse_log_entries(LOG);
+        assert_eq!(entries.len(), 3);
+        assert_eq!(entries[0].title, "User Input");
+        assert_eq!(entries[0].timestamp, "2025-06-07 17:54:46");
+        assert_eq!(entries[1].content, "## Answer (soon)\nIt is late.");
+        assert_eq!(entries[2].content, "ok");
+    }
+
+    #[test]
+    fn test_parse_memory_entries() {
+        let content = "# Prime Long-term Memory\n\n(notes)\n## Entry (2025-06-07 13:54:37.123 UTC)\nUser prefers tabs.\n\n## Entry (2025-06-08 09:00:00 UTC)\nProject uses Rust.\n";
+        let entries = parse_memory_entries(content);
+        assert_eq!(entries.len(), 2);
+        assert_eq!(entries[0].content, "User prefers tabs.");
+        assert_eq!(entries[1].date(), Some("2025-06-08"));
+    }
+
+    #[test]
+    fn test_render_session_links_and_front_matter() {
+        let note = |id: &str| SessionNote { id: id.to_string(), started: session_start(id), entries: parse_log_entries(LOG) };
+        let (prev, current, next) = (note("session_20250606_100000"), note("session_20250607_175437"), note("session_20250608_080000"));
+        let params = ModelParams { temperature: Some(0.2), ..Default::default() };
+        let rendered = render_session(&current, Some(&params), Some(&prev), Some(&next));
+        assert!(rendered.starts_with("---\ntype: prime-session\nsession: session_20250607_175437\ndate: 2025-06-07\n"));
+        assert!(rendered.contains("summary: \"whats the time\""));
+        assert!(rendered.contains("params: \"temperature 0.2\"\n"));
+        assert!(rendered.contains("← [[session_20250606_100000]] · [[Prime 2025-06-07]] · [[session_20250608_080000]] →"));
+        assert!(rendered.contains("> whats the time"));
+    }
+}
@@ -0,0 +1,227 @@
+//! Injectable filesystem
+//! The session log, memory files, the pattern files in the config directory and the
+//! file actions (`read_file`, `write_file`, `list_dir`) go through a `FileSystem`
+//! rather than `std::fs`, and the REPL's home directory comes from it rather than
+//! `dirs::home_dir()`. `RealFs` is the disk; the test-only `MemoryFs` keeps everything
+//! in a map behind a mutex, so unit tests and replays run without touching the machine
+//! or racing each other over temp paths.
+//!
+//! Shell commands, metadata, locks, snapshots, scratch directories and the audit log
+//! still work on the real disk, inside the directories the session is given. The
+//! subcommands that run without a session (`prime update`, `prime exec`, the exports)
+//! use the real home directory.
+
+#[cfg(test)]
+use std::collections::{BTreeMap, BTreeSet};
+use std::fs;
+use std::io::{self, Read, Write};
+use std::path::{Path, PathBuf};
+use std::sync::Arc;
+#[cfg(test)]
+use std::sync::Mutex;
+use std::time::SystemTime;
+#[cfg(test)]
+use std::time::Duration;
+
+pub trait FileSystem: Send + Sync + std::fmt::Debug {
+    fn home_dir(&self) -> Option<PathBuf>;
+    /// Up to `limit` bytes of a file, and whether the file is longer
+    fn read_head(&self, path: &Path, limit: u64) -> io::Result<(Vec<u8>, bool)>;
+    /// Writes or appends to a file, creating it; its directory must exist
+    fn write(&self, path: &Path, contents: &[u8], append: bool) -> io::Result<()>;
+    fn create_dir_all(&self, path: &Path) -> io::Result<()>;
+    /// Entry names of a directory, each with whether it is a directory itself
+    fn read_dir(&self, path: &Path) -> io::Result<Vec<(String, bool)>>;
+    fn is_dir(&self, path: &Path) -> bool;
+    fn exists(&self, path: &Path) -> bool;
+    /// Modification time and length of a file; None when it does not exist
+    fn stat(&self, path: &Path) -> Option<(SystemTime, u64)>;
+
+    fn read(&self, path: &Path) -> io::Result<Vec<u8>> {
+        self.read_head(path, u64::MAX).map(|(contents, _)| contents)
+    }
+
+    fn read_to_string(&self, path: &Path) -> io::Result<String> {
+        String::from_utf8(self.read(path)?).map_err(|e| io::Error::new(io::ErrorKind::InvalidData, e))
+    }
+}
+
+pub type SharedFs = Arc<dyn FileSystem>;
+
+/// The machine's filesystem
+#[derive(Debug, Default)]
+pub struct RealFs;
+
+impl FileSystem for RealFs {
+    fn home_dir(&self) -> Option<PathBuf> {
+        dirs::home_dir()
+    }
+
+    fn read_head(&self, path: &Path, limit: u64) -> io::Result<(Vec<u8>, bool)> {
+        let file = fs::File::open(path)?;
+        let length = file.metadata()?.len();
+        let mut contents = Vec::new();
+        file.take(limit).read_to_end(&mut contents)?;
+        Ok((contents, length > limit))
+    }
+
+    fn write(&self, path: &Path, contents: &[u8], append: bool) -> io::Result<()> {
+        fs::OpenOptions::new().write(true).create(true).append(append).truncate(!append).open(path)?.write_all(contents)
+    }
+
+    fn create_dir_all(&self, path: &Path) -> io::Result<()> {
+        fs::create_dir_all(path)
+    }
+
+    fn read_dir(&self, path: &Path) -> io::Result<Vec<(String, bool)>> {
+        fs::read_dir(path)?
+            .map(|entry| entry.map(|entry| (entry.file_name().to_string_lossy().to_string(), entry.path().is_dir())))
+            .collect()
+    }
+
+    fn is_dir(&self, path: &Path) -> bool {
+        path.is_dir()
+    }
+
+    fn exists(&self, path: &Path) -> bool {
+        path.exists()
+    }
+
+    fn stat(&self, path: &Path) -> Option<(SystemTime, u64)> {
+        let metadata = fs::metadata(path).ok()?;
+        Some((metadata.modified().ok()?, metadata.len()))
+    }
+}
+
+/// The machine's filesystem, shared
+pub fn real() -> SharedFs {
+    Arc::new(RealFs)
+}
+
+#[cfg(test)]
+#[derive(Debug, Default)]
+struct MemoryTree {
+    files: BTreeMap<PathBuf, (Vec<u8>, SystemTime)>,
+    dirs: BTreeSet<PathBuf>,
+    /// Bumped on every write so modification times always move forward
+    writes: u64,
+}
+
+/// A filesystem held in memory, with `home` as the home directory
+#[cfg(test)]
+#[derive(Debug)]
+pub struct MemoryFs {
+    home: PathBuf,
+    tree: Mutex<MemoryTree>,
+}
+
+#[cfg(test)]
+impl MemoryFs {
+    pub fn new(home: impl Into<PathBuf>) -> Self {
+        let memory = Self { home: home.into(), tree: Mutex::default() };
+        let _ = memory.create_dir_all(&memory.home.clone());
+        memory
+    }
+
+    fn tree(&self) -> std::sync::MutexGuard<'_, MemoryTree> {
+        self.tree.lock().unwrap_or_else(|poisoned| poisoned.into_inner())
+    }
+}
+
+#[cfg(test)]
+fn not_found(path: &Path) -> io::Error {
+    io::Error::new(io::ErrorKind::NotFound, format!("{} does not exist", path.display()))
+}
+
+#[cfg(test)]
+impl FileSystem for MemoryFs {
+    fn home_dir(&self) -> Option<PathBuf> {
+        Some(self.home.clone())
+    }
+
+    fn read_head(&self, path: &Path, limit: u64) -> io::Result<(Vec<u8>, bool)> {
+        let tree = self.tree();
+        let (contents, _) = tree.files.get(path).ok_or_else(|| not_found(path))?;
+        let end = contents.len().min(usize::try_from(limit).unwrap_or(usize::MAX));
+        Ok((contents[..end].to_vec(), end < contents.len()))
+    }
+
+    fn write(&self, path: &Path, contents: &[u8], append: bool) -> io::Result<()> {
+        let mut tree = self.tree();
+        if tree.dirs.contains(path) {
+            return Err(io::Error::new(io::ErrorKind::Other, format!("{} is a directory", path.display())));
+        }
+        match path.parent() {
+            Some(parent) if !parent.as_os_str().is_empty() && !tree.dirs.contains(parent) => return Err(not_found(parent)),
+            _ => {}
+        }
+        tree.writes += 1;
+        let modified = SystemTime::UNIX_EPOCH + Duration::from_nanos(tree.writes);
+        let file = tree.files.entry(path.to_path_buf()).or_insert_with(|| (Vec::new(), modified));
+        if !append {
+            file.0.clear();
+        }
+        file.0.extend_from_slice(contents);
+        file.1 = modified;
+        Ok(())
+    }
+
+    fn create_dir_all(&self, path: &Path) -> io::Result<()> {
+        let mut tree = self.tree();
+        if let Some(file) = path.ancestors().find(|ancestor| tree.files.contains_key(*ancestor)) {
+            return Err(io::Error::new(io::ErrorKind::AlreadyExists, format!("{} is a file", file.display())));
+        }
+        for ancestor in path.ancestors().filter(|ancestor| !ancestor.as_os_str().is_empty()) {
+            tree.dirs.insert(ancestor.to_path_buf());
+        }
+        Ok(())
+    }
+
+    fn read_dir(&self, path: &Path) -> io::Result<Vec<(String, bool)>> {
+        let tree = self.tree();
+        if !tree.dirs.contains(path) {
+            return Err(not_found(path));
+        }
+        let name = |child: &Path| child.file_name().map(|name| name.to_string_lossy().to_string());
+        let dirs = tree.dirs.iter().filter(|dir| dir.parent() == Some(path)).filter_map(|dir| Some((name(dir)?, true)));
+        let files = tree.files.keys().filter(|file| file.parent() == Some(path)).filter_map(|file| Some((name(file)?, false)));
+        Ok(dirs.chain(files).collect())
+    }
+
+    fn is_dir(&self, path: &Path) -> bool {
+        self.tree().dirs.contains(path)
+    }
+
+    fn exists(&self, path: &Path) -> bool {
+        let tree = self.tree();
+        tree.dirs.contains(path) || tree.files.contains_key(path)
+    }
+
+    fn stat(&self, path: &Path) -> Option<(SystemTime, u64)> {
+        let tree = self.tree();
+        let (contents, modified) = tree.files.get(path)?;
+        Some((*modified, contents.len() as u64))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_memory_fs_behaves_like_a_disk() {
+        let fs = MemoryFs::new("/home/prime");
+        let notes = Path::new("/home/prime/.prime/notes.md");
+        assert_eq!(fs.write(notes, b"a", false).unwrap_err().kind(), io::ErrorKind::NotFound);
+        fs.create_dir_all(Path::new("/home/prime/.prime/memory")).unwrap();
+        fs.write(notes, b"one\n", false).unwrap();
+        let first = fs.stat(notes).unwrap();
+        fs.write(notes, b"two\n", true).unwrap();
+        assert_eq!(fs.read_to_string(notes).unwrap(), "one\ntwo\n");
+        assert!(fs.stat(notes).unwrap() > first);
+        assert_eq!(fs.read_head(notes, 3).unwrap(), (b"one".to_vec(), true));
+        assert_eq!(fs.read_dir(Path::new("/home/prime/.prime")).unwrap(), vec![("memory".to_string(), true), ("notes.md".to_string(), false)]);
+        assert!(fs.is_dir(Path::new("/home")) && !fs.is_dir(notes));
+        assert_eq!(fs.read(Path::new("/home/prime/missing")).unwrap_err().kind(), io::ErrorKind::NotFound);
+    }
+}
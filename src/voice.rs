@@ -0,0 +1,165 @@
+//! Experimental voice input
+//! `!voice [seconds]` records from the microphone with the configured recorder command
+//! (arecord on Linux, sox elsewhere), sends the WAV to a speech-to-text server and puts
+//! the transcription at the prompt. Nothing runs until the user has read it, edited it
+//! if the recognizer misheard, and pressed Enter; from there it is an ordinary turn.
+//!
+//! The server can be a local whisper.cpp `server` (`/inference`, the default) or any
+//! OpenAI-compatible `/v1/audio/transcriptions` endpoint; both take a multipart `file`
+//! field and answer `{"text": ...}`. Voice input is off until `[voice] enabled = true`.
+
+use std::fs;
+use std::io;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::{Duration, SystemTime};
+
+use anyhow::{anyhow, Context, Result};
+
+use crate::config::VoiceConfig;
+
+const TRANSCRIBE_TIMEOUT: Duration = Duration::from_secs(120);
+const BOUNDARY: &str = "prime-voice-boundary-7f3a9c";
+
+/// Creates an empty, previously absent WAV file in `dir` for one recording. Names carry
+/// the process, the time and a counter, and `create_new` refuses a file that is already
+/// there, so a planted file or link at a guessable path is never written through.
+fn recording_file(dir: &Path) -> Result<PathBuf> {
+    static COUNTER: AtomicU64 = AtomicU64::new(0);
+    for _ in 0..16 {
+        let nanos = SystemTime::now().duration_since(SystemTime::UNIX_EPOCH).map(|d| d.as_nanos()).unwrap_or_default();
+        let name = format!("prime_voice_{}_{}_{}.wav", std::process::id(), nanos, COUNTER.fetch_add(1, Ordering::Relaxed));
+        let file = dir.join(name);
+        match fs::OpenOptions::new().write(true).create_new(true).open(&file) {
+            Ok(_) => return Ok(file),
+            Err(e) if e.kind() == io::ErrorKind::AlreadyExists => continue,
+            Err(e) => return Err(e).with_context(|| format!("Failed to create {}", file.display())),
+        }
+    }
+    Err(anyhow!("Failed to create a recording file in {}", dir.display()))
+}
+
+/// Program and arguments of the recorder command, with `{file}` and `{seconds}` filled in
+fn recorder_args(template: &str, file: &Path, seconds: u64) -> Result<(String, Vec<String>)> {
+    let file = file.to_string_lossy();
+    let mut words = template
+        .split_whitespace()
+        .map(|word| word.replace("{file}", &file).replace("{seconds}", &seconds.to_string()));
+    let program = words.next().ok_or_else(|| anyhow!("voice.record_command is empty"))?;
+    let args: Vec<String> = words.collect();
+    if !template.contains("{file}") {
+        return Err(anyhow!("voice.record_command must write to {{file}}"));
+    }
+    Ok((program, args))
+}
+
+/// Records `seconds` of audio to `file`
+fn record(config: &VoiceConfig, file: &Path, seconds: u64) -> Result<()> {
+    let (program, args) = recorder_args(&config.record_command, file, seconds)?;
+    let output = Command::new(&program)
+        .args(&args)
+        .stdin(Stdio::null())
+        .stdout(Stdio::null())
+        .stderr(Stdio::piped())
+        .output()
+        .with_context(|| format!("Failed to run {} (is it installed and on PATH?)", program))?;
+    if !output.status.success() {
+        return Err(anyhow!("{} failed: {}", program, String::from_utf8_lossy(&output.stderr).trim()));
+    }
+    Ok(())
+}
+
+/// multipart/form-data body with the text `fields` and the audio as `file`
+fn multipart_body(fields: &[(&str, &str)], audio: &[u8]) -> Vec<u8> {
+    let mut body = Vec::with_capacity(audio.len() + 512);
+    for (name, value) in fields {
+        body.extend_from_slice(format!("--{}\r\nContent-Disposition: form-data; name=\"{}\"\r\n\r\n{}\r\n", BOUNDARY, name, value).as_bytes());
+    }
+    body.extend_from_slice(
+        format!("--{}\r\nContent-Disposition: form-data; name=\"file\"; filename=\"voice.wav\"\r\nContent-Type: audio/wav\r\n\r\n", BOUNDARY).as_bytes(),
+    );
+    body.extend_from_slice(audio);
+    body.extend_from_slice(format!("\r\n--{}--\r\n", BOUNDARY).as_bytes());
+    body
+}
+
+/// The transcription in a server reply, on one line; JSON `{"text"}` or plain text
+fn parse_transcript(reply: &str) -> Result<String> {
+    let text = match serde_json::from_str::<serde_json::Value>(reply) {
+        Ok(json) => json["text"].as_str().ok_or_else(|| anyhow!("Unexpected transcription reply: {}", reply.trim()))?.to_string(),
+        Err(_) => reply.to_string(),
+    };
+    let text = text.split_whitespace().collect::<Vec<_>>().join(" ");
+    match text.is_empty() || text == "[BLANK_AUDIO]" {
+        true => Err(anyhow!("No speech was recognized")),
+        false => Ok(text),
+    }
+}
+
+async fn transcribe(config: &VoiceConfig, audio: &[u8]) -> Result<String> {
+    let mut fields = vec![("response_format", "json"), ("temperature", "0")];
+    if let Some(model) = &config.model {
+        fields.push(("model", model));
+    }
+    if let Some(language) = &config.language {
+        fields.push(("language", language));
+    }
+    let client = reqwest::Client::builder().timeout(TRANSCRIBE_TIMEOUT).build().context("Failed to build HTTP client")?;
+    let mut request = client
+        .post(&config.stt_url)
+        .header("Content-Type", format!("multipart/form-data; boundary={}", BOUNDARY))
+        .body(multipart_body(&fields, audio));
+    if let Some(key) = config.api_key.as_ref().filter(|key| !key.is_empty()) {
+        request = request.bearer_auth(key);
+    }
+    let reply = request
+        .send()
+        .await
+        .and_then(|response| response.error_for_status())
+        .with_context(|| format!("Transcription request to {} failed", config.stt_url))?
+        .text()
+        .await
+        .context("Failed to read the transcription")?;
+    parse_transcript(&reply)
+}
+
+/// Records `seconds` from the microphone and returns what was said
+pub async fn listen(config: &VoiceConfig, seconds: u64) -> Result<String> {
+    let file = recording_file(&std::env::temp_dir())?;
+    let recorded = record(config, &file, seconds).and_then(|_| fs::read(&file).context("Failed to read the recording"));
+    let _ = fs::remove_file(&file);
+    transcribe(config, &recorded?).await
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_request_and_reply_formats() {
+        let (program, args) = recorder_args("arecord -q -d {seconds} {file}", Path::new("/tmp/v.wav"), 5).unwrap();
+        assert_eq!((program.as_str(), args), ("arecord", vec!["-q".to_string(), "-d".into(), "5".into(), "/tmp/v.wav".into()]));
+        assert!(recorder_args("arecord -d {seconds}", Path::new("/tmp/v.wav"), 5).is_err());
+
+        let body = String::from_utf8(multipart_body(&[("language", "en")], b"RIFF")).unwrap();
+        assert!(body.starts_with(&format!("--{}\r\nContent-Disposition: form-data; name=\"language\"\r\n\r\nen\r\n", BOUNDARY)));
+        assert!(body.ends_with(&format!("Content-Type: audio/wav\r\n\r\nRIFF\r\n--{}--\r\n", BOUNDARY)));
+
+        assert_eq!(parse_transcript("{\"text\":\" list the\\n largest files \"}").unwrap(), "list the largest files");
+        assert_eq!(parse_transcript("show disk usage\n").unwrap(), "show disk usage");
+        assert!(parse_transcript("{\"text\":\" [BLANK_AUDIO]\"}").is_err());
+        assert!(parse_transcript("{\"error\":\"bad file\"}").is_err());
+    }
+
+    #[test]
+    fn test_each_recording_gets_a_new_file() {
+        let dir = std::env::temp_dir().join(format!("prime_voice_test_{}", std::process::id()));
+        fs::create_dir_all(&dir).unwrap();
+        let first = recording_file(&dir).unwrap();
+        let second = recording_file(&dir).unwrap();
+        assert_ne!(first, second);
+        assert!(first.starts_with(&dir) && fs::metadata(&second).unwrap().len() == 0);
+        let _ = fs::remove_dir_all(&dir);
+    }
+}